@@ -1,4 +1,4 @@
 #!/usr/bin/env bash
 
-go build -o subscribe-o-mast main.go
+go build -o subscribe-o-mast .
 chmod +x subscribe-o-mast
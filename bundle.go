@@ -0,0 +1,699 @@
+package main
+
+// Bundles of filters, followed tags, featured tags, lists and domain blocks, shared as a single file or URL.
+
+import (
+  "archive/tar"
+  "bytes"
+  "compress/gzip"
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/url"
+  "os"
+  "path"
+  "strings"
+  "time"
+)
+
+// FeaturedTag is a hashtag featured on the user's profile.
+type FeaturedTag struct {
+  ID   string `json:"id,omitempty"`
+  Name string `json:"name"`
+}
+
+// AccountList is a Mastodon list, along with the accounts in it as user@domain.
+type AccountList struct {
+  ID            string   `json:"id,omitempty"`
+  Title         string   `json:"title"`
+  RepliesPolicy string   `json:"replies_policy,omitempty"`
+  Exclusive     bool     `json:"exclusive,omitempty"`
+  Accounts      []string `json:"accounts,omitempty"`
+}
+
+// listChange creates, updates or deletes a list, adding accounts to it or removing them.
+type listChange struct {
+  Action         string
+  List           *AccountList
+  Existing       *AccountList
+  AddAccounts    []string
+  RemoveAccounts []string
+}
+
+// bundlePlan is every change needed to apply a bundle to the account.
+type bundlePlan struct {
+  Filters        []filterChange
+  Tags           []tagChange
+  FeaturedTags   []string
+  UnfeatureTags  []*FeaturedTag
+  Lists          []listChange
+  DomainBlocks   []string
+  DomainUnblocks []string
+}
+
+// bundleSections are the files written to, and read from, a tar.gz bundle.
+var bundleSections = []string{"filters", "tags", "featured_tags", "lists", "domain_blocks"}
+
+// empty reports whether the plan has nothing to do.
+func (plan *bundlePlan) empty() bool {
+  return len(plan.Filters) == 0 && len(plan.Tags) == 0 && len(plan.FeaturedTags) == 0 && len(plan.UnfeatureTags) == 0 &&
+    len(plan.Lists) == 0 && len(plan.DomainBlocks) == 0 && len(plan.DomainUnblocks) == 0
+}
+
+// remaining returns the changes in the plan that come after those done, which applyBundlePlan makes in order.
+func (plan *bundlePlan) remaining(done *bundlePlan) *bundlePlan {
+  return &bundlePlan{
+    Filters:        plan.Filters[len(done.Filters):],
+    Tags:           plan.Tags[len(done.Tags):],
+    FeaturedTags:   plan.FeaturedTags[len(done.FeaturedTags):],
+    UnfeatureTags:  plan.UnfeatureTags[len(done.UnfeatureTags):],
+    Lists:          plan.Lists[len(done.Lists):],
+    DomainBlocks:   plan.DomainBlocks[len(done.DomainBlocks):],
+    DomainUnblocks: plan.DomainUnblocks[len(done.DomainUnblocks):],
+  }
+}
+
+// summary counts the changes in the plan, e.g. "2 filter, 0 tag, 0 featured tag, 1 list and 0 domain block changes".
+func (plan *bundlePlan) summary() string {
+  return fmt.Sprintf("%d filter, %d tag, %d featured tag, %d list and %d domain block changes",
+    len(plan.Filters), len(plan.Tags), len(plan.FeaturedTags)+len(plan.UnfeatureTags), len(plan.Lists), len(plan.DomainBlocks)+len(plan.DomainUnblocks))
+}
+
+// print shows the planned changes.
+func (plan *bundlePlan) print() {
+  plan.fprint(os.Stdout)
+}
+
+// fprint writes the planned changes to w.
+func (plan *bundlePlan) fprint(w io.Writer) {
+  for _, change := range plan.Filters {
+    fmt.Fprintf(w, " %s filter %s (%s)\n", change.Action, change.Title, change.Reason)
+  }
+  for _, change := range plan.Tags {
+    fmt.Fprintf(w, " %s #%s\n", change.Action, change.Name)
+  }
+  for _, name := range plan.FeaturedTags {
+    fmt.Fprintf(w, " feature #%s\n", name)
+  }
+  for _, tag := range plan.UnfeatureTags {
+    fmt.Fprintf(w, " unfeature #%s\n", tag.Name)
+  }
+  for _, change := range plan.Lists {
+    switch change.Action {
+    case "create":
+      fmt.Fprintf(w, " create list %s\n", change.List.Title)
+    case "delete":
+      fmt.Fprintf(w, " delete list %s\n", change.Existing.Title)
+      continue
+    }
+    for _, account := range change.AddAccounts {
+      fmt.Fprintf(w, " follow and add %s to list %s\n", account, change.List.Title)
+    }
+    for _, account := range change.RemoveAccounts {
+      fmt.Fprintf(w, " remove %s from list %s\n", account, change.List.Title)
+    }
+  }
+  for _, domain := range plan.DomainBlocks {
+    fmt.Fprintf(w, " block domain %s\n", domain)
+  }
+  for _, domain := range plan.DomainUnblocks {
+    fmt.Fprintf(w, " unblock domain %s\n", domain)
+  }
+}
+
+// parseBundle parses a bundle in JSON, YAML, TOML or tar.gz form. Any list file is also a valid bundle.
+func parseBundle(name string, data []byte) (*ListFile, error) {
+  // A tar.gz bundle holds a list file per section.
+  if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+    gz, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+      return nil, fmt.Errorf("error reading %s: %w", name, err)
+    }
+    defer gz.Close()
+
+    bundle := &ListFile{FormatVersion: listFormatVersion, Name: strings.TrimSuffix(path.Base(name), ".tar.gz")}
+    archive := tar.NewReader(gz)
+    for {
+      header, err := archive.Next()
+      if err == io.EOF {
+        break
+      }
+      if err != nil {
+        return nil, fmt.Errorf("error reading %s: %w", name, err)
+      }
+      if header.Typeflag != tar.TypeReg || !isDocumentFile(header.Name) {
+        continue
+      }
+
+      contents, err := ioutil.ReadAll(archive)
+      if err != nil {
+        return nil, fmt.Errorf("error reading %s in %s: %w", header.Name, name, err)
+      }
+      section, err := parseBundle(header.Name, contents)
+      if err != nil {
+        return nil, fmt.Errorf("error reading %s in %s: %w", header.Name, name, err)
+      }
+      bundle.merge(section)
+    }
+    return bundle, nil
+  }
+
+  // URLs don't always have an extension, so anything that isn't a JSON object or array is read as YAML.
+  format := documentFormat(name)
+  if trimmed := bytes.TrimSpace(data); format == "json" && len(trimmed) > 0 && trimmed[0] != '{' && trimmed[0] != '[' {
+    format = "yaml"
+  }
+  data, err := decodeDocument(format, data)
+  if err != nil {
+    return nil, fmt.Errorf("error reading %s: %w", name, err)
+  }
+
+  list, err := parseListFile(data, detectListKind(name, data))
+  if err != nil {
+    return nil, fmt.Errorf("error reading %s: %w", name, err)
+  }
+  return list, nil
+}
+
+// merge adds the contents of another list file to this one.
+func (list *ListFile) merge(other *ListFile) {
+  list.Filters = append(list.Filters, other.Filters...)
+  list.Tags = append(list.Tags, other.Tags...)
+  list.FeaturedTags = append(list.FeaturedTags, other.FeaturedTags...)
+  list.Lists = append(list.Lists, other.Lists...)
+  list.DomainBlocks = append(list.DomainBlocks, other.DomainBlocks...)
+}
+
+// readBundle reads a bundle from a local file or downloads it from a URL.
+func readBundle(config *MastodonConfig, source string) (*ListFile, error) {
+  if isURL(source) {
+    resp, err := downloadCached(context.Background(), config.downloadDir("bundle"), source, "", config.TrustedKeys)
+    if err != nil {
+      return nil, fmt.Errorf("error downloading bundle: %w", err)
+    }
+    return parseBundle(source, resp.Body)
+  }
+
+  data, err := ioutil.ReadFile(source)
+  if err != nil {
+    return nil, fmt.Errorf("error reading bundle: %w", err)
+  }
+  return parseBundle(source, data)
+}
+
+// instanceHost returns the host name of the configured instance, used to qualify local account names.
+func instanceHost(config *MastodonConfig) string {
+  parsed, err := url.Parse(config.InstanceURL)
+  if err != nil {
+    return ""
+  }
+  return parsed.Host
+}
+
+// qualifyAccount returns an account name as user@domain, adding the instance host to local accounts.
+func qualifyAccount(config *MastodonConfig, acct string) string {
+  acct = strings.ToLower(strings.TrimPrefix(acct, "@"))
+  if !strings.Contains(acct, "@") {
+    acct += "@" + instanceHost(config)
+  }
+  return acct
+}
+
+// fetchJSON gets an API endpoint and decodes the response into v.
+func fetchJSON(config *MastodonConfig, path string, v interface{}) error {
+  body, err := apiRequest(config, "GET", path, nil)
+  if err != nil {
+    return err
+  }
+  if err := json.Unmarshal(body, v); err != nil {
+    return fmt.Errorf("error parsing %s: %w", path, err)
+  }
+  return nil
+}
+
+// fetchAllJSON downloads every page of a paginated list from the API and decodes it into v.
+func fetchAllJSON(config *MastodonConfig, path string, v interface{}) error {
+  body, err := apiGetAll(config, path)
+  if err != nil {
+    return err
+  }
+  if err := json.Unmarshal(body, v); err != nil {
+    return fmt.Errorf("error parsing %s: %w", path, err)
+  }
+  return nil
+}
+
+// fetchLists downloads the user's lists along with the accounts in each.
+func fetchLists(config *MastodonConfig) ([]*AccountList, error) {
+  var lists []*AccountList
+  if err := fetchJSON(config, "/api/v1/lists", &lists); err != nil {
+    return nil, err
+  }
+
+  for _, list := range lists {
+    var accounts []struct {
+      Acct string `json:"acct"`
+    }
+    if err := fetchJSON(config, "/api/v1/lists/"+list.ID+"/accounts?limit=0", &accounts); err != nil {
+      return nil, err
+    }
+    for _, account := range accounts {
+      list.Accounts = append(list.Accounts, qualifyAccount(config, account.Acct))
+    }
+  }
+
+  return lists, nil
+}
+
+// fetchBundle downloads everything a bundle can contain from the user's account, without any instance specific IDs.
+// A shareable bundle also leaves out when filters expire and the posts they filter, which only make sense for this
+// account; a snapshot keeps them, with the expiry as expires_in from when it was taken.
+func fetchBundle(config *MastodonConfig, shareable bool) (*ListFile, error) {
+  now := time.Now().UTC().Truncate(time.Second)
+  bundle := &ListFile{
+    FormatVersion: listFormatVersion,
+    Name:          "Bundle from " + instanceHost(config),
+    UpdatedAt:     now.Format(time.RFC3339),
+  }
+
+  filters, err := fetchFilters(config)
+  if err != nil {
+    return nil, fmt.Errorf("error downloading filters: %w", err)
+  }
+  for _, filter := range filters {
+    filter.ID = ""
+    for i := range filter.Keywords {
+      filter.Keywords[i].ID = ""
+    }
+    if shareable {
+      filter.ExpiresAt = nil
+      filter.Statuses = []FilterStatus{}
+      continue
+    }
+    for i := range filter.Statuses {
+      filter.Statuses[i].ID = ""
+    }
+    if filter.ExpiresAt != nil {
+      if expiresAt, err := time.Parse(time.RFC3339, *filter.ExpiresAt); err == nil && expiresAt.After(now) {
+        expiresIn := Duration(expiresAt.Sub(now).Round(time.Second))
+        filter.ExpiresIn = &expiresIn
+      }
+    }
+  }
+  bundle.Filters = filters
+
+  if bundle.Tags, err = fetchTags(config); err != nil {
+    return nil, fmt.Errorf("error downloading tags: %w", err)
+  }
+  for _, tag := range bundle.Tags {
+    tag.History = nil
+  }
+
+  if err := fetchJSON(config, "/api/v1/featured_tags", &bundle.FeaturedTags); err != nil {
+    return nil, fmt.Errorf("error downloading featured tags: %w", err)
+  }
+  for _, tag := range bundle.FeaturedTags {
+    tag.ID = ""
+  }
+
+  if bundle.Lists, err = fetchLists(config); err != nil {
+    return nil, fmt.Errorf("error downloading lists: %w", err)
+  }
+  for _, list := range bundle.Lists {
+    list.ID = ""
+  }
+
+  if err := fetchAllJSON(config, "/api/v1/domain_blocks", &bundle.DomainBlocks); err != nil {
+    return nil, fmt.Errorf("error downloading domain blocks: %w", err)
+  }
+
+  return bundle, nil
+}
+
+// encodeListFile encodes a list file in the format of the file name.
+func encodeListFile(name string, list *ListFile) ([]byte, error) {
+  data, err := json.Marshal(list)
+  if err != nil {
+    return nil, err
+  }
+  return encodeDocument(documentFormat(name), data)
+}
+
+// writeBundle writes a bundle as JSON, YAML, TOML or tar.gz depending on the file name.
+func writeBundle(file string, bundle *ListFile) error {
+  if !strings.HasSuffix(file, ".tar.gz") && !strings.HasSuffix(file, ".tgz") {
+    data, err := encodeListFile(file, bundle)
+    if err != nil {
+      return fmt.Errorf("error encoding bundle: %w", err)
+    }
+    return ioutil.WriteFile(file, data, 0644)
+  }
+
+  var buf bytes.Buffer
+  gz := gzip.NewWriter(&buf)
+  archive := tar.NewWriter(gz)
+
+  // Write each non-empty section as its own list file.
+  for _, section := range bundleSections {
+    part := &ListFile{FormatVersion: listFormatVersion, Name: bundle.Name + " " + section, UpdatedAt: bundle.UpdatedAt}
+    switch section {
+    case "filters":
+      part.Filters = bundle.Filters
+    case "tags":
+      part.Tags = bundle.Tags
+    case "featured_tags":
+      part.FeaturedTags = bundle.FeaturedTags
+    case "lists":
+      part.Lists = bundle.Lists
+    case "domain_blocks":
+      part.DomainBlocks = bundle.DomainBlocks
+    }
+    if len(part.Filters)+len(part.Tags)+len(part.FeaturedTags)+len(part.Lists)+len(part.DomainBlocks) == 0 {
+      continue
+    }
+    data, err := json.MarshalIndent(part, "", "  ")
+    if err != nil {
+      return fmt.Errorf("error encoding bundle: %w", err)
+    }
+    data = append(data, '\n')
+
+    header := &tar.Header{Name: section + ".json", Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+    if err := archive.WriteHeader(header); err != nil {
+      return fmt.Errorf("error writing bundle: %w", err)
+    }
+    if _, err := archive.Write(data); err != nil {
+      return fmt.Errorf("error writing bundle: %w", err)
+    }
+  }
+
+  if err := archive.Close(); err != nil {
+    return fmt.Errorf("error writing bundle: %w", err)
+  }
+  if err := gz.Close(); err != nil {
+    return fmt.Errorf("error writing bundle: %w", err)
+  }
+  return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// exportBundle writes everything on the account to a single bundle file.
+func exportBundle(config *MastodonConfig, file string) error {
+  bundle, err := fetchBundle(config, true)
+  if err != nil {
+    return err
+  }
+  if err := writeBundle(file, bundle); err != nil {
+    return err
+  }
+
+  reportExport("bundle", map[string]bool{file: true})
+  fmt.Printf("Exported %d filters, %d tags, %d featured tags, %d lists and %d domain blocks to %s\n",
+    len(bundle.Filters), len(bundle.Tags), len(bundle.FeaturedTags), len(bundle.Lists), len(bundle.DomainBlocks), file)
+  return nil
+}
+
+// planBundle works out the changes needed to apply a bundle to the account.
+// Like sync, nothing on the account is removed unless the bundle asks for it, except when exact is set,
+// which also removes everything the bundle doesn't have so the account ends up matching it, as for a rollback.
+func planBundle(config *MastodonConfig, bundle *ListFile, exact bool) (*bundlePlan, error) {
+  plan := &bundlePlan{}
+
+  if len(bundle.Filters) > 0 || exact {
+    current, err := fetchFilters(config)
+    if err != nil {
+      return nil, fmt.Errorf("error downloading filters: %w", err)
+    }
+    if plan.Filters, err = planFilterSync(current, bundle.Filters, time.Now()); err != nil {
+      return nil, err
+    }
+    if exact {
+      wanted := make(map[string]bool)
+      for _, filter := range bundle.Filters {
+        wanted[strings.ToLower(filter.Title)] = true
+      }
+      for _, filter := range current {
+        if !wanted[strings.ToLower(filter.Title)] {
+          plan.Filters = append(plan.Filters, filterChange{Action: "delete", Title: filter.Title, Reason: "not in the bundle", Existing: filter})
+        }
+      }
+      plan.Filters = planFilterStatuses(current, bundle.Filters, plan.Filters)
+    }
+  }
+
+  if len(bundle.Tags) > 0 || exact {
+    current, err := fetchTags(config)
+    if err != nil {
+      return nil, fmt.Errorf("error downloading tags: %w", err)
+    }
+    plan.Tags = planTagSync(current, bundle.Tags)
+    if exact {
+      wanted := make(map[string]bool)
+      for _, tag := range bundle.Tags {
+        wanted[strings.ToLower(tag.Name)] = tag.Following == nil || *tag.Following
+      }
+      for _, tag := range current {
+        if _, listed := wanted[strings.ToLower(tag.Name)]; !listed {
+          plan.Tags = append(plan.Tags, tagChange{Action: "unfollow", Name: tag.Name})
+        }
+      }
+    }
+  }
+
+  if len(bundle.FeaturedTags) > 0 || exact {
+    var current []*FeaturedTag
+    if err := fetchJSON(config, "/api/v1/featured_tags", &current); err != nil {
+      return nil, fmt.Errorf("error downloading featured tags: %w", err)
+    }
+    featured := make(map[string]bool)
+    for _, tag := range current {
+      featured[strings.ToLower(tag.Name)] = true
+    }
+    wanted := make(map[string]bool)
+    for _, tag := range bundle.FeaturedTags {
+      wanted[strings.ToLower(tag.Name)] = true
+      if !featured[strings.ToLower(tag.Name)] {
+        plan.FeaturedTags = append(plan.FeaturedTags, tag.Name)
+        featured[strings.ToLower(tag.Name)] = true
+      }
+    }
+    if exact {
+      for _, tag := range current {
+        if !wanted[strings.ToLower(tag.Name)] {
+          plan.UnfeatureTags = append(plan.UnfeatureTags, tag)
+        }
+      }
+    }
+  }
+
+  if len(bundle.Lists) > 0 || exact {
+    current, err := fetchLists(config)
+    if err != nil {
+      return nil, fmt.Errorf("error downloading lists: %w", err)
+    }
+    existing := make(map[string]*AccountList)
+    for _, list := range current {
+      existing[strings.ToLower(list.Title)] = list
+    }
+    wanted := make(map[string]bool)
+    for _, list := range bundle.Lists {
+      wanted[strings.ToLower(list.Title)] = true
+      change := listChange{Action: "update", List: list, Existing: existing[strings.ToLower(list.Title)]}
+      if change.Existing == nil {
+        change.Action = "create"
+      }
+
+      members := make(map[string]bool)
+      if change.Existing != nil {
+        for _, account := range change.Existing.Accounts {
+          members[account] = true
+        }
+      }
+      listed := make(map[string]bool)
+      for _, account := range list.Accounts {
+        listed[qualifyAccount(config, account)] = true
+        if !members[qualifyAccount(config, account)] {
+          change.AddAccounts = append(change.AddAccounts, account)
+        }
+      }
+      if exact && change.Existing != nil {
+        for _, account := range change.Existing.Accounts {
+          if !listed[account] {
+            change.RemoveAccounts = append(change.RemoveAccounts, account)
+          }
+        }
+      }
+
+      if change.Action == "create" || len(change.AddAccounts) > 0 || len(change.RemoveAccounts) > 0 {
+        plan.Lists = append(plan.Lists, change)
+      }
+    }
+    if exact {
+      for _, list := range current {
+        if !wanted[strings.ToLower(list.Title)] {
+          plan.Lists = append(plan.Lists, listChange{Action: "delete", Existing: list})
+        }
+      }
+    }
+  }
+
+  if len(bundle.DomainBlocks) > 0 || exact {
+    var current []string
+    if err := fetchAllJSON(config, "/api/v1/domain_blocks", &current); err != nil {
+      return nil, fmt.Errorf("error downloading domain blocks: %w", err)
+    }
+    blocked := make(map[string]bool)
+    for _, domain := range current {
+      blocked[strings.ToLower(domain)] = true
+    }
+    wanted := make(map[string]bool)
+    for _, domain := range bundle.DomainBlocks {
+      wanted[strings.ToLower(domain)] = true
+      if !blocked[strings.ToLower(domain)] {
+        plan.DomainBlocks = append(plan.DomainBlocks, domain)
+        blocked[strings.ToLower(domain)] = true
+      }
+    }
+    if exact {
+      for _, domain := range current {
+        if !wanted[strings.ToLower(domain)] {
+          plan.DomainUnblocks = append(plan.DomainUnblocks, domain)
+        }
+      }
+    }
+  }
+
+  return plan, nil
+}
+
+// resolveAccount finds the ID of an account on the user's instance, fetching it from its home instance if needed.
+func resolveAccount(config *MastodonConfig, acct string) (string, error) {
+  var results struct {
+    Accounts []struct {
+      ID string `json:"id"`
+    } `json:"accounts"`
+  }
+  query := url.Values{"q": {acct}, "type": {"accounts"}, "resolve": {"true"}, "limit": {"1"}}
+  if err := fetchJSON(config, "/api/v2/search?"+query.Encode(), &results); err != nil {
+    return "", err
+  }
+  if len(results.Accounts) == 0 {
+    return "", fmt.Errorf("account %s not found", acct)
+  }
+  return results.Accounts[0].ID, nil
+}
+
+// applyListChange creates or deletes a list, then follows each new account and adds it to the list and removes any others.
+func applyListChange(config *MastodonConfig, change listChange) error {
+  if change.Action == "delete" {
+    if _, err := apiRequest(config, "DELETE", "/api/v1/lists/"+change.Existing.ID, nil); err != nil {
+      return fmt.Errorf("error deleting list %q: %w", change.Existing.Title, err)
+    }
+    return nil
+  }
+
+  listID := ""
+  if change.Existing != nil {
+    listID = change.Existing.ID
+  } else {
+    payload := map[string]interface{}{"title": change.List.Title, "exclusive": change.List.Exclusive}
+    if change.List.RepliesPolicy != "" {
+      payload["replies_policy"] = change.List.RepliesPolicy
+    }
+    body, err := apiRequest(config, "POST", "/api/v1/lists", payload)
+    if err != nil {
+      return fmt.Errorf("error creating list %q: %w", change.List.Title, err)
+    }
+    var created AccountList
+    if err := json.Unmarshal(body, &created); err != nil {
+      return fmt.Errorf("error parsing created list: %w", err)
+    }
+    listID = created.ID
+  }
+
+  // Accounts must be followed before they can be added to a list.
+  for _, account := range change.AddAccounts {
+    accountID, err := resolveAccount(config, account)
+    if err != nil {
+      return err
+    }
+    if _, err := apiRequest(config, "POST", "/api/v1/accounts/"+accountID+"/follow", nil); err != nil {
+      return fmt.Errorf("error following %s: %w", account, err)
+    }
+    if _, err := apiRequest(config, "POST", "/api/v1/lists/"+listID+"/accounts", map[string]interface{}{"account_ids": []string{accountID}}); err != nil {
+      return fmt.Errorf("error adding %s to list %q: %w", account, change.List.Title, err)
+    }
+  }
+  for _, account := range change.RemoveAccounts {
+    accountID, err := resolveAccount(config, account)
+    if err != nil {
+      return err
+    }
+    if _, err := apiRequest(config, "DELETE", "/api/v1/lists/"+listID+"/accounts", map[string]interface{}{"account_ids": []string{accountID}}); err != nil {
+      return fmt.Errorf("error removing %s from list %q: %w", account, change.List.Title, err)
+    }
+  }
+
+  return nil
+}
+
+// applyBundlePlan takes a snapshot of the account, then applies every change in the plan, stopping at the first
+// that fails. It returns the changes that were made, so a failure can say what was done before it.
+func applyBundlePlan(config *MastodonConfig, plan *bundlePlan, reason string) (*bundlePlan, error) {
+  done := &bundlePlan{}
+  if plan.empty() {
+    return done, nil
+  }
+  if _, err := takeSnapshot(config, reason); err != nil {
+    return done, err
+  }
+
+  for _, change := range plan.Filters {
+    if err := applyFilterChange(config, change); err != nil {
+      return done, fmt.Errorf("error applying %s of filter %q: %w", change.Action, change.Title, err)
+    }
+    done.Filters = append(done.Filters, change)
+  }
+  for _, change := range plan.Tags {
+    if err := applyTagChange(config, change); err != nil {
+      return done, fmt.Errorf("error applying %s of tag %q: %w", change.Action, change.Name, err)
+    }
+    done.Tags = append(done.Tags, change)
+  }
+  for _, name := range plan.FeaturedTags {
+    if _, err := apiRequest(config, "POST", "/api/v1/featured_tags", map[string]interface{}{"name": name}); err != nil {
+      return done, fmt.Errorf("error featuring tag %q: %w", name, err)
+    }
+    done.FeaturedTags = append(done.FeaturedTags, name)
+  }
+  for _, tag := range plan.UnfeatureTags {
+    if _, err := apiRequest(config, "DELETE", "/api/v1/featured_tags/"+tag.ID, nil); err != nil {
+      return done, fmt.Errorf("error unfeaturing tag %q: %w", tag.Name, err)
+    }
+    done.UnfeatureTags = append(done.UnfeatureTags, tag)
+  }
+  for _, change := range plan.Lists {
+    if err := applyListChange(config, change); err != nil {
+      return done, err
+    }
+    done.Lists = append(done.Lists, change)
+  }
+  for _, domain := range plan.DomainBlocks {
+    if _, err := apiRequest(config, "POST", "/api/v1/domain_blocks", map[string]interface{}{"domain": domain}); err != nil {
+      return done, fmt.Errorf("error blocking domain %q: %w", domain, err)
+    }
+    done.DomainBlocks = append(done.DomainBlocks, domain)
+  }
+  for _, domain := range plan.DomainUnblocks {
+    if _, err := apiRequest(config, "DELETE", "/api/v1/domain_blocks", map[string]interface{}{"domain": domain}); err != nil {
+      return done, fmt.Errorf("error unblocking domain %q: %w", domain, err)
+    }
+    done.DomainUnblocks = append(done.DomainUnblocks, domain)
+  }
+  return done, nil
+}
+
+// importBundle applies a bundle from a file or URL to the account, asking the user to confirm first when interactive.
+func importBundle(config *MastodonConfig, source string, interactive bool) (*bundlePlan, error) {
+  return importSources(config, []string{source}, nil, interactive)
+}
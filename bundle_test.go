@@ -0,0 +1,75 @@
+package main
+
+import (
+  "io/ioutil"
+  "path/filepath"
+  "reflect"
+  "testing"
+)
+
+func TestWriteBundle(t *testing.T) {
+  bundle := &ListFile{
+    FormatVersion: listFormatVersion,
+    Name:          "Cycling",
+    Filters:       []*Filter{{Title: "Spoilers", Context: []string{"home"}, FilterAction: "warn", Keywords: []FilterKeyword{{Keyword: "finale", WholeWord: true}}}},
+    Tags:          []*Tag{{Name: "cycling"}, {Name: "bikes"}},
+    FeaturedTags:  []*FeaturedTag{{Name: "cycling"}},
+    Lists:         []*AccountList{{Title: "Riders", RepliesPolicy: "list", Accounts: []string{"friend@elsewhere.example"}}},
+    DomainBlocks:  []string{"spam.example"},
+  }
+
+  for _, name := range []string{"bundle.json", "bundle.yaml", "bundle.toml", "bundle.tar.gz"} {
+    t.Run(name, func(t *testing.T) {
+      file := filepath.Join(t.TempDir(), name)
+      if err := writeBundle(file, bundle); err != nil {
+        t.Fatal(err)
+      }
+      data, err := ioutil.ReadFile(file)
+      if err != nil {
+        t.Fatal(err)
+      }
+      got, err := parseBundle(name, data)
+      if err != nil {
+        t.Fatal(err)
+      }
+
+      // A tar.gz bundle is named after its file, as each section has a name of its own.
+      want := "Cycling"
+      if name == "bundle.tar.gz" {
+        want = "bundle"
+      }
+      if got.Name != want {
+        t.Errorf("name %q, want %q", got.Name, want)
+      }
+      if !reflect.DeepEqual(got.Filters, bundle.Filters) {
+        t.Errorf("filters %+v", got.Filters)
+      }
+      if !reflect.DeepEqual(got.Tags, bundle.Tags) || !reflect.DeepEqual(got.FeaturedTags, bundle.FeaturedTags) {
+        t.Errorf("tags %+v, featured tags %+v", got.Tags, got.FeaturedTags)
+      }
+      if !reflect.DeepEqual(got.Lists, bundle.Lists) || !reflect.DeepEqual(got.DomainBlocks, bundle.DomainBlocks) {
+        t.Errorf("lists %+v, domain blocks %v", got.Lists, got.DomainBlocks)
+      }
+    })
+  }
+}
+
+func TestParseBundleListFile(t *testing.T) {
+  // Any list file is a bundle, including legacy ones without a header.
+  list, err := parseBundle("tags/cycling.json", []byte(`[{"name": "cycling"}]`))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(list.Tags) != 1 || list.Tags[0].Name != "cycling" {
+    t.Errorf("tags %+v", list.Tags)
+  }
+
+  // A URL without an extension may still be YAML.
+  list, err = parseBundle("https://example.com/bundle", []byte("format_version: 2\nname: Blocks\ndomain_blocks:\n  - spam.example\n"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(list.DomainBlocks, []string{"spam.example"}) {
+    t.Errorf("domain blocks %v", list.DomainBlocks)
+  }
+}
@@ -0,0 +1,175 @@
+package main
+
+// Caching of subscription downloads, with conditional requests and SHA-256 pinning.
+
+import (
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "time"
+)
+
+// cacheMeta is stored next to a cached download and holds the validators for the next conditional request.
+type cacheMeta struct {
+  URL          string    `json:"url"`
+  ETag         string    `json:"etag,omitempty"`
+  LastModified string    `json:"last_modified,omitempty"`
+  SHA256       string    `json:"sha256"`
+  FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// cacheFilename returns the path a URL is cached at within the download directory.
+func cacheFilename(dir, url string) string {
+  // clean the URL to replace spaces with underscores, and slashes and colons with dashes
+  name := url
+  for _, prefix := range []string{"https://", "http://"} {
+    name = strings.TrimPrefix(name, prefix)
+  }
+  name = strings.NewReplacer(" ", "_", "/", "-", ":", "-", "?", "-", "&", "-").Replace(name)
+  return filepath.Join(dir, name)
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of the data.
+func sha256Hex(data []byte) string {
+  sum := sha256.Sum256(data)
+  return hex.EncodeToString(sum[:])
+}
+
+// verifyPin checks the data against a pinned SHA-256 hash, written either as bare hex or as "sha256:<hex>".
+func verifyPin(url string, data []byte, pin string) error {
+  if pin == "" {
+    return nil
+  }
+
+  want := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(pin), "sha256:"))
+  got := sha256Hex(data)
+  if got != want {
+    return fmt.Errorf("content of %s changed unexpectedly: sha256 is %s but it is pinned to %s", url, got, want)
+  }
+  return nil
+}
+
+// memoryEntry is a download kept in memory when there's no download directory, so repeated polls can still be conditional.
+type memoryEntry struct {
+  meta      cacheMeta
+  body      []byte
+  signature []byte
+}
+
+// memoryCache holds the downloads of subscriptions without a download directory, by URL, for the life of the process.
+var (
+  memoryCache   = make(map[string]memoryEntry)
+  memoryCacheMu sync.Mutex
+)
+
+// downloadCached downloads a subscription into the download directory, using a conditional request when it has been cached before.
+// If the download directory is empty the content is only cached in memory, for the daemon's next poll.
+// Content that doesn't match the pin, or isn't signed by a trusted key when there are any, is rejected and not cached.
+func downloadCached(ctx context.Context, dir, url, pin string, trusted []TrustedKey) (*conditionalResponse, error) {
+  filename := cacheFilename(dir, url)
+  metaFilename := filename + ".meta.json"
+  signatureFilename := filename + signatureSuffix
+
+  // Load the validators from the previous download, if the cached content is still there.
+  var meta cacheMeta
+  var cached, cachedSignature []byte
+  if dir == "" {
+    memoryCacheMu.Lock()
+    if entry, ok := memoryCache[url]; ok {
+      meta, cached, cachedSignature = entry.meta, entry.body, entry.signature
+    }
+    memoryCacheMu.Unlock()
+  } else if data, err := ioutil.ReadFile(filename); err == nil {
+    cached = data
+    if data, err := ioutil.ReadFile(metaFilename); err == nil {
+      if err := json.Unmarshal(data, &meta); err != nil {
+        meta = cacheMeta{}
+      }
+    }
+    if signature, err := ioutil.ReadFile(signatureFilename); err == nil {
+      cachedSignature = signature
+    }
+  }
+
+  resp, err := downloadConditional(ctx, url, meta.ETag, meta.LastModified)
+  if err != nil {
+    return nil, err
+  }
+
+  // Serve unchanged content from the cache.
+  if resp.NotModified {
+    resp.Body = cached
+    if err := verifyPin(url, resp.Body, pin); err != nil {
+      return nil, err
+    }
+    if _, err := verifyDownload(ctx, trusted, url, resp.Body, cachedSignature); err != nil {
+      return nil, err
+    }
+    return resp, nil
+  }
+
+  // Check the new content before anything is cached.
+  if err := verifyPin(url, resp.Body, pin); err != nil {
+    return nil, err
+  }
+  signature, err := verifyDownload(ctx, trusted, url, resp.Body, nil)
+  if err != nil {
+    return nil, err
+  }
+  meta = cacheMeta{
+    URL:          url,
+    ETag:         resp.ETag,
+    LastModified: resp.LastModified,
+    SHA256:       sha256Hex(resp.Body),
+    FetchedAt:    time.Now().UTC(),
+  }
+
+  if dir == "" {
+    memoryCacheMu.Lock()
+    memoryCache[url] = memoryEntry{meta: meta, body: resp.Body, signature: signature}
+    memoryCacheMu.Unlock()
+    return resp, nil
+  }
+
+  // Save the content and its validators to the download directory.
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return nil, fmt.Errorf("error creating download directory: %w", err)
+  }
+  if err := ioutil.WriteFile(filename, resp.Body, 0644); err != nil {
+    return nil, fmt.Errorf("error writing download cache: %w", err)
+  }
+  metaJSON, err := json.MarshalIndent(meta, "", "  ")
+  if err != nil {
+    return nil, fmt.Errorf("error encoding download cache metadata: %w", err)
+  }
+  if err := ioutil.WriteFile(metaFilename, metaJSON, 0644); err != nil {
+    return nil, fmt.Errorf("error writing download cache metadata: %w", err)
+  }
+  if signature != nil {
+    if err := ioutil.WriteFile(signatureFilename, signature, 0644); err != nil {
+      return nil, fmt.Errorf("error writing download cache signature: %w", err)
+    }
+  }
+
+  return resp, nil
+}
+
+// downloadDir returns the configured download directory for a kind of list. Bundles are cached with filters.
+func (config *MastodonConfig) downloadDir(kind string) string {
+  if kind == "tags" {
+    return config.TagsDownload
+  }
+  return config.FilterDownload
+}
+
+// fetchSubscription downloads a subscription through the download cache of its kind.
+func fetchSubscription(ctx context.Context, config *MastodonConfig, sub Subscription) (*conditionalResponse, error) {
+  return downloadCached(ctx, config.downloadDir(sub.Kind), sub.URL, sub.SHA256, config.TrustedKeys)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+  "context"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "testing"
+)
+
+// listServer serves a list with an ETag, answering conditional requests with 304, and counts the full downloads.
+func listServer(t *testing.T, body string) (*httptest.Server, *int) {
+  t.Helper()
+  downloads := 0
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("If-None-Match") == `"v1"` {
+      w.WriteHeader(http.StatusNotModified)
+      return
+    }
+    downloads++
+    w.Header().Set("ETag", `"v1"`)
+    w.Write([]byte(body))
+  }))
+  t.Cleanup(server.Close)
+  return server, &downloads
+}
+
+func TestDownloadCached(t *testing.T) {
+  const body = `[{"name": "golang"}]`
+  for name, dir := range map[string]string{"in memory": "", "on disk": filepath.Join(t.TempDir(), "downloads")} {
+    server, downloads := listServer(t, body)
+    url := server.URL + "/tags.json"
+
+    first, err := downloadCached(context.Background(), dir, url, "", nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    second, err := downloadCached(context.Background(), dir, url, "", nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if first.NotModified || !second.NotModified {
+      t.Errorf("%s: not modified %v then %v, want false then true", name, first.NotModified, second.NotModified)
+    }
+    if string(second.Body) != body {
+      t.Errorf("%s: cached body %q", name, second.Body)
+    }
+    if *downloads != 1 {
+      t.Errorf("%s: downloaded %d times, want 1", name, *downloads)
+    }
+  }
+}
+
+func TestDownloadCachedPin(t *testing.T) {
+  const body = `[{"name": "golang"}]`
+  server, _ := listServer(t, body)
+  dir := t.TempDir()
+
+  if _, err := downloadCached(context.Background(), dir, server.URL+"/a.json", "sha256:"+sha256Hex([]byte(body)), nil); err != nil {
+    t.Errorf("matching pin: %s", err)
+  }
+  if _, err := downloadCached(context.Background(), dir, server.URL+"/b.json", sha256Hex([]byte("something else")), nil); err == nil {
+    t.Error("mismatched pin was accepted")
+  }
+  if _, err := downloadCached(context.Background(), "", server.URL+"/c.json", sha256Hex([]byte("something else")), nil); err == nil {
+    t.Error("mismatched pin was accepted without a download directory")
+  }
+}
@@ -0,0 +1,398 @@
+package main
+
+// Catalog of the community filter and tag lists, described by a generated index.json.
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net/url"
+  "os"
+  "path"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// defaultCatalog is where the catalog index is read from when the config doesn't set catalog.
+const defaultCatalog = "https://raw.githubusercontent.com/sammcj/subscribe-o-mast/main/index.json"
+
+// catalogFormatVersion is the version of the index.json layout written by catalog generate.
+const catalogFormatVersion = 1
+
+// CatalogIndex is the index.json manifest describing the lists in the catalog.
+type CatalogIndex struct {
+  FormatVersion int            `json:"format_version"`
+  Lists         []CatalogEntry `json:"lists"`
+}
+
+// CatalogEntry describes a single list in the catalog.
+// Path is relative to the index. Description, language and maintainers are written by hand and kept when the index is regenerated.
+type CatalogEntry struct {
+  Name        string   `json:"name"`
+  Kind        string   `json:"kind"`
+  Title       string   `json:"title,omitempty"`
+  Description string   `json:"description"`
+  Path        string   `json:"path"`
+  Count       int      `json:"count"`
+  Language    string   `json:"language"`
+  Maintainers []string `json:"maintainers"`
+  Version     int      `json:"version"`
+  SHA256      string   `json:"sha256"`
+}
+
+// catalogLocation returns the configured catalog index URL or path.
+func catalogLocation(config *MastodonConfig) string {
+  if config != nil && config.Catalog != "" {
+    return config.Catalog
+  }
+  return defaultCatalog
+}
+
+// isURL reports whether a location is an http or https URL rather than a local path.
+func isURL(location string) bool {
+  return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+// loadCatalog reads the catalog index from a URL or local path.
+// A remote index must be signed when trusted keys are configured, like any other download.
+func loadCatalog(config *MastodonConfig) (*CatalogIndex, string, error) {
+  location := catalogLocation(config)
+
+  var data []byte
+  if isURL(location) {
+    var trusted []TrustedKey
+    if config != nil {
+      trusted = config.TrustedKeys
+    }
+    resp, err := downloadCached(context.Background(), "", location, "", trusted)
+    if err != nil {
+      return nil, "", fmt.Errorf("error downloading catalog: %w", err)
+    }
+    data = resp.Body
+  } else {
+    var err error
+    if data, err = ioutil.ReadFile(location); err != nil {
+      return nil, "", fmt.Errorf("error reading catalog: %w", err)
+    }
+  }
+
+  var index CatalogIndex
+  if err := json.Unmarshal(data, &index); err != nil {
+    return nil, "", fmt.Errorf("error parsing catalog %s: %w", location, err)
+  }
+  if index.FormatVersion > catalogFormatVersion {
+    return nil, "", fmt.Errorf("catalog %s has format version %d, this version of subscribe-o-mast reads up to %d", location, index.FormatVersion, catalogFormatVersion)
+  }
+
+  return &index, location, nil
+}
+
+// entryLocation resolves the path of a catalog entry against the location of the index.
+func entryLocation(location string, entry CatalogEntry) (string, error) {
+  if isURL(entry.Path) {
+    return entry.Path, nil
+  }
+  if isURL(location) {
+    base, err := url.Parse(location)
+    if err != nil {
+      return "", fmt.Errorf("invalid catalog URL: %w", err)
+    }
+    base.Path = path.Join(path.Dir(base.Path), entry.Path)
+    return base.String(), nil
+  }
+  return filepath.Join(filepath.Dir(location), filepath.FromSlash(entry.Path)), nil
+}
+
+// findCatalogEntry looks up a list in the catalog by name.
+func findCatalogEntry(index *CatalogIndex, name string) (*CatalogEntry, error) {
+  for i, entry := range index.Lists {
+    if strings.EqualFold(entry.Name, name) {
+      return &index.Lists[i], nil
+    }
+  }
+  return nil, fmt.Errorf("no list named %q in the catalog", name)
+}
+
+// printCatalogEntries prints a one line summary of each entry.
+func printCatalogEntries(entries []CatalogEntry) {
+  for _, entry := range entries {
+    unit := "keywords"
+    if entry.Kind == "tags" {
+      unit = "tags"
+    }
+    fmt.Printf("%-24s %-8s %4d %-8s %s\n", entry.Name, entry.Kind, entry.Count, unit, entry.Description)
+  }
+}
+
+// searchCatalog returns the entries whose name, title, description, language or maintainers contain the term.
+func searchCatalog(index *CatalogIndex, term string) []CatalogEntry {
+  term = strings.ToLower(term)
+
+  var matches []CatalogEntry
+  for _, entry := range index.Lists {
+    haystack := strings.ToLower(strings.Join(append([]string{entry.Name, entry.Kind, entry.Title, entry.Description, entry.Language}, entry.Maintainers...), " "))
+    if strings.Contains(haystack, term) {
+      matches = append(matches, entry)
+    }
+  }
+  return matches
+}
+
+// showCatalogEntry prints the details of an entry along with the keywords or tags in the list.
+func showCatalogEntry(config *MastodonConfig, location string, entry *CatalogEntry) error {
+  source, err := entryLocation(location, *entry)
+  if err != nil {
+    return err
+  }
+
+  fmt.Printf("Name:        %s\n", entry.Name)
+  fmt.Printf("Kind:        %s\n", entry.Kind)
+  if entry.Title != "" {
+    fmt.Printf("Title:       %s\n", entry.Title)
+  }
+  fmt.Printf("Description: %s\n", entry.Description)
+  fmt.Printf("Language:    %s\n", entry.Language)
+  fmt.Printf("Maintainers: %s\n", strings.Join(entry.Maintainers, ", "))
+  fmt.Printf("Version:     %d\n", entry.Version)
+  fmt.Printf("SHA-256:     %s\n", entry.SHA256)
+  fmt.Printf("Source:      %s\n", source)
+
+  // Fetch the list itself to show what subscribing would add.
+  var data []byte
+  if isURL(source) {
+    resp, err := downloadCached(context.Background(), config.downloadDir(entry.Kind), source, "", config.TrustedKeys)
+    if err != nil {
+      return fmt.Errorf("error downloading %s: %w", source, err)
+    }
+    data = resp.Body
+  } else if data, err = ioutil.ReadFile(source); err != nil {
+    return fmt.Errorf("error reading %s: %w", source, err)
+  }
+  if data, err = decodeDocument(documentFormat(source), data); err != nil {
+    return fmt.Errorf("error reading %s: %w", source, err)
+  }
+
+  switch entry.Kind {
+  case "filters":
+    filters, err := parseFilterList(data)
+    if err != nil {
+      return err
+    }
+    for _, filter := range filters {
+      fmt.Printf("\n%s (%s, %s)\n", filter.Title, filter.FilterAction, strings.Join(filter.Context, ", "))
+      for _, keyword := range filter.Keywords {
+        fmt.Printf("  %s\n", keyword.Keyword)
+      }
+    }
+  case "tags":
+    tags, err := parseTagList(data)
+    if err != nil {
+      return err
+    }
+    fmt.Println()
+    for _, tag := range tags {
+      fmt.Printf("  #%s\n", tag.Name)
+    }
+  }
+
+  return nil
+}
+
+// subscribeCatalogEntry adds a catalog list to the subscriptions in the config file.
+func subscribeCatalogEntry(configFile, location string, entry *CatalogEntry) error {
+  source, err := entryLocation(location, *entry)
+  if err != nil {
+    return err
+  }
+  if !isURL(source) {
+    return fmt.Errorf("%s is a local file, subscriptions need a URL", source)
+  }
+
+  if err := addSubscription(configFile, Subscription{Kind: entry.Kind, URL: source}); err != nil {
+    return err
+  }
+  fmt.Printf("Subscribed to %s (%s)\n", entry.Name, source)
+  return nil
+}
+
+// generateCatalog writes index.json for the list files in the filters/ and tags/ directories under dir.
+// Hand-written metadata from an existing index is kept, and an entry's version is bumped whenever its file changes.
+func generateCatalog(dir string) error {
+  indexFile := filepath.Join(dir, "index.json")
+
+  // Load the previous index, if there is one.
+  previous := make(map[string]CatalogEntry)
+  if data, err := ioutil.ReadFile(indexFile); err == nil {
+    var index CatalogIndex
+    if err := json.Unmarshal(data, &index); err != nil {
+      return fmt.Errorf("error parsing existing %s: %w", indexFile, err)
+    }
+    for _, entry := range index.Lists {
+      previous[entry.Path] = entry
+    }
+  }
+
+  index := CatalogIndex{FormatVersion: catalogFormatVersion}
+  for _, kind := range []string{"filters", "tags"} {
+    files, err := filepath.Glob(filepath.Join(dir, kind, "*"))
+    if err != nil {
+      return fmt.Errorf("error listing %s: %w", kind, err)
+    }
+    sort.Strings(files)
+
+    for _, file := range files {
+      if !isDocumentFile(file) {
+        continue
+      }
+      raw, err := ioutil.ReadFile(file)
+      if err != nil {
+        return fmt.Errorf("error reading %s: %w", file, err)
+      }
+      data, err := decodeDocument(documentFormat(file), raw)
+      if err != nil {
+        return fmt.Errorf("error reading %s: %w", file, err)
+      }
+
+      entry := CatalogEntry{
+        Name:        strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+        Kind:        kind,
+        Path:        kind + "/" + filepath.Base(file),
+        Language:    "en",
+        Maintainers: []string{},
+        Version:     1,
+        SHA256:      sha256Hex(raw),
+      }
+
+      // Start from the description in the list file's header, if it has one.
+      if listFileVersion(data) > 1 {
+        if list, err := parseListFile(data, kind); err == nil {
+          entry.Description = list.Description
+        }
+      }
+
+      // Count the keywords or tags in the list.
+      if kind == "filters" {
+        filters, err := parseFilterList(data)
+        if err != nil {
+          return fmt.Errorf("error reading %s: %w", file, err)
+        }
+        var titles []string
+        for _, filter := range filters {
+          titles = append(titles, filter.Title)
+          entry.Count += len(filter.Keywords)
+        }
+        entry.Title = strings.Join(titles, ", ")
+      } else {
+        tags, err := parseTagList(data)
+        if err != nil {
+          return fmt.Errorf("error reading %s: %w", file, err)
+        }
+        entry.Count = len(tags)
+      }
+
+      // Keep the hand-written metadata and bump the version if the list changed.
+      if old, ok := previous[entry.Path]; ok {
+        entry.Name = old.Name
+        if old.Description != "" {
+          entry.Description = old.Description
+        }
+        if old.Language != "" {
+          entry.Language = old.Language
+        }
+        if old.Maintainers != nil {
+          entry.Maintainers = old.Maintainers
+        }
+        entry.Version = old.Version
+        if old.SHA256 != entry.SHA256 {
+          entry.Version++
+        }
+      }
+
+      index.Lists = append(index.Lists, entry)
+    }
+  }
+
+  data, err := json.MarshalIndent(index, "", "  ")
+  if err != nil {
+    return fmt.Errorf("error encoding index: %w", err)
+  }
+  if err := ioutil.WriteFile(indexFile, append(data, '\n'), 0644); err != nil {
+    return fmt.Errorf("error writing index: %w", err)
+  }
+
+  fmt.Printf("Wrote %d lists to %s\n", len(index.Lists), indexFile)
+  return nil
+}
+
+// catalogCommand defines the catalog command's flags and returns the function that runs it.
+func catalogCommand(flags *flag.FlagSet) func(args []string) error {
+  location := flags.String("catalog", "", "the catalog index URL or path, overriding catalog in the config file")
+  dir := flags.String("dir", ".", "the directory containing filters/ and tags/, for generate")
+  return func(args []string) error {
+    if len(args) == 0 {
+      flags.Usage()
+      return fmt.Errorf("missing catalog command")
+    }
+    command := args[0]
+
+    // Generating the index is for list maintainers and doesn't need a config.
+    if command == "generate" {
+      return generateCatalog(*dir)
+    }
+
+    // The catalog location comes from the config, which doesn't need to be complete to browse.
+    config, err := readConfig(*configFile)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+      return err
+    }
+    if config == nil {
+      config = &MastodonConfig{}
+    }
+    if *location != "" {
+      config.Catalog = *location
+    }
+
+    index, indexLocation, err := loadCatalog(config)
+    if err != nil {
+      return err
+    }
+
+    switch command {
+    case "list":
+      printCatalogEntries(index.Lists)
+
+    case "search":
+      if len(args) < 2 {
+        return fmt.Errorf("missing search term")
+      }
+      matches := searchCatalog(index, strings.Join(args[1:], " "))
+      if len(matches) == 0 {
+        fmt.Println("No matching lists.")
+      }
+      printCatalogEntries(matches)
+
+    case "show", "subscribe":
+      if len(args) < 2 {
+        return fmt.Errorf("missing list name")
+      }
+      entry, err := findCatalogEntry(index, args[1])
+      if err != nil {
+        return err
+      }
+      if command == "show" {
+        return showCatalogEntry(config, indexLocation, entry)
+      }
+      return subscribeCatalogEntry(*configFile, indexLocation, entry)
+
+    default:
+      flags.Usage()
+      return fmt.Errorf("unknown catalog command %q", command)
+    }
+
+    return nil
+  }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// readCatalogIndex reads an index.json written by generateCatalog.
+func readCatalogIndex(t *testing.T, file string) *CatalogIndex {
+  t.Helper()
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var index CatalogIndex
+  if err := json.Unmarshal(data, &index); err != nil {
+    t.Fatal(err)
+  }
+  return &index
+}
+
+// writeCatalogFile writes a list file into a catalog directory.
+func writeCatalogFile(t *testing.T, dir, path, data string) {
+  t.Helper()
+  file := filepath.Join(dir, filepath.FromSlash(path))
+  if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+    t.Fatal(err)
+  }
+  if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestGenerateCatalog(t *testing.T) {
+  dir := t.TempDir()
+  writeCatalogFile(t, dir, "filters/spoilers.json", `[{"title": "Spoilers", "context": ["home"], "keywords": [{"keyword": "finale"}, {"keyword": "ending"}]}]`)
+  writeCatalogFile(t, dir, "tags/cycling.yaml", "format_version: 2\nname: Cycling\ndescription: Bikes.\ntags:\n  - name: cycling\n  - name: bikes\n")
+
+  if err := generateCatalog(dir); err != nil {
+    t.Fatal(err)
+  }
+  index := readCatalogIndex(t, filepath.Join(dir, "index.json"))
+  if len(index.Lists) != 2 {
+    t.Fatalf("%d lists in the index, want 2", len(index.Lists))
+  }
+  spoilers, tags := index.Lists[0], index.Lists[1]
+  if spoilers.Name != "spoilers" || spoilers.Kind != "filters" || spoilers.Title != "Spoilers" || spoilers.Count != 2 || spoilers.Version != 1 {
+    t.Errorf("filter entry %+v", spoilers)
+  }
+  if tags.Name != "cycling" || tags.Kind != "tags" || tags.Path != "tags/cycling.yaml" || tags.Count != 2 || tags.Description != "Bikes." {
+    t.Errorf("tag entry %+v", tags)
+  }
+
+  // Hand-written metadata is kept, and the version only goes up when the list changes.
+  index.Lists[0].Description = "Hides spoilers."
+  index.Lists[0].Maintainers = []string{"someone"}
+  data, _ := json.MarshalIndent(index, "", "  ")
+  if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644); err != nil {
+    t.Fatal(err)
+  }
+  writeCatalogFile(t, dir, "filters/spoilers.json", `[{"title": "Spoilers", "context": ["home"], "keywords": [{"keyword": "finale"}]}]`)
+  if err := generateCatalog(dir); err != nil {
+    t.Fatal(err)
+  }
+  index = readCatalogIndex(t, filepath.Join(dir, "index.json"))
+  spoilers, tags = index.Lists[0], index.Lists[1]
+  if spoilers.Description != "Hides spoilers." || len(spoilers.Maintainers) != 1 || spoilers.Count != 1 || spoilers.Version != 2 {
+    t.Errorf("filter entry after regenerating %+v", spoilers)
+  }
+  if tags.Version != 1 {
+    t.Errorf("unchanged tag list went to version %d", tags.Version)
+  }
+}
+
+// TestShippedCatalog checks that index.json is up to date with the lists in the repository.
+func TestShippedCatalog(t *testing.T) {
+  shipped := readCatalogIndex(t, "index.json")
+  for _, entry := range shipped.Lists {
+    data, err := ioutil.ReadFile(filepath.FromSlash(entry.Path))
+    if err != nil {
+      t.Errorf("%s: %v", entry.Name, err)
+      continue
+    }
+    if sha256Hex(data) != entry.SHA256 {
+      t.Errorf("%s: index.json is out of date, run catalog generate", entry.Name)
+    }
+  }
+}
+
+func TestEntryLocation(t *testing.T) {
+  tests := []struct {
+    location, path, want string
+  }{
+    {"https://example.com/lists/index.json", "filters/sportsball.json", "https://example.com/lists/filters/sportsball.json"},
+    {"https://example.com/lists/index.json", "https://other.example/tags.json", "https://other.example/tags.json"},
+    {filepath.Join("catalog", "index.json"), "tags/cycling.json", filepath.Join("catalog", "tags", "cycling.json")},
+  }
+  for _, test := range tests {
+    got, err := entryLocation(test.location, CatalogEntry{Path: test.path})
+    if err != nil {
+      t.Errorf("%s: %v", test.path, err)
+    } else if got != test.want {
+      t.Errorf("entryLocation(%q, %q) = %q, want %q", test.location, test.path, got, test.want)
+    }
+  }
+}
+
+func TestSearchCatalog(t *testing.T) {
+  index := &CatalogIndex{Lists: []CatalogEntry{
+    {Name: "sportsball", Kind: "filters", Description: "Hides posts about football."},
+    {Name: "cycling", Kind: "tags", Language: "en", Maintainers: []string{"Rider"}},
+  }}
+  for term, want := range map[string]string{"FOOTBALL": "sportsball", "rider": "cycling", "tags": "cycling"} {
+    if got := searchCatalog(index, term); len(got) != 1 || got[0].Name != want {
+      t.Errorf("searching for %q found %+v, want %s", term, got, want)
+    }
+  }
+  if got := searchCatalog(index, "knitting"); len(got) != 0 {
+    t.Errorf("searching for knitting found %+v", got)
+  }
+  if _, err := findCatalogEntry(index, "Cycling"); err != nil {
+    t.Error(err)
+  }
+}
@@ -0,0 +1,608 @@
+package main
+
+// The subcommands, their help text and shell completion.
+
+import (
+  "errors"
+  "flag"
+  "fmt"
+  "os"
+  "sort"
+  "strings"
+)
+
+// importHelp describes the sources import and sync read.
+const importHelp = `Sources may be list files or bundles, directories of them, http or https URLs, - for stdin, or catalog:<name>.
+Whether each holds filters, tags or a bundle is worked out from its contents. Give filters or tags to apply only those.
+With no sources, the filters_import and tags_import directories and URLs in the config are used.`
+
+// command is a subcommand with its own flags.
+type command struct {
+  name    string
+  args    string   // the positional arguments, for the usage line
+  summary string   // one line for the command list
+  help    string   // more detail for the command's own help, if needed
+  words   []string // the completions for its first positional argument
+  files   bool     // whether its positional arguments are files or directories
+  // setup defines the command's flags and returns the function that runs it with the positional arguments.
+  setup func(flags *flag.FlagSet) func(args []string) error
+}
+
+// commands is every subcommand, in the order they're listed in the help. It's set in init because help and completion refer to it.
+var commands []*command
+
+func init() {
+  commands = []*command{
+    {name: "export", args: "[filters|tags]", summary: "export the account's filters and followed tags to list files",
+      help: "Exports both filters and tags unless one is given.", words: []string{"filters", "tags"}, setup: exportCommand},
+    {name: "import", args: "[filters|tags] [sources...]", summary: "import list files, after confirmation",
+      help: importHelp, words: []string{"filters", "tags", "-"}, files: true, setup: importCommand},
+    {name: "sync", args: "[filters|tags] [sources...]", summary: "bring the account in line with list files, after confirmation",
+      help: importHelp, words: []string{"filters", "tags", "-"}, files: true, setup: syncCommand},
+    {name: "subscribe", args: "<url>", summary: "add a list URL to the subscriptions the daemon keeps in sync", setup: subscribeCommand},
+    {name: "unsubscribe", args: "<url>", summary: "remove a list URL from the subscriptions", setup: unsubscribeCommand},
+    {name: "daemon", summary: "keep the account in sync with the subscriptions until stopped", setup: daemonCommand},
+    {name: "filter", args: "add|edit|rm <title> | keyword add|rm <title> <keywords...>", summary: "add, edit or remove a filter and its keywords",
+      help: "Changes the filter on the account, or in a list file with -file. Values that aren't given as flags are asked for in a terminal.",
+      words: []string{"add", "edit", "rm", "keyword"}, setup: filterCommand},
+    {name: "tags", args: "follow|unfollow <tags, status URLs or @accounts...> | discover | report", summary: "follow or unfollow many tags at once, discover new ones or report on followed ones",
+      help: "Tags may be given with or without a #. A status URL stands for the hashtags in the post, and an @account for those in its recent posts.\n" +
+        "discover suggests tags from trends, followed accounts and followed tags, and adds those picked to a tag list file.\n" +
+        "report ranks followed tags by their posts in the last days, and with -unfollow offers to unfollow those with none.",
+      words: []string{"follow", "unfollow", "discover", "report"}, setup: tagsCommand},
+    {name: "catalog", args: "list|search <term>|show <name>|subscribe <name>|generate", summary: "browse and subscribe to the catalog of community lists",
+      words: []string{"list", "search", "show", "subscribe", "generate"}, setup: catalogCommand},
+    {name: "config", args: "validate|show|set <key> <value>|migrate", summary: "check, show and edit the config file",
+      words: []string{"validate", "show", "set", "migrate"}, setup: configCommand},
+    {name: "login", summary: "save an access token to the encrypted token store",
+      help: "Use the global -profile flag to save more than one account. The passphrase can be given in " + passphraseEnvVar + ".", setup: loginCommand},
+    {name: "history", args: "list|show <id>", summary: "list the snapshots taken before each import",
+      help: "Lists the snapshots taken before each import. Use rollback <id> to return the account to one.", words: []string{"list", "show"}, setup: historyCommand},
+    {name: "rollback", args: "<id>", summary: "return the account to a snapshot, see history", setup: rollbackCommand},
+    {name: "lint", args: "[files or directories...]", summary: "check list files against the schemas",
+      help: "Checks list files against the schemas in schema/, defaulting to the filters/ and tags/ directories.", files: true, setup: lintCommand},
+    {name: "sign", args: "[list files...]", summary: "sign list files, or generate a signing key", files: true, setup: signCommand},
+    {name: "migrate-files", args: "<files or directories...>", summary: "upgrade list files to the current format version", files: true, setup: migrateFilesCommand},
+    {name: "completion", args: "bash|zsh|fish", summary: "print a shell completion script",
+      help: "Load it in the current shell with e.g. source <(subscribe-o-mast completion bash), or save it where the shell looks for completions.",
+      words: []string{"bash", "zsh", "fish"}, setup: completionCommand},
+    {name: "help", args: "[command]", summary: "show help for a command", setup: helpCommand},
+  }
+  findCommand("help").words = commandNames()
+}
+
+// findCommand returns the command with the name, or nil.
+func findCommand(name string) *command {
+  for _, cmd := range commands {
+    if cmd.name == name {
+      return cmd
+    }
+  }
+  return nil
+}
+
+// flagSet returns the command's flags with its usage set, and the function that runs it.
+func (cmd *command) flagSet() (*flag.FlagSet, func(args []string) error) {
+  flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+  run := cmd.setup(flags)
+  flags.Usage = func() {
+    out := flags.Output()
+    fmt.Fprintf(out, "Usage: subscribe-o-mast [global flags] %s", cmd.name)
+    hasFlags := false
+    flags.VisitAll(func(*flag.Flag) { hasFlags = true })
+    if hasFlags {
+      fmt.Fprint(out, " [flags]")
+    }
+    if cmd.args != "" {
+      fmt.Fprint(out, " "+cmd.args)
+    }
+    fmt.Fprintf(out, "\n\n%s%s.\n", strings.ToUpper(cmd.summary[:1]), cmd.summary[1:])
+    if cmd.help != "" {
+      fmt.Fprintln(out, cmd.help)
+    }
+    if hasFlags {
+      fmt.Fprintln(out, "\nFlags:")
+      flags.PrintDefaults()
+    }
+  }
+  return flags, run
+}
+
+// parseInterspersed parses the flags wherever they are among the positional arguments, which the flag package stops at.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+  var positional []string
+  for {
+    if err := flags.Parse(args); err != nil {
+      return nil, err
+    }
+    rest := flags.Args()
+    // Everything after -- is positional.
+    if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+      return append(positional, rest...), nil
+    }
+    if len(rest) == 0 {
+      return positional, nil
+    }
+    positional = append(positional, rest[0])
+    args = rest[1:]
+  }
+}
+
+// legacyArgs rewrites the old "filters import" style of arguments to "import filters", so existing scripts keep working.
+func legacyArgs(args []string) []string {
+  if len(args) == 0 || (args[0] != "filters" && args[0] != "tags") {
+    return args
+  }
+  for i, arg := range args {
+    if arg == "export" || arg == "import" || arg == "sync" {
+      rewritten := append([]string{arg}, args[:i]...)
+      rewritten = append(rewritten, args[i+1:]...)
+      fmt.Fprintf(os.Stderr, "note: %q is deprecated, use subscribe-o-mast %s\n", strings.Join(args, " "), strings.Join(rewritten, " "))
+      return rewritten
+    }
+  }
+  return args
+}
+
+// runCommand runs the command named by the first argument and returns the exit code.
+// With no arguments the interactive menu is shown.
+func runCommand(args []string) int {
+  if len(args) == 0 {
+    args = []string{"menu"}
+  }
+  args = legacyArgs(args)
+
+  var err error
+  if args[0] == "menu" {
+    err = runMenu()
+  } else {
+    cmd := findCommand(args[0])
+    if cmd == nil {
+      printError(args[0], fmt.Errorf("unknown command %q, see subscribe-o-mast help", args[0]))
+      return 2
+    }
+
+    flags, run := cmd.flagSet()
+    positional, parseErr := parseInterspersed(flags, args[1:])
+    if errors.Is(parseErr, flag.ErrHelp) {
+      writeResult(cmd.name, nil)
+      return 0
+    }
+    if parseErr != nil {
+      // The flag package has already printed the problem and the usage, so only JSON output needs it.
+      if jsonResult != nil {
+        writeResult(cmd.name, parseErr)
+      }
+      return 2
+    }
+    err = run(positional)
+  }
+
+  if err != nil {
+    printError(args[0], err)
+    return 1
+  }
+  writeResult(args[0], nil)
+  return 0
+}
+
+// printUsage prints the global flags and the list of commands.
+func printUsage() {
+  out := flag.CommandLine.Output()
+  fmt.Fprintln(out, "Usage: subscribe-o-mast [global flags] <command> [flags] [arguments]")
+  fmt.Fprintln(out, "\nWith no command, a menu of the common actions is shown.")
+  fmt.Fprintln(out, "\nCommands:")
+  for _, cmd := range commands {
+    fmt.Fprintf(out, "  %-14s %s\n", cmd.name, cmd.summary)
+  }
+  fmt.Fprintln(out, "\nGlobal flags:")
+  flag.PrintDefaults()
+  fmt.Fprintln(out, "\nRun subscribe-o-mast help <command> for a command's flags.")
+}
+
+// helpCommand returns the function that runs the help command.
+func helpCommand(flags *flag.FlagSet) func(args []string) error {
+  return func(args []string) error {
+    if len(args) == 0 {
+      flag.CommandLine.SetOutput(os.Stdout)
+      printUsage()
+      return nil
+    }
+    cmd := findCommand(args[0])
+    if cmd == nil {
+      return fmt.Errorf("unknown command %q", args[0])
+    }
+    cmdFlags, _ := cmd.flagSet()
+    cmdFlags.SetOutput(os.Stdout)
+    cmdFlags.Usage()
+    return nil
+  }
+}
+
+// loadAccount loads the config for commands that talk to the account, generating a template config file if there isn't one.
+func loadAccount() (*MastodonConfig, error) {
+  if err := generateConfig(*configFile); err != nil {
+    return nil, fmt.Errorf("error generating config file: %w", err)
+  }
+  config, err := loadConfig(*configFile)
+  if err != nil {
+    return nil, fmt.Errorf("error loading configuration: %w", err)
+  }
+  return config, nil
+}
+
+// listKinds checks the positional arguments are list kinds, defaulting to the kinds configured reports true for.
+func listKinds(args []string, configured func(kind string) bool) ([]string, error) {
+  for _, kind := range args {
+    if kind != "filters" && kind != "tags" {
+      return nil, fmt.Errorf("unknown list kind %q, expected filters or tags", kind)
+    }
+  }
+  if len(args) > 0 {
+    return args, nil
+  }
+
+  var kinds []string
+  for _, kind := range []string{"filters", "tags"} {
+    if configured(kind) {
+      kinds = append(kinds, kind)
+    }
+  }
+  if len(kinds) == 0 {
+    return nil, fmt.Errorf("no filters or tags to import are configured, see filters_import and tags_import")
+  }
+  return kinds, nil
+}
+
+// importConfigured reports whether there's an import directory or URL for the list kind.
+func (config *MastodonConfig) importConfigured(kind string) bool {
+  if kind == "filters" {
+    return config.FilterImport != "" || config.FilterURL != ""
+  }
+  return config.TagsImport != "" || config.TagsURL != ""
+}
+
+// exportCommand defines the export command's flags and returns the function that runs it.
+func exportCommand(flags *flag.FlagSet) func(args []string) error {
+  format := flags.String("format", "", "the format to export list files in: json, yaml or toml (default export_format, or json)")
+  bundle := flags.String("bundle", "", "export everything to this single bundle file instead")
+  return func(args []string) error {
+    kinds, err := listKinds(args, func(string) bool { return true })
+    if err != nil {
+      return err
+    }
+    config, err := loadAccount()
+    if err != nil {
+      return err
+    }
+    if *format != "" {
+      config.ExportFormat = *format
+    }
+
+    if *bundle != "" {
+      if len(args) > 0 {
+        return fmt.Errorf("-bundle exports everything, it can't be used with %s", strings.Join(args, " "))
+      }
+      return exportBundle(config, *bundle)
+    }
+
+    for _, kind := range kinds {
+      if kind == "filters" {
+        err = exportFilters(config)
+      } else {
+        err = exportTags(config)
+      }
+      if err != nil {
+        return fmt.Errorf("error exporting %s: %w", kind, err)
+      }
+    }
+    return nil
+  }
+}
+
+// splitSources separates the list kinds among the positional arguments from the sources to read lists from.
+func splitSources(args []string) (kinds, sources []string) {
+  for _, arg := range args {
+    if arg == "filters" || arg == "tags" {
+      kinds = append(kinds, arg)
+    } else {
+      sources = append(sources, arg)
+    }
+  }
+  return kinds, sources
+}
+
+// applySources applies the lists in the sources, asking first unless yes is set.
+func applySources(config *MastodonConfig, sources, kinds []string, yes bool) error {
+  for _, source := range sources {
+    if source == "-" && !yes {
+      return fmt.Errorf("reading a list from stdin needs -yes, since the changes can't be confirmed")
+    }
+  }
+
+  plan, err := importSources(config, sources, kinds, !yes)
+  if err != nil {
+    return err
+  }
+  if yes {
+    if plan.empty() {
+      fmt.Println("The account already matches " + strings.Join(sources, ", ") + ".")
+    } else {
+      plan.print()
+    }
+  }
+  return nil
+}
+
+// importCommand defines the import command's flags and returns the function that runs it.
+func importCommand(flags *flag.FlagSet) func(args []string) error {
+  bundle := flags.String("bundle", "", "apply this bundle file or URL, the same as giving it as a source")
+  yes := flags.Bool("yes", false, "apply the changes from sources without asking, needed to read from stdin")
+  return func(args []string) error {
+    config, err := loadAccount()
+    if err != nil {
+      return err
+    }
+
+    kinds, sources := splitSources(args)
+    if *bundle != "" {
+      sources = append(sources, *bundle)
+    }
+    if len(sources) > 0 {
+      return applySources(config, sources, kinds, *yes)
+    }
+    if *yes {
+      return fmt.Errorf("-yes needs a source, importing from filters_import and tags_import always asks")
+    }
+
+    kinds, err = listKinds(kinds, config.importConfigured)
+    if err != nil {
+      return err
+    }
+    for _, kind := range kinds {
+      if kind == "filters" {
+        err = importFilters(config)
+      } else {
+        err = importTags(config)
+      }
+      if err != nil {
+        return fmt.Errorf("error importing %s: %w", kind, err)
+      }
+    }
+    return nil
+  }
+}
+
+// syncCommand defines the sync command's flags and returns the function that runs it.
+func syncCommand(flags *flag.FlagSet) func(args []string) error {
+  yes := flags.Bool("yes", false, "apply the changes without asking, needed to read from stdin")
+  return func(args []string) error {
+    config, err := loadAccount()
+    if err != nil {
+      return err
+    }
+
+    kinds, sources := splitSources(args)
+    if len(sources) > 0 {
+      return applySources(config, sources, kinds, *yes)
+    }
+
+    kinds, err = listKinds(kinds, config.importConfigured)
+    if err != nil {
+      return err
+    }
+    for _, kind := range kinds {
+      if kind == "filters" {
+        err = syncFilters(config, !*yes)
+      } else {
+        err = syncTags(config, !*yes)
+      }
+      if err != nil {
+        return fmt.Errorf("error syncing %s: %w", kind, err)
+      }
+    }
+    return nil
+  }
+}
+
+// completionCommand returns the function that runs the completion command.
+func completionCommand(flags *flag.FlagSet) func(args []string) error {
+  return func(args []string) error {
+    if len(args) != 1 {
+      return fmt.Errorf("missing shell, expected bash, zsh or fish")
+    }
+    switch args[0] {
+    case "bash":
+      fmt.Print(bashCompletion())
+    case "zsh":
+      fmt.Print(zshCompletion())
+    case "fish":
+      fmt.Print(fishCompletion())
+    default:
+      return fmt.Errorf("unknown shell %q, expected bash, zsh or fish", args[0])
+    }
+    return nil
+  }
+}
+
+// completionFlag is a flag as offered by shell completion.
+type completionFlag struct {
+  name   string
+  usage  string
+  isBool bool
+}
+
+// completionFlags returns the flags in the set, sorted by name.
+func completionFlags(flags *flag.FlagSet) []completionFlag {
+  var found []completionFlag
+  flags.VisitAll(func(f *flag.Flag) {
+    b, ok := f.Value.(interface{ IsBoolFlag() bool })
+    found = append(found, completionFlag{name: f.Name, usage: f.Usage, isBool: ok && b.IsBoolFlag()})
+  })
+  sort.Slice(found, func(i, j int) bool { return found[i].name < found[j].name })
+  return found
+}
+
+// globalValueFlags returns the global flags that take a value, as a shell case pattern.
+func globalValueFlags() string {
+  var names []string
+  for _, f := range completionFlags(flag.CommandLine) {
+    if !f.isBool {
+      names = append(names, "-"+f.name, "--"+f.name)
+    }
+  }
+  return strings.Join(names, "|")
+}
+
+// commandNames returns the names of every command.
+func commandNames() []string {
+  var names []string
+  for _, cmd := range commands {
+    names = append(names, cmd.name)
+  }
+  return names
+}
+
+// commandWords returns the command's flags and positional completions.
+func commandWords(cmd *command) []string {
+  flags, _ := cmd.flagSet()
+  var words []string
+  for _, f := range completionFlags(flags) {
+    words = append(words, "-"+f.name)
+  }
+  return append(words, cmd.words...)
+}
+
+// bashCompletion returns the bash completion script.
+func bashCompletion() string {
+  var b strings.Builder
+  b.WriteString("# bash completion for subscribe-o-mast, generated by subscribe-o-mast completion bash\n")
+  b.WriteString("_subscribe_o_mast() {\n")
+  b.WriteString("  local cur=${COMP_WORDS[COMP_CWORD]} cmd= i\n")
+  b.WriteString("  for ((i = 1; i < COMP_CWORD; i++)); do\n")
+  b.WriteString("    case ${COMP_WORDS[i]} in\n")
+  fmt.Fprintf(&b, "      %s) ((i++)) ;;\n", globalValueFlags())
+  b.WriteString("      -*) ;;\n")
+  b.WriteString("      *) cmd=${COMP_WORDS[i]}; break ;;\n")
+  b.WriteString("    esac\n")
+  b.WriteString("  done\n\n")
+  b.WriteString("  local words\n")
+  b.WriteString("  case $cmd in\n")
+  var globals []string
+  for _, f := range completionFlags(flag.CommandLine) {
+    globals = append(globals, "-"+f.name)
+  }
+  fmt.Fprintf(&b, "    \"\") words=\"%s\" ;;\n", strings.Join(append(globals, commandNames()...), " "))
+  for _, cmd := range commands {
+    fmt.Fprintf(&b, "    %s) words=\"%s\" ;;\n", cmd.name, strings.Join(commandWords(cmd), " "))
+  }
+  b.WriteString("  esac\n")
+  b.WriteString("  COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+  b.WriteString("}\n")
+  b.WriteString("complete -o default -F _subscribe_o_mast subscribe-o-mast\n")
+  return b.String()
+}
+
+// zshQuote escapes a description for _describe in single quotes.
+func zshQuote(s string) string {
+  return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// zshCompletion returns the zsh completion script.
+func zshCompletion() string {
+  var b strings.Builder
+  b.WriteString("#compdef subscribe-o-mast\n")
+  b.WriteString("# zsh completion for subscribe-o-mast, generated by subscribe-o-mast completion zsh\n")
+  b.WriteString("_subscribe_o_mast() {\n")
+  b.WriteString("  local cmd i\n")
+  b.WriteString("  for ((i = 2; i < CURRENT; i++)); do\n")
+  b.WriteString("    case ${words[i]} in\n")
+  fmt.Fprintf(&b, "      %s) ((i++)) ;;\n", globalValueFlags())
+  b.WriteString("      -*) ;;\n")
+  b.WriteString("      *) cmd=${words[i]}; break ;;\n")
+  b.WriteString("    esac\n")
+  b.WriteString("  done\n\n")
+  b.WriteString("  if [[ -z $cmd ]]; then\n")
+  b.WriteString("    local -a commands flags\n")
+  b.WriteString("    commands=(\n")
+  for _, cmd := range commands {
+    fmt.Fprintf(&b, "      '%s:%s'\n", cmd.name, zshQuote(cmd.summary))
+  }
+  b.WriteString("    )\n")
+  b.WriteString("    flags=(\n")
+  for _, f := range completionFlags(flag.CommandLine) {
+    fmt.Fprintf(&b, "      '-%s:%s'\n", f.name, zshQuote(f.usage))
+  }
+  b.WriteString("    )\n")
+  b.WriteString("    if [[ $PREFIX == -* ]]; then\n")
+  b.WriteString("      _describe -t flags 'global flag' flags\n")
+  b.WriteString("    else\n")
+  b.WriteString("      _describe -t commands 'command' commands\n")
+  b.WriteString("    fi\n")
+  b.WriteString("    return\n")
+  b.WriteString("  fi\n\n")
+  b.WriteString("  case $cmd in\n")
+  for _, cmd := range commands {
+    flags, _ := cmd.flagSet()
+    var specs []string
+    for _, f := range completionFlags(flags) {
+      specs = append(specs, fmt.Sprintf("'-%s:%s'", f.name, zshQuote(f.usage)))
+    }
+    fmt.Fprintf(&b, "    %s)\n", cmd.name)
+    if len(specs) > 0 {
+      fmt.Fprintf(&b, "      local -a flags=(%s)\n", strings.Join(specs, " "))
+      b.WriteString("      [[ $PREFIX == -* ]] && _describe -t flags 'flag' flags\n")
+    }
+    if len(cmd.words) > 0 {
+      fmt.Fprintf(&b, "      compadd -- %s\n", strings.Join(cmd.words, " "))
+    }
+    if cmd.files {
+      b.WriteString("      _files\n")
+    }
+    b.WriteString("      ;;\n")
+  }
+  b.WriteString("  esac\n")
+  b.WriteString("}\n")
+  b.WriteString("compdef _subscribe_o_mast subscribe-o-mast\n")
+  return b.String()
+}
+
+// fishQuote quotes a string for fish.
+func fishQuote(s string) string {
+  return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// fishCompletion returns the fish completion script.
+func fishCompletion() string {
+  var b strings.Builder
+  b.WriteString("# fish completion for subscribe-o-mast, generated by subscribe-o-mast completion fish\n")
+  b.WriteString("complete -c subscribe-o-mast -f\n")
+  for _, f := range completionFlags(flag.CommandLine) {
+    requires := ""
+    if !f.isBool {
+      requires = " -r"
+    }
+    fmt.Fprintf(&b, "complete -c subscribe-o-mast -n __fish_use_subcommand -o %s%s -d %s\n", f.name, requires, fishQuote(f.usage))
+  }
+  for _, cmd := range commands {
+    fmt.Fprintf(&b, "complete -c subscribe-o-mast -n __fish_use_subcommand -a %s -d %s\n", cmd.name, fishQuote(cmd.summary))
+  }
+  for _, cmd := range commands {
+    condition := fishQuote("__fish_seen_subcommand_from " + cmd.name)
+    flags, _ := cmd.flagSet()
+    for _, f := range completionFlags(flags) {
+      requires := ""
+      if !f.isBool {
+        requires = " -r"
+      }
+      fmt.Fprintf(&b, "complete -c subscribe-o-mast -n %s -o %s%s -d %s\n", condition, f.name, requires, fishQuote(f.usage))
+    }
+    if len(cmd.words) > 0 {
+      fmt.Fprintf(&b, "complete -c subscribe-o-mast -n %s -a %s\n", condition, fishQuote(strings.Join(cmd.words, " ")))
+    }
+    if cmd.files {
+      fmt.Fprintf(&b, "complete -c subscribe-o-mast -n %s -F\n", condition)
+    }
+  }
+  return b.String()
+}
@@ -0,0 +1,970 @@
+package main
+
+// Editing YAML and TOML documents in place, so their comments and layout are kept wherever the values didn't change.
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "os"
+  "reflect"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+
+  "github.com/BurntSushi/toml"
+  "gopkg.in/yaml.v3"
+)
+
+// updateDocument returns the JSON data in the format of the file, editing the original document so the comments
+// and layout of a YAML or TOML file are kept. JSON, and files that are empty or new, are encoded in full.
+func updateDocument(file string, original, data []byte) ([]byte, error) {
+  format := documentFormat(file)
+  if len(bytes.TrimSpace(original)) == 0 || (format != "yaml" && format != "toml") {
+    return encodeDocument(format, data)
+  }
+
+  var edited []byte
+  var err error
+  if format == "yaml" {
+    edited, err = mergeYAML(original, data)
+  } else {
+    edited, err = mergeTOML(original, data)
+  }
+  return checkEdit(file, edited, err, data)
+}
+
+// migrateDocument wraps the payload of a legacy list file in the header of the current format,
+// keeping the comments of a YAML or TOML file.
+func migrateDocument(file string, original []byte, name, kind string) ([]byte, error) {
+  format := documentFormat(file)
+  data, err := decodeDocument(format, original)
+  if err != nil {
+    return nil, err
+  }
+
+  // Keep the payload as raw JSON so nothing in it is lost or reordered.
+  var payload interface{}
+  if err := json.Unmarshal(data, &payload); err != nil {
+    return nil, err
+  }
+  if _, isArray := payload.([]interface{}); !isArray {
+    payload = []interface{}{payload}
+  }
+  migrated, err := marshalListFile(name, kind, payload)
+  if err != nil {
+    return nil, err
+  }
+
+  switch format {
+  case "yaml":
+    edited, err := migrateYAML(original, migrated, kind)
+    return checkEdit(file, edited, err, migrated)
+  case "toml":
+    edited, err := migrateTOML(original, migrated, kind)
+    return checkEdit(file, edited, err, migrated)
+  }
+  return encodeDocument(format, migrated)
+}
+
+// checkEdit makes sure an edited document holds the JSON data, or else encodes the data in full,
+// telling the user the file's comments couldn't be kept.
+func checkEdit(file string, edited []byte, err error, data []byte) ([]byte, error) {
+  format := documentFormat(file)
+  if err == nil {
+    if decoded, err := decodeDocument(format, edited); err == nil && sameJSON(decoded, data) {
+      return edited, nil
+    }
+  }
+  fmt.Fprintf(os.Stderr, "note: %s is written again in full, so its comments aren't kept\n", file)
+  return encodeDocument(format, data)
+}
+
+// sameJSON reports whether two JSON documents hold the same value, ignoring nulls, which TOML can't hold.
+func sameJSON(a, b []byte) bool {
+  x, err := normalizeJSON(a)
+  if err != nil {
+    return false
+  }
+  y, err := normalizeJSON(b)
+  return err == nil && reflect.DeepEqual(x, y)
+}
+
+// normalizeJSON decodes JSON into values that compare equal whichever format they came from.
+func normalizeJSON(data []byte) (interface{}, error) {
+  decoder := json.NewDecoder(bytes.NewReader(data))
+  decoder.UseNumber()
+  var value interface{}
+  if err := decoder.Decode(&value); err != nil {
+    return nil, err
+  }
+  return tomlValue(value), nil
+}
+
+// normalizeValue converts a decoded YAML or TOML value into the form normalizeJSON gives.
+func normalizeValue(value interface{}) (interface{}, error) {
+  data, err := json.Marshal(value)
+  if err != nil {
+    return nil, err
+  }
+  return normalizeJSON(data)
+}
+
+// matchItems pairs each new array item with an old one, first an equal item and then one with the same
+// title, name, keyword or URL, so an edited item keeps its comments. Unmatched items get -1.
+func matchItems(old, updated []interface{}) []int {
+  matched := make([]int, len(updated))
+  for j := range matched {
+    matched[j] = -1
+  }
+  used := make([]bool, len(old))
+  for _, same := range []func(a, b interface{}) bool{reflect.DeepEqual, sameIdentity} {
+    for j, item := range updated {
+      if matched[j] >= 0 {
+        continue
+      }
+      for i, candidate := range old {
+        if !used[i] && same(candidate, item) {
+          matched[j], used[i] = i, true
+          break
+        }
+      }
+    }
+  }
+  return matched
+}
+
+// sameIdentity reports whether two objects are the same filter, tag, keyword or subscription.
+func sameIdentity(a, b interface{}) bool {
+  x, ok := a.(map[string]interface{})
+  if !ok {
+    return false
+  }
+  y, ok := b.(map[string]interface{})
+  if !ok {
+    return false
+  }
+  for _, key := range []string{"title", "name", "keyword", "url"} {
+    if value, ok := x[key].(string); ok && value != "" {
+      return value == y[key]
+    }
+  }
+  return false
+}
+
+// parseYAMLDocument parses a YAML document into its node tree, with its comments.
+func parseYAMLDocument(data []byte) (*yaml.Node, error) {
+  var document yaml.Node
+  if err := yaml.Unmarshal(data, &document); err != nil {
+    return nil, fmt.Errorf("error parsing YAML: %w", err)
+  }
+  return &document, nil
+}
+
+// yamlNodeFromJSON converts a JSON document to a YAML node, keeping the order of object keys.
+func yamlNodeFromJSON(data []byte) (*yaml.Node, error) {
+  decoder := json.NewDecoder(bytes.NewReader(data))
+  decoder.UseNumber()
+  node, err := jsonToYAMLNode(decoder)
+  if err != nil {
+    return nil, fmt.Errorf("error converting to YAML: %w", err)
+  }
+  return node, nil
+}
+
+// encodeYAMLNode encodes a YAML node tree with two space indentation.
+func encodeYAMLNode(node *yaml.Node) ([]byte, error) {
+  var out bytes.Buffer
+  encoder := yaml.NewEncoder(&out)
+  encoder.SetIndent(2)
+  if err := encoder.Encode(node); err != nil {
+    return nil, fmt.Errorf("error encoding YAML: %w", err)
+  }
+  return out.Bytes(), nil
+}
+
+// mergeYAML returns the YAML document with the value of the JSON data, reusing the nodes of the original wherever possible.
+func mergeYAML(original, data []byte) ([]byte, error) {
+  document, err := parseYAMLDocument(original)
+  if err != nil {
+    return nil, err
+  }
+  updated, err := yamlNodeFromJSON(data)
+  if err != nil {
+    return nil, err
+  }
+  if len(document.Content) == 0 {
+    return encodeYAMLNode(updated)
+  }
+  document.Content[0] = reuseYAMLNode(document.Content[0], updated)
+  return encodeYAMLNode(document)
+}
+
+// migrateYAML moves the payload of a legacy YAML list file, with its comments, into the migrated document.
+func migrateYAML(original, migrated []byte, kind string) ([]byte, error) {
+  document, err := parseYAMLDocument(original)
+  if err != nil {
+    return nil, err
+  }
+  updated, err := yamlNodeFromJSON(migrated)
+  if err != nil {
+    return nil, err
+  }
+  if len(document.Content) == 0 {
+    return encodeYAMLNode(updated)
+  }
+
+  // The old document is the list of the given kind, or its only item. Its own comment now heads the file.
+  old := document.Content[0]
+  updated.HeadComment, old.HeadComment = old.HeadComment, ""
+  items := old
+  if items.Kind != yaml.SequenceNode {
+    items = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{old}}
+  }
+  if i := yamlKeyIndex(updated, kind); i >= 0 {
+    updated.Content[i+1] = reuseYAMLNode(items, updated.Content[i+1])
+  }
+  document.Content[0] = updated
+  return encodeYAMLNode(document)
+}
+
+// reuseYAMLNode returns the old node if it holds the same value as the updated one,
+// or else the updated node with the old node's unchanged children and comments.
+func reuseYAMLNode(old, updated *yaml.Node) *yaml.Node {
+  if sameYAMLValue(old, updated) {
+    return old
+  }
+
+  switch {
+  case old.Kind == yaml.MappingNode && updated.Kind == yaml.MappingNode:
+    // Keys stay in their old order, with new keys after the key before them in the updated mapping.
+    var content []*yaml.Node
+    for i := 0; i+1 < len(old.Content); i += 2 {
+      if j := yamlKeyIndex(updated, old.Content[i].Value); j >= 0 {
+        content = append(content, old.Content[i], reuseYAMLNode(old.Content[i+1], updated.Content[j+1]))
+      }
+    }
+    for j := 0; j+1 < len(updated.Content); j += 2 {
+      if yamlKeyIndex(old, updated.Content[j].Value) >= 0 {
+        continue
+      }
+      at := 0
+      if j > 0 {
+        at = yamlKeyIndex(&yaml.Node{Content: content}, updated.Content[j-2].Value) + 2
+      }
+      content = append(content[:at], append([]*yaml.Node{updated.Content[j], updated.Content[j+1]}, content[at:]...)...)
+    }
+    old.Content = content
+    return old
+
+  case old.Kind == yaml.SequenceNode && updated.Kind == yaml.SequenceNode:
+    matched := matchItems(yamlValues(old.Content), yamlValues(updated.Content))
+    content := make([]*yaml.Node, len(updated.Content))
+    for j, item := range updated.Content {
+      if i := matched[j]; i >= 0 {
+        item = reuseYAMLNode(old.Content[i], item)
+      }
+      content[j] = item
+    }
+    old.Content = content
+    return old
+  }
+
+  // A changed value keeps the comments of the one it replaces.
+  updated.HeadComment, updated.LineComment, updated.FootComment = old.HeadComment, old.LineComment, old.FootComment
+  return updated
+}
+
+// yamlKeyIndex returns the index of a key in a mapping node's content, or -1.
+func yamlKeyIndex(mapping *yaml.Node, key string) int {
+  for i := 0; i+1 < len(mapping.Content); i += 2 {
+    if mapping.Content[i].Value == key {
+      return i
+    }
+  }
+  return -1
+}
+
+// yamlValue decodes a YAML node, or returns nil if it can't be.
+func yamlValue(node *yaml.Node) interface{} {
+  var value interface{}
+  if err := decodeYAML(node, &value); err != nil {
+    return nil
+  }
+  normalized, err := normalizeValue(value)
+  if err != nil {
+    return nil
+  }
+  return normalized
+}
+
+// yamlValues decodes each of the nodes.
+func yamlValues(nodes []*yaml.Node) []interface{} {
+  values := make([]interface{}, len(nodes))
+  for i, node := range nodes {
+    values[i] = yamlValue(node)
+  }
+  return values
+}
+
+// sameYAMLValue reports whether two YAML nodes hold the same value.
+func sameYAMLValue(a, b *yaml.Node) bool {
+  x, y := yamlValue(a), yamlValue(b)
+  return x != nil && y != nil && reflect.DeepEqual(x, y)
+}
+
+// Kinds of TOML statements.
+const (
+  tomlBlank = iota
+  tomlComment
+  tomlKeyValue
+  tomlTable
+  tomlArrayTable
+)
+
+// tomlStatement is a line of a TOML document, or several for a multi-line value.
+type tomlStatement struct {
+  kind       int
+  start, end int // the byte range, including the line's comment and newline
+  key        []string
+}
+
+// tomlScanError reports TOML the scanner doesn't understand.
+func tomlScanError(data []byte, i int) error {
+  return fmt.Errorf("error scanning TOML at line %d", bytes.Count(data[:i], []byte("\n"))+1)
+}
+
+// scanTOML splits a TOML document into its statements.
+func scanTOML(data []byte) ([]tomlStatement, error) {
+  var statements []tomlStatement
+  for i := 0; i < len(data); {
+    s := tomlStatement{start: i}
+    j := skipTOMLSpace(data, i)
+    var err error
+    switch {
+    case j >= len(data) || data[j] == '\n' || data[j] == '\r':
+      s.kind = tomlBlank
+    case data[j] == '#':
+      s.kind = tomlComment
+    case data[j] == '[':
+      s.kind = tomlTable
+      j++
+      if j < len(data) && data[j] == '[' {
+        s.kind = tomlArrayTable
+        j++
+      }
+      if s.key, j, err = scanTOMLKey(data, j); err != nil {
+        return nil, err
+      }
+    default:
+      s.kind = tomlKeyValue
+      if s.key, j, err = scanTOMLKey(data, j); err != nil {
+        return nil, err
+      }
+      if j >= len(data) || data[j] != '=' {
+        return nil, tomlScanError(data, j)
+      }
+      if j, err = scanTOMLValue(data, skipTOMLSpace(data, j+1)); err != nil {
+        return nil, err
+      }
+    }
+
+    // The statement runs to the end of its last line, taking in any comment.
+    s.end = len(data)
+    if k := bytes.IndexByte(data[j:], '\n'); k >= 0 {
+      s.end = j + k + 1
+    }
+    statements = append(statements, s)
+    i = s.end
+  }
+  return statements, nil
+}
+
+// skipTOMLSpace returns the index of the first byte from i that isn't a space or tab.
+func skipTOMLSpace(data []byte, i int) int {
+  for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+    i++
+  }
+  return i
+}
+
+// scanTOMLKey reads a dotted key, returning its parts and the index after it and any space.
+func scanTOMLKey(data []byte, i int) ([]string, int, error) {
+  var key []string
+  for {
+    i = skipTOMLSpace(data, i)
+    if i >= len(data) {
+      return nil, i, tomlScanError(data, i)
+    }
+    if data[i] == '"' || data[i] == '\'' {
+      end, err := scanTOMLString(data, i)
+      if err != nil {
+        return nil, i, err
+      }
+      part := string(data[i+1 : end-1])
+      if data[i] == '"' {
+        json.Unmarshal(data[i:end], &part)
+      }
+      key, i = append(key, part), end
+    } else {
+      start := i
+      for i < len(data) && bareTOMLKey.Match(data[i:i+1]) {
+        i++
+      }
+      if i == start {
+        return nil, i, tomlScanError(data, i)
+      }
+      key = append(key, string(data[start:i]))
+    }
+
+    i = skipTOMLSpace(data, i)
+    if i >= len(data) || data[i] != '.' {
+      return key, i, nil
+    }
+    i++
+  }
+}
+
+// bareTOMLKey matches the characters of a key that needs no quotes.
+var bareTOMLKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// scanTOMLString returns the index after the string starting at i, which may be basic, literal or multi-line.
+func scanTOMLString(data []byte, i int) (int, error) {
+  quote := data[i]
+  delimiter := []byte{quote, quote, quote}
+  if bytes.HasPrefix(data[i:], delimiter) {
+    for j := i + 3; j < len(data); j++ {
+      if quote == '"' && data[j] == '\\' {
+        j++
+        continue
+      }
+      if bytes.HasPrefix(data[j:], delimiter) {
+        // Up to two more quotes belong to the string.
+        end := j + 3
+        for end < len(data) && end < j+5 && data[end] == quote {
+          end++
+        }
+        return end, nil
+      }
+    }
+    return 0, tomlScanError(data, i)
+  }
+
+  for j := i + 1; j < len(data); j++ {
+    switch data[j] {
+    case '\\':
+      if quote == '"' {
+        j++
+      }
+    case quote:
+      return j + 1, nil
+    case '\n':
+      return 0, tomlScanError(data, i)
+    }
+  }
+  return 0, tomlScanError(data, i)
+}
+
+// scanTOMLValue returns the index after the value starting at i, which may be an array or inline table over several lines.
+func scanTOMLValue(data []byte, i int) (int, error) {
+  if i >= len(data) {
+    return 0, tomlScanError(data, i)
+  }
+  switch data[i] {
+  case '"', '\'':
+    return scanTOMLString(data, i)
+  case '[', '{':
+    closing := byte(']')
+    if data[i] == '{' {
+      closing = '}'
+    }
+    for j := i + 1; j < len(data); {
+      switch data[j] {
+      case closing:
+        return j + 1, nil
+      case '#':
+        if k := bytes.IndexByte(data[j:], '\n'); k >= 0 {
+          j += k
+        } else {
+          j = len(data)
+        }
+      case '"', '\'', '[', '{':
+        end, err := scanTOMLValue(data, j)
+        if err != nil {
+          return 0, err
+        }
+        j = end
+      default:
+        j++
+      }
+    }
+    return 0, tomlScanError(data, i)
+  }
+
+  // A number, boolean or date, which may have a space between the date and time.
+  j := i
+  for j < len(data) && !strings.ContainsRune(",]}#\r\n", rune(data[j])) {
+    j++
+  }
+  return j, nil
+}
+
+// tomlBlock is a table of a TOML document: the root table, a [table] or an element of an [[array]].
+type tomlBlock struct {
+  path       string                     // where the table is, with array elements numbered, like filters.0.keywords.1
+  start, end int                        // the byte range, from the comments just above the header to the next table
+  values     map[string][]tomlStatement // the key/values, by the first part of their key
+  valuesEnd  int                        // where new key/values go
+}
+
+// tomlDocument is a TOML document split into its tables.
+type tomlDocument struct {
+  data   []byte
+  blocks []*tomlBlock
+  tables map[string]*tomlBlock // by path
+  arrays map[string][]string   // the paths of the elements of each array of tables
+}
+
+// parseTOMLDocument splits a TOML document into its tables.
+func parseTOMLDocument(data []byte) (*tomlDocument, error) {
+  if len(data) > 0 && data[len(data)-1] != '\n' {
+    data = append(data[:len(data):len(data)], '\n')
+  }
+  statements, err := scanTOML(data)
+  if err != nil {
+    return nil, err
+  }
+
+  document := &tomlDocument{data: data, tables: make(map[string]*tomlBlock), arrays: make(map[string][]string)}
+  current := document.add("", 0, 0)
+  header := -1
+  counts := make(map[string]int)
+  for i, s := range statements {
+    switch s.kind {
+    case tomlKeyValue:
+      current.values[s.key[0]] = append(current.values[s.key[0]], s)
+      current.valuesEnd = s.end
+
+    case tomlTable, tomlArrayTable:
+      // The comments directly above a header belong to its table.
+      start := s.start
+      for k := i - 1; k > header && statements[k].kind == tomlComment; k-- {
+        start = statements[k].start
+      }
+      document.close(current, start)
+      current, header = document.add(document.resolve(s.key, s.kind == tomlArrayTable, counts), start, s.end), i
+    }
+  }
+  document.close(current, len(data))
+  return document, nil
+}
+
+// add starts a table at the given path.
+func (d *tomlDocument) add(path string, start, valuesEnd int) *tomlBlock {
+  block := &tomlBlock{path: path, start: start, values: make(map[string][]tomlStatement), valuesEnd: valuesEnd}
+  d.blocks = append(d.blocks, block)
+  d.tables[path] = block
+  return block
+}
+
+// close ends a table where the next one starts. New key/values go at the end of a root table without any.
+func (d *tomlDocument) close(block *tomlBlock, end int) {
+  block.end = end
+  if block.path == "" && len(block.values) == 0 {
+    block.valuesEnd = end
+  }
+}
+
+// resolve returns the path of a table header, counting the elements of arrays of tables.
+func (d *tomlDocument) resolve(key []string, element bool, counts map[string]int) string {
+  path := ""
+  for i, part := range key {
+    path = joinTOMLPath(path, part)
+    if element && i == len(key)-1 {
+      element := joinTOMLPath(path, strconv.Itoa(counts[path]))
+      counts[path]++
+      d.arrays[path] = append(d.arrays[path], element)
+      return element
+    }
+    // A header inside an array of tables belongs to its last element so far.
+    if n := counts[path]; n > 0 {
+      path = joinTOMLPath(path, strconv.Itoa(n-1))
+    }
+  }
+  return path
+}
+
+// joinTOMLPath adds a part to a table path.
+func joinTOMLPath(path, part string) string {
+  if path == "" {
+    return part
+  }
+  return path + "." + part
+}
+
+// subtree returns the table at the path and all the tables inside it.
+func (d *tomlDocument) subtree(path string) []*tomlBlock {
+  var blocks []*tomlBlock
+  for _, block := range d.blocks {
+    if path == "" || block.path == path || strings.HasPrefix(block.path, path+".") {
+      blocks = append(blocks, block)
+    }
+  }
+  return blocks
+}
+
+// subtreeEnd returns where the table at the path and the tables inside it end.
+func (d *tomlDocument) subtreeEnd(path string) int {
+  end := 0
+  for _, block := range d.subtree(path) {
+    if block.end > end {
+      end = block.end
+    }
+  }
+  return end
+}
+
+// tomlEdit replaces a byte range of a TOML document.
+type tomlEdit struct {
+  start, end int
+  text       string
+}
+
+// tomlEditor collects the edits that turn a TOML document into one with another value.
+type tomlEditor struct {
+  document *tomlDocument
+  edits    []tomlEdit
+}
+
+// splice replaces a byte range of the document with the text.
+func (e *tomlEditor) splice(start, end int, text string) {
+  e.edits = append(e.edits, tomlEdit{start: start, end: end, text: text})
+}
+
+// remove deletes the table at the path and the tables inside it.
+func (e *tomlEditor) remove(path string) {
+  for _, block := range e.document.subtree(path) {
+    e.splice(block.start, block.end, "")
+  }
+}
+
+// apply returns the edited document. Insertions go before a removal at the same place.
+func (e *tomlEditor) apply() []byte {
+  sort.SliceStable(e.edits, func(i, j int) bool {
+    a, b := e.edits[i], e.edits[j]
+    if a.start != b.start {
+      return a.start < b.start
+    }
+    return a.start == a.end && b.start != b.end
+  })
+
+  data := e.document.data
+  var out bytes.Buffer
+  at := 0
+  for _, edit := range e.edits {
+    if edit.start < at {
+      // Overlapping edits leave the document wrong, which the caller's check catches.
+      continue
+    }
+    out.Write(data[at:edit.start])
+    out.WriteString(edit.text)
+    at = edit.end
+  }
+  out.Write(data[at:])
+  return out.Bytes()
+}
+
+// mergeTOML returns the TOML document with the value of the JSON data, keeping the text of every key that didn't change.
+func mergeTOML(original, data []byte) ([]byte, error) {
+  document, err := parseTOMLDocument(original)
+  if err != nil {
+    return nil, err
+  }
+  var decoded map[string]interface{}
+  if _, err := toml.Decode(string(original), &decoded); err != nil {
+    return nil, fmt.Errorf("error parsing TOML: %w", err)
+  }
+  old, err := normalizeValue(tomlDatesAsStrings(decoded))
+  if err != nil {
+    return nil, err
+  }
+  updated, err := normalizeJSON(data)
+  if err != nil {
+    return nil, err
+  }
+  oldTable, ok := old.(map[string]interface{})
+  updatedTable, ok2 := updated.(map[string]interface{})
+  if !ok || !ok2 {
+    return nil, fmt.Errorf("error converting to TOML: only objects can be written as TOML")
+  }
+
+  editor := &tomlEditor{document: document}
+  if err := editor.mergeTable("", nil, oldTable, updatedTable); err != nil {
+    return nil, err
+  }
+  return editor.apply(), nil
+}
+
+// mergeTable edits the table at the path, whose header has the names, from the old value to the updated one.
+func (e *tomlEditor) mergeTable(path string, names []string, old, updated map[string]interface{}) error {
+  block := e.document.tables[path]
+  if block == nil {
+    return fmt.Errorf("error editing TOML: no table %s", path)
+  }
+
+  var keys []string
+  for key := range old {
+    keys = append(keys, key)
+  }
+  for key := range updated {
+    if _, ok := old[key]; !ok {
+      keys = append(keys, key)
+    }
+  }
+  sort.Strings(keys)
+
+  for _, key := range keys {
+    before, inOld := old[key]
+    after, inNew := updated[key]
+    if inOld && inNew && reflect.DeepEqual(before, after) {
+      continue
+    }
+    child := joinTOMLPath(path, key)
+    childNames := append(append([]string{}, names...), key)
+
+    // Tables and arrays of tables are edited in turn.
+    if inOld && inNew && len(block.values[key]) == 0 {
+      beforeTable, ok := before.(map[string]interface{})
+      afterTable, ok2 := after.(map[string]interface{})
+      if ok && ok2 && e.document.tables[child] != nil {
+        if err := e.mergeTable(child, childNames, beforeTable, afterTable); err != nil {
+          return err
+        }
+        continue
+      }
+      beforeArray, ok := tableArray(before)
+      afterArray, ok2 := tableArray(after)
+      if ok && ok2 && len(beforeArray) > 0 && len(e.document.arrays[child]) == len(beforeArray) {
+        if err := e.mergeArray(child, childNames, beforeArray, afterArray); err != nil {
+          return err
+        }
+        continue
+      }
+    }
+
+    // Anything else is written again, where the key was or else after the table's key/values or at the end of its tables.
+    at := -1
+    for _, s := range block.values[key] {
+      if at < 0 {
+        at = s.start
+      }
+      e.splice(s.start, s.end, "")
+    }
+    sectionAt := -1
+    for _, b := range e.document.subtree(child) {
+      if sectionAt < 0 {
+        sectionAt = b.start
+      }
+      e.splice(b.start, b.end, "")
+    }
+    if !inNew {
+      continue
+    }
+
+    text, section, err := encodeTOMLEntry(childNames, after)
+    if err != nil {
+      return err
+    }
+    switch {
+    case section && sectionAt < 0:
+      sectionAt = e.document.subtreeEnd(path)
+      fallthrough
+    case section:
+      e.splice(sectionAt, sectionAt, text)
+    case at >= 0:
+      e.splice(at, at, text)
+    default:
+      e.splice(block.valuesEnd, block.valuesEnd, text)
+    }
+  }
+  return nil
+}
+
+// mergeArray edits the elements of an array of tables, keeping the ones that match and adding and removing the rest.
+func (e *tomlEditor) mergeArray(path string, names []string, old, updated []map[string]interface{}) error {
+  elements := e.document.arrays[path]
+  oldItems := make([]interface{}, len(old))
+  for i, item := range old {
+    oldItems[i] = item
+  }
+  updatedItems := make([]interface{}, len(updated))
+  for j, item := range updated {
+    updatedItems[j] = item
+  }
+
+  matched := matchItems(oldItems, updatedItems)
+  used := make([]bool, len(old))
+  at := e.document.tables[elements[0]].start
+  for j, item := range updated {
+    if i := matched[j]; i >= 0 {
+      used[i] = true
+      if err := e.mergeTable(elements[i], names, old[i], item); err != nil {
+        return err
+      }
+      at = e.document.subtreeEnd(elements[i])
+      continue
+    }
+    text, err := encodeTOMLTable(names, true, item)
+    if err != nil {
+      return err
+    }
+    e.splice(at, at, text)
+  }
+
+  for i, element := range elements {
+    if !used[i] {
+      e.remove(element)
+    }
+  }
+  return nil
+}
+
+// tableArray returns the tables of an array that only holds tables.
+func tableArray(value interface{}) ([]map[string]interface{}, bool) {
+  array, ok := value.([]interface{})
+  if !ok {
+    return nil, false
+  }
+  tables := make([]map[string]interface{}, len(array))
+  for i, item := range array {
+    if tables[i], ok = item.(map[string]interface{}); !ok {
+      return nil, false
+    }
+  }
+  return tables, true
+}
+
+// encodeTOMLEntry encodes the value of the last of the names, reporting whether it's written as tables rather than a key/value.
+func encodeTOMLEntry(names []string, value interface{}) (string, bool, error) {
+  if table, ok := value.(map[string]interface{}); ok {
+    text, err := encodeTOMLTable(names, false, table)
+    return text, true, err
+  }
+  if tables, ok := tableArray(value); ok && len(tables) > 0 {
+    var text string
+    for _, table := range tables {
+      element, err := encodeTOMLTable(names, true, table)
+      if err != nil {
+        return "", false, err
+      }
+      text += element
+    }
+    return text, true, nil
+  }
+
+  var out bytes.Buffer
+  if err := toml.NewEncoder(&out).Encode(map[string]interface{}{names[len(names)-1]: value}); err != nil {
+    return "", false, fmt.Errorf("error encoding TOML: %w", err)
+  }
+  return out.String(), false, nil
+}
+
+// encodeTOMLTable encodes a table, or an element of an array of tables, under a header with the names.
+func encodeTOMLTable(names []string, element bool, table map[string]interface{}) (string, error) {
+  var out bytes.Buffer
+  encoder := toml.NewEncoder(&out)
+  encoder.Indent = ""
+  if err := encoder.Encode(table); err != nil {
+    return "", fmt.Errorf("error encoding TOML: %w", err)
+  }
+
+  header := tomlKeyPath(names)
+  text := "\n[" + header + "]\n"
+  if element {
+    text = "\n[[" + header + "]]\n"
+  }
+  // The encoder writes the headers of nested tables relative to the table.
+  for _, line := range strings.SplitAfter(out.String(), "\n") {
+    switch {
+    case strings.HasPrefix(line, "[["):
+      line = "[[" + header + "." + line[2:]
+    case strings.HasPrefix(line, "["):
+      line = "[" + header + "." + line[1:]
+    }
+    text += line
+  }
+  return text, nil
+}
+
+// tomlKeyPath writes the names as a dotted key, quoting those that need it.
+func tomlKeyPath(names []string) string {
+  parts := make([]string, len(names))
+  for i, name := range names {
+    parts[i] = name
+    if !bareTOMLKey.MatchString(name) {
+      parts[i] = strconv.Quote(name)
+    }
+  }
+  return strings.Join(parts, ".")
+}
+
+// migrateTOML moves the keys and tables of a legacy TOML list file, with its comments, into an element of the list.
+func migrateTOML(original, migrated []byte, kind string) ([]byte, error) {
+  data := original
+  if len(data) > 0 && data[len(data)-1] != '\n' {
+    data = append(data[:len(data):len(data)], '\n')
+  }
+  statements, err := scanTOML(data)
+  if err != nil {
+    return nil, err
+  }
+
+  list, err := normalizeJSON(migrated)
+  if err != nil {
+    return nil, err
+  }
+  header := make(map[string]interface{})
+  for key, value := range list.(map[string]interface{}) {
+    if key != kind {
+      header[key] = value
+    }
+  }
+  var out bytes.Buffer
+  if err := toml.NewEncoder(&out).Encode(header); err != nil {
+    return nil, fmt.Errorf("error encoding TOML: %w", err)
+  }
+  headerText := out.String()
+  out.Reset()
+
+  // Comments at the top of the file, up to the last blank line before the first key or table, stay at the top.
+  top := 0
+  for _, s := range statements {
+    if s.kind != tomlBlank && s.kind != tomlComment {
+      break
+    }
+    if s.kind == tomlBlank {
+      top = s.end
+    }
+  }
+  out.Write(data[:top])
+  out.WriteString(headerText)
+  fmt.Fprintf(&out, "\n[[%s]]\n", kind)
+
+  // The old tables now belong to the element.
+  at := top
+  for _, s := range statements {
+    if s.start < top || (s.kind != tomlTable && s.kind != tomlArrayTable) {
+      continue
+    }
+    bracket := s.start + bytes.IndexByte(data[s.start:], '[') + 1
+    if s.kind == tomlArrayTable {
+      bracket++
+    }
+    out.Write(data[at:bracket])
+    out.WriteString(kind + ".")
+    at = bracket
+  }
+  out.Write(data[at:])
+  return out.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestUpdateDocument(t *testing.T) {
+  const yamlList = `# Spoilers for the shows I'm watching.
+format_version: 2
+name: Spoilers
+filters:
+  - title: Spoilers # shown on the warning
+    context: [home]
+    keywords:
+      # The finale airs on Sunday.
+      - keyword: finale
+      - keyword: trailer # for now
+`
+  const tomlList = `# Spoilers for the shows I'm watching.
+format_version = 2
+name = "Spoilers"
+
+[[filters]]
+title = "Spoilers" # shown on the warning
+context = ["home"]
+
+# The finale airs on Sunday.
+[[filters.keywords]]
+keyword = "finale"
+
+[[filters.keywords]]
+keyword = "trailer" # for now
+`
+
+  tests := []struct {
+    name    string
+    data    string // the new value
+    keep    []string
+    dropped []string
+  }{
+    {"unchanged", `{"format_version": 2, "name": "Spoilers", "filters": [{"title": "Spoilers", "context": ["home"], "keywords": [{"keyword": "finale"}, {"keyword": "trailer"}]}]}`,
+      []string{"Spoilers for the shows", "shown on the warning", "The finale airs", "for now"}, nil},
+    {"keyword removed", `{"format_version": 2, "name": "Spoilers", "filters": [{"title": "Spoilers", "context": ["home"], "keywords": [{"keyword": "trailer"}]}]}`,
+      []string{"Spoilers for the shows", "shown on the warning", "for now"}, []string{"The finale airs"}},
+    {"keyword edited and added", `{"format_version": 2, "name": "Spoilers", "filters": [{"title": "Spoilers", "context": ["home", "public"], "keywords": [{"keyword": "finale", "whole_word": true}, {"keyword": "trailer"}, {"keyword": "cliffhanger"}]}]}`,
+      []string{"Spoilers for the shows", "shown on the warning", "The finale airs", "for now", "cliffhanger"}, nil},
+    {"filter and header key added", `{"format_version": 2, "name": "Spoilers", "description": "Shows", "filters": [{"title": "Spoilers", "context": ["home"], "keywords": [{"keyword": "finale"}, {"keyword": "trailer"}]}, {"title": "Politics", "context": ["home"], "keywords": []}]}`,
+      []string{"Spoilers for the shows", "shown on the warning", "The finale airs", "for now", "Politics"}, nil},
+  }
+  for _, original := range []struct{ file, data string }{{"list.yaml", yamlList}, {"list.toml", tomlList}} {
+    for _, test := range tests {
+      updated, err := updateDocument(original.file, []byte(original.data), []byte(test.data))
+      if err != nil {
+        t.Fatalf("%s %s: %s", original.file, test.name, err)
+      }
+      decoded, err := decodeDocument(documentFormat(original.file), updated)
+      if err != nil || !sameJSON(decoded, []byte(test.data)) {
+        t.Errorf("%s %s: updated to %s, %v", original.file, test.name, decoded, err)
+      }
+      if test.name == "unchanged" && string(updated) != original.data {
+        t.Errorf("%s: unchanged document rewritten as\n%s", original.file, updated)
+      }
+      for _, text := range test.keep {
+        if !strings.Contains(string(updated), text) {
+          t.Errorf("%s %s: %q lost from\n%s", original.file, test.name, text, updated)
+        }
+      }
+      for _, text := range test.dropped {
+        if strings.Contains(string(updated), text) {
+          t.Errorf("%s %s: %q kept in\n%s", original.file, test.name, text, updated)
+        }
+      }
+    }
+  }
+}
+
+func TestScanTOML(t *testing.T) {
+  data := []byte(`a = "x # not a comment" # a comment
+b = """
+[not a table]
+"""
+c = [
+  1, # one
+  [2, "]"],
+]
+d = { e = 'f', g = [3] }
+"h.i" = 1979-05-27 07:32:00
+[[j.k]]
+`)
+  statements, err := scanTOML(data)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var keys []string
+  for _, s := range statements {
+    keys = append(keys, strings.Join(s.key, "."))
+  }
+  if want := "a b c d h.i j.k"; strings.Join(keys, " ") != want {
+    t.Errorf("scanned keys %q, want %q", keys, want)
+  }
+  if last := statements[len(statements)-1]; last.kind != tomlArrayTable || last.end != len(data) {
+    t.Errorf("last statement %+v", last)
+  }
+}
@@ -0,0 +1,501 @@
+package main
+
+// The config validate, show, set and migrate commands.
+
+import (
+  "context"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net/url"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "time"
+)
+
+// configVersion is the version of the config file layout written by config migrate.
+// Version 1 is the original layout without a config_version key.
+const configVersion = 2
+
+// configDirectoryKeys are the config keys holding directories, which the import and export code appends file names to.
+var configDirectoryKeys = []string{"tags_export", "tags_import", "tags_download", "filters_export", "filters_import", "filters_download", "history_dir", "export_repo"}
+
+// configProblem is a problem found when validating the config file.
+type configProblem struct {
+  Key     string
+  Message string
+  Warning bool
+}
+
+// String formats the problem as error|warning: key: message.
+func (p configProblem) String() string {
+  level := "error"
+  if p.Warning {
+    level = "warning"
+  }
+  if p.Key == "" {
+    return level + ": " + p.Message
+  }
+  return level + ": " + p.Key + ": " + p.Message
+}
+
+// configKeys returns the top-level keys of the config file and the Go type of each.
+func configKeys() map[string]reflect.Type {
+  keys := make(map[string]reflect.Type)
+  configType := reflect.TypeOf(MastodonConfig{})
+  for i := 0; i < configType.NumField(); i++ {
+    field := configType.Field(i)
+    name := strings.Split(field.Tag.Get("json"), ",")[0]
+    if name != "" && name != "-" {
+      keys[name] = field.Type
+    }
+  }
+  return keys
+}
+
+// checkURL checks a configured URL is absolute and uses http or https.
+func checkURL(key, value string, problems []configProblem) []configProblem {
+  parsed, err := url.Parse(value)
+  if err != nil {
+    return append(problems, configProblem{Key: key, Message: fmt.Sprintf("invalid URL %q: %s", value, err)})
+  }
+  switch {
+  case parsed.Scheme != "http" && parsed.Scheme != "https":
+    return append(problems, configProblem{Key: key, Message: fmt.Sprintf("URL %q must use https", value)})
+  case parsed.Host == "":
+    return append(problems, configProblem{Key: key, Message: fmt.Sprintf("URL %q has no host", value)})
+  case parsed.Scheme == "http":
+    return append(problems, configProblem{Key: key, Message: fmt.Sprintf("URL %q isn't encrypted, use https", value), Warning: true})
+  }
+  return problems
+}
+
+// checkWritable checks that files can be created in a directory, or in the nearest parent that exists if it hasn't been created yet.
+func checkWritable(dir string) error {
+  for {
+    info, err := os.Stat(dir)
+    if os.IsNotExist(err) && filepath.Dir(dir) != dir {
+      dir = filepath.Dir(dir)
+      continue
+    }
+    if err != nil {
+      return err
+    }
+    if !info.IsDir() {
+      return fmt.Errorf("%s is not a directory", dir)
+    }
+    break
+  }
+
+  probe, err := ioutil.TempFile(dir, ".subscribe-o-mast-*")
+  if err != nil {
+    return fmt.Errorf("%s is not writable", dir)
+  }
+  probe.Close()
+  return os.Remove(probe.Name())
+}
+
+// validateConfig checks the config file, and unless offline also checks the subscribed URLs can be downloaded.
+func validateConfig(file string, offline bool) ([]configProblem, error) {
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return nil, fmt.Errorf("error reading config file: %w", err)
+  }
+  config, err := readConfig(file)
+  if err != nil {
+    return []configProblem{{Message: err.Error()}}, nil
+  }
+
+  var problems []configProblem
+  add := func(key, message string, warning bool) {
+    problems = append(problems, configProblem{Key: key, Message: message, Warning: warning})
+  }
+
+  // Keys that aren't part of the config are usually typos.
+  if fields, err := readConfigFields(file, data); err == nil {
+    known := configKeys()
+    for _, field := range fields {
+      if _, ok := known[field.Key]; !ok {
+        add(field.Key, "unknown key", true)
+      }
+    }
+  }
+  if config.ConfigVersion < configVersion {
+    add("config_version", fmt.Sprintf("config file layout is older than version %d, run config migrate to upgrade it", configVersion), true)
+  } else if config.ConfigVersion > configVersion {
+    add("config_version", fmt.Sprintf("config file layout is version %d, this version of subscribe-o-mast reads up to %d", config.ConfigVersion, configVersion), false)
+  }
+
+  // The account details.
+  if config.InstanceURL == "" {
+    add("instance_url", "missing", false)
+  } else {
+    problems = checkURL("instance_url", config.InstanceURL, problems)
+  }
+  if err := config.resolveAccessToken(); err != nil {
+    add(config.tokenSourceKey(), err.Error(), false)
+  } else if config.AccessToken == "" {
+    add("access_token", fmt.Sprintf("missing, set access_token, access_token_file, access_token_cmd or %s", tokenEnvVar), false)
+  } else if config.tokenSource == "access_token" {
+    add("access_token", fmt.Sprintf("stored in plain text, consider %s, access_token_file or access_token_cmd", tokenEnvVar), true)
+  }
+  if config.AccessTokenFile != "" {
+    if info, err := os.Stat(config.AccessTokenFile); err == nil && info.Mode().Perm()&0077 != 0 {
+      add("access_token_file", fmt.Sprintf("%s can be read by other users", config.AccessTokenFile), true)
+    }
+  }
+
+  // URLs.
+  if config.FilterURL != "" {
+    problems = checkURL("filters_import_url", config.FilterURL, problems)
+  }
+  if config.TagsURL != "" {
+    problems = checkURL("tags_import_url", config.TagsURL, problems)
+  }
+  if config.Catalog != "" && isURL(config.Catalog) {
+    problems = checkURL("catalog", config.Catalog, problems)
+  }
+  subs, err := config.subscriptions()
+  if err != nil {
+    add("subscriptions", err.Error(), false)
+  }
+  for _, sub := range config.Subscriptions {
+    if sub.URL != "" {
+      problems = checkURL("subscriptions", sub.URL, problems)
+    }
+  }
+
+  // Directories.
+  for _, key := range configDirectoryKeys {
+    dir := config.directory(key)
+    if dir == "" {
+      continue
+    }
+    if !strings.HasSuffix(dir, "/") {
+      add(key, fmt.Sprintf("directory %q should end in /, run config migrate to fix it", dir), true)
+    }
+    if strings.HasSuffix(key, "_import") {
+      if _, err := os.Stat(dir); err != nil {
+        add(key, fmt.Sprintf("directory %q doesn't exist", dir), true)
+      }
+      continue
+    }
+    if err := checkWritable(dir); err != nil {
+      add(key, err.Error(), false)
+    }
+  }
+
+  // Exports are committed to export_repo, so they have to be inside it.
+  if config.ExportRepo != "" {
+    if _, err := exec.LookPath("git"); err != nil {
+      add("export_repo", "git isn't installed", false)
+    }
+    for _, key := range []string{"filters_export", "tags_export"} {
+      rel, err := filepath.Rel(config.ExportRepo, config.directory(key))
+      if err != nil || strings.HasPrefix(rel, "..") {
+        add(key, fmt.Sprintf("directory %q isn't inside export_repo %q", config.directory(key), config.ExportRepo), false)
+      }
+    }
+  } else if config.ExportRemote != "" {
+    add("export_remote", "export_remote is only used with export_repo", true)
+  }
+
+  // Everything else.
+  if _, err := formatExtension(config.ExportFormat); err != nil {
+    add("export_format", err.Error(), false)
+  }
+  for _, key := range config.TrustedKeys {
+    if _, err := parsePublicKey(key); err != nil {
+      add("trusted_keys", err.Error(), false)
+    }
+  }
+
+  // Check the subscribed lists can be downloaded.
+  if !offline && len(subs) > 0 {
+    for _, sub := range subs {
+      ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+      _, err := downloadConditional(ctx, sub.URL, "", "")
+      cancel()
+      if err != nil {
+        key := "subscriptions"
+        if sub.URL == config.FilterURL {
+          key = "filters_import_url"
+        } else if sub.URL == config.TagsURL {
+          key = "tags_import_url"
+        }
+        add(key, fmt.Sprintf("%s is unreachable: %s", sub.URL, err), false)
+      }
+    }
+  }
+
+  return problems, nil
+}
+
+// directory returns the value of one of the directory keys.
+func (config *MastodonConfig) directory(key string) string {
+  switch key {
+  case "tags_export":
+    return config.TagsExport
+  case "tags_import":
+    return config.TagsImport
+  case "tags_download":
+    return config.TagsDownload
+  case "filters_export":
+    return config.FilterExport
+  case "filters_import":
+    return config.FilterImport
+  case "filters_download":
+    return config.FilterDownload
+  case "history_dir":
+    return config.HistoryDir
+  case "export_repo":
+    return config.ExportRepo
+  }
+  return ""
+}
+
+// readConfigFields reads the top-level fields of a config file in any format without changing it.
+func readConfigFields(file string, data []byte) ([]configField, error) {
+  var fields []configField
+  _, err := editConfigData(file, data, func(f []configField) ([]configField, error) {
+    fields = f
+    return f, nil
+  })
+  return fields, err
+}
+
+// redactToken hides all but the start of an access token.
+func redactToken(token string) string {
+  if token == "" {
+    return ""
+  }
+  if len(token) <= 8 {
+    return "(redacted)"
+  }
+  return token[:4] + "…(redacted)"
+}
+
+// tokenSourceKey returns the config key, or environment variable, the access token is read from.
+func (config *MastodonConfig) tokenSourceKey() string {
+  switch {
+  case os.Getenv(tokenEnvVar) != "":
+    return tokenEnvVar
+  case config.AccessTokenCmd != "":
+    return "access_token_cmd"
+  case config.AccessTokenFile != "":
+    return "access_token_file"
+  case config.usesTokenStore():
+    return "token_store"
+  }
+  return "access_token"
+}
+
+// showConfig prints the config as it is read, with the access token redacted and where it came from.
+func showConfig(file string) error {
+  config, err := readConfig(file)
+  if err != nil {
+    return err
+  }
+  tokenErr := config.resolveAccessToken()
+  config.AccessToken = redactToken(config.AccessToken)
+
+  data, err := json.MarshalIndent(config, "", "  ")
+  if err != nil {
+    return err
+  }
+  fmt.Println("# " + file)
+  fmt.Println(string(data))
+
+  fmt.Println()
+  fmt.Println("# The access token is read from the first of these that is set: " + strings.Join(tokenPrecedence, ", "))
+  switch {
+  case tokenErr != nil:
+    fmt.Printf("# %s\n", tokenErr)
+  case config.tokenSource == "":
+    fmt.Println("# no access token is set")
+  default:
+    fmt.Println("# access token read from " + config.tokenSource)
+    if config.tokenSource == "token_store" {
+      fmt.Printf("# profile %s in %s\n", config.profile(), config.tokenStorePath())
+    }
+  }
+  return nil
+}
+
+// setConfig sets a top-level key in the config file. Values of string keys are taken as given, anything else is parsed as JSON.
+func setConfig(file, key, value string) error {
+  fieldType, ok := configKeys()[key]
+  if !ok {
+    return fmt.Errorf("unknown config key %q", key)
+  }
+
+  var parsed interface{} = value
+  if fieldType.Kind() != reflect.String {
+    if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+      return fmt.Errorf("value for %s must be JSON: %w", key, err)
+    }
+  }
+
+  return editConfigFile(file, func(fields []configField) ([]configField, error) {
+    fields, err := setConfigField(fields, key, parsed)
+    if err != nil {
+      return nil, err
+    }
+
+    // Check the new value has the right type before anything is written.
+    data, err := formatConfigFields(fields)
+    if err != nil {
+      return nil, err
+    }
+    var config MastodonConfig
+    if err := json.Unmarshal(data, &config); err != nil {
+      return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+    }
+    return fields, nil
+  })
+}
+
+// migrateConfigFields upgrades the fields of an older config file layout, returning a description of each change.
+func migrateConfigFields(fields []configField) ([]configField, []string, error) {
+  var changes []string
+  version := 1
+  if raw := getConfigField(fields, "config_version"); raw != nil {
+    if err := json.Unmarshal(raw, &version); err != nil {
+      return nil, nil, fmt.Errorf("invalid config_version: %w", err)
+    }
+  }
+  if version > configVersion {
+    return nil, nil, fmt.Errorf("config file layout is version %d, this version of subscribe-o-mast reads up to %d", version, configVersion)
+  }
+
+  // Version 2 adds the keys introduced since the original layout, and directories that end in a slash.
+  if version < 2 {
+    template, err := parseConfigFields("template", configTemplate)
+    if err != nil {
+      return nil, nil, err
+    }
+    for _, field := range template {
+      // The token may come from elsewhere, so a missing access_token isn't added.
+      if field.Key == "config_version" || field.Key == "access_token" {
+        continue
+      }
+      if getConfigField(fields, field.Key) == nil {
+        fields = append(fields, field)
+        changes = append(changes, fmt.Sprintf("added %s: %s", field.Key, field.Value))
+      }
+    }
+
+    for _, key := range configDirectoryKeys {
+      var dir string
+      if raw := getConfigField(fields, key); raw == nil || json.Unmarshal(raw, &dir) != nil || dir == "" || strings.HasSuffix(dir, "/") {
+        continue
+      }
+      if fields, err = setConfigField(fields, key, dir+"/"); err != nil {
+        return nil, nil, err
+      }
+      changes = append(changes, fmt.Sprintf("changed %s to %q", key, dir+"/"))
+    }
+  }
+
+  if version < configVersion {
+    var err error
+    if fields, err = setConfigField(fields, "config_version", configVersion); err != nil {
+      return nil, nil, err
+    }
+    changes = append(changes, fmt.Sprintf("set config_version to %d", configVersion))
+  }
+
+  return fields, changes, nil
+}
+
+// migrateConfig upgrades the config file to the current layout in place.
+func migrateConfig(file string, dryRun bool) error {
+  var changes []string
+  edit := func(fields []configField) ([]configField, error) {
+    var err error
+    fields, changes, err = migrateConfigFields(fields)
+    return fields, err
+  }
+
+  if dryRun {
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+      return fmt.Errorf("error reading config file: %w", err)
+    }
+    if _, err := editConfigData(file, data, edit); err != nil {
+      return err
+    }
+  } else if err := editConfigFile(file, edit); err != nil {
+    return err
+  }
+
+  if len(changes) == 0 {
+    fmt.Printf("%s is already at config version %d.\n", file, configVersion)
+    return nil
+  }
+  for _, change := range changes {
+    fmt.Println(" " + change)
+  }
+  if dryRun {
+    fmt.Println("Nothing was written, run without -dry-run to migrate.")
+  } else {
+    fmt.Printf("Migrated %s to config version %d.\n", file, configVersion)
+  }
+  return nil
+}
+
+// configCommand defines the config command's flags and returns the function that runs it.
+func configCommand(flags *flag.FlagSet) func(args []string) error {
+  offline := flags.Bool("offline", false, "don't check that subscribed URLs can be downloaded, for validate")
+  dryRun := flags.Bool("dry-run", false, "only show what would change, for migrate")
+  return func(args []string) error {
+    if len(args) == 0 {
+      flags.Usage()
+      return fmt.Errorf("missing config command")
+    }
+
+    switch args[0] {
+    case "validate":
+      problems, err := validateConfig(*configFile, *offline)
+      if err != nil {
+        return err
+      }
+      count := 0
+      for _, problem := range problems {
+        fmt.Println(problem)
+        if !problem.Warning {
+          count++
+        }
+      }
+      if count > 0 {
+        return fmt.Errorf("%s has %d errors", *configFile, count)
+      }
+      fmt.Printf("%s is valid.\n", *configFile)
+
+    case "show":
+      return showConfig(*configFile)
+
+    case "set":
+      if len(args) != 3 {
+        return fmt.Errorf("usage: config set <key> <value>")
+      }
+      if err := setConfig(*configFile, args[1], args[2]); err != nil {
+        return err
+      }
+      fmt.Printf("Set %s in %s\n", args[1], *configFile)
+
+    case "migrate":
+      return migrateConfig(*configFile, *dryRun)
+
+    default:
+      flags.Usage()
+      return fmt.Errorf("unknown config command %q", args[0])
+    }
+
+    return nil
+  }
+}
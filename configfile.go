@@ -0,0 +1,564 @@
+package main
+
+// Editing the config file in place, keeping the order of its keys.
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "sort"
+  "strings"
+
+  "github.com/BurntSushi/toml"
+  "gopkg.in/yaml.v3"
+)
+
+// configField is a single top-level key of the config file and its raw JSON value.
+type configField struct {
+  Key   string
+  Value json.RawMessage
+
+  // Where the key and its value are in a JSON config file, for editing it in place.
+  start, valueStart, valueEnd int
+}
+
+// parseConfigFields splits the config file contents into its top-level keys, in the order they appear.
+func parseConfigFields(file string, data []byte) ([]configField, error) {
+  stripped, err := stripJSONC(file, data)
+  if err != nil {
+    return nil, err
+  }
+  decoder := json.NewDecoder(bytes.NewReader(stripped))
+
+  // The config file must be a single JSON object.
+  token, err := decoder.Token()
+  if err != nil {
+    return nil, fmt.Errorf("error parsing config file: %w", err)
+  }
+  if delim, ok := token.(json.Delim); !ok || delim != '{' {
+    return nil, fmt.Errorf("error parsing config file: expected an object")
+  }
+
+  var fields []configField
+  end := int(decoder.InputOffset())
+  for decoder.More() {
+    start := skipJSONSpace(stripped, end, ",")
+    token, err := decoder.Token()
+    if err != nil {
+      return nil, fmt.Errorf("error parsing config file: %w", err)
+    }
+    key, _ := token.(string)
+    valueStart := skipJSONSpace(stripped, int(decoder.InputOffset()), ":")
+
+    var value json.RawMessage
+    if err := decoder.Decode(&value); err != nil {
+      return nil, fmt.Errorf("error parsing config file value for %s: %w", key, err)
+    }
+    end = int(decoder.InputOffset())
+    fields = append(fields, configField{Key: key, Value: value, start: start, valueStart: valueStart, valueEnd: end})
+  }
+
+  return fields, nil
+}
+
+// formatConfigFields writes the fields back out as an indented JSON object.
+func formatConfigFields(fields []configField) ([]byte, error) {
+  var out bytes.Buffer
+  out.WriteString("{\n")
+  for i, field := range fields {
+    key, err := json.Marshal(field.Key)
+    if err != nil {
+      return nil, err
+    }
+    out.WriteString("  ")
+    out.Write(key)
+    out.WriteString(": ")
+    if err := json.Indent(&out, field.Value, "  ", "  "); err != nil {
+      return nil, fmt.Errorf("error formatting config value for %s: %w", field.Key, err)
+    }
+    if i < len(fields)-1 {
+      out.WriteString(",")
+    }
+    out.WriteString("\n")
+  }
+  out.WriteString("}\n")
+  return out.Bytes(), nil
+}
+
+// getConfigField returns the raw value of a top-level key, or nil if it isn't set.
+func getConfigField(fields []configField, key string) json.RawMessage {
+  for _, field := range fields {
+    if field.Key == key {
+      return field.Value
+    }
+  }
+  return nil
+}
+
+// setConfigField sets a top-level key, replacing it in place or adding it to the end.
+func setConfigField(fields []configField, key string, value interface{}) ([]configField, error) {
+  raw, err := json.Marshal(value)
+  if err != nil {
+    return nil, fmt.Errorf("error encoding config value for %s: %w", key, err)
+  }
+
+  for i, field := range fields {
+    if field.Key == key {
+      fields[i].Value = raw
+      return fields, nil
+    }
+  }
+  return append(fields, configField{Key: key, Value: raw}), nil
+}
+
+// editConfigFile reads the config file, lets edit change its fields, and writes it back keeping the file's permissions.
+// Config files in every format keep the text and comments of the keys that didn't change.
+func editConfigFile(file string, edit func(fields []configField) ([]configField, error)) error {
+  info, err := os.Stat(file)
+  if err != nil {
+    return fmt.Errorf("error reading config file: %w", err)
+  }
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return fmt.Errorf("error reading config file: %w", err)
+  }
+
+  out, err := editConfigData(file, data, edit)
+  if err != nil {
+    return err
+  }
+
+  if err := ioutil.WriteFile(file, out, info.Mode().Perm()); err != nil {
+    return fmt.Errorf("error writing config file: %w", err)
+  }
+  return nil
+}
+
+// editConfigData applies edit to the contents of a config file in the format of its name, returning the new contents.
+func editConfigData(file string, data []byte, edit func(fields []configField) ([]configField, error)) ([]byte, error) {
+  switch documentFormat(file) {
+  case "yaml":
+    return editYAMLConfig(data, edit)
+  case "toml":
+    return editTOMLConfig(file, data, edit)
+  }
+
+  fields, err := parseConfigFields(file, data)
+  if err != nil {
+    return nil, err
+  }
+  original := append([]configField(nil), fields...)
+  if fields, err = edit(fields); err != nil {
+    return nil, err
+  }
+  return spliceConfigFields(file, data, original, fields)
+}
+
+// skipJSONSpace returns the index of the first byte from i that isn't whitespace or one of the separators.
+func skipJSONSpace(data []byte, i int, separators string) int {
+  for i < len(data) && strings.ContainsRune(" \t\r\n"+separators, rune(data[i])) {
+    i++
+  }
+  return i
+}
+
+// spliceConfigFields edits a JSON config file from its original fields to the updated ones, changing only the text
+// of the keys that changed so comments and layout are kept. If that can't be done, a file without comments is
+// written again in full, and one with comments is left for the user to edit.
+func spliceConfigFields(file string, data []byte, original, updated []configField) ([]byte, error) {
+  out, err := spliceChangedFields(file, data, original, updated)
+  if err == nil {
+    out, err = spliceAddedFields(file, out, updated)
+  }
+  if err == nil {
+    var fields []configField
+    if fields, err = parseConfigFields(file, out); err == nil && sameConfigFields(fields, updated) {
+      return out, nil
+    }
+  }
+
+  stripped, err := stripJSONC(file, data)
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(stripped, data) {
+    return nil, fmt.Errorf("error editing %s: the change can't be made without losing its comments, make it by hand", file)
+  }
+  return formatConfigFields(updated)
+}
+
+// jsonSplice replaces a byte range of a JSON document with text.
+type jsonSplice struct {
+  start, end int
+  text       string
+}
+
+// applySplices makes the splices to the data, which mustn't overlap.
+func applySplices(file string, data []byte, splices []jsonSplice) ([]byte, error) {
+  sort.SliceStable(splices, func(i, j int) bool { return splices[i].start < splices[j].start })
+  var out bytes.Buffer
+  at := 0
+  for _, s := range splices {
+    if s.start < at {
+      return nil, fmt.Errorf("error editing %s: overlapping changes", file)
+    }
+    out.Write(data[at:s.start])
+    out.WriteString(s.text)
+    at = s.end
+  }
+  out.Write(data[at:])
+  return out.Bytes(), nil
+}
+
+// spliceChangedFields replaces the values that changed and removes the keys that are gone.
+func spliceChangedFields(file string, data []byte, original, updated []configField) ([]byte, error) {
+  stripped, err := stripJSONC(file, data)
+  if err != nil {
+    return nil, err
+  }
+
+  // The keys that are kept must stay in their order.
+  var kept []string
+  for _, field := range updated {
+    if getConfigField(original, field.Key) != nil {
+      kept = append(kept, field.Key)
+    }
+  }
+
+  var splices []jsonSplice
+  for i, field := range original {
+    value := getConfigField(updated, field.Key)
+    if value != nil {
+      if len(kept) == 0 || kept[0] != field.Key {
+        return nil, fmt.Errorf("error editing %s: keys were reordered", file)
+      }
+      kept = kept[1:]
+      if sameJSON(field.Value, value) {
+        continue
+      }
+      // Items added to the end of a list, such as a new subscription, leave the others as written.
+      if appended, ok := spliceAppendedItems(data, stripped, field, value); ok {
+        splices = append(splices, appended...)
+        continue
+      }
+      text, err := formatJSONEntry(value, lineIndent(data, field.start), false)
+      if err != nil {
+        return nil, err
+      }
+      splices = append(splices, jsonSplice{field.valueStart, field.valueEnd, text})
+      continue
+    }
+
+    // Remove the key with its comma, and its line if nothing else is on it.
+    start, end := field.start, field.valueEnd
+    if comma := commaAfter(data, stripped, end); comma >= 0 {
+      end = comma + 1
+    } else {
+      // The last key takes the comma of the last key kept before it.
+      for p := i - 1; p >= 0; p-- {
+        if getConfigField(updated, original[p].Key) != nil {
+          if comma := commaAfter(data, stripped, original[p].valueEnd); comma >= 0 {
+            splices = append(splices, jsonSplice{comma, comma + 1, ""})
+          }
+          break
+        }
+      }
+    }
+    lineStart := bytes.LastIndexByte(stripped[:start], '\n') + 1
+    if len(bytes.TrimSpace(stripped[lineStart:start])) == 0 {
+      start = lineStart
+    }
+    if lineEnd := bytes.IndexByte(stripped[end:], '\n'); lineEnd >= 0 && len(bytes.TrimSpace(stripped[end:end+lineEnd])) == 0 {
+      end += lineEnd + 1
+    }
+    splices = append(splices, jsonSplice{start, end, ""})
+  }
+  return applySplices(file, data, splices)
+}
+
+// spliceAppendedItems returns the splices that add items to the end of a field's list,
+// if the updated value is the old list with items added.
+func spliceAppendedItems(data, stripped []byte, field configField, value json.RawMessage) ([]jsonSplice, bool) {
+  var old, updated []json.RawMessage
+  if json.Unmarshal(field.Value, &old) != nil || json.Unmarshal(value, &updated) != nil || len(updated) <= len(old) {
+    return nil, false
+  }
+  for i := range old {
+    if !sameJSON(old[i], updated[i]) {
+      return nil, false
+    }
+  }
+
+  // Find where the last item is.
+  lastStart, lastEnd := -1, -1
+  decoder := json.NewDecoder(bytes.NewReader(stripped[field.valueStart:field.valueEnd]))
+  if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+    return nil, false
+  }
+  end := int(decoder.InputOffset())
+  for decoder.More() {
+    lastStart = field.valueStart + skipJSONSpace(stripped[field.valueStart:], end, ",")
+    var item json.RawMessage
+    if err := decoder.Decode(&item); err != nil {
+      return nil, false
+    }
+    end = int(decoder.InputOffset())
+    lastEnd = field.valueStart + end
+  }
+
+  splices, err := appendJSONEntries(data, stripped, field.valueStart, lastStart, lastEnd, func(indent string, inline bool) ([]string, error) {
+    var entries []string
+    for _, item := range updated[len(old):] {
+      text, err := formatJSONEntry(item, indent, inline)
+      if err != nil {
+        return nil, err
+      }
+      entries = append(entries, text)
+    }
+    return entries, nil
+  })
+  return splices, err == nil
+}
+
+// spliceAddedFields adds the keys that are new after the last key, or at the start of an empty object.
+func spliceAddedFields(file string, data []byte, updated []configField) ([]byte, error) {
+  fields, err := parseConfigFields(file, data)
+  if err != nil {
+    return nil, err
+  }
+  var added []configField
+  for _, field := range updated {
+    if getConfigField(fields, field.Key) == nil {
+      added = append(added, field)
+    }
+  }
+  if len(added) == 0 {
+    return data, nil
+  }
+  stripped, err := stripJSONC(file, data)
+  if err != nil {
+    return nil, err
+  }
+
+  lastStart, lastEnd := -1, -1
+  if len(fields) > 0 {
+    lastStart, lastEnd = fields[len(fields)-1].start, fields[len(fields)-1].valueEnd
+  }
+  splices, err := appendJSONEntries(data, stripped, bytes.IndexByte(stripped, '{'), lastStart, lastEnd, func(indent string, inline bool) ([]string, error) {
+    var entries []string
+    for _, field := range added {
+      key, err := json.Marshal(field.Key)
+      if err != nil {
+        return nil, err
+      }
+      value, err := formatJSONEntry(field.Value, indent, inline)
+      if err != nil {
+        return nil, err
+      }
+      entries = append(entries, string(key)+": "+value)
+    }
+    return entries, nil
+  })
+  if err != nil {
+    return nil, err
+  }
+  return applySplices(file, data, splices)
+}
+
+// appendJSONEntries returns the splices that add entries to the object or array opening at open, after its last
+// entry from lastStart to lastEnd, or at its start if lastEnd is -1. Entries go on their own lines, after any
+// comment on the line of the last entry, unless the object or array closes on that line.
+func appendJSONEntries(data, stripped []byte, open, lastStart, lastEnd int, entries func(indent string, inline bool) ([]string, error)) ([]jsonSplice, error) {
+  indent, at, comma, trailing := lineIndent(data, open)+"  ", open+1, false, false
+  if lastEnd >= 0 {
+    indent, at = lineIndent(data, lastStart), lastEnd
+    if commaAfter(data, stripped, at) >= 0 {
+      trailing = true
+    } else {
+      comma = true
+    }
+  }
+  inline := true
+  if lineEnd := bytes.IndexByte(stripped[at:], '\n'); lineEnd >= 0 && !bytes.ContainsAny(stripped[at:at+lineEnd], "]}") {
+    at += lineEnd
+    inline = false
+  }
+
+  texts, err := entries(indent, inline)
+  if err != nil {
+    return nil, err
+  }
+  var text strings.Builder
+  for i, entry := range texts {
+    if i > 0 {
+      text.WriteString(",")
+    }
+    switch {
+    case !inline:
+      text.WriteString("\n" + indent)
+    case i > 0 || lastEnd >= 0:
+      text.WriteString(" ")
+    }
+    text.WriteString(entry)
+  }
+  if trailing {
+    text.WriteString(",")
+  }
+
+  var splices []jsonSplice
+  if comma {
+    // The comma goes straight after the last entry, before any comment.
+    splices = append(splices, jsonSplice{lastEnd, lastEnd, ","})
+  }
+  return append(splices, jsonSplice{at, at, text.String()}), nil
+}
+
+// commaAfter returns the index of the comma after a value, including a trailing comma stripJSONC blanked, or -1.
+func commaAfter(data, stripped []byte, i int) int {
+  for ; i < len(data); i++ {
+    switch {
+    case data[i] == ',':
+      return i
+    case stripped[i] != data[i] || !strings.ContainsRune(" \t\r\n", rune(data[i])):
+      return -1
+    }
+  }
+  return -1
+}
+
+// lineIndent returns the whitespace at the start of the line holding the offset.
+func lineIndent(data []byte, offset int) string {
+  start := bytes.LastIndexByte(data[:offset], '\n') + 1
+  end := start
+  for end < offset && (data[end] == ' ' || data[end] == '\t') {
+    end++
+  }
+  return string(data[start:end])
+}
+
+// formatJSONEntry formats a value on one line, or indented for a line with the indent.
+func formatJSONEntry(value json.RawMessage, indent string, inline bool) (string, error) {
+  var out bytes.Buffer
+  err := json.Compact(&out, value)
+  if !inline {
+    out.Reset()
+    err = json.Indent(&out, value, indent, "  ")
+  }
+  if err != nil {
+    return "", fmt.Errorf("error formatting config value: %w", err)
+  }
+  return out.String(), nil
+}
+
+// sameConfigFields reports whether two lists of fields have the same keys in the same order with the same values.
+func sameConfigFields(a, b []configField) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for i := range a {
+    if a[i].Key != b[i].Key || !sameJSON(a[i].Value, b[i].Value) {
+      return false
+    }
+  }
+  return true
+}
+
+// editYAMLConfig edits the top-level keys of a YAML config file, keeping the nodes and comments of the keys that didn't change.
+func editYAMLConfig(data []byte, edit func(fields []configField) ([]configField, error)) ([]byte, error) {
+  var document yaml.Node
+  if err := yaml.Unmarshal(data, &document); err != nil {
+    return nil, fmt.Errorf("error parsing config file: %w", err)
+  }
+  if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+    return nil, fmt.Errorf("error parsing config file: expected a mapping")
+  }
+  mapping := document.Content[0]
+
+  // Read the fields, remembering the nodes each came from.
+  type nodes struct {
+    key, value *yaml.Node
+    raw        json.RawMessage
+  }
+  original := make(map[string]nodes)
+  var fields []configField
+  for i := 0; i+1 < len(mapping.Content); i += 2 {
+    var value interface{}
+    if err := decodeYAML(mapping.Content[i+1], &value); err != nil {
+      return nil, fmt.Errorf("error parsing config file value for %s: %w", mapping.Content[i].Value, err)
+    }
+    raw, err := json.Marshal(value)
+    if err != nil {
+      return nil, fmt.Errorf("error parsing config file value for %s: %w", mapping.Content[i].Value, err)
+    }
+    original[mapping.Content[i].Value] = nodes{key: mapping.Content[i], value: mapping.Content[i+1], raw: raw}
+    fields = append(fields, configField{Key: mapping.Content[i].Value, Value: raw})
+  }
+
+  fields, err := edit(fields)
+  if err != nil {
+    return nil, err
+  }
+
+  // Rebuild the mapping, only replacing the values that changed.
+  var content []*yaml.Node
+  for _, field := range fields {
+    old, ok := original[field.Key]
+    if ok && bytes.Equal(old.raw, field.Value) {
+      content = append(content, old.key, old.value)
+      continue
+    }
+    value, err := yamlNodeFromJSON(field.Value)
+    if err != nil {
+      return nil, fmt.Errorf("error encoding config value for %s: %w", field.Key, err)
+    }
+    key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: field.Key}
+    if ok {
+      // Unchanged items of a changed list, such as the subscriptions, keep their comments.
+      key, value = old.key, reuseYAMLNode(old.value, value)
+    }
+    content = append(content, key, value)
+  }
+  mapping.Content = content
+
+  var out bytes.Buffer
+  encoder := yaml.NewEncoder(&out)
+  encoder.SetIndent(2)
+  if err := encoder.Encode(&document); err != nil {
+    return nil, fmt.Errorf("error encoding config file: %w", err)
+  }
+  return out.Bytes(), nil
+}
+
+// editTOMLConfig edits the top-level keys of a TOML config file, keeping the text and comments of everything that didn't change.
+func editTOMLConfig(file string, data []byte, edit func(fields []configField) ([]configField, error)) ([]byte, error) {
+  var document map[string]interface{}
+  meta, err := toml.Decode(string(data), &document)
+  if err != nil {
+    return nil, fmt.Errorf("error parsing config file: %w", err)
+  }
+
+  // Read the top-level keys in the order they appear.
+  var fields []configField
+  for _, key := range meta.Keys() {
+    if len(key) != 1 {
+      continue
+    }
+    raw, err := json.Marshal(tomlDatesAsStrings(document[key[0]]))
+    if err != nil {
+      return nil, fmt.Errorf("error parsing config file value for %s: %w", key[0], err)
+    }
+    fields = append(fields, configField{Key: key[0], Value: raw})
+  }
+
+  if fields, err = edit(fields); err != nil {
+    return nil, err
+  }
+
+  out, err := formatConfigFields(fields)
+  if err != nil {
+    return nil, err
+  }
+  return updateDocument(file, data, out)
+}
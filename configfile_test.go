@@ -0,0 +1,189 @@
+package main
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestEditConfigDataJSONC(t *testing.T) {
+  const config = `{
+  // My instance.
+  "mastodon_server": "https://example.social", // the home server
+  "access_token": "abc",
+  /* Where lists live. */
+  "filters_import": "filters/"
+}
+`
+  set := func(key string, value interface{}) func([]configField) ([]configField, error) {
+    return func(fields []configField) ([]configField, error) {
+      return setConfigField(fields, key, value)
+    }
+  }
+  remove := func(key string) func([]configField) ([]configField, error) {
+    return func(fields []configField) ([]configField, error) {
+      var kept []configField
+      for _, field := range fields {
+        if field.Key != key {
+          kept = append(kept, field)
+        }
+      }
+      return kept, nil
+    }
+  }
+
+  tests := []struct {
+    name string
+    data string
+    edit func([]configField) ([]configField, error)
+    want string
+  }{
+    {"value changed", config, set("access_token", "xyz"), strings.Replace(config, `"abc"`, `"xyz"`, 1)},
+    {"key added", config, set("tags_import", "tags/"), strings.Replace(config, `"filters/"`, "\"filters/\",\n  \"tags_import\": \"tags/\"", 1)},
+    {"key added after a trailing comma", `{
+  "a": 1, // one
+}`, set("b", []int{2}), `{
+  "a": 1, // one
+  "b": [
+    2
+  ],
+}`},
+    {"key added after a comment", `{
+  "a": 1 // one
+}`, set("b", 2), `{
+  "a": 1, // one
+  "b": 2
+}`},
+    {"key added on one line", `{"a": 1}`, set("b", []int{2}), `{"a": 1, "b": [2]}`},
+    {"key added to an empty object", `{}`, set("a", 1), `{"a": 1}`},
+    {"key added to an empty object on lines", "{\n}\n", set("a", 1), "{\n  \"a\": 1\n}\n"},
+    {"key removed", config, remove("access_token"), strings.Replace(config, "  \"access_token\": \"abc\",\n", "", 1)},
+    {"last key removed", config, remove("filters_import"), strings.Replace(config, "\"abc\",\n  /* Where lists live. */\n  \"filters_import\": \"filters/\"\n", "\"abc\"\n  /* Where lists live. */\n", 1)},
+  }
+  for _, test := range tests {
+    got, err := editConfigData("config.json", []byte(test.data), test.edit)
+    if err != nil {
+      t.Errorf("%s: %s", test.name, err)
+      continue
+    }
+    if string(got) != test.want {
+      t.Errorf("%s: edited to\n%s\nwant\n%s", test.name, got, test.want)
+    }
+  }
+
+  // Reordering keys can't keep the comments, so a commented file is refused and a plain one is written again.
+  reverse := func(fields []configField) ([]configField, error) {
+    reversed := make([]configField, len(fields))
+    for i, field := range fields {
+      reversed[len(fields)-1-i] = field
+    }
+    return reversed, nil
+  }
+  if _, err := editConfigData("config.json", []byte(config), reverse); err == nil || !strings.Contains(err.Error(), "by hand") {
+    t.Errorf("reordering a commented file: %v", err)
+  }
+  got, err := editConfigData("config.json", []byte(`{"a": 1, "b": 2}`), reverse)
+  if err != nil || string(got) != "{\n  \"b\": 2,\n  \"a\": 1\n}\n" {
+    t.Errorf("reordering a plain file = %s, %v", got, err)
+  }
+}
+
+func TestParseConfigFields(t *testing.T) {
+  fields, err := parseConfigFields("config.json", []byte(`{"a": "// not a comment", /* b */ "b": [1, 2,], }`))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(fields) != 2 || string(fields[0].Value) != `"// not a comment"` || fields[1].Key != "b" {
+    t.Fatalf("parsed %+v", fields)
+  }
+  var b []int
+  if err := json.Unmarshal(fields[1].Value, &b); err != nil || len(b) != 2 {
+    t.Errorf("b = %s", fields[1].Value)
+  }
+}
+
+func TestAddSubscription(t *testing.T) {
+  tests := map[string]string{
+    "config.json": `{
+  "instance_url": "https://example.social",
+  "subscriptions": [
+    // Shared by a friend.
+    {"kind": "filters", "url": "https://example.com/filters.json"}, // checked daily
+  ]
+}
+`,
+    "config.yaml": `instance_url: https://example.social
+subscriptions:
+  # Shared by a friend.
+  - kind: filters
+    url: https://example.com/filters.json # checked daily
+`,
+    "config.toml": `instance_url = "https://example.social"
+
+# Shared by a friend.
+[[subscriptions]]
+kind = "filters"
+url = "https://example.com/filters.json" # checked daily
+`,
+  }
+
+  dir := t.TempDir()
+  for name, data := range tests {
+    file := filepath.Join(dir, name)
+    if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+      t.Fatal(err)
+    }
+    if err := addSubscription(file, Subscription{Kind: "tags", URL: "https://example.com/tags.json"}); err != nil {
+      t.Fatalf("%s: %s", name, err)
+    }
+    if err := addSubscription(file, Subscription{Kind: "tags", URL: "https://example.com/tags.json"}); err == nil {
+      t.Errorf("%s: subscribed twice", name)
+    }
+
+    edited, err := ioutil.ReadFile(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    for _, comment := range []string{"Shared by a friend.", "checked daily"} {
+      if !strings.Contains(string(edited), comment) {
+        t.Errorf("%s: %q lost from\n%s", name, comment, edited)
+      }
+    }
+    fields, err := readConfigFields(file, edited)
+    if err != nil {
+      t.Fatal(err)
+    }
+    var subs []Subscription
+    if err := json.Unmarshal(getConfigField(fields, "subscriptions"), &subs); err != nil || len(subs) != 2 || subs[1].Kind != "tags" {
+      t.Errorf("%s: subscriptions %+v, %v", name, subs, err)
+    }
+  }
+}
+
+func TestEditTOMLConfig(t *testing.T) {
+  const config = `# My instance.
+instance_url = "https://example.social" # the home server
+filters_import = "filters/"
+
+# Shared by a friend.
+[[subscriptions]]
+kind = "filters"
+url = "https://example.com/filters.json"
+`
+  edited, err := editConfigData("config.toml", []byte(config), func(fields []configField) ([]configField, error) {
+    fields, err := setConfigField(fields, "filters_import", "lists/")
+    if err != nil {
+      return nil, err
+    }
+    return setConfigField(fields, "tags_import", "tags/")
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := strings.Replace(config, `filters_import = "filters/"`, "filters_import = \"lists/\"\ntags_import = \"tags/\"", 1)
+  if string(edited) != want {
+    t.Errorf("edited to\n%s\nwant\n%s", edited, want)
+  }
+}
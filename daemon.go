@@ -0,0 +1,419 @@
+package main
+
+// Long-running daemon mode that re-syncs subscriptions on a schedule.
+
+import (
+  "context"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "net/http"
+  "os"
+  "os/signal"
+  "strings"
+  "sync"
+  "syscall"
+  "time"
+)
+
+// defaultSubscriptionInterval is how often a subscription is polled when it doesn't set an interval.
+const defaultSubscriptionInterval = time.Hour
+
+// Subscription is a remote filter list, tag list or bundle that the daemon keeps the account in sync with.
+type Subscription struct {
+  Kind     string    `json:"kind"`
+  URL      string    `json:"url"`
+  Interval *Duration `json:"interval,omitempty"`
+  SHA256   string    `json:"sha256,omitempty"`
+}
+
+// subscriptions returns the configured subscriptions, including the legacy filters_import_url and tags_import_url.
+func (config *MastodonConfig) subscriptions() ([]Subscription, error) {
+  subs := append([]Subscription{}, config.Subscriptions...)
+  if config.FilterURL != "" {
+    subs = append(subs, Subscription{Kind: "filters", URL: config.FilterURL, SHA256: config.FilterSHA256})
+  }
+  if config.TagsURL != "" {
+    subs = append(subs, Subscription{Kind: "tags", URL: config.TagsURL, SHA256: config.TagsSHA256})
+  }
+
+  for _, sub := range subs {
+    if sub.Kind != "filters" && sub.Kind != "tags" && sub.Kind != "bundle" {
+      return nil, fmt.Errorf("subscription %s: kind must be filters, tags or bundle", sub.URL)
+    }
+    if sub.URL == "" {
+      return nil, fmt.Errorf("subscription is missing a url")
+    }
+  }
+
+  return subs, nil
+}
+
+// addSubscription adds a subscription to the end of the config file's list, keeping the existing ones and their comments.
+func addSubscription(configFile string, added Subscription) error {
+  return editConfigFile(configFile, func(fields []configField) ([]configField, error) {
+    var subs []json.RawMessage
+    if raw := getConfigField(fields, "subscriptions"); raw != nil {
+      if err := json.Unmarshal(raw, &subs); err != nil {
+        return nil, fmt.Errorf("error parsing subscriptions: %w", err)
+      }
+    }
+
+    for _, raw := range subs {
+      var sub Subscription
+      if err := json.Unmarshal(raw, &sub); err != nil {
+        return nil, fmt.Errorf("error parsing subscriptions: %w", err)
+      }
+      if sub.URL == added.URL {
+        return nil, fmt.Errorf("already subscribed to %s", added.URL)
+      }
+    }
+    data, err := json.Marshal(added)
+    if err != nil {
+      return nil, err
+    }
+    return setConfigField(fields, "subscriptions", append(subs, data))
+  })
+}
+
+// removeSubscription removes the subscription to a URL from the config file, including the legacy filters_import_url and tags_import_url.
+func removeSubscription(configFile, url string) error {
+  return editConfigFile(configFile, func(fields []configField) ([]configField, error) {
+    found := false
+    for _, legacy := range [][2]string{{"filters_import_url", "filters_import_sha256"}, {"tags_import_url", "tags_import_sha256"}} {
+      var value string
+      if raw := getConfigField(fields, legacy[0]); raw != nil && json.Unmarshal(raw, &value) == nil && value == url {
+        found = true
+        var err error
+        if fields, err = setConfigField(fields, legacy[0], ""); err != nil {
+          return nil, err
+        }
+        if getConfigField(fields, legacy[1]) != nil {
+          if fields, err = setConfigField(fields, legacy[1], ""); err != nil {
+            return nil, err
+          }
+        }
+      }
+    }
+
+    var subs, kept []json.RawMessage
+    if raw := getConfigField(fields, "subscriptions"); raw != nil {
+      if err := json.Unmarshal(raw, &subs); err != nil {
+        return nil, fmt.Errorf("error parsing subscriptions: %w", err)
+      }
+    }
+    for _, raw := range subs {
+      var sub Subscription
+      if err := json.Unmarshal(raw, &sub); err != nil {
+        return nil, fmt.Errorf("error parsing subscriptions: %w", err)
+      }
+      if sub.URL == url {
+        found = true
+        continue
+      }
+      kept = append(kept, raw)
+    }
+    if !found {
+      return nil, fmt.Errorf("not subscribed to %s", url)
+    }
+    if kept == nil {
+      kept = []json.RawMessage{}
+    }
+    return setConfigField(fields, "subscriptions", kept)
+  })
+}
+
+// guessListKind works out the kind of list at a URL from its path, or returns "" if it can't tell.
+func guessListKind(url string) string {
+  switch {
+  case strings.Contains(url, "/filters/"):
+    return "filters"
+  case strings.Contains(url, "/tags/"):
+    return "tags"
+  case strings.HasSuffix(url, ".tar.gz") || strings.HasSuffix(url, ".tgz") || strings.Contains(url, "/bundles/"):
+    return "bundle"
+  }
+  return ""
+}
+
+// subscribeCommand defines the subscribe command's flags and returns the function that runs it.
+func subscribeCommand(flags *flag.FlagSet) func(args []string) error {
+  kind := flags.String("kind", "", "the kind of list: filters, tags or bundle (default guessed from the URL)")
+  interval := flags.String("interval", "", "how often the daemon checks the list, e.g. 30m or 1d (default 1h)")
+  checksum := flags.String("sha256", "", "the SHA-256 checksum the list must have")
+  return func(args []string) error {
+    if len(args) != 1 {
+      flags.Usage()
+      return fmt.Errorf("missing list URL")
+    }
+    sub := Subscription{Kind: *kind, URL: args[0], SHA256: *checksum}
+    if !isURL(sub.URL) {
+      return fmt.Errorf("%s is not an http or https URL", sub.URL)
+    }
+    if sub.Kind == "" {
+      if sub.Kind = guessListKind(sub.URL); sub.Kind == "" {
+        return fmt.Errorf("can't tell what kind of list %s is, use -kind filters, tags or bundle", sub.URL)
+      }
+    }
+    if sub.Kind != "filters" && sub.Kind != "tags" && sub.Kind != "bundle" {
+      return fmt.Errorf("kind must be filters, tags or bundle")
+    }
+    if *interval != "" {
+      parsed, err := parseDuration(*interval)
+      if err != nil {
+        return fmt.Errorf("invalid interval: %w", err)
+      }
+      d := Duration(parsed)
+      sub.Interval = &d
+    }
+
+    if err := addSubscription(*configFile, sub); err != nil {
+      return err
+    }
+    fmt.Printf("Subscribed to %s (%s)\n", sub.URL, sub.Kind)
+    return nil
+  }
+}
+
+// unsubscribeCommand returns the function that runs the unsubscribe command.
+func unsubscribeCommand(flags *flag.FlagSet) func(args []string) error {
+  return func(args []string) error {
+    if len(args) != 1 {
+      flags.Usage()
+      return fmt.Errorf("missing list URL")
+    }
+    if err := removeSubscription(*configFile, args[0]); err != nil {
+      return err
+    }
+    fmt.Printf("Unsubscribed from %s\n", args[0])
+    return nil
+  }
+}
+
+// interval returns how often the subscription should be polled.
+func (sub Subscription) interval() time.Duration {
+  if sub.Interval == nil || *sub.Interval <= 0 {
+    return defaultSubscriptionInterval
+  }
+  return time.Duration(*sub.Interval)
+}
+
+// conditionalResponse is the result of a conditional GET of a subscription.
+type conditionalResponse struct {
+  Body         []byte
+  ETag         string
+  LastModified string
+  NotModified  bool
+}
+
+// downloadConditional downloads a URL, sending the validators from a previous response so unchanged lists aren't downloaded again.
+func downloadConditional(ctx context.Context, url, etag, lastModified string) (*conditionalResponse, error) {
+  // Create an HTTP request to download the file.
+  req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+  if err != nil {
+    return nil, fmt.Errorf("error creating request: %w", err)
+  }
+  if etag != "" {
+    req.Header.Set("If-None-Match", etag)
+  }
+  if lastModified != "" {
+    req.Header.Set("If-Modified-Since", lastModified)
+  }
+
+  // Send the request and get the response.
+  resp, err := httpClient.Do(req)
+  if err != nil {
+    return nil, fmt.Errorf("error sending request: %w", err)
+  }
+  defer resp.Body.Close()
+
+  if resp.StatusCode == http.StatusNotModified {
+    return &conditionalResponse{ETag: etag, LastModified: lastModified, NotModified: true}, nil
+  }
+
+  // Check the response status code.
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
+  }
+
+  // Read the response body.
+  body, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return nil, fmt.Errorf("error reading response body: %w", err)
+  }
+
+  return &conditionalResponse{
+    Body:         body,
+    ETag:         resp.Header.Get("ETag"),
+    LastModified: resp.Header.Get("Last-Modified"),
+  }, nil
+}
+
+// subscriptionPoller holds what the daemon remembers about a subscription between polls.
+type subscriptionPoller struct {
+  config  *MastodonConfig
+  sub     Subscription
+  filters []*Filter
+  tags    []*Tag
+  bundle  *ListFile
+  applied bool
+
+  // applyMu is shared by all pollers so only one of them changes the account at a time.
+  applyMu *sync.Mutex
+}
+
+// poll fetches the subscription if it has changed and applies it to the account without prompting.
+func (p *subscriptionPoller) poll(ctx context.Context) error {
+  resp, err := fetchSubscription(ctx, p.config, p.sub)
+  if err != nil {
+    return fmt.Errorf("error downloading %s: %w", p.sub.URL, err)
+  }
+
+  // Parse the list when it changed, or when it was served from the cache of a previous run.
+  if !resp.NotModified || (p.filters == nil && p.tags == nil && p.bundle == nil) {
+    body := resp.Body
+    if p.sub.Kind != "bundle" {
+      if body, err = decodeDocument(documentFormat(p.sub.URL), body); err != nil {
+        return fmt.Errorf("error reading %s: %w", p.sub.URL, err)
+      }
+    }
+    switch p.sub.Kind {
+    case "filters":
+      if p.filters, err = parseFilterList(body); err != nil {
+        return fmt.Errorf("error reading %s: %w", p.sub.URL, err)
+      }
+    case "tags":
+      if p.tags, err = parseTagList(body); err != nil {
+        return fmt.Errorf("error reading %s: %w", p.sub.URL, err)
+      }
+    case "bundle":
+      if p.bundle, err = parseBundle(p.sub.URL, resp.Body); err != nil {
+        return err
+      }
+    }
+  }
+
+  p.applyMu.Lock()
+  defer p.applyMu.Unlock()
+
+  switch p.sub.Kind {
+  case "filters":
+    // Scheduled filters can change state without the list changing, so filters are re-planned on every poll.
+    changes, err := applyFilterSync(p.config, p.filters, false)
+    for _, change := range changes {
+      log.Printf("%s %s: %s filter %q (%s)", p.sub.Kind, p.sub.URL, change.Action, change.Title, change.Reason)
+    }
+    if err != nil {
+      return err
+    }
+    if len(changes) == 0 && resp.NotModified {
+      log.Printf("%s %s: not modified", p.sub.Kind, p.sub.URL)
+    } else if len(changes) == 0 {
+      log.Printf("%s %s: in sync", p.sub.Kind, p.sub.URL)
+    }
+
+  case "tags":
+    if resp.NotModified && p.applied {
+      log.Printf("%s %s: not modified", p.sub.Kind, p.sub.URL)
+      return nil
+    }
+    changes, err := applyTagSync(p.config, p.tags, false)
+    p.applied = err == nil
+    for _, change := range changes {
+      log.Printf("%s %s: %s #%s", p.sub.Kind, p.sub.URL, change.Action, change.Name)
+    }
+    if err != nil {
+      return err
+    }
+    if len(changes) == 0 {
+      log.Printf("%s %s: in sync", p.sub.Kind, p.sub.URL)
+    }
+
+  case "bundle":
+    // Bundles can hold scheduled filters too, so they are re-planned on every poll.
+    plan, err := planBundle(p.config, p.bundle, false)
+    if err != nil {
+      return err
+    }
+    if plan.empty() {
+      log.Printf("%s %s: in sync", p.sub.Kind, p.sub.URL)
+      return nil
+    }
+    log.Printf("%s %s: applying %s", p.sub.Kind, p.sub.URL, plan.summary())
+    if done, err := applyBundlePlan(p.config, plan, "daemon sync of bundle "+p.sub.URL); err != nil {
+      if !done.empty() {
+        log.Printf("%s %s: applied %s before failing", p.sub.Kind, p.sub.URL, done.summary())
+      }
+      return err
+    }
+  }
+
+  return nil
+}
+
+// run polls the subscription on its interval until the context is cancelled.
+func (p *subscriptionPoller) run(ctx context.Context) {
+  ticker := time.NewTicker(p.sub.interval())
+  defer ticker.Stop()
+
+  for {
+    if err := p.poll(ctx); err != nil && ctx.Err() == nil {
+      log.Printf("%s %s: %s", p.sub.Kind, p.sub.URL, err)
+    }
+
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+    }
+  }
+}
+
+// runDaemon polls every subscription on its own interval until SIGINT or SIGTERM is received.
+func runDaemon(config *MastodonConfig) error {
+  subs, err := config.subscriptions()
+  if err != nil {
+    return err
+  }
+  if len(subs) == 0 {
+    return fmt.Errorf("no subscriptions, filters_import_url or tags_import_url in configuration")
+  }
+
+  // Stop polling when asked to shut down.
+  ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+  defer stop()
+
+  var wg sync.WaitGroup
+  var applyMu sync.Mutex
+  for _, sub := range subs {
+    log.Printf("%s %s: polling every %s", sub.Kind, sub.URL, sub.interval())
+    poller := &subscriptionPoller{config: config, sub: sub, applyMu: &applyMu}
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      poller.run(ctx)
+    }()
+  }
+
+  <-ctx.Done()
+  log.Printf("shutting down")
+  wg.Wait()
+
+  return nil
+}
+
+// daemonCommand returns the function that runs the daemon command.
+func daemonCommand(flags *flag.FlagSet) func(args []string) error {
+  return func(args []string) error {
+    if jsonResult != nil {
+      return fmt.Errorf("the daemon logs what it does and runs until stopped, so it can't print a JSON result")
+    }
+    config, err := loadAccount()
+    if err != nil {
+      return err
+    }
+    return runDaemon(config)
+  }
+}
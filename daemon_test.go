@@ -0,0 +1,50 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+  "time"
+)
+
+func TestSubscriptionInterval(t *testing.T) {
+  tests := []struct {
+    config string
+    want   time.Duration
+  }{
+    {`{"url": "https://example.com/tags/a.json"}`, defaultSubscriptionInterval},
+    {`{"url": "https://example.com/tags/a.json", "interval": "30m"}`, 30 * time.Minute},
+    {`{"url": "https://example.com/tags/a.json", "interval": "1d"}`, 24 * time.Hour},
+    {`{"url": "https://example.com/tags/a.json", "interval": 900}`, 15 * time.Minute},
+    {`{"url": "https://example.com/tags/a.json", "interval": 0}`, defaultSubscriptionInterval},
+  }
+  for _, test := range tests {
+    var sub Subscription
+    if err := json.Unmarshal([]byte(test.config), &sub); err != nil {
+      t.Errorf("%s: %v", test.config, err)
+      continue
+    }
+    if got := sub.interval(); got != test.want {
+      t.Errorf("%s: interval %v, want %v", test.config, got, test.want)
+    }
+  }
+
+  var sub Subscription
+  if err := json.Unmarshal([]byte(`{"interval": "often"}`), &sub); err == nil {
+    t.Errorf("an interval of \"often\" was accepted")
+  }
+}
+
+func TestGuessListKind(t *testing.T) {
+  tests := map[string]string{
+    "https://example.com/lists/filters/sports.json": "filters",
+    "https://example.com/lists/tags/cycling.yaml":   "tags",
+    "https://example.com/everything.tar.gz":         "bundle",
+    "https://example.com/bundles/starter":           "bundle",
+    "https://example.com/list.json":                 "",
+  }
+  for url, want := range tests {
+    if got := guessListKind(url); got != want {
+      t.Errorf("guessListKind(%q) = %q, want %q", url, got, want)
+    }
+  }
+}
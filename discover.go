@@ -0,0 +1,330 @@
+package main
+
+// Discovering tags to follow from trends, the accounts the user follows and the tags used alongside followed tags.
+
+import (
+  "errors"
+  "fmt"
+  "net/url"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// tagCandidate is a tag that might be worth following, with why.
+type tagCandidate struct {
+  Name     string
+  URL      string
+  Trending bool
+  Accounts map[string]bool // followed accounts that used the tag
+  With     map[string]int  // followed tags it was used with, and how often
+}
+
+// score ranks a candidate: trending is worth 5, each followed account using it 3, and each post alongside a followed tag 1.
+func (c *tagCandidate) score() int {
+  score := 3 * len(c.Accounts)
+  if c.Trending {
+    score += 5
+  }
+  for _, n := range c.With {
+    score += n
+  }
+  return score
+}
+
+// reasons describes why the tag was suggested.
+func (c *tagCandidate) reasons() string {
+  var reasons []string
+  if c.Trending {
+    reasons = append(reasons, "trending")
+  }
+  if n := len(c.Accounts); n == 1 {
+    reasons = append(reasons, "used by 1 account you follow")
+  } else if n > 1 {
+    reasons = append(reasons, fmt.Sprintf("used by %d accounts you follow", n))
+  }
+  if len(c.With) > 0 {
+    var with []string
+    for tag := range c.With {
+      with = append(with, "#"+tag)
+    }
+    sort.Strings(with)
+    reasons = append(reasons, "used with "+strings.Join(with, " "))
+  }
+  return strings.Join(reasons, ", ")
+}
+
+// discoverOptions limits how much discovery reads.
+type discoverOptions struct {
+  Accounts int // followed accounts, and followed tags, to read recent posts from
+  Posts    int // recent posts to read from each
+}
+
+// discoverTags gathers candidate tags from the instance's trends, recent posts by followed accounts and recent posts
+// under followed tags, leaving out tags already followed and those in skip. A source that fails is reported and skipped.
+func discoverTags(config *MastodonConfig, options discoverOptions, skip map[string]bool) ([]*tagCandidate, error) {
+  followed, err := fetchTags(config)
+  if err != nil {
+    return nil, fmt.Errorf("error downloading tags: %w", err)
+  }
+  known := make(map[string]bool)
+  for name := range skip {
+    known[name] = true
+  }
+  var followedNames []string
+  for _, tag := range followed {
+    name := normalizeTag(tag.Name)
+    known[name] = true
+    followedNames = append(followedNames, name)
+  }
+
+  candidates := make(map[string]*tagCandidate)
+  candidate := func(name, tagURL string) *tagCandidate {
+    name = normalizeTag(name)
+    if name == "" || known[name] {
+      return nil
+    }
+    c, ok := candidates[name]
+    if !ok {
+      c = &tagCandidate{Name: name, Accounts: make(map[string]bool), With: make(map[string]int)}
+      candidates[name] = c
+    }
+    if c.URL == "" {
+      c.URL = tagURL
+    }
+    return c
+  }
+
+  // Trending tags, which instances can switch off.
+  var trends []Tag
+  if err := fetchJSON(config, "/api/v1/trends/tags?limit=20", &trends); err != nil {
+    fmt.Printf("Skipping trends: %s\n", err)
+  }
+  for _, tag := range trends {
+    if c := candidate(tag.Name, tag.URL); c != nil {
+      c.Trending = true
+    }
+  }
+
+  // Tags in recent posts by followed accounts.
+  var me struct {
+    ID string `json:"id"`
+  }
+  if err := fetchJSON(config, "/api/v1/accounts/verify_credentials", &me); err != nil {
+    return nil, fmt.Errorf("error checking access token: %w", err)
+  }
+  var following []struct {
+    ID   string `json:"id"`
+    Acct string `json:"acct"`
+  }
+  if err := fetchJSON(config, "/api/v1/accounts/"+me.ID+"/following?limit="+strconv.Itoa(options.Accounts), &following); err != nil {
+    fmt.Printf("Skipping followed accounts: %s\n", err)
+  }
+  for _, account := range following {
+    var statuses []discoveredStatus
+    query := url.Values{"limit": {strconv.Itoa(options.Posts)}, "exclude_reblogs": {"true"}}
+    if err := fetchJSON(config, "/api/v1/accounts/"+account.ID+"/statuses?"+query.Encode(), &statuses); err != nil {
+      fmt.Printf("Skipping posts by %s: %s\n", account.Acct, err)
+      continue
+    }
+    for _, status := range statuses {
+      for _, tag := range status.Tags {
+        if c := candidate(tag.Name, tag.URL); c != nil {
+          c.Accounts[account.Acct] = true
+        }
+      }
+    }
+  }
+
+  // Tags used alongside followed tags.
+  if len(followedNames) > options.Accounts {
+    followedNames = followedNames[:options.Accounts]
+  }
+  for _, name := range followedNames {
+    var statuses []discoveredStatus
+    if err := fetchJSON(config, "/api/v1/timelines/tag/"+url.PathEscape(name)+"?limit="+strconv.Itoa(options.Posts), &statuses); err != nil {
+      fmt.Printf("Skipping posts under #%s: %s\n", name, err)
+      continue
+    }
+    for _, status := range statuses {
+      for _, tag := range status.Tags {
+        if c := candidate(tag.Name, tag.URL); c != nil {
+          c.With[name]++
+        }
+      }
+    }
+  }
+
+  // Rank the candidates, best first, then by name so the order is stable.
+  var ranked []*tagCandidate
+  for _, c := range candidates {
+    ranked = append(ranked, c)
+  }
+  sort.Slice(ranked, func(i, j int) bool {
+    if ranked[i].score() != ranked[j].score() {
+      return ranked[i].score() > ranked[j].score()
+    }
+    return ranked[i].Name < ranked[j].Name
+  })
+  return ranked, nil
+}
+
+// discoveredStatus is the part of a status discovery reads.
+type discoveredStatus struct {
+  Tags []struct {
+    Name string `json:"name"`
+    URL  string `json:"url"`
+  } `json:"tags"`
+}
+
+// readTagListFile reads a tag list file for adding to, or returns a new one if the file doesn't exist yet.
+func readTagListFile(file string) (*ListFile, error) {
+  data, err := readDocument(file)
+  if errors.Is(err, os.ErrNotExist) {
+    return &ListFile{FormatVersion: listFormatVersion}, nil
+  }
+  if err != nil {
+    return nil, fmt.Errorf("error reading %s: %w", file, err)
+  }
+  list, err := parseListFile(data, "tags")
+  if err != nil {
+    return nil, fmt.Errorf("error reading %s: %w", file, err)
+  }
+  list.FormatVersion = listFormatVersion
+  return list, nil
+}
+
+// addTagsToFile adds the tags to the list file, in the current format, creating it if needed.
+func addTagsToFile(file string, list *ListFile, tags []*tagCandidate) error {
+  if list.Name == "" {
+    list.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+  }
+  for _, tag := range tags {
+    list.Tags = append(list.Tags, &Tag{Name: tag.Name, URL: tag.URL})
+  }
+  list.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+  return writeListFile(file, "tags", list)
+}
+
+// parseSelection parses the numbers of the picked items, like 1,3-5, all or none, out of n.
+func parseSelection(answer string, n int) ([]int, error) {
+  answer = strings.ToLower(strings.TrimSpace(answer))
+  switch answer {
+  case "", "none", "n":
+    return []int{}, nil
+  case "all", "a":
+    picked := []int{}
+    for i := 0; i < n; i++ {
+      picked = append(picked, i)
+    }
+    return picked, nil
+  }
+
+  var picked []int
+  seen := make(map[int]bool)
+  for _, part := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+    from, to := part, part
+    if i := strings.Index(part, "-"); i > 0 {
+      from, to = part[:i], part[i+1:]
+    }
+    start, err1 := strconv.Atoi(from)
+    end, err2 := strconv.Atoi(to)
+    if err1 != nil || err2 != nil || start < 1 || end > n || start > end {
+      return nil, fmt.Errorf("invalid selection %q, expected numbers from 1 to %d like 1,3-5, all or none", part, n)
+    }
+    for i := start; i <= end; i++ {
+      if !seen[i] {
+        seen[i] = true
+        picked = append(picked, i-1)
+      }
+    }
+  }
+  return picked, nil
+}
+
+// discoverCommand suggests tags to follow and adds the ones picked to a tag list file.
+// Without a terminal, or with -yes, every suggestion shown is added.
+func discoverCommand(config *MastodonConfig, file string, top int, options discoverOptions, yes bool) error {
+  if file == "" {
+    if config.TagsImport == "" {
+      return fmt.Errorf("missing -list, or tags_import in the configuration to add to discovered.json in")
+    }
+    file = filepath.Join(config.TagsImport, "discovered.json")
+  }
+  list, err := readTagListFile(file)
+  if err != nil {
+    return err
+  }
+  skip := make(map[string]bool)
+  for _, tag := range list.Tags {
+    skip[normalizeTag(tag.Name)] = true
+  }
+
+  candidates, err := discoverTags(config, options, skip)
+  if err != nil {
+    return err
+  }
+  if len(candidates) == 0 {
+    fmt.Println("No new tags found.")
+    return nil
+  }
+  if len(candidates) > top {
+    candidates = candidates[:top]
+  }
+
+  labels := make([]string, len(candidates))
+  for i, c := range candidates {
+    labels[i] = fmt.Sprintf("#%s (%s)", c.Name, c.reasons())
+  }
+
+  // Pick the tags to add.
+  var picked []int
+  prompt := newPrompter()
+  switch {
+  case yes:
+    picked, _ = parseSelection("all", len(candidates))
+    for _, label := range labels {
+      fmt.Println(" " + label)
+    }
+  case useTUI():
+    if picked, err = pickItems("Pick tags to add to "+file, labels); err != nil {
+      return err
+    }
+  case prompt != nil:
+    for i, label := range labels {
+      fmt.Printf("%3d. %s\n", i+1, label)
+    }
+    answer, err := prompt.ask("Add which tags to "+file+" (e.g. 1,3-5, all or none)", "none", func(value string) error {
+      _, err := parseSelection(value, len(candidates))
+      return err
+    })
+    if err != nil {
+      return err
+    }
+    picked, _ = parseSelection(answer, len(candidates))
+  default:
+    for _, label := range labels {
+      fmt.Println(" " + label)
+    }
+    fmt.Println("Run in a terminal to pick tags to add, or use -yes to add them all.")
+    return nil
+  }
+  if len(picked) == 0 {
+    fmt.Println("No tags added.")
+    return nil
+  }
+
+  var tags []*tagCandidate
+  for _, i := range picked {
+    tags = append(tags, candidates[i])
+  }
+  if err := addTagsToFile(file, list, tags); err != nil {
+    return err
+  }
+  fmt.Printf("Added %d tags to %s, sync tags to follow them.\n", len(tags), file)
+  return nil
+}
@@ -0,0 +1,1001 @@
+package main
+
+// End-to-end tests driving export, import and sync against the fake server in mastodontest.
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "sort"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/sammcj/subscribe-o-mast/mastodontest"
+)
+
+// newTestConfig returns a config for the server with every directory inside a temporary directory.
+func newTestConfig(t *testing.T, server *mastodontest.Server) *MastodonConfig {
+  t.Helper()
+  dir := t.TempDir()
+  return &MastodonConfig{
+    InstanceURL:    server.URL,
+    AccessToken:    mastodontest.Token,
+    FilterExport:   filepath.Join(dir, "export", "filters") + "/",
+    FilterImport:   filepath.Join(dir, "import", "filters") + "/",
+    FilterDownload: filepath.Join(dir, "downloads", "filters") + "/",
+    TagsExport:     filepath.Join(dir, "export", "tags") + "/",
+    TagsImport:     filepath.Join(dir, "import", "tags") + "/",
+    TagsDownload:   filepath.Join(dir, "downloads", "tags") + "/",
+    HistoryDir:     filepath.Join(dir, "history") + "/",
+    ExportFormat:   "json",
+  }
+}
+
+// answer makes the next confirmation prompt read the answer, as if typed by the user.
+func answer(t *testing.T, text string) {
+  t.Helper()
+  r, w, err := os.Pipe()
+  if err != nil {
+    t.Fatal(err)
+  }
+  w.WriteString(text + "\n")
+  w.Close()
+
+  stdin := os.Stdin
+  os.Stdin = r
+  t.Cleanup(func() {
+    os.Stdin = stdin
+    r.Close()
+  })
+}
+
+// writeList writes a list file of filters or tags to the directory.
+func writeList(t *testing.T, dir, name, kind string, entries interface{}) {
+  t.Helper()
+  data, err := marshalListFile(name, kind, entries)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    t.Fatal(err)
+  }
+  if err := ioutil.WriteFile(filepath.Join(dir, name+".json"), data, 0644); err != nil {
+    t.Fatal(err)
+  }
+}
+
+// keywords returns the keywords of a server filter, sorted.
+func keywords(filter mastodontest.Filter) []string {
+  var words []string
+  for _, keyword := range filter.Keywords {
+    words = append(words, keyword.Keyword)
+  }
+  sort.Strings(words)
+  return words
+}
+
+// snapshotCount returns the number of snapshots in the history directory.
+func snapshotCount(t *testing.T, config *MastodonConfig) int {
+  t.Helper()
+  ids, err := snapshotIDs(config)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return len(ids)
+}
+
+func TestExportFilters(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{
+    Title:        "Sportsball",
+    Context:      []string{"home", "public"},
+    FilterAction: "hide",
+    Keywords:     []mastodontest.FilterKeyword{{Keyword: "world cup", WholeWord: true}, {Keyword: "offside"}},
+    Statuses:     []mastodontest.FilterStatus{{StatusID: "1234"}},
+  })
+  config := newTestConfig(t, server)
+
+  if err := exportFilters(config); err != nil {
+    t.Fatal(err)
+  }
+
+  data, err := readDocument(filepath.Join(config.FilterExport, "Sportsball.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  filters, err := parseFilterList(data)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(filters) != 1 {
+    t.Fatalf("exported %d filters, want 1", len(filters))
+  }
+  filter := filters[0]
+  if filter.ID != "" {
+    t.Errorf("exported filter kept its id %q", filter.ID)
+  }
+  if filter.FilterAction != "hide" || !reflect.DeepEqual(filter.Context, []string{"home", "public"}) {
+    t.Errorf("exported filter has action %q and context %v", filter.FilterAction, filter.Context)
+  }
+  want := []FilterKeyword{{Keyword: "world cup", WholeWord: true}, {Keyword: "offside"}}
+  if !reflect.DeepEqual(filter.Keywords, want) {
+    t.Errorf("exported keywords %+v, want %+v", filter.Keywords, want)
+  }
+}
+
+func TestExportFiltersYAML(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{Title: "Spoilers", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "finale"}}})
+  config := newTestConfig(t, server)
+  config.ExportFormat = "yaml"
+
+  if err := exportFilters(config); err != nil {
+    t.Fatal(err)
+  }
+
+  data, err := readDocument(filepath.Join(config.FilterExport, "Spoilers.yaml"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  filters, err := parseFilterList(data)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(filters) != 1 || filters[0].Keywords[0].Keyword != "finale" {
+    t.Errorf("exported %+v", filters)
+  }
+}
+
+func TestExportTagsFollowsPages(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.PageSize = 4
+  names := strings.Fields("golang rust zig ocaml haskell erlang elixir scheme clojure")
+  server.FollowTags(names...)
+  config := newTestConfig(t, server)
+
+  if err := exportTags(config); err != nil {
+    t.Fatal(err)
+  }
+
+  files, err := filepath.Glob(filepath.Join(config.TagsExport, "*.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(files) != len(names) {
+    t.Errorf("exported %d tags over several pages, want %d", len(files), len(names))
+  }
+  data, err := readDocument(filepath.Join(config.TagsExport, "zig.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  tags, err := parseTagList(data)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(tags) != 1 || tags[0].Name != "zig" {
+    t.Errorf("exported %+v", tags)
+  }
+}
+
+func TestImportFilters(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{Title: "Existing", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "old"}}})
+  config := newTestConfig(t, server)
+  writeList(t, config.FilterImport, "Existing", "filters", []*Filter{{Title: "Existing", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "new"}}}})
+  writeList(t, config.FilterImport, "Crypto", "filters", []*Filter{{Title: "Crypto", Context: []string{"home", "public"}, FilterAction: "hide", Keywords: []FilterKeyword{{Keyword: "nft"}, {Keyword: "web3"}}}})
+
+  // A legacy file holding an array of filters, one of them already on the account.
+  legacy := `[{"title": "existing", "context": ["home"], "keywords": [{"keyword": "newer"}]},
+    {"title": "Politics", "context": ["home"], "keywords": [{"keyword": "election"}]}]`
+  if err := ioutil.WriteFile(filepath.Join(config.FilterImport, "legacy.json"), []byte(legacy), 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  answer(t, "y")
+  if err := importFilters(config); err != nil {
+    t.Fatal(err)
+  }
+
+  if got := len(server.Filters()); got != 3 {
+    t.Fatalf("account has %d filters after import, want 3", got)
+  }
+  if _, ok := server.Filter("Politics"); !ok {
+    t.Error("filter from the legacy array file is missing")
+  }
+  crypto, ok := server.Filter("Crypto")
+  if !ok {
+    t.Fatal("imported filter is missing")
+  }
+  if crypto.FilterAction != "hide" || !reflect.DeepEqual(keywords(crypto), []string{"nft", "web3"}) {
+    t.Errorf("imported filter %+v", crypto)
+  }
+  existing, _ := server.Filter("Existing")
+  if !reflect.DeepEqual(keywords(existing), []string{"old"}) {
+    t.Errorf("import changed an existing filter's keywords to %v", keywords(existing))
+  }
+  if snapshotCount(t, config) != 1 {
+    t.Errorf("import didn't take a snapshot first")
+  }
+}
+
+func TestImportFiltersURL(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  list, _ := listServer(t, "format_version: 2\nname: Crypto\nfilters:\n  - title: Crypto\n    context: [home]\n    keywords:\n      - keyword: nft\n")
+  config := newTestConfig(t, server)
+  config.FilterImport = ""
+  config.FilterURL = list.URL + "/filters/crypto.yaml"
+
+  answer(t, "y")
+  if err := importFilters(config); err != nil {
+    t.Fatal(err)
+  }
+  crypto, ok := server.Filter("Crypto")
+  if !ok {
+    t.Fatal("filter from filters_import_url wasn't imported")
+  }
+  if !reflect.DeepEqual(keywords(crypto), []string{"nft"}) {
+    t.Errorf("imported filter %+v", crypto)
+  }
+}
+
+func TestImportFiltersDeclined(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  config := newTestConfig(t, server)
+  writeList(t, config.FilterImport, "Crypto", "filters", []*Filter{{Title: "Crypto", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "nft"}}}})
+
+  answer(t, "n")
+  if err := importFilters(config); err != nil {
+    t.Fatal(err)
+  }
+  if got := len(server.Filters()); got != 0 {
+    t.Errorf("declined import created %d filters", got)
+  }
+}
+
+func TestImportTags(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.FollowTags("golang")
+  config := newTestConfig(t, server)
+  writeList(t, config.TagsImport, "languages", "tags", []*Tag{{Name: "rust"}, {Name: "zig"}})
+
+  answer(t, "y")
+  if err := importTags(config); err != nil {
+    t.Fatal(err)
+  }
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, []string{"golang", "rust", "zig"}) {
+    t.Errorf("followed tags %v after importing the directory", got)
+  }
+
+  // The tags URL is downloaded and imported in place of the directory.
+  list, err := marshalListFile("fediverse", "tags", []*Tag{{Name: "fediverse"}})
+  if err != nil {
+    t.Fatal(err)
+  }
+  lists, _ := listServer(t, string(list))
+  config.TagsURL = lists.URL + "/fediverse.json"
+  answer(t, "y")
+  if err := importTags(config); err != nil {
+    t.Fatal(err)
+  }
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, []string{"golang", "rust", "zig", "fediverse"}) {
+    t.Errorf("followed tags %v after importing the URL", got)
+  }
+  if snapshotCount(t, config) != 2 {
+    t.Errorf("took %d snapshots, want one for each import", snapshotCount(t, config))
+  }
+}
+
+func TestImportPlan(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{Title: "Existing", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "old"}}})
+  server.FollowTags("golang")
+  config := newTestConfig(t, server)
+  writeList(t, config.FilterImport, "Crypto", "filters", []*Filter{
+    {Title: "Existing", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "new"}}},
+    {Title: "Crypto", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "nft"}}},
+  })
+  writeList(t, config.TagsImport, "languages", "tags", []*Tag{{Name: "golang"}, {Name: "rust"}})
+
+  var out bytes.Buffer
+  jsonResult, jsonOutput = &commandResult{OK: true}, &out
+  t.Cleanup(func() { jsonResult, jsonOutput = nil, nil })
+
+  // Imports from files are planned like sync, so only the changes are offered and reported.
+  answer(t, "y")
+  if err := importFilters(config); err != nil {
+    t.Fatal(err)
+  }
+  answer(t, "y")
+  if err := importTags(config); err != nil {
+    t.Fatal(err)
+  }
+  var changes []string
+  for _, change := range jsonResult.Changes {
+    changes = append(changes, fmt.Sprintf("%s %s %s (%s) %v", change.Action, change.Kind, change.Name, change.Reason, change.Applied))
+  }
+  want := []string{"create filter Crypto (imported from Crypto.json) true", "follow tag rust () true"}
+  if !reflect.DeepEqual(changes, want) {
+    t.Errorf("changes %q, want %q", changes, want)
+  }
+  for _, request := range server.Requests() {
+    if strings.Contains(request, "/tags/golang/follow") {
+      t.Errorf("followed a tag that was already followed: %s", request)
+    }
+  }
+}
+
+func TestSyncFilters(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{
+    Title:    "Sportsball",
+    Context:  []string{"home"},
+    Keywords: []mastodontest.FilterKeyword{{Keyword: "offside"}, {Keyword: "penalty"}},
+    Statuses: []mastodontest.FilterStatus{{StatusID: "99"}},
+  })
+  config := newTestConfig(t, server)
+  writeList(t, config.FilterImport, "sportsball", "filters", []*Filter{{
+    Title:    "Sportsball",
+    Context:  []string{"home"},
+    Keywords: []FilterKeyword{{Keyword: "offside"}, {Keyword: "world cup", WholeWord: true}},
+  }})
+  writeList(t, config.FilterImport, "spoilers", "filters", []*Filter{{Title: "Spoilers", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "finale"}}}})
+
+  answer(t, "y")
+  if err := syncFilters(config, true); err != nil {
+    t.Fatal(err)
+  }
+
+  sports, _ := server.Filter("Sportsball")
+  if !reflect.DeepEqual(keywords(sports), []string{"offside", "world cup"}) {
+    t.Errorf("synced keywords %v", keywords(sports))
+  }
+  if len(sports.Statuses) != 1 {
+    t.Errorf("sync dropped the filter's statuses")
+  }
+  if _, ok := server.Filter("Spoilers"); !ok {
+    t.Errorf("sync didn't create the new filter")
+  }
+  if snapshotCount(t, config) != 1 {
+    t.Errorf("sync didn't take a snapshot first")
+  }
+
+  // A second sync has nothing to do, so it needs no answer and takes no snapshot.
+  if err := syncFilters(config, true); err != nil {
+    t.Fatal(err)
+  }
+  if snapshotCount(t, config) != 1 {
+    t.Errorf("a sync without changes took a snapshot")
+  }
+}
+
+func TestTagSync(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.FollowTags("golang", "crypto")
+  config := newTestConfig(t, server)
+
+  no := false
+  changes, err := applyTagSync(config, []*Tag{{Name: "Rust"}, {Name: "golang"}, {Name: "crypto", Following: &no}}, false)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(changes) != 2 {
+    t.Errorf("applied %d tag changes, want 2", len(changes))
+  }
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, []string{"golang", "rust"}) {
+    t.Errorf("followed tags %v after sync", got)
+  }
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+  source := mastodontest.NewServer()
+  defer source.Close()
+  expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+  source.AddFilter(mastodontest.Filter{Title: "Spoilers", Context: []string{"home"}, ExpiresAt: &expiresAt, Keywords: []mastodontest.FilterKeyword{{Keyword: "finale"}}, Statuses: []mastodontest.FilterStatus{{StatusID: "101"}}})
+  source.FollowTags("cycling", "bikes")
+  source.BlockDomains("spam.example")
+  friend := source.AddAccount("friend@elsewhere.example")
+  sourceConfig := newTestConfig(t, source)
+  if _, err := apiRequest(sourceConfig, "POST", "/api/v1/featured_tags", map[string]interface{}{"name": "cycling"}); err != nil {
+    t.Fatal(err)
+  }
+  if err := applyListChange(sourceConfig, listChange{Action: "create", List: &AccountList{Title: "Riders"}, AddAccounts: []string{friend.Acct}}); err != nil {
+    t.Fatal(err)
+  }
+
+  file := filepath.Join(t.TempDir(), "bundle.tar.gz")
+  if err := exportBundle(sourceConfig, file); err != nil {
+    t.Fatal(err)
+  }
+
+  target := mastodontest.NewServer()
+  defer target.Close()
+  target.AddAccount("friend@elsewhere.example")
+  targetConfig := newTestConfig(t, target)
+  plan, err := importBundle(targetConfig, file, false)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if plan.empty() {
+    t.Fatal("importing the bundle into an empty account planned nothing")
+  }
+
+  if filter, ok := target.Filter("Spoilers"); !ok {
+    t.Errorf("bundle filter wasn't imported")
+  } else if filter.ExpiresAt != nil || len(filter.Statuses) != 0 {
+    t.Errorf("bundle carried the account's expiry and posts: %+v", filter)
+  }
+  if got := target.FollowedTags(); !reflect.DeepEqual(got, []string{"cycling", "bikes"}) {
+    t.Errorf("followed tags %v after import", got)
+  }
+  if got := target.FeaturedTags(); !reflect.DeepEqual(got, []string{"cycling"}) {
+    t.Errorf("featured tags %v after import", got)
+  }
+  if got := target.DomainBlocks(); !reflect.DeepEqual(got, []string{"spam.example"}) {
+    t.Errorf("domain blocks %v after import", got)
+  }
+  if got := target.Lists(); !reflect.DeepEqual(got, map[string][]string{"Riders": {"friend@elsewhere.example"}}) {
+    t.Errorf("lists %v after import", got)
+  }
+
+  // Importing again changes nothing.
+  plan, err = importBundle(targetConfig, file, false)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !plan.empty() {
+    t.Errorf("importing the bundle twice planned more changes")
+  }
+}
+
+func TestImportSources(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  config := newTestConfig(t, server)
+
+  // A directory holding a filter list and a tag list.
+  dir := t.TempDir()
+  writeList(t, dir, "spoilers", "filters", []*Filter{{Title: "Spoilers", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "finale"}}}})
+  writeList(t, dir, "languages", "tags", []*Tag{{Name: "golang"}})
+
+  // A catalog with a tag list next to its index.
+  catalog := t.TempDir()
+  writeList(t, filepath.Join(catalog, "tags"), "rust", "tags", []*Tag{{Name: "rust"}})
+  index := `{"format_version": 1, "lists": [{"name": "rust", "kind": "tags", "path": "tags/rust.json"}]}`
+  if err := ioutil.WriteFile(filepath.Join(catalog, "index.json"), []byte(index), 0644); err != nil {
+    t.Fatal(err)
+  }
+  config.Catalog = filepath.Join(catalog, "index.json")
+
+  // And a bare legacy tag on stdin, in YAML.
+  answer(t, "name: zig")
+
+  if _, err := importSources(config, []string{dir, "catalog:rust", "-"}, nil, false); err != nil {
+    t.Fatal(err)
+  }
+  if _, ok := server.Filter("Spoilers"); !ok {
+    t.Errorf("filter from the directory wasn't imported")
+  }
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, []string{"golang", "rust", "zig"}) {
+    t.Errorf("followed tags %v after import", got)
+  }
+
+  // Only the kinds asked for are applied.
+  writeList(t, dir, "more", "filters", []*Filter{{Title: "Politics", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "election"}}}})
+  plan, err := importSources(config, []string{filepath.Join(dir, "more.json")}, []string{"tags"}, false)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !plan.empty() {
+    t.Errorf("importing only tags from a filter list planned %+v", plan)
+  }
+
+  if _, err := importSources(config, []string{filepath.Join(dir, "missing.json")}, nil, false); err == nil {
+    t.Errorf("importing a missing file succeeded")
+  }
+}
+
+func TestRollback(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{Title: "Spoilers", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "finale"}}})
+  server.FollowTags("golang")
+  config := newTestConfig(t, server)
+
+  // Sync in a new filter, an extra keyword and a new tag.
+  writeList(t, config.FilterImport, "lists", "filters", []*Filter{
+    {Title: "Spoilers", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "finale"}, {Keyword: "ending"}}},
+    {Title: "Crypto", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "nft"}}},
+  })
+  answer(t, "y")
+  if err := syncFilters(config, true); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := applyTagSync(config, []*Tag{{Name: "rust"}}, false); err != nil {
+    t.Fatal(err)
+  }
+
+  ids, err := snapshotIDs(config)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(ids) != 2 {
+    t.Fatalf("took %d snapshots, want 2", len(ids))
+  }
+
+  answer(t, "y")
+  if err := rollback(config, ids[0]); err != nil {
+    t.Fatal(err)
+  }
+
+  filters := server.Filters()
+  if len(filters) != 1 || filters[0].Title != "Spoilers" || !reflect.DeepEqual(keywords(filters[0]), []string{"finale"}) {
+    t.Errorf("filters after rollback: %+v", filters)
+  }
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, []string{"golang"}) {
+    t.Errorf("followed tags %v after rollback", got)
+  }
+  if snapshotCount(t, config) != 3 {
+    t.Errorf("rollback didn't take a snapshot of its own")
+  }
+}
+
+func TestRollbackFilterStatuses(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+  spoilers := server.AddFilter(mastodontest.Filter{Title: "Spoilers", Context: []string{"home"}, ExpiresAt: &expiresAt, Keywords: []mastodontest.FilterKeyword{{Keyword: "finale"}}, Statuses: []mastodontest.FilterStatus{{StatusID: "101"}}})
+  threads := server.AddFilter(mastodontest.Filter{Title: "Threads", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "thread"}}, Statuses: []mastodontest.FilterStatus{{StatusID: "201"}}})
+  config := newTestConfig(t, server)
+
+  id, err := takeSnapshot(config, "test")
+  if err != nil {
+    t.Fatal(err)
+  }
+  snapshot, err := readSnapshot(config, id)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, filter := range snapshot.Filters {
+    if filter.Title == "Spoilers" && (filter.ExpiresIn == nil || len(filter.Statuses) != 1 || filter.Statuses[0].StatusID != "101") {
+      t.Errorf("snapshot lost the filter's expiry or posts: %+v", filter)
+    }
+  }
+
+  // Delete one filter and swap the post of the other.
+  for _, request := range []struct{ method, path string }{
+    {"DELETE", "/api/v2/filters/" + spoilers.ID},
+    {"DELETE", "/api/v2/filters/statuses/" + threads.Statuses[0].ID},
+  } {
+    if _, err := apiRequest(config, request.method, request.path, nil); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if _, err := apiRequest(config, "POST", "/api/v2/filters/"+threads.ID+"/statuses", map[string]interface{}{"status_id": "202"}); err != nil {
+    t.Fatal(err)
+  }
+
+  answer(t, "y")
+  if err := rollback(config, id); err != nil {
+    t.Fatal(err)
+  }
+
+  statusIDs := func(filter mastodontest.Filter) []string {
+    var ids []string
+    for _, status := range filter.Statuses {
+      ids = append(ids, status.StatusID)
+    }
+    return ids
+  }
+  if filter, ok := server.Filter("Spoilers"); !ok {
+    t.Errorf("deleted filter wasn't restored")
+  } else if filter.ExpiresAt == nil || !reflect.DeepEqual(statusIDs(filter), []string{"101"}) {
+    t.Errorf("restored filter lost its expiry or posts: %+v", filter)
+  }
+  if filter, _ := server.Filter("Threads"); !reflect.DeepEqual(statusIDs(filter), []string{"201"}) {
+    t.Errorf("posts %v after rollback, want [201]", statusIDs(filter))
+  }
+}
+
+func TestAPIErrors(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  config := newTestConfig(t, server)
+
+  // Mastodon's explanation is passed on.
+  server.FailNext("POST", "/api/v2/filters", 422, "Validation failed: Title can't be blank")
+  _, err := applyFilterSync(config, []*Filter{{Title: "Spoilers", Context: []string{"home"}}}, false)
+  if err == nil || !strings.Contains(err.Error(), "422") || !strings.Contains(err.Error(), "Title can't be blank") {
+    t.Errorf("failed create gave error %v", err)
+  }
+
+  // The server validates filters itself too.
+  _, err = apiRequest(config, "POST", "/api/v2/filters", map[string]interface{}{"title": "Bad", "context": []string{"everywhere"}})
+  if err == nil || !strings.Contains(err.Error(), "invalid context") {
+    t.Errorf("invalid context gave error %v", err)
+  }
+
+  // A bad token is refused.
+  config.AccessToken = "wrong"
+  if _, err := fetchFilters(config); err == nil || !strings.Contains(err.Error(), "401") {
+    t.Errorf("bad token gave error %v", err)
+  }
+}
+
+func TestRateLimit(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.RateLimit = 2
+  config := newTestConfig(t, server)
+
+  for i := 0; i < 2; i++ {
+    if _, err := fetchTags(config); err != nil {
+      t.Fatalf("request %d within the rate limit failed: %v", i+1, err)
+    }
+  }
+  if _, err := fetchTags(config); err == nil || !strings.Contains(err.Error(), "429") {
+    t.Errorf("request over the rate limit gave error %v", err)
+  }
+}
+
+func TestJSONOutput(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{Title: "Spoilers", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "finale"}}})
+  server.FollowTags("golang")
+  config := newTestConfig(t, server)
+
+  var out bytes.Buffer
+  jsonResult, jsonOutput = &commandResult{OK: true}, &out
+  t.Cleanup(func() { jsonResult, jsonOutput = nil, nil })
+
+  desired := []*Filter{{Title: "Spoilers", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "finale"}, {Keyword: "ending"}}}}
+  if _, err := applyFilterSync(config, desired, false); err != nil {
+    t.Fatal(err)
+  }
+  if err := exportTags(config); err != nil {
+    t.Fatal(err)
+  }
+  writeResult("sync", errors.New("something went wrong"))
+
+  var result commandResult
+  if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+    t.Fatalf("output isn't JSON: %s\n%s", err, out.String())
+  }
+  if result.Command != "sync" || result.OK || result.Error != "something went wrong" {
+    t.Errorf("result %+v", result)
+  }
+  if len(result.Changes) != 1 || result.Changes[0].Kind != "filter" || result.Changes[0].Name != "Spoilers" || !result.Changes[0].Applied {
+    t.Errorf("changes %+v", result.Changes)
+  }
+  if len(result.Exports) != 1 || result.Exports[0].Kind != "tags" || len(result.Exports[0].Files) != 1 {
+    t.Errorf("exports %+v", result.Exports)
+  }
+}
+
+func TestJSONOutputPartialImport(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  config := newTestConfig(t, server)
+
+  var out bytes.Buffer
+  jsonResult, jsonOutput = &commandResult{OK: true}, &out
+  t.Cleanup(func() { jsonResult, jsonOutput = nil, nil })
+
+  file := filepath.Join(t.TempDir(), "bundle.json")
+  if err := writeBundle(file, &ListFile{
+    FormatVersion: listFormatVersion,
+    Filters:       []*Filter{{Title: "Crypto", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "nft"}}}},
+    Tags:          []*Tag{{Name: "rust"}, {Name: "golang"}},
+  }); err != nil {
+    t.Fatal(err)
+  }
+  server.FailNext("POST", "/api/v1/tags/golang/follow", 500, "Something went wrong")
+  if _, err := importBundle(config, file, false); err == nil {
+    t.Fatal("import with a failing follow succeeded")
+  }
+
+  applied := make(map[string]bool)
+  for _, change := range jsonResult.Changes {
+    applied[change.Kind+" "+change.Name] = change.Applied
+  }
+  want := map[string]bool{"filter Crypto": true, "tag rust": true, "tag golang": false}
+  if !reflect.DeepEqual(applied, want) {
+    t.Errorf("applied %v, want %v", applied, want)
+  }
+}
+
+// useConfigFile writes the config to a file and points -config at it until the test ends, for running whole commands.
+func useConfigFile(t *testing.T, config *MastodonConfig) {
+  t.Helper()
+  data, err := json.Marshal(config)
+  if err != nil {
+    t.Fatal(err)
+  }
+  file := filepath.Join(t.TempDir(), "config.json")
+  if err := ioutil.WriteFile(file, data, 0600); err != nil {
+    t.Fatal(err)
+  }
+  previous := *configFile
+  *configFile = file
+  t.Cleanup(func() { *configFile = previous })
+}
+
+func TestFilterCommand(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.AddFilter(mastodontest.Filter{Title: "Spoilers", Context: []string{"home"}, FilterAction: "warn", Keywords: []mastodontest.FilterKeyword{{Keyword: "finale"}}})
+  config := newTestConfig(t, server)
+  useConfigFile(t, config)
+
+  // Filters on the account.
+  for _, args := range [][]string{
+    {"filter", "add", "-context", "home,public", "-action", "hide", "Sportsball", "world cup", "penalty"},
+    {"filter", "keyword", "add", "spoilers", "-whole-word", "true", "ending"},
+    {"filter", "keyword", "rm", "Spoilers", "finale"},
+    {"filter", "edit", "Spoilers", "-action", "hide", "-title", "TV spoilers"},
+  } {
+    if status := runCommand(args); status != 0 {
+      t.Fatalf("%v exited with %d", args, status)
+    }
+  }
+  sportsball, ok := server.Filter("Sportsball")
+  if !ok || sportsball.FilterAction != "hide" || !reflect.DeepEqual(sportsball.Context, []string{"home", "public"}) || !reflect.DeepEqual(keywords(sportsball), []string{"penalty", "world cup"}) {
+    t.Errorf("created filter %+v", sportsball)
+  }
+  spoilers, ok := server.Filter("TV spoilers")
+  if !ok || spoilers.FilterAction != "hide" || len(spoilers.Keywords) != 1 || spoilers.Keywords[0].Keyword != "ending" || !spoilers.Keywords[0].WholeWord {
+    t.Errorf("edited filter %+v", spoilers)
+  }
+  if n := snapshotCount(t, config); n != 4 {
+    t.Errorf("%d snapshots, want one per change", n)
+  }
+
+  // Removing from the account needs -yes without a terminal.
+  if status := runCommand([]string{"filter", "rm", "Sportsball"}); status == 0 {
+    t.Error("filter rm without -yes succeeded")
+  }
+  if status := runCommand([]string{"filter", "rm", "-yes", "Sportsball"}); status != 0 {
+    t.Errorf("filter rm -yes exited with %d", status)
+  }
+  if _, ok := server.Filter("Sportsball"); ok {
+    t.Error("filter wasn't removed")
+  }
+
+  // Invalid values are rejected before anything changes.
+  for _, args := range [][]string{
+    {"filter", "add", "-context", "home,timeline", "Bad"},
+    {"filter", "add", "-action", "mute", "Bad"},
+    {"filter", "keyword", "add", "-whole-word", "maybe", "TV spoilers", "cliffhanger"},
+    {"filter", "keyword", "add", "TV spoilers", "Ending"},
+    {"filter", "keyword", "rm", "TV spoilers", "missing"},
+    {"filter", "edit", "Nothing", "-action", "warn"},
+  } {
+    if status := runCommand(args); status == 0 {
+      t.Errorf("%v succeeded", args)
+    }
+  }
+  if n := snapshotCount(t, config); n != 5 {
+    t.Errorf("%d snapshots after failed commands, want 5", n)
+  }
+
+  // Filters in a list file, written back in its format.
+  file := filepath.Join(t.TempDir(), "cycling.yaml")
+  for _, args := range [][]string{
+    {"filter", "add", "-file", file, "-context", "home", "Cycling", "peloton"},
+    {"filter", "keyword", "add", "-file", file, "Cycling", "tour"},
+    {"filter", "add", "-file", file, "-action", "blur", "Doping"},
+    {"filter", "rm", "-file", file, "doping"},
+  } {
+    if status := runCommand(args); status != 0 {
+      t.Fatalf("%v exited with %d", args, status)
+    }
+  }
+  data, err := readDocument(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+  list, err := parseListFile(data, "filters")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if list.FormatVersion != listFormatVersion || len(list.Filters) != 1 || list.Filters[0].Title != "Cycling" || len(list.Filters[0].Keywords) != 2 {
+    t.Errorf("list file %s", data)
+  }
+  if problems, err := lintListFile(file); err != nil || len(problems) > 0 {
+    t.Errorf("list file has problems %v %v", problems, err)
+  }
+}
+
+func TestTagsFollow(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.FollowTags("golang")
+  status := server.AddStatus("alice@example.com", "Learning #Rust and #Zig", "Rust", "Zig")
+  server.AddStatus("bob@example.com", "Out on the #bike", "bike")
+  server.AddStatus("bob@example.com", "More #bike and #Cycling", "bike", "cycling")
+  config := newTestConfig(t, server)
+  useConfigFile(t, config)
+
+  file := filepath.Join(t.TempDir(), "tags.txt")
+  if err := ioutil.WriteFile(file, []byte("# Tags to follow\n#fediverse, Mastodon\n\n42 zig\n"), 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  // Cafe\u0301 is café with a combining accent, which follows as the composed form.
+  args := []string{"tags", "follow", "#GoLang", "Cafe\u0301", "not-a-tag", status.URL, "@bob@example.com", "-file", file}
+  if code := runCommand(args); code != 0 {
+    t.Fatalf("tags follow exited with %d", code)
+  }
+  want := []string{"golang", "café", "rust", "zig", "bike", "cycling", "fediverse", "mastodon"}
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, want) {
+    t.Errorf("followed %v, want %v", got, want)
+  }
+  if n := snapshotCount(t, config); n != 1 {
+    t.Errorf("%d snapshots, want 1", n)
+  }
+
+  if code := runCommand([]string{"tags", "unfollow", "Rust", "#ZIG", "elixir"}); code != 0 {
+    t.Fatalf("tags unfollow exited with %d", code)
+  }
+  want = []string{"golang", "café", "bike", "cycling", "fediverse", "mastodon"}
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, want) {
+    t.Errorf("followed %v after unfollowing, want %v", got, want)
+  }
+
+  // Nothing valid to follow is an error, and a dry run changes nothing.
+  if code := runCommand([]string{"tags", "follow", "123", "#"}); code == 0 {
+    t.Error("following only invalid tags succeeded")
+  }
+  if code := runCommand([]string{"tags", "follow", "-dry-run", "elixir"}); code != 0 {
+    t.Errorf("dry run exited with %d", code)
+  }
+  if len(server.FollowedTags()) != len(want) {
+    t.Errorf("dry run followed %v", server.FollowedTags())
+  }
+}
+
+func TestNormalizeTag(t *testing.T) {
+  for name, want := range map[string]string{
+    "#GoLang":        "golang",
+    "  ##Fediverse ": "fediverse",
+    "Cafe\u0301":    "café",
+    "CAFÉ":           "café",
+    "straße":         "strasse",
+    "100DaysOfCode":  "100daysofcode",
+    "tag_with_under": "tag_with_under",
+    "2024":           "",
+    "two words":      "",
+    "hyphen-ated":    "",
+    "#":              "",
+  } {
+    if got := normalizeTag(name); got != want {
+      t.Errorf("normalizeTag(%q) = %q, want %q", name, got, want)
+    }
+  }
+}
+
+func TestTagsDiscover(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.FollowTags("golang")
+  server.SetTrendingTags("Fediverse", "golang")
+  server.FollowAccounts("alice@example.com")
+  server.AddStatus("alice@example.com", "Trying #Rust and #zig", "rust", "zig")
+  server.AddStatus("carol@example.com", "#golang #gopher", "golang", "gopher")
+  server.AddStatus("carol@example.com", "#golang #gopher #rust", "golang", "gopher", "rust")
+  config := newTestConfig(t, server)
+  useConfigFile(t, config)
+  file := filepath.Join(t.TempDir(), "tags", "discovered.yaml")
+
+  // Trending scores 5, each followed account 3 and each post with a followed tag 1.
+  if code := runCommand([]string{"tags", "discover", "-list", file, "-top", "3", "-yes"}); code != 0 {
+    t.Fatalf("tags discover exited with %d", code)
+  }
+  names := func() []string {
+    list, err := readTagListFile(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    var names []string
+    for _, tag := range list.Tags {
+      names = append(names, tag.Name)
+    }
+    return names
+  }
+  if got, want := names(), []string{"fediverse", "rust", "zig"}; !reflect.DeepEqual(got, want) {
+    t.Errorf("added %v, want %v", got, want)
+  }
+
+  // Tags already in the list aren't suggested again, and nothing is followed.
+  if code := runCommand([]string{"tags", "discover", "-list", file, "-yes"}); code != 0 {
+    t.Fatalf("second tags discover exited with %d", code)
+  }
+  if got, want := names(), []string{"fediverse", "rust", "zig", "gopher"}; !reflect.DeepEqual(got, want) {
+    t.Errorf("added %v, want %v", got, want)
+  }
+  if got := server.FollowedTags(); !reflect.DeepEqual(got, []string{"golang"}) {
+    t.Errorf("discover followed %v", got)
+  }
+  if problems, err := lintListFile(file); err != nil || len(problems) > 0 {
+    t.Errorf("list file has problems %v %v", problems, err)
+  }
+}
+
+func TestParseSelection(t *testing.T) {
+  for answer, want := range map[string][]int{
+    "":         {},
+    "none":     {},
+    "all":      {0, 1, 2, 3, 4},
+    "1,3-5":    {0, 2, 3, 4},
+    " 2 2, 1 ": {1, 0},
+  } {
+    if got, err := parseSelection(answer, 5); err != nil || !reflect.DeepEqual(got, want) {
+      t.Errorf("parseSelection(%q) = %v, %v, want %v", answer, got, err, want)
+    }
+  }
+  for _, answer := range []string{"0", "6", "4-2", "x"} {
+    if _, err := parseSelection(answer, 5); err == nil {
+      t.Errorf("parseSelection(%q) succeeded", answer)
+    }
+  }
+}
+
+func TestTagsReport(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  server.FollowTags("quiet", "golang", "rust", "gone")
+  server.SetTagUses("golang", 4, 0, 3)
+  server.SetTagUses("rust", 1, 9)
+  server.SetTagUses("quiet", 0, 0, 0, 0, 0, 0, 2)
+  config := newTestConfig(t, server)
+  useConfigFile(t, config)
+
+  // Busiest first, and tags with no posts in the days asked for are stale.
+  report, err := tagReport(config, 3)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var names, stale []string
+  for _, usage := range report {
+    names = append(names, usage.Name)
+    if usage.Stale {
+      stale = append(stale, usage.Name)
+    }
+  }
+  if want := []string{"rust", "golang", "gone", "quiet"}; !reflect.DeepEqual(names, want) {
+    t.Errorf("ranked %v, want %v", names, want)
+  }
+  if want := []string{"gone", "quiet"}; !reflect.DeepEqual(stale, want) {
+    t.Errorf("stale %v, want %v", stale, want)
+  }
+  if report[1].Uses != 7 || report[1].Accounts != 4 || report[1].Days != 3 || report[1].LastUsed == "" {
+    t.Errorf("golang usage %+v", report[1])
+  }
+
+  // A week back, quiet was used, so only gone is unfollowed.
+  if code := runCommand([]string{"tags", "report", "-unfollow", "-yes"}); code != 0 {
+    t.Fatalf("tags report exited with %d", code)
+  }
+  if got, want := server.FollowedTags(), []string{"quiet", "golang", "rust"}; !reflect.DeepEqual(got, want) {
+    t.Errorf("followed %v, want %v", got, want)
+  }
+  if snapshotCount(t, config) != 1 {
+    t.Errorf("unfollowing didn't take a snapshot first")
+  }
+}
@@ -0,0 +1,223 @@
+package main
+
+// Keeping exports in a git repository, committing each export with a summary of what changed on the account.
+
+import (
+  "fmt"
+  "os"
+  "os/exec"
+  "path/filepath"
+  "sort"
+  "strings"
+)
+
+// exportState is what an export directory holds, read before and after an export to describe the changes.
+type exportState struct {
+  Filters map[string]*Filter
+  Tags    map[string]bool
+}
+
+// readExportState reads the filters or tags in an export directory, skipping any file that can't be parsed.
+func readExportState(dir, kind string) *exportState {
+  state := &exportState{Filters: make(map[string]*Filter), Tags: make(map[string]bool)}
+  files, _ := filepath.Glob(filepath.Join(dir, "*"))
+  for _, file := range files {
+    if !isDocumentFile(file) {
+      continue
+    }
+    data, err := readDocument(file)
+    if err != nil {
+      continue
+    }
+    if kind == "filters" {
+      filters, _ := parseFilterList(data)
+      for _, filter := range filters {
+        state.Filters[filter.Title] = filter
+      }
+    } else if tags, err := parseTagList(data); err == nil {
+      for _, tag := range tags {
+        state.Tags[tag.Name] = true
+      }
+    }
+  }
+  return state
+}
+
+// summariseExport describes the changes between two export states, e.g. "+3 keywords in sportsball, unfollowed #foo".
+func summariseExport(before, after *exportState) []string {
+  var changes []string
+
+  for _, title := range filterTitles(after.Filters) {
+    filter, old := after.Filters[title], before.Filters[title]
+    if old == nil {
+      changes = append(changes, fmt.Sprintf("added filter %s", title))
+      continue
+    }
+
+    keywords := make(map[string]bool)
+    for _, keyword := range old.Keywords {
+      keywords[strings.ToLower(keyword.Keyword)] = true
+    }
+    added, kept := 0, 0
+    for _, keyword := range filter.Keywords {
+      if keywords[strings.ToLower(keyword.Keyword)] {
+        kept++
+      } else {
+        added++
+      }
+    }
+    removed := len(old.Keywords) - kept
+    if added > 0 {
+      changes = append(changes, fmt.Sprintf("+%d %s in %s", added, plural(added, "keyword"), title))
+    }
+    if removed > 0 {
+      changes = append(changes, fmt.Sprintf("-%d %s in %s", removed, plural(removed, "keyword"), title))
+    }
+    if added == 0 && removed == 0 && (filter.FilterAction != old.FilterAction || !sameStrings(filter.Context, old.Context)) {
+      changes = append(changes, fmt.Sprintf("changed filter %s", title))
+    }
+  }
+  for _, title := range filterTitles(before.Filters) {
+    if after.Filters[title] == nil {
+      changes = append(changes, fmt.Sprintf("removed filter %s", title))
+    }
+  }
+
+  for _, name := range tagNames(after.Tags) {
+    if !before.Tags[name] {
+      changes = append(changes, "followed #"+name)
+    }
+  }
+  for _, name := range tagNames(before.Tags) {
+    if !after.Tags[name] {
+      changes = append(changes, "unfollowed #"+name)
+    }
+  }
+
+  return changes
+}
+
+// filterTitles returns the titles of the filters in order.
+func filterTitles(filters map[string]*Filter) []string {
+  titles := make([]string, 0, len(filters))
+  for title := range filters {
+    titles = append(titles, title)
+  }
+  sort.Strings(titles)
+  return titles
+}
+
+// tagNames returns the names of the tags in order.
+func tagNames(tags map[string]bool) []string {
+  names := make([]string, 0, len(tags))
+  for name := range tags {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// plural adds an s to the word unless there is exactly one.
+func plural(n int, word string) string {
+  if n == 1 {
+    return word
+  }
+  return word + "s"
+}
+
+// exportCommitMessage turns the changes into a commit message, listing them all in the body if they don't fit on the subject line.
+func exportCommitMessage(changes []string) string {
+  if len(changes) == 0 {
+    return "Update export"
+  }
+  subject := strings.Join(changes, ", ")
+  if len(subject) <= 72 {
+    return subject
+  }
+
+  subject = ""
+  shown := 0
+  for _, change := range changes {
+    next := change
+    if subject != "" {
+      next = subject + ", " + change
+    }
+    if len(next) > 56 {
+      break
+    }
+    subject, shown = next, shown+1
+  }
+  if shown == 0 {
+    subject = fmt.Sprintf("%d changes", len(changes))
+  } else {
+    subject = fmt.Sprintf("%s and %d more", subject, len(changes)-shown)
+  }
+  return subject + "\n\n" + strings.Join(changes, "\n") + "\n"
+}
+
+// runGit runs a git command in the repository and returns its output.
+func runGit(repo string, args ...string) (string, error) {
+  cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+  out, err := cmd.CombinedOutput()
+  if err != nil {
+    return "", fmt.Errorf("error running git: %w: %s", err, strings.TrimSpace(string(out)))
+  }
+  return string(out), nil
+}
+
+// commitExport removes files for anything no longer on the account, then commits the export directory to export_repo,
+// creating the repository if needed, and pushes it to export_remote if that is set.
+func commitExport(config *MastodonConfig, dir, kind string, before *exportState, written map[string]bool) error {
+  repo := config.ExportRepo
+  rel, err := filepath.Rel(repo, dir)
+  if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+    return fmt.Errorf("export directory %s isn't inside export_repo %s", dir, repo)
+  }
+
+  // With the history in git, files for deleted filters and unfollowed tags can go.
+  files, _ := filepath.Glob(filepath.Join(dir, "*"))
+  for _, file := range files {
+    if isDocumentFile(file) && !written[filepath.Clean(file)] {
+      if err := os.Remove(file); err != nil {
+        return fmt.Errorf("error removing %s: %w", file, err)
+      }
+    }
+  }
+
+  if _, err := os.Stat(filepath.Join(repo, ".git")); os.IsNotExist(err) {
+    if _, err := runGit(repo, "init", "--quiet"); err != nil {
+      return err
+    }
+    fmt.Printf("Created git repository in %s\n", repo)
+  }
+
+  if _, err := runGit(repo, "add", "--all", "--", rel); err != nil {
+    return err
+  }
+  status, err := runGit(repo, "status", "--porcelain", "--", rel)
+  if err != nil {
+    return err
+  }
+  if strings.TrimSpace(status) == "" {
+    fmt.Println("No changes since the last export.")
+    return nil
+  }
+
+  // Commit as the tool if git hasn't been told who the user is.
+  args := []string{"commit", "--quiet", "-m", exportCommitMessage(summariseExport(before, readExportState(dir, kind))), "--", rel}
+  if email, _ := runGit(repo, "config", "user.email"); strings.TrimSpace(email) == "" {
+    args = append([]string{"-c", "user.name=subscribe-o-mast", "-c", "user.email=subscribe-o-mast@localhost"}, args...)
+  }
+  if _, err := runGit(repo, args...); err != nil {
+    return err
+  }
+  fmt.Printf("Committed the export to %s\n", repo)
+
+  if config.ExportRemote != "" {
+    if _, err := runGit(repo, "push", "--quiet", config.ExportRemote, "HEAD"); err != nil {
+      return err
+    }
+    fmt.Printf("Pushed the export to %s\n", config.ExportRemote)
+  }
+  return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+  "io/ioutil"
+  "path/filepath"
+  "regexp"
+  "strings"
+  "testing"
+
+  "github.com/sammcj/subscribe-o-mast/mastodontest"
+)
+
+// exportCommits returns the subjects of the commits in the export repository, newest first.
+func exportCommits(t *testing.T, repo string) []string {
+  t.Helper()
+  out, err := runGit(repo, "log", "--format=%s")
+  if err != nil {
+    t.Fatal(err)
+  }
+  return strings.Split(strings.TrimSpace(out), "\n")
+}
+
+func TestExportRepo(t *testing.T) {
+  server := mastodontest.NewServer()
+  defer server.Close()
+  filter := server.AddFilter(mastodontest.Filter{Title: "Sportsball", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "offside"}}})
+  config := newTestConfig(t, server)
+  config.ExportRepo = filepath.Dir(filepath.Clean(config.FilterExport))
+
+  if err := exportFilters(config); err != nil {
+    t.Fatal(err)
+  }
+  if got := exportCommits(t, config.ExportRepo); len(got) != 1 || got[0] != "added filter Sportsball" {
+    t.Fatalf("commits %q after the first export", got)
+  }
+
+  // Date the export in the past, so a second export would change updated_at if it rewrote the file.
+  file := filepath.Join(config.FilterExport, "Sportsball.json")
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+  dated := regexp.MustCompile(`"updated_at": "[^"]*"`).ReplaceAll(data, []byte(`"updated_at": "2000-01-01T00:00:00Z"`))
+  if err := ioutil.WriteFile(file, dated, 0644); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := runGit(config.ExportRepo, "-c", "user.name=test", "-c", "user.email=test@localhost", "commit", "--quiet", "--all", "--amend", "--no-edit"); err != nil {
+    t.Fatal(err)
+  }
+
+  if err := exportFilters(config); err != nil {
+    t.Fatal(err)
+  }
+  if got := exportCommits(t, config.ExportRepo); len(got) != 1 {
+    t.Errorf("exporting an unchanged account made commits %q", got)
+  }
+  if data, _ := ioutil.ReadFile(file); string(data) != string(dated) {
+    t.Errorf("exporting an unchanged account rewrote the file:\n%s", data)
+  }
+
+  if _, err := apiRequest(config, "POST", "/api/v2/filters/"+filter.ID+"/keywords", map[string]interface{}{"keyword": "penalty"}); err != nil {
+    t.Fatal(err)
+  }
+  if err := exportFilters(config); err != nil {
+    t.Fatal(err)
+  }
+  if got := exportCommits(t, config.ExportRepo); len(got) != 2 || got[0] != "+1 keyword in Sportsball" {
+    t.Errorf("commits %q after adding a keyword", got)
+  }
+}
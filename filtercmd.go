@@ -0,0 +1,554 @@
+package main
+
+// The filter command, for adding, editing and removing a single filter and its keywords, in a list file or on the account.
+
+import (
+  "bufio"
+  "errors"
+  "flag"
+  "fmt"
+  "os"
+  "strings"
+  "time"
+
+  "golang.org/x/term"
+)
+
+// filterContexts are the places a filter can apply, as accepted by the Mastodon API.
+var filterContexts = []string{"home", "notifications", "public", "thread", "account"}
+
+// filterActions are what a filter can do to matching posts.
+var filterActions = []string{"warn", "hide", "blur"}
+
+// parseFilterContext parses a comma separated list of filter contexts, checking each is valid.
+func parseFilterContext(value string) ([]string, error) {
+  var context []string
+  seen := make(map[string]bool)
+  for _, part := range strings.Split(value, ",") {
+    part = strings.ToLower(strings.TrimSpace(part))
+    if part == "" || seen[part] {
+      continue
+    }
+    if !containsString(filterContexts, part) {
+      return nil, fmt.Errorf("invalid context %q, expected some of %s", part, strings.Join(filterContexts, ", "))
+    }
+    seen[part] = true
+    context = append(context, part)
+  }
+  if len(context) == 0 {
+    return nil, fmt.Errorf("a filter needs at least one context out of %s", strings.Join(filterContexts, ", "))
+  }
+  return context, nil
+}
+
+// parseFilterAction checks a filter_action value.
+func parseFilterAction(value string) (string, error) {
+  value = strings.ToLower(strings.TrimSpace(value))
+  if !containsString(filterActions, value) {
+    return "", fmt.Errorf("invalid filter_action %q, expected one of %s", value, strings.Join(filterActions, ", "))
+  }
+  return value, nil
+}
+
+// parseWholeWord parses a whole_word value, which may also be given as yes or no.
+func parseWholeWord(value string) (bool, error) {
+  switch strings.ToLower(strings.TrimSpace(value)) {
+  case "true", "yes", "y":
+    return true, nil
+  case "false", "no", "n":
+    return false, nil
+  }
+  return false, fmt.Errorf("invalid whole_word %q, expected true or false", value)
+}
+
+// containsString reports whether the value is in the list.
+func containsString(list []string, value string) bool {
+  for _, s := range list {
+    if s == value {
+      return true
+    }
+  }
+  return false
+}
+
+// findFilter returns the filter with the title, ignoring case, as sync matches them.
+func findFilter(filters []*Filter, title string) *Filter {
+  for _, filter := range filters {
+    if strings.EqualFold(filter.Title, title) {
+      return filter
+    }
+  }
+  return nil
+}
+
+// copyFilter returns a copy of the filter that can be edited without changing the original.
+// Its lists are never nil, so they're written as empty arrays as the schema wants.
+func copyFilter(filter *Filter) *Filter {
+  copied := *filter
+  copied.Context = append([]string{}, filter.Context...)
+  copied.Keywords = append([]FilterKeyword{}, filter.Keywords...)
+  copied.Statuses = append([]FilterStatus{}, filter.Statuses...)
+  return &copied
+}
+
+// prompter asks for the values missing from the command line, when stdin is a terminal.
+type prompter struct {
+  reader *bufio.Reader
+}
+
+// newPrompter returns a prompter, or nil when there's nobody to ask.
+func newPrompter() *prompter {
+  if jsonResult != nil || !term.IsTerminal(int(os.Stdin.Fd())) {
+    return nil
+  }
+  return &prompter{reader: bufio.NewReader(os.Stdin)}
+}
+
+// ask prompts for a value until parse accepts it, keeping current if the answer is empty.
+func (p *prompter) ask(question, current string, parse func(string) error) (string, error) {
+  for {
+    if current != "" {
+      fmt.Printf("%s [%s]: ", question, current)
+    } else {
+      fmt.Printf("%s: ", question)
+    }
+    line, err := p.reader.ReadString('\n')
+    if err != nil && line == "" {
+      return "", fmt.Errorf("error reading %s: %w", strings.ToLower(question), err)
+    }
+    answer := strings.TrimSpace(line)
+    if answer == "" {
+      answer = current
+    }
+    if parse == nil {
+      return answer, nil
+    }
+    if err := parse(answer); err != nil {
+      fmt.Println(err)
+      continue
+    }
+    return answer, nil
+  }
+}
+
+// askSettings prompts for the filter's context and action, showing the current ones.
+func (p *prompter) askSettings(filter *Filter) error {
+  answer, err := p.ask("Context ("+strings.Join(filterContexts, ",")+")", strings.Join(filter.Context, ","), func(value string) error {
+    _, err := parseFilterContext(value)
+    return err
+  })
+  if err != nil {
+    return err
+  }
+  filter.Context, _ = parseFilterContext(answer)
+
+  answer, err = p.ask("Filter action ("+strings.Join(filterActions, ", ")+")", filter.FilterAction, func(value string) error {
+    _, err := parseFilterAction(value)
+    return err
+  })
+  if err != nil {
+    return err
+  }
+  filter.FilterAction, _ = parseFilterAction(answer)
+  return nil
+}
+
+// askKeywords prompts for keywords until an empty answer, asking whether each matches whole words only.
+func (p *prompter) askKeywords(wholeWord bool) ([]FilterKeyword, error) {
+  var keywords []FilterKeyword
+  for {
+    keyword, err := p.ask("Keyword (empty to finish)", "", nil)
+    if err != nil || keyword == "" {
+      return keywords, err
+    }
+    current := "n"
+    if wholeWord {
+      current = "y"
+    }
+    answer, err := p.ask("Whole word only (y/n)", current, func(value string) error {
+      _, err := parseWholeWord(value)
+      return err
+    })
+    if err != nil {
+      return nil, err
+    }
+    whole, _ := parseWholeWord(answer)
+    keywords = append(keywords, FilterKeyword{Keyword: keyword, WholeWord: whole})
+  }
+}
+
+// addKeywords adds the keywords to the filter, failing if it already has one of them.
+func addKeywords(filter *Filter, keywords []FilterKeyword) error {
+  for _, keyword := range keywords {
+    keyword.Keyword = strings.TrimSpace(keyword.Keyword)
+    if keyword.Keyword == "" {
+      return fmt.Errorf("empty keyword")
+    }
+    for _, existing := range filter.Keywords {
+      if strings.EqualFold(existing.Keyword, keyword.Keyword) {
+        return fmt.Errorf("filter %q already has the keyword %q", filter.Title, existing.Keyword)
+      }
+    }
+    filter.Keywords = append(filter.Keywords, keyword)
+  }
+  return nil
+}
+
+// removeKeywords removes the keywords from the filter, failing if it doesn't have one of them.
+func removeKeywords(filter *Filter, keywords []string) error {
+  for _, keyword := range keywords {
+    found := false
+    for i, existing := range filter.Keywords {
+      if strings.EqualFold(existing.Keyword, strings.TrimSpace(keyword)) {
+        filter.Keywords = append(filter.Keywords[:i], filter.Keywords[i+1:]...)
+        found = true
+        break
+      }
+    }
+    if !found {
+      return fmt.Errorf("filter %q has no keyword %q", filter.Title, keyword)
+    }
+  }
+  return nil
+}
+
+// editFilterFile makes a change to the filters in a list file, creating it if needed, and writes it back in its own format.
+// Legacy files are written in the current format, and the comments of YAML and TOML files are kept.
+func editFilterFile(file string, edit func(list *ListFile) (filterChange, error)) (filterChange, error) {
+  list := &ListFile{FormatVersion: listFormatVersion}
+  data, err := readDocument(file)
+  switch {
+  case errors.Is(err, os.ErrNotExist):
+  case err != nil:
+    return filterChange{}, fmt.Errorf("error reading %s: %w", file, err)
+  default:
+    if list, err = parseListFile(data, "filters"); err != nil {
+      return filterChange{}, fmt.Errorf("error reading %s: %w", file, err)
+    }
+    list.FormatVersion = listFormatVersion
+  }
+
+  change, err := edit(list)
+  if err != nil {
+    return filterChange{}, err
+  }
+  if list.Name == "" && len(list.Filters) > 0 {
+    list.Name = list.Filters[0].Title
+  }
+  list.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+  if err := writeListFile(file, "filters", list); err != nil {
+    return filterChange{}, err
+  }
+  return change, nil
+}
+
+// editAccountFilter makes a change to a filter on the account, after taking a snapshot.
+// edit is given a copy of the filter with the title, or nil if there isn't one, and returns the filter wanted, or nil to delete it.
+func editAccountFilter(config *MastodonConfig, title string, edit func(filter *Filter) (*Filter, error)) (filterChange, error) {
+  current, err := fetchFilters(config)
+  if err != nil {
+    return filterChange{}, fmt.Errorf("error downloading filters: %w", err)
+  }
+  existing := findFilter(current, title)
+  var edited *Filter
+  if existing != nil {
+    edited = copyFilter(existing)
+  }
+  if edited, err = edit(edited); err != nil {
+    return filterChange{}, err
+  }
+
+  if existing != nil && edited != nil && !strings.EqualFold(edited.Title, existing.Title) && findFilter(current, edited.Title) != nil {
+    return filterChange{}, fmt.Errorf("there is already a filter %q", edited.Title)
+  }
+
+  var change filterChange
+  if edited == nil {
+    change = filterChange{Action: "delete", Title: existing.Title, Reason: "removed with filter rm", Existing: existing}
+  } else {
+    // Plan the change as sync would, under the existing title so a renamed filter is updated rather than created.
+    desired := copyFilter(edited)
+    var matching []*Filter
+    if existing != nil {
+      desired.Title = existing.Title
+      matching = []*Filter{existing}
+    }
+    changes, err := planFilterSync(matching, []*Filter{desired}, time.Now())
+    if err != nil {
+      return filterChange{}, err
+    }
+    if existing != nil && edited.Title != existing.Title {
+      if len(changes) == 0 {
+        changes = []filterChange{{Action: "update", Existing: existing}}
+      }
+      changes[0].Reason = strings.TrimPrefix(changes[0].Reason+", renamed to "+edited.Title, ", ")
+    }
+    if len(changes) == 0 {
+      return filterChange{}, fmt.Errorf("filter %q is unchanged", existing.Title)
+    }
+    change = changes[0]
+    change.Title, change.Filter = edited.Title, edited
+  }
+
+  if _, err := takeSnapshot(config, change.Action+" of filter "+change.Title); err != nil {
+    return filterChange{}, err
+  }
+  if err := applyFilterChange(config, change); err != nil {
+    reportFilterChanges([]filterChange{change}, false)
+    return filterChange{}, fmt.Errorf("error applying %s of filter %q: %w", change.Action, change.Title, err)
+  }
+  return change, nil
+}
+
+// filterCommand defines the filter command's flags and returns the function that runs it.
+func filterCommand(flags *flag.FlagSet) func(args []string) error {
+  file := flags.String("file", "", "edit the filter in this list file instead of on the account")
+  context := flags.String("context", "", "comma separated contexts for add and edit: "+strings.Join(filterContexts, ", ")+" (default all of them for add)")
+  action := flags.String("action", "", "the filter_action for add and edit: "+strings.Join(filterActions, ", ")+" (default warn for add)")
+  title := flags.String("title", "", "a new title, for edit")
+  wholeWord := flags.String("whole-word", "false", "whether added keywords only match whole words, true or false")
+  yes := flags.Bool("yes", false, "remove the filter from the account without asking, for rm")
+  return func(args []string) error {
+    if len(args) > 0 && args[0] == "keyword" {
+      args = args[1:]
+      if len(args) > 0 && (args[0] == "add" || args[0] == "rm") {
+        args[0] = "keyword " + args[0]
+      } else {
+        flags.Usage()
+        return fmt.Errorf("expected keyword add or keyword rm")
+      }
+    }
+    if len(args) < 2 {
+      flags.Usage()
+      if len(args) == 0 {
+        return fmt.Errorf("missing filter command")
+      }
+      return fmt.Errorf("missing filter title")
+    }
+    subcommand, name, rest := args[0], strings.TrimSpace(args[1]), args[2:]
+    if name == "" {
+      return fmt.Errorf("a filter needs a title")
+    }
+
+    // Check the values given on the command line before changing anything.
+    set := make(map[string]bool)
+    flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+    whole, err := parseWholeWord(*wholeWord)
+    if err != nil {
+      return err
+    }
+    var contextValue []string
+    if set["context"] {
+      if contextValue, err = parseFilterContext(*context); err != nil {
+        return err
+      }
+    }
+    var actionValue string
+    if set["action"] {
+      if actionValue, err = parseFilterAction(*action); err != nil {
+        return err
+      }
+    }
+    prompt := newPrompter()
+
+    // edit changes the filter with the title, which is nil if there isn't one, returning the filter wanted or nil to remove it.
+    var edit func(filter *Filter) (*Filter, error)
+    switch subcommand {
+    case "add":
+      edit = func(filter *Filter) (*Filter, error) {
+        if filter != nil {
+          return nil, fmt.Errorf("there is already a filter %q, use filter edit or filter keyword add", filter.Title)
+        }
+        filter = copyFilter(&Filter{Title: name, Context: filterContexts, FilterAction: "warn"})
+        if contextValue != nil {
+          filter.Context = contextValue
+        }
+        if actionValue != "" {
+          filter.FilterAction = actionValue
+        }
+        if prompt != nil && !set["context"] && !set["action"] {
+          if err := prompt.askSettings(filter); err != nil {
+            return nil, err
+          }
+        }
+        keywords := keywordArgs(rest, whole)
+        if len(keywords) == 0 && prompt != nil {
+          var err error
+          if keywords, err = prompt.askKeywords(whole); err != nil {
+            return nil, err
+          }
+        }
+        return filter, addKeywords(filter, keywords)
+      }
+
+    case "edit":
+      edit = func(filter *Filter) (*Filter, error) {
+        if filter == nil {
+          return nil, fmt.Errorf("there is no filter %q", name)
+        }
+        if !set["context"] && !set["action"] && !set["title"] {
+          if prompt == nil {
+            return nil, fmt.Errorf("nothing to change, use -context, -action or -title")
+          }
+          newTitle, err := prompt.ask("Title", filter.Title, nil)
+          if err != nil {
+            return nil, err
+          }
+          filter.Title = newTitle
+          return filter, prompt.askSettings(filter)
+        }
+        if contextValue != nil {
+          filter.Context = contextValue
+        }
+        if actionValue != "" {
+          filter.FilterAction = actionValue
+        }
+        if set["title"] {
+          if strings.TrimSpace(*title) == "" {
+            return nil, fmt.Errorf("a filter needs a title")
+          }
+          filter.Title = strings.TrimSpace(*title)
+        }
+        return filter, nil
+      }
+
+    case "rm":
+      edit = func(filter *Filter) (*Filter, error) {
+        if filter == nil {
+          return nil, fmt.Errorf("there is no filter %q", name)
+        }
+        if *file == "" && !*yes {
+          if prompt == nil {
+            return nil, fmt.Errorf("removing filter %q from the account needs -yes", filter.Title)
+          }
+          answer, err := prompt.ask(fmt.Sprintf("Remove filter %s and its %d keywords from the account (y/n)", filter.Title, len(filter.Keywords)), "", nil)
+          if err != nil {
+            return nil, err
+          }
+          if answer != "y" {
+            return nil, fmt.Errorf("filter %q was not removed", filter.Title)
+          }
+        }
+        return nil, nil
+      }
+
+    case "keyword add":
+      edit = func(filter *Filter) (*Filter, error) {
+        if filter == nil {
+          return nil, fmt.Errorf("there is no filter %q, use filter add to create it", name)
+        }
+        keywords := keywordArgs(rest, whole)
+        if len(keywords) == 0 && prompt != nil {
+          var err error
+          if keywords, err = prompt.askKeywords(whole); err != nil {
+            return nil, err
+          }
+        }
+        if len(keywords) == 0 {
+          return nil, fmt.Errorf("missing keywords to add")
+        }
+        return filter, addKeywords(filter, keywords)
+      }
+
+    case "keyword rm":
+      edit = func(filter *Filter) (*Filter, error) {
+        if filter == nil {
+          return nil, fmt.Errorf("there is no filter %q", name)
+        }
+        if len(rest) == 0 {
+          return nil, fmt.Errorf("missing keywords to remove")
+        }
+        return filter, removeKeywords(filter, rest)
+      }
+
+    default:
+      flags.Usage()
+      return fmt.Errorf("unknown filter command %q", subcommand)
+    }
+
+    // Make the change in the list file, or on the account.
+    var change filterChange
+    if *file != "" {
+      change, err = editFilterFile(*file, func(list *ListFile) (filterChange, error) {
+        return editListFilter(list, name, edit)
+      })
+    } else {
+      config, loadErr := loadAccount()
+      if loadErr != nil {
+        return loadErr
+      }
+      change, err = editAccountFilter(config, name, edit)
+    }
+    if err != nil {
+      return err
+    }
+
+    reportFilterChanges([]filterChange{change}, true)
+    where := "on the account"
+    if *file != "" {
+      where = "in " + *file
+    }
+    fmt.Printf("Filter %s %s: %s.\n", change.Title, where, describeFilterChange(change))
+    return nil
+  }
+}
+
+// editListFilter makes the change to the filter with the title in a list file, returning it as a change for reporting.
+func editListFilter(list *ListFile, title string, edit func(filter *Filter) (*Filter, error)) (filterChange, error) {
+  index := -1
+  var existing *Filter
+  for i, filter := range list.Filters {
+    if strings.EqualFold(filter.Title, title) {
+      index, existing = i, filter
+      break
+    }
+  }
+
+  var edited *Filter
+  if existing != nil {
+    edited = copyFilter(existing)
+  }
+  edited, err := edit(edited)
+  if err != nil {
+    return filterChange{}, err
+  }
+
+  switch {
+  case edited == nil:
+    list.Filters = append(list.Filters[:index], list.Filters[index+1:]...)
+    return filterChange{Action: "delete", Title: existing.Title, Existing: existing}, nil
+  case existing == nil:
+    list.Filters = append(list.Filters, edited)
+    return filterChange{Action: "create", Title: edited.Title, Filter: edited, AddKeywords: edited.Keywords}, nil
+  }
+  if findFilter(list.Filters, edited.Title) != nil && !strings.EqualFold(edited.Title, existing.Title) {
+    return filterChange{}, fmt.Errorf("there is already a filter %q", edited.Title)
+  }
+  list.Filters[index] = edited
+  return filterChange{Action: "update", Title: edited.Title, Filter: edited, Existing: existing}, nil
+}
+
+// keywordArgs turns the keyword arguments into keywords, all with the same whole_word.
+func keywordArgs(args []string, wholeWord bool) []FilterKeyword {
+  var keywords []FilterKeyword
+  for _, arg := range args {
+    keywords = append(keywords, FilterKeyword{Keyword: arg, WholeWord: wholeWord})
+  }
+  return keywords
+}
+
+// describeFilterChange summarises a change made by the filter command.
+func describeFilterChange(change filterChange) string {
+  switch change.Action {
+  case "create":
+    return fmt.Sprintf("created with %d keywords", len(change.Filter.Keywords))
+  case "delete":
+    return "removed"
+  }
+  var keywords []string
+  for _, keyword := range change.Filter.Keywords {
+    keywords = append(keywords, keyword.Keyword)
+  }
+  return fmt.Sprintf("%s, %s, keywords %s", change.Filter.FilterAction, strings.Join(change.Filter.Context, ","), strings.Join(keywords, ", "))
+}
@@ -0,0 +1,275 @@
+package main
+
+// Recording every HTTP request and response to a directory of fixtures, and replaying them instead of using the network,
+// so a problem with someone's instance can be reproduced from a bug report.
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+  "sync"
+)
+
+// httpClient sends every request the tool makes, so that they can be recorded or replayed, see -record and -replay.
+var httpClient = &http.Client{}
+
+// redacted replaces tokens and other secrets in fixtures.
+const redacted = "REDACTED"
+
+// fixture is a recorded request and its response.
+type fixture struct {
+  Method          string      `json:"method"`
+  URL             string      `json:"url"`
+  RequestHeaders  http.Header `json:"request_headers,omitempty"`
+  RequestBody     string      `json:"request_body,omitempty"`
+  Status          int         `json:"status"`
+  ResponseHeaders http.Header `json:"response_headers,omitempty"`
+  ResponseBody    string      `json:"response_body,omitempty"`
+}
+
+// secretHeaders are the headers whose values are never recorded.
+var secretHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// secretFields matches JSON fields and query parameters holding tokens, whose values are never recorded.
+var secretFields = regexp.MustCompile(`("(?:access_token|refresh_token|token|client_secret|password)"\s*:\s*")[^"]*(")|((?:access_token|client_secret|password)=)[^&\s"]*`)
+
+// recorder is an http.RoundTripper that sends requests on and writes each exchange to a numbered fixture file.
+type recorder struct {
+  dir     string
+  next    http.RoundTripper
+  mu      sync.Mutex
+  count   int
+  secrets []string
+}
+
+// newRecorder returns a recorder writing to the directory, which is created if needed.
+func newRecorder(dir string, next http.RoundTripper) (*recorder, error) {
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return nil, fmt.Errorf("error creating fixture directory: %w", err)
+  }
+  existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+  if err != nil {
+    return nil, err
+  }
+  // Carry on numbering after any fixtures already there, so several commands can be recorded into one directory.
+  return &recorder{dir: dir, next: next, count: len(existing)}, nil
+}
+
+// RoundTrip sends the request and records it with its response.
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+  var requestBody []byte
+  if req.Body != nil {
+    var err error
+    if requestBody, err = ioutil.ReadAll(req.Body); err != nil {
+      return nil, err
+    }
+    req.Body.Close()
+    req.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+  }
+
+  resp, err := r.next.RoundTrip(req)
+  if err != nil {
+    return nil, err
+  }
+  responseBody, err := ioutil.ReadAll(resp.Body)
+  resp.Body.Close()
+  if err != nil {
+    return nil, err
+  }
+  resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  // Remember the bearer token so it can be scrubbed wherever else it turns up.
+  if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != "" && token != req.Header.Get("Authorization") {
+    r.addSecret(token)
+  }
+
+  recorded := fixture{
+    Method:          req.Method,
+    URL:             r.scrub(req.URL.String()),
+    RequestHeaders:  r.scrubHeaders(req.Header),
+    RequestBody:     r.scrub(string(requestBody)),
+    Status:          resp.StatusCode,
+    ResponseHeaders: r.scrubHeaders(resp.Header),
+    ResponseBody:    r.scrub(string(responseBody)),
+  }
+  data, err := json.MarshalIndent(recorded, "", "  ")
+  if err != nil {
+    return nil, err
+  }
+
+  r.count++
+  name := fmt.Sprintf("%04d-%s-%s.json", r.count, req.Method, fixtureName(req.URL.Path))
+  if err := ioutil.WriteFile(filepath.Join(r.dir, name), append(data, '\n'), 0644); err != nil {
+    return nil, fmt.Errorf("error writing fixture: %w", err)
+  }
+  return resp, nil
+}
+
+// addSecret adds a value to scrub from everything recorded.
+func (r *recorder) addSecret(secret string) {
+  for _, known := range r.secrets {
+    if known == secret {
+      return
+    }
+  }
+  r.secrets = append(r.secrets, secret)
+}
+
+// scrub replaces the known secrets and any token fields in the text.
+func (r *recorder) scrub(text string) string {
+  for _, secret := range r.secrets {
+    text = strings.ReplaceAll(text, secret, redacted)
+  }
+  return secretFields.ReplaceAllString(text, "${1}${3}"+redacted+"${2}")
+}
+
+// scrubHeaders returns a copy of the headers with secret headers redacted and secrets removed from the rest.
+func (r *recorder) scrubHeaders(header http.Header) http.Header {
+  scrubbed := make(http.Header)
+  for key, values := range header {
+    for _, value := range values {
+      scrubbed.Add(key, r.scrub(value))
+    }
+  }
+  for _, key := range secretHeaders {
+    if scrubbed.Get(key) != "" {
+      scrubbed.Set(key, redacted)
+    }
+  }
+  return scrubbed
+}
+
+// unsafeFileChars matches runs of characters left out of fixture file names.
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// fixtureName turns a URL path into part of a file name.
+func fixtureName(path string) string {
+  name := strings.Trim(unsafeFileChars.ReplaceAllString(path, "_"), "_")
+  if len(name) > 60 {
+    name = name[:60]
+  }
+  if name == "" {
+    name = "root"
+  }
+  return name
+}
+
+// replayer is an http.RoundTripper that answers requests from recorded fixtures instead of the network.
+type replayer struct {
+  mu       sync.Mutex
+  fixtures []*fixture
+  used     []bool
+}
+
+// newReplayer loads the fixtures in the directory, in the order they were recorded.
+func newReplayer(dir string) (*replayer, error) {
+  files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+  if err != nil {
+    return nil, err
+  }
+  if len(files) == 0 {
+    return nil, fmt.Errorf("there are no fixtures in %s", dir)
+  }
+  sort.Strings(files)
+
+  r := &replayer{}
+  for _, file := range files {
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("error reading fixture: %w", err)
+    }
+    var recorded fixture
+    if err := json.Unmarshal(data, &recorded); err != nil {
+      return nil, fmt.Errorf("error parsing fixture %s: %w", file, err)
+    }
+    r.fixtures = append(r.fixtures, &recorded)
+  }
+  r.used = make([]bool, len(r.fixtures))
+  return r, nil
+}
+
+// RoundTrip answers the request with the first unused fixture for the same method, path and query.
+// The host isn't compared, so fixtures can be replayed against any instance_url.
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+  if req.Body != nil {
+    req.Body.Close()
+  }
+  r.mu.Lock()
+  defer r.mu.Unlock()
+
+  // Answer repeated reads with the last response recorded for them, in case the replay makes more than the recording did.
+  match := -1
+  for i, recorded := range r.fixtures {
+    if recorded.Method != req.Method || !sameRequestURI(recorded.URL, req.URL.RequestURI()) {
+      continue
+    }
+    if !r.used[i] {
+      match = i
+      break
+    }
+    if req.Method == "GET" {
+      match = i
+    }
+  }
+
+  if match >= 0 {
+    r.used[match] = true
+    recorded := r.fixtures[match]
+    return &http.Response{
+      Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+      StatusCode:    recorded.Status,
+      Proto:         "HTTP/1.1",
+      ProtoMajor:    1,
+      ProtoMinor:    1,
+      Header:        recorded.ResponseHeaders.Clone(),
+      Body:          ioutil.NopCloser(strings.NewReader(recorded.ResponseBody)),
+      ContentLength: int64(len(recorded.ResponseBody)),
+      Request:       req,
+    }, nil
+  }
+  return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL.RequestURI())
+}
+
+// sameRequestURI reports whether a recorded URL has the request URI, ignoring its scheme and host.
+func sameRequestURI(recorded, requestURI string) bool {
+  if i := strings.Index(recorded, "://"); i >= 0 {
+    recorded = recorded[i+3:]
+    if j := strings.Index(recorded, "/"); j >= 0 {
+      recorded = recorded[j:]
+    } else {
+      recorded = "/"
+    }
+  }
+  return recorded == requestURI
+}
+
+// setupFixtures points httpClient at a recorder or replayer for the -record or -replay flags.
+func setupFixtures(recordDir, replayDir string) error {
+  switch {
+  case recordDir != "" && replayDir != "":
+    return fmt.Errorf("-record and -replay can't be used together")
+  case recordDir != "":
+    r, err := newRecorder(recordDir, http.DefaultTransport)
+    if err != nil {
+      return err
+    }
+    httpClient.Transport = r
+  case replayDir != "":
+    r, err := newReplayer(replayDir)
+    if err != nil {
+      return err
+    }
+    httpClient.Transport = r
+  }
+  return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+  "io/ioutil"
+  "net/http"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/sammcj/subscribe-o-mast/mastodontest"
+)
+
+// useTransport sends httpClient's requests through the transport until the test ends.
+func useTransport(t *testing.T, transport http.RoundTripper) {
+  t.Helper()
+  previous := httpClient.Transport
+  httpClient.Transport = transport
+  t.Cleanup(func() { httpClient.Transport = previous })
+}
+
+func TestRecordAndReplay(t *testing.T) {
+  server := mastodontest.NewServer()
+  server.AddFilter(mastodontest.Filter{Title: "Spoilers", Context: []string{"home"}, Keywords: []mastodontest.FilterKeyword{{Keyword: "finale"}}})
+  server.FollowTags("golang")
+  config := newTestConfig(t, server)
+  desired := []*Filter{{Title: "Spoilers", Context: []string{"home"}, Keywords: []FilterKeyword{{Keyword: "finale"}, {Keyword: "ending"}}}}
+
+  // Record a sync against the server.
+  dir := t.TempDir()
+  recorder, err := newRecorder(dir, http.DefaultTransport)
+  if err != nil {
+    t.Fatal(err)
+  }
+  useTransport(t, recorder)
+  recorded, err := applyFilterSync(config, desired, false)
+  if err != nil {
+    t.Fatal(err)
+  }
+  server.Close()
+
+  // The token mustn't be anywhere in the fixtures.
+  files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(files) == 0 {
+    t.Fatal("nothing was recorded")
+  }
+  for _, file := range files {
+    data, err := ioutil.ReadFile(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if strings.Contains(string(data), mastodontest.Token) {
+      t.Errorf("%s contains the access token", filepath.Base(file))
+    }
+  }
+
+  // Replaying gives the same result without the server, and with any token.
+  replayer, err := newReplayer(dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  useTransport(t, replayer)
+  config.AccessToken = redacted
+  config.HistoryDir = t.TempDir()
+  replayed, err := applyFilterSync(config, desired, false)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(replayed) != 1 || !reflect.DeepEqual(replayed[0].AddKeywords, recorded[0].AddKeywords) {
+    t.Errorf("replayed changes %+v, recorded %+v", replayed, recorded)
+  }
+
+  // Requests that weren't recorded fail.
+  if _, err := apiRequest(config, "DELETE", "/api/v2/filters/1", nil); err == nil || !strings.Contains(err.Error(), "no recorded response") {
+    t.Errorf("unrecorded request gave error %v", err)
+  }
+}
+
+func TestScrub(t *testing.T) {
+  r := &recorder{}
+  r.addSecret("s3cret")
+  for text, want := range map[string]string{
+    `{"access_token":"abc","scope":"read"}`:        `{"access_token":"REDACTED","scope":"read"}`,
+    `{"client_secret": "xyz"}`:                     `{"client_secret": "REDACTED"}`,
+    `https://example.com/oauth?access_token=abc&x=1`: `https://example.com/oauth?access_token=REDACTED&x=1`,
+    `token s3cret in a body`:                       `token REDACTED in a body`,
+  } {
+    if got := r.scrub(text); got != want {
+      t.Errorf("scrub(%q) = %q, want %q", text, got, want)
+    }
+  }
+
+  header := r.scrubHeaders(http.Header{"Authorization": {"Bearer s3cret"}, "Link": {"<https://example.com/?max_id=1>; rel=\"next\""}})
+  if header.Get("Authorization") != redacted || header.Get("Link") == "" {
+    t.Errorf("scrubbed headers %v", header)
+  }
+}
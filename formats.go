@@ -0,0 +1,276 @@
+package main
+
+// Reading and writing list files and the config file as JSON, YAML or TOML, chosen by file extension.
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "path"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/BurntSushi/toml"
+  "gopkg.in/yaml.v3"
+)
+
+// documentFormats are the supported formats, with the extension written for each.
+var documentFormats = map[string]string{
+  "json": ".json",
+  "yaml": ".yaml",
+  "toml": ".toml",
+}
+
+// documentFormat returns the format of a file from its extension: "yaml", "toml", or "json" for anything else.
+func documentFormat(name string) string {
+  // URLs may have a query string after the file name.
+  if i := strings.IndexAny(name, "?#"); i >= 0 && isURL(name) {
+    name = name[:i]
+  }
+  switch strings.ToLower(path.Ext(name)) {
+  case ".yaml", ".yml":
+    return "yaml"
+  case ".toml":
+    return "toml"
+  }
+  return "json"
+}
+
+// isDocumentFile reports whether a file has the extension of one of the supported formats.
+func isDocumentFile(name string) bool {
+  switch strings.ToLower(path.Ext(name)) {
+  case ".json", ".yaml", ".yml", ".toml":
+    return true
+  }
+  return false
+}
+
+// formatExtension returns the file extension for an export format.
+func formatExtension(format string) (string, error) {
+  if format == "" {
+    return ".json", nil
+  }
+  ext, ok := documentFormats[strings.ToLower(format)]
+  if !ok {
+    return "", fmt.Errorf("unknown format %q, must be json, yaml or toml", format)
+  }
+  return ext, nil
+}
+
+// decodeDocument converts a YAML or TOML document to JSON, so it can be read like any other list or config file.
+// JSON documents are returned unchanged.
+func decodeDocument(format string, data []byte) ([]byte, error) {
+  switch format {
+  case "yaml":
+    var node yaml.Node
+    if err := yaml.Unmarshal(data, &node); err != nil {
+      return nil, fmt.Errorf("error parsing YAML: %w", err)
+    }
+    var document interface{}
+    if err := decodeYAML(&node, &document); err != nil {
+      return nil, fmt.Errorf("error parsing YAML: %w", err)
+    }
+    return json.Marshal(document)
+
+  case "toml":
+    var document map[string]interface{}
+    if _, err := toml.Decode(string(data), &document); err != nil {
+      return nil, fmt.Errorf("error parsing TOML: %w", err)
+    }
+    return json.Marshal(tomlDatesAsStrings(document))
+  }
+  return data, nil
+}
+
+// decodeYAML decodes a YAML node with its timestamps kept as written, as they would be in JSON, so 2026-06-11
+// stays a date rather than becoming a time. The node itself is left as it was.
+func decodeYAML(node *yaml.Node, v interface{}) error {
+  var timestamps []*yaml.Node
+  var tags []string
+  var find func(node *yaml.Node)
+  find = func(node *yaml.Node) {
+    if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!timestamp" {
+      timestamps, tags = append(timestamps, node), append(tags, node.Tag)
+      node.Tag = "!!str"
+    }
+    for _, child := range node.Content {
+      find(child)
+    }
+  }
+  find(node)
+  err := node.Decode(v)
+  for i, timestamp := range timestamps {
+    timestamp.Tag = tags[i]
+  }
+  return err
+}
+
+// tomlDatesAsStrings writes the dates and times in a decoded TOML document the way they're written in TOML,
+// so a local date such as 2026-06-11 reads like the same date in a JSON string.
+func tomlDatesAsStrings(value interface{}) interface{} {
+  switch value := value.(type) {
+  case map[string]interface{}:
+    for key, item := range value {
+      value[key] = tomlDatesAsStrings(item)
+    }
+  case []interface{}:
+    for i, item := range value {
+      value[i] = tomlDatesAsStrings(item)
+    }
+  case []map[string]interface{}:
+    for _, item := range value {
+      tomlDatesAsStrings(item)
+    }
+  case time.Time:
+    // The toml package marks local dates and times with locations of these names.
+    switch value.Location().String() {
+    case "date-local":
+      return value.Format("2006-01-02")
+    case "datetime-local":
+      return value.Format("2006-01-02T15:04:05.999999999")
+    case "time-local":
+      return value.Format("15:04:05.999999999")
+    }
+    return value.Format(time.RFC3339Nano)
+  }
+  return value
+}
+
+// readDocument reads a list file in any of the supported formats, returning it as JSON.
+func readDocument(file string) ([]byte, error) {
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return nil, err
+  }
+  return decodeDocument(documentFormat(file), data)
+}
+
+// encodeDocument converts JSON to the given format, keeping the order of keys where the format allows.
+// JSON is returned indented with a trailing newline.
+func encodeDocument(format string, data []byte) ([]byte, error) {
+  switch format {
+  case "yaml":
+    node, err := yamlNodeFromJSON(data)
+    if err != nil {
+      return nil, err
+    }
+    return encodeYAMLNode(node)
+
+  case "toml":
+    // TOML has no null and no top-level arrays, and its encoder sorts keys.
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.UseNumber()
+    var document interface{}
+    if err := decoder.Decode(&document); err != nil {
+      return nil, fmt.Errorf("error converting to TOML: %w", err)
+    }
+    table, ok := tomlValue(document).(map[string]interface{})
+    if !ok {
+      return nil, fmt.Errorf("error converting to TOML: only objects can be written as TOML, use the list file format")
+    }
+    var out bytes.Buffer
+    encoder := toml.NewEncoder(&out)
+    encoder.Indent = ""
+    if err := encoder.Encode(table); err != nil {
+      return nil, fmt.Errorf("error encoding TOML: %w", err)
+    }
+    return out.Bytes(), nil
+  }
+
+  var out bytes.Buffer
+  if err := json.Indent(&out, bytes.TrimSpace(data), "", "  "); err != nil {
+    return nil, err
+  }
+  out.WriteString("\n")
+  return out.Bytes(), nil
+}
+
+// jsonToYAMLNode reads the next JSON value from the decoder as a YAML node, keeping the order of object keys.
+func jsonToYAMLNode(decoder *json.Decoder) (*yaml.Node, error) {
+  token, err := decoder.Token()
+  if err != nil {
+    return nil, err
+  }
+
+  switch value := token.(type) {
+  case json.Delim:
+    node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+    if value == '{' {
+      node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+    }
+    for decoder.More() {
+      if node.Kind == yaml.MappingNode {
+        key, err := decoder.Token()
+        if err != nil {
+          return nil, err
+        }
+        node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string)})
+      }
+      child, err := jsonToYAMLNode(decoder)
+      if err != nil {
+        return nil, err
+      }
+      node.Content = append(node.Content, child)
+    }
+    // Consume the closing delimiter.
+    if _, err := decoder.Token(); err != nil {
+      return nil, err
+    }
+    return node, nil
+
+  case string:
+    node := &yaml.Node{}
+    node.SetString(value)
+    return node, nil
+  case json.Number:
+    return &yaml.Node{Kind: yaml.ScalarNode, Tag: numberTag(value), Value: value.String()}, nil
+  case float64:
+    number := json.Number(strconv.FormatFloat(value, 'f', -1, 64))
+    return &yaml.Node{Kind: yaml.ScalarNode, Tag: numberTag(number), Value: number.String()}, nil
+  case bool:
+    return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: fmt.Sprint(value)}, nil
+  case nil:
+    return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+  }
+  return nil, io.ErrUnexpectedEOF
+}
+
+// numberTag returns the YAML tag for a JSON number.
+func numberTag(number json.Number) string {
+  if _, err := number.Int64(); err == nil {
+    return "!!int"
+  }
+  return "!!float"
+}
+
+// tomlValue converts a decoded JSON value into one the TOML encoder can write, dropping nulls.
+func tomlValue(value interface{}) interface{} {
+  switch value := value.(type) {
+  case map[string]interface{}:
+    table := make(map[string]interface{}, len(value))
+    for key, item := range value {
+      if item != nil {
+        table[key] = tomlValue(item)
+      }
+    }
+    return table
+  case []interface{}:
+    array := make([]interface{}, 0, len(value))
+    for _, item := range value {
+      if item != nil {
+        array = append(array, tomlValue(item))
+      }
+    }
+    return array
+  case json.Number:
+    if i, err := value.Int64(); err == nil {
+      return i
+    }
+    f, _ := value.Float64()
+    return f
+  }
+  return value
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestDecodeDocumentDates(t *testing.T) {
+  tests := []struct {
+    format, data, want string
+  }{
+    {"toml", "from = 2026-06-11", `{"from":"2026-06-11"}`},
+    {"toml", "at = 2026-06-11T09:30:00", `{"at":"2026-06-11T09:30:00"}`},
+    {"toml", "at = 2026-06-11T09:30:00+10:00", `{"at":"2026-06-11T09:30:00+10:00"}`},
+    {"toml", "at = 09:30:00", `{"at":"09:30:00"}`},
+    {"toml", "[[windows]]\nfrom = 2026-06-11", `{"windows":[{"from":"2026-06-11"}]}`},
+    {"yaml", "from: 2026-06-11", `{"from":"2026-06-11"}`},
+    {"yaml", "windows:\n  - from: 2026-06-11T09:30:00Z", `{"windows":[{"from":"2026-06-11T09:30:00Z"}]}`},
+  }
+  for _, test := range tests {
+    got, err := decodeDocument(test.format, []byte(test.data))
+    if err != nil {
+      t.Errorf("%s %q: %v", test.format, test.data, err)
+    } else if string(got) != test.want {
+      t.Errorf("%s %q decoded to %s, want %s", test.format, test.data, got, test.want)
+    }
+  }
+}
+
+// TestScheduleDates checks that schedules with unquoted dates, which TOML and YAML read as dates, are valid.
+func TestScheduleDates(t *testing.T) {
+  lists := map[string]string{
+    "toml": `format_version = 2
+name = "Festival"
+
+[[filters]]
+title = "Festival"
+context = ["home"]
+keywords = [{keyword = "lineup"}]
+schedule = {windows = [{from = 2026-06-11, until = 2026-06-14}]}
+`,
+    "yaml": `format_version: 2
+name: Festival
+filters:
+  - title: Festival
+    context: [home]
+    keywords: [{keyword: lineup}]
+    schedule:
+      windows:
+        - from: 2026-06-11
+          until: 2026-06-14
+`,
+  }
+  for format, list := range lists {
+    data, err := decodeDocument(format, []byte(list))
+    if err != nil {
+      t.Fatalf("%s: %v", format, err)
+    }
+    filters, err := parseFilterList(data)
+    if err != nil {
+      t.Fatalf("%s: %v", format, err)
+    }
+    schedule := filters[0].Schedule
+    if schedule == nil || schedule.Windows[0].From != "2026-06-11" || schedule.Windows[0].Until != "2026-06-14" {
+      t.Errorf("%s: schedule %+v", format, schedule)
+      continue
+    }
+    if err := schedule.validate(); err != nil {
+      t.Errorf("%s: %v", format, err)
+    }
+
+    // Editing another key leaves the dates as they were written.
+    edited, err := updateDocument("list."+format, []byte(list), []byte(strings.Replace(string(data), `"lineup"`, `"headliner"`, 1)))
+    if err != nil {
+      t.Fatalf("%s: %v", format, err)
+    }
+    if !strings.Contains(string(edited), "headliner") || !strings.Contains(string(edited), "from = 2026-06-11") && !strings.Contains(string(edited), "from: 2026-06-11") {
+      t.Errorf("%s: edited to\n%s", format, edited)
+    }
+  }
+}
@@ -0,0 +1,251 @@
+package main
+
+// Snapshots of the account taken before every import, and rolling the account back to one of them.
+
+import (
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+)
+
+// snapshotIDFormat is the layout of snapshot ids, which sort in the order the snapshots were taken.
+const snapshotIDFormat = "20060102T150405Z"
+
+// historyDir returns the directory snapshots are kept in, which defaults to history/.
+func (config *MastodonConfig) historyDir() string {
+  if config.HistoryDir != "" {
+    return config.HistoryDir
+  }
+  return "history/"
+}
+
+// takeSnapshot saves everything the tool manages on the account to the history directory and returns the snapshot's id.
+func takeSnapshot(config *MastodonConfig, reason string) (string, error) {
+  snapshot, err := fetchBundle(config, false)
+  if err != nil {
+    return "", fmt.Errorf("error taking snapshot: %w", err)
+  }
+  snapshot.Name = "Snapshot of " + instanceHost(config)
+  snapshot.Description = "Before " + reason
+
+  dir := config.historyDir()
+  if err := os.MkdirAll(dir, 0755); err != nil {
+    return "", fmt.Errorf("error creating history directory: %w", err)
+  }
+
+  // Imports can follow each other within a second, so number any later snapshots in the same second.
+  id := time.Now().UTC().Format(snapshotIDFormat)
+  for n := 2; ; n++ {
+    if _, err := os.Stat(filepath.Join(dir, id+".json")); os.IsNotExist(err) {
+      break
+    }
+    id = fmt.Sprintf("%s-%d", strings.SplitN(id, "-", 2)[0], n)
+  }
+
+  if err := writeBundle(filepath.Join(dir, id+".json"), snapshot); err != nil {
+    return "", fmt.Errorf("error saving snapshot: %w", err)
+  }
+  fmt.Printf("Saved snapshot %s, run rollback %s to undo this import.\n", id, id)
+  return id, nil
+}
+
+// readSnapshot reads the snapshot with the given id from the history directory.
+func readSnapshot(config *MastodonConfig, id string) (*ListFile, error) {
+  if strings.ContainsAny(id, `/\`) {
+    return nil, fmt.Errorf("invalid snapshot id %q", id)
+  }
+  file := filepath.Join(config.historyDir(), strings.TrimSuffix(id, ".json")+".json")
+  data, err := ioutil.ReadFile(file)
+  if errors.Is(err, os.ErrNotExist) {
+    return nil, fmt.Errorf("there is no snapshot %s in %s, see history list", id, config.historyDir())
+  }
+  if err != nil {
+    return nil, fmt.Errorf("error reading snapshot: %w", err)
+  }
+  snapshot, err := parseBundle(file, data)
+  if err != nil {
+    return nil, fmt.Errorf("error parsing snapshot %s: %w", id, err)
+  }
+  return snapshot, nil
+}
+
+// snapshotIDs returns the ids of the snapshots in the history directory, oldest first.
+func snapshotIDs(config *MastodonConfig) ([]string, error) {
+  files, err := filepath.Glob(filepath.Join(config.historyDir(), "*.json"))
+  if err != nil {
+    return nil, err
+  }
+  var ids []string
+  for _, file := range files {
+    ids = append(ids, strings.TrimSuffix(filepath.Base(file), ".json"))
+  }
+  sort.Strings(ids)
+  return ids, nil
+}
+
+// listHistory prints the snapshots with what they contain.
+func listHistory(config *MastodonConfig) error {
+  ids, err := snapshotIDs(config)
+  if err != nil {
+    return err
+  }
+  if len(ids) == 0 {
+    fmt.Printf("No snapshots in %s yet, one is taken before every import.\n", config.historyDir())
+    return nil
+  }
+
+  for _, id := range ids {
+    snapshot, err := readSnapshot(config, id)
+    if err != nil {
+      fmt.Printf("%s\t%s\n", id, err)
+      continue
+    }
+    fmt.Printf("%s\t%s\t%s\t%d filters, %d tags, %d featured tags, %d lists, %d domain blocks\n", id, strings.TrimPrefix(snapshot.Name, "Snapshot of "),
+      snapshot.Description, len(snapshot.Filters), len(snapshot.Tags), len(snapshot.FeaturedTags), len(snapshot.Lists), len(snapshot.DomainBlocks))
+  }
+  return nil
+}
+
+// showSnapshot prints everything in a snapshot.
+func showSnapshot(config *MastodonConfig, id string) error {
+  snapshot, err := readSnapshot(config, id)
+  if err != nil {
+    return err
+  }
+
+  fmt.Printf("%s\n%s, taken %s\n", snapshot.Name, snapshot.Description, snapshot.UpdatedAt)
+  fmt.Printf("\nFilters (%d):\n", len(snapshot.Filters))
+  for _, filter := range snapshot.Filters {
+    var keywords []string
+    for _, keyword := range filter.Keywords {
+      keywords = append(keywords, keyword.Keyword)
+    }
+    fmt.Printf(" %s [%s, %s]: %s\n", filter.Title, filter.FilterAction, strings.Join(filter.Context, ", "), strings.Join(keywords, ", "))
+  }
+  fmt.Printf("\nFollowed tags (%d):\n", len(snapshot.Tags))
+  for _, tag := range snapshot.Tags {
+    fmt.Printf(" #%s\n", tag.Name)
+  }
+  fmt.Printf("\nFeatured tags (%d):\n", len(snapshot.FeaturedTags))
+  for _, tag := range snapshot.FeaturedTags {
+    fmt.Printf(" #%s\n", tag.Name)
+  }
+  fmt.Printf("\nLists (%d):\n", len(snapshot.Lists))
+  for _, list := range snapshot.Lists {
+    fmt.Printf(" %s: %s\n", list.Title, strings.Join(list.Accounts, ", "))
+  }
+  fmt.Printf("\nBlocked domains (%d):\n", len(snapshot.DomainBlocks))
+  for _, domain := range snapshot.DomainBlocks {
+    fmt.Printf(" %s\n", domain)
+  }
+  return nil
+}
+
+// rollback changes the account back to how it was in a snapshot, removing anything added since.
+func rollback(config *MastodonConfig, id string) error {
+  snapshot, err := readSnapshot(config, id)
+  if err != nil {
+    return err
+  }
+  if host := strings.TrimPrefix(snapshot.Name, "Snapshot of "); host != instanceHost(config) {
+    return fmt.Errorf("snapshot %s was taken on %s, not %s", id, host, instanceHost(config))
+  }
+
+  restoreExpiry(snapshot, time.Now())
+  plan, err := planBundle(config, snapshot, true)
+  if err != nil {
+    return err
+  }
+  if plan.empty() {
+    fmt.Printf("The account already matches snapshot %s.\n", id)
+    return nil
+  }
+
+  selected, err := confirmPlan(plan)
+  if err != nil {
+    return err
+  }
+  if selected == nil {
+    reportPlan(plan, false)
+    return nil
+  }
+  // The rollback takes a snapshot of its own, so it can be undone too.
+  done, err := applyBundlePlan(config, selected, "rollback to "+id)
+  reportPlan(done, true)
+  reportPlan(selected.remaining(done), false)
+  return err
+}
+
+// restoreExpiry makes the expires_in of the snapshot's filters count from now instead of from when it was taken,
+// leaving out the filters that would have expired since.
+func restoreExpiry(snapshot *ListFile, now time.Time) {
+  taken, err := time.Parse(time.RFC3339, snapshot.UpdatedAt)
+  if err != nil {
+    return
+  }
+  var filters []*Filter
+  for _, filter := range snapshot.Filters {
+    if filter.ExpiresIn != nil {
+      left := time.Duration(*filter.ExpiresIn) - now.Sub(taken)
+      if left <= 0 {
+        continue
+      }
+      expiresIn := Duration(left.Round(time.Second))
+      filter.ExpiresIn = &expiresIn
+    }
+    filters = append(filters, filter)
+  }
+  snapshot.Filters = filters
+}
+
+// historyCommand returns the function that runs the history command, for looking at snapshots without needing an access token.
+func historyCommand(flags *flag.FlagSet) func(args []string) error {
+  return func(args []string) error {
+    config, err := readConfig(*configFile)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+      return err
+    }
+    if config == nil {
+      config = &MastodonConfig{}
+    }
+
+    if len(args) == 0 {
+      args = []string{"list"}
+    }
+    switch args[0] {
+    case "list":
+      return listHistory(config)
+
+    case "show":
+      if len(args) < 2 {
+        return fmt.Errorf("missing snapshot id")
+      }
+      return showSnapshot(config, args[1])
+
+    default:
+      flags.Usage()
+      return fmt.Errorf("unknown history command %q", args[0])
+    }
+  }
+}
+
+// rollbackCommand returns the function that runs the rollback command.
+func rollbackCommand(flags *flag.FlagSet) func(args []string) error {
+  return func(args []string) error {
+    if len(args) != 1 {
+      flags.Usage()
+      return fmt.Errorf("missing snapshot id, see history list for the ids")
+    }
+    config, err := loadAccount()
+    if err != nil {
+      return err
+    }
+    return rollback(config, args[0])
+  }
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+  "testing"
+  "time"
+)
+
+func TestRestoreExpiry(t *testing.T) {
+  hour := Duration(time.Hour)
+  minute := Duration(time.Minute)
+  snapshot := &ListFile{UpdatedAt: "2024-01-01T12:00:00Z", Filters: []*Filter{
+    {Title: "Spoilers", ExpiresIn: &hour},
+    {Title: "Brief", ExpiresIn: &minute},
+    {Title: "Forever"},
+  }}
+  restoreExpiry(snapshot, time.Date(2024, 1, 1, 12, 10, 0, 0, time.UTC))
+
+  if len(snapshot.Filters) != 2 || snapshot.Filters[0].Title != "Spoilers" || snapshot.Filters[1].Title != "Forever" {
+    t.Fatalf("filters %+v, want the expired one left out", snapshot.Filters)
+  }
+  if got := time.Duration(*snapshot.Filters[0].ExpiresIn); got != 50*time.Minute {
+    t.Errorf("expires in %v, want 50m", got)
+  }
+}
@@ -0,0 +1,150 @@
+package main
+
+// Parsing of JSON config files with comments and trailing commas (JSONC), with exact error positions.
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "strings"
+  "unicode/utf8"
+)
+
+// syntaxError is an error at a position in a file, shown with an excerpt of the line and a caret under the column.
+type syntaxError struct {
+  File    string
+  Line    int
+  Column  int
+  Message string
+  Excerpt string
+}
+
+// Error formats the error as file:line:column: message followed by the excerpt.
+func (e *syntaxError) Error() string {
+  return fmt.Sprintf("%s:%d:%d: %s\n%s", e.File, e.Line, e.Column, e.Message, e.Excerpt)
+}
+
+// newSyntaxError builds a syntaxError for the byte offset in data.
+func newSyntaxError(file string, data []byte, offset int, message string) *syntaxError {
+  if offset > len(data) {
+    offset = len(data)
+  }
+  if offset < 0 {
+    offset = 0
+  }
+
+  // Find the line containing the offset and the column within it, counting characters rather than bytes.
+  line := bytes.Count(data[:offset], []byte("\n")) + 1
+  start := bytes.LastIndexByte(data[:offset], '\n') + 1
+  column := utf8.RuneCount(data[start:offset]) + 1
+
+  end := bytes.IndexByte(data[start:], '\n')
+  if end < 0 {
+    end = len(data) - start
+  }
+  text := strings.TrimRight(string(data[start:start+end]), "\r")
+
+  // Show the line before for context, then the line with a caret under the column.
+  var excerpt strings.Builder
+  gutter := len(fmt.Sprint(line))
+  if line > 1 {
+    prevStart := bytes.LastIndexByte(data[:start-1], '\n') + 1
+    prev := strings.TrimRight(string(data[prevStart:start-1]), "\r")
+    fmt.Fprintf(&excerpt, "%*d | %s\n", gutter, line-1, prev)
+  }
+  fmt.Fprintf(&excerpt, "%*d | %s\n", gutter, line, text)
+
+  // Keep tabs in the padding so the caret lines up with the text above.
+  padding := []rune(string(data[start:offset]))
+  for i, r := range padding {
+    if r != '\t' {
+      padding[i] = ' '
+    }
+  }
+  fmt.Fprintf(&excerpt, "%*s | %s^", gutter, "", string(padding))
+
+  return &syntaxError{File: file, Line: line, Column: column, Message: message, Excerpt: excerpt.String()}
+}
+
+// stripJSONC replaces the comments and trailing commas in JSONC with spaces, leaving plain JSON.
+// Newlines and the length of the data are kept, so offsets in errors from encoding/json still point into the original.
+func stripJSONC(file string, data []byte) ([]byte, error) {
+  out := make([]byte, len(data))
+  copy(out, data)
+
+  blank := func(from, to int) {
+    for i := from; i < to; i++ {
+      if out[i] != '\n' && out[i] != '\r' {
+        out[i] = ' '
+      }
+    }
+  }
+
+  // pendingComma is the offset of a comma that is only kept if a value follows it.
+  pendingComma := -1
+  for i := 0; i < len(data); i++ {
+    switch c := data[i]; {
+    case c == '"':
+      pendingComma = -1
+      // Skip over the string, minding escaped quotes.
+      for i++; i < len(data) && data[i] != '"'; i++ {
+        if data[i] == '\\' {
+          i++
+        }
+      }
+
+    case c == '/' && i+1 < len(data) && data[i+1] == '/':
+      end := bytes.IndexByte(data[i:], '\n')
+      if end < 0 {
+        end = len(data) - i
+      }
+      blank(i, i+end)
+      i += end - 1
+
+    case c == '/' && i+1 < len(data) && data[i+1] == '*':
+      end := bytes.Index(data[i+2:], []byte("*/"))
+      if end < 0 {
+        return nil, newSyntaxError(file, data, i, "unterminated /* comment")
+      }
+      blank(i, i+2+end+2)
+      i += 2 + end + 1
+
+    case c == ',':
+      pendingComma = i
+
+    case c == '}' || c == ']':
+      if pendingComma >= 0 {
+        blank(pendingComma, pendingComma+1)
+      }
+      pendingComma = -1
+
+    case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+
+    default:
+      pendingComma = -1
+    }
+  }
+
+  return out, nil
+}
+
+// unmarshalJSONC decodes JSONC into v, reporting syntax and type errors with their line and column in the file.
+func unmarshalJSONC(file string, data []byte, v interface{}) error {
+  stripped, err := stripJSONC(file, data)
+  if err != nil {
+    return err
+  }
+
+  err = json.Unmarshal(stripped, v)
+  var syntax *json.SyntaxError
+  var typeError *json.UnmarshalTypeError
+  switch {
+  case errors.As(err, &syntax):
+    // The offset is just past the character that couldn't be parsed.
+    return newSyntaxError(file, data, int(syntax.Offset)-1, syntax.Error())
+  case errors.As(err, &typeError):
+    return newSyntaxError(file, data, int(typeError.Offset)-1, fmt.Sprintf("%s must be %s, not %s", typeError.Field, typeError.Type, typeError.Value))
+  }
+  return err
+}
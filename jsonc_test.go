@@ -0,0 +1,55 @@
+package main
+
+import (
+  "errors"
+  "testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+  for data, want := range map[string]string{
+    `{"a": 1} // comment`:             `{"a": 1}           `,
+    `{"a": /* one */ 1}`:               `{"a":           1}`,
+    `{"a": [1, 2,],}`:                  `{"a": [1, 2 ] }`,
+    `{"a": "// not a comment"}`:        `{"a": "// not a comment"}`,
+    `{"a": "/* not */ a comment"}`:     `{"a": "/* not */ a comment"}`,
+    `{"a": "quote \" // still a string"}`: `{"a": "quote \" // still a string"}`,
+    "{\"a\": 1, // one\n\"b\": 2}":     "{\"a\": 1,       \n\"b\": 2}",
+    `["a,", "]"]`:                      `["a,", "]"]`,
+  } {
+    got, err := stripJSONC("config.json", []byte(data))
+    if err != nil || string(got) != want {
+      t.Errorf("stripJSONC(%s) = %q, %v, want %q", data, got, err, want)
+    }
+  }
+
+  var syntax *syntaxError
+  if _, err := stripJSONC("config.json", []byte("{\n  /* never closed\n}")); !errors.As(err, &syntax) || syntax.Line != 2 || syntax.Column != 3 {
+    t.Errorf("unterminated comment error %v", err)
+  }
+}
+
+func TestUnmarshalJSONC(t *testing.T) {
+  var config struct {
+    Server string `json:"server"`
+    Port   int    `json:"port"`
+  }
+  if err := unmarshalJSONC("config.json", []byte("{\n  // the server\n  \"server\": \"example.social\",\n  \"port\": 443,\n}"), &config); err != nil || config.Port != 443 {
+    t.Errorf("unmarshalJSONC = %+v, %v", config, err)
+  }
+
+  tests := []struct {
+    data         string
+    line, column int
+  }{
+    {"{\n  // the server\n  \"server\": example\n}", 3, 13},
+    {"{\n  \"port\": \"443\"\n}", 2, 15},
+    {"{\n\t\"server\": \"é\", \"port\": x}", 2, 25},
+  }
+  for _, test := range tests {
+    var syntax *syntaxError
+    err := unmarshalJSONC("config.json", []byte(test.data), &config)
+    if !errors.As(err, &syntax) || syntax.Line != test.line || syntax.Column != test.column {
+      t.Errorf("unmarshalJSONC(%q) error %v, want line %d column %d", test.data, err, test.line, test.column)
+    }
+  }
+}
@@ -0,0 +1,363 @@
+package main
+
+// Validation of filter and tag list files against the published JSON Schemas.
+
+import (
+  "bytes"
+  "embed"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strconv"
+  "strings"
+
+  "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaFiles holds the JSON Schemas for list files, also published in the schema/ directory.
+//go:embed schema/*.json
+var schemaFiles embed.FS
+
+// listSchemas maps each kind of list file to its current schema file.
+// Files in the versioned list format are checked against the "list" schema, which refers to the others.
+var listSchemas = map[string]string{
+  "filters": "schema/filters.v1.json",
+  "tags":    "schema/tags.v1.json",
+  "list":    "schema/list.v2.json",
+}
+
+// lintProblem is a single problem found in a list file.
+type lintProblem struct {
+  File     string
+  Location string
+  Message  string
+}
+
+// String formats the problem as file: location: message.
+func (p lintProblem) String() string {
+  if p.Location == "" {
+    return p.File + ": " + p.Message
+  }
+  return p.File + ": " + p.Location + ": " + p.Message
+}
+
+// compileListSchema compiles the embedded schema for a kind of list file.
+// Every schema is registered under its $id so they can refer to each other.
+func compileListSchema(kind string) (*jsonschema.Schema, error) {
+  name, ok := listSchemas[kind]
+  if !ok {
+    return nil, fmt.Errorf("unknown list kind %q", kind)
+  }
+
+  compiler := jsonschema.NewCompiler()
+  compiler.AssertFormat = true
+
+  var id string
+  for _, file := range listSchemas {
+    data, err := schemaFiles.ReadFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("error reading schema %s: %w", file, err)
+    }
+    var header struct {
+      ID string `json:"$id"`
+    }
+    if err := json.Unmarshal(data, &header); err != nil {
+      return nil, fmt.Errorf("error reading schema %s: %w", file, err)
+    }
+    if err := compiler.AddResource(header.ID, bytes.NewReader(data)); err != nil {
+      return nil, fmt.Errorf("error loading schema %s: %w", file, err)
+    }
+    if file == name {
+      id = header.ID
+    }
+  }
+
+  return compiler.Compile(id)
+}
+
+// detectListKind works out whether a file is a filter or tag list, from its directory or else its contents.
+func detectListKind(file string, data []byte) string {
+  switch filepath.Base(filepath.Dir(file)) {
+  case "filters":
+    return "filters"
+  case "tags":
+    return "tags"
+  }
+
+  // Filters have titles, tags have names.
+  if bytes.Contains(data, []byte(`"title"`)) {
+    return "filters"
+  }
+  return "tags"
+}
+
+// duplicateKeys returns the location of every key that appears more than once in the same JSON object.
+// encoding/json silently keeps the last value, so these would otherwise go unnoticed.
+func duplicateKeys(data []byte) ([]string, error) {
+  type frame struct {
+    object    bool
+    keys      map[string]bool
+    expectKey bool
+    key       string
+    index     int
+    path      string
+  }
+
+  var stack []*frame
+  var duplicates []string
+
+  // childPath returns the location of the next value in the current container.
+  childPath := func() string {
+    if len(stack) == 0 {
+      return ""
+    }
+    top := stack[len(stack)-1]
+    if top.object {
+      return top.path + "/" + top.key
+    }
+    return top.path + "/" + strconv.Itoa(top.index)
+  }
+
+  // valueDone moves the current container on to its next key or element.
+  valueDone := func() {
+    if len(stack) == 0 {
+      return
+    }
+    top := stack[len(stack)-1]
+    if top.object {
+      top.expectKey = true
+    } else {
+      top.index++
+    }
+  }
+
+  decoder := json.NewDecoder(bytes.NewReader(data))
+  for {
+    token, err := decoder.Token()
+    if err != nil {
+      if err == io.EOF {
+        return duplicates, nil
+      }
+      return duplicates, err
+    }
+
+    // An object key.
+    if len(stack) > 0 && stack[len(stack)-1].object && stack[len(stack)-1].expectKey {
+      if delim, ok := token.(json.Delim); ok && delim == '}' {
+        stack = stack[:len(stack)-1]
+        valueDone()
+        continue
+      }
+      top := stack[len(stack)-1]
+      key, _ := token.(string)
+      if top.keys[key] {
+        duplicates = append(duplicates, top.path+"/"+key)
+      }
+      top.keys[key] = true
+      top.key = key
+      top.expectKey = false
+      continue
+    }
+
+    switch token {
+    case json.Delim('{'):
+      stack = append(stack, &frame{object: true, keys: make(map[string]bool), expectKey: true, path: childPath()})
+    case json.Delim('['):
+      stack = append(stack, &frame{path: childPath()})
+    case json.Delim(']'), json.Delim('}'):
+      stack = stack[:len(stack)-1]
+      valueDone()
+    default:
+      valueDone()
+    }
+  }
+}
+
+// lintListFile checks a single list file and returns the problems found.
+// YAML and TOML files are converted to JSON and checked against the same schemas.
+func lintListFile(file string) ([]lintProblem, error) {
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return nil, fmt.Errorf("error reading %s: %w", file, err)
+  }
+
+  var problems []lintProblem
+  add := func(location, format string, args ...interface{}) {
+    problems = append(problems, lintProblem{File: file, Location: location, Message: fmt.Sprintf(format, args...)})
+  }
+
+  // The YAML and TOML parsers reject duplicate keys themselves.
+  if format := documentFormat(file); format != "json" {
+    if data, err = decodeDocument(format, data); err != nil {
+      add("", "%s", err)
+      return problems, nil
+    }
+  }
+
+  // The file must be JSON before anything else can be checked.
+  var document interface{}
+  if err := json.Unmarshal(data, &document); err != nil {
+    add("", "invalid JSON: %s", err)
+    return problems, nil
+  }
+
+  duplicates, err := duplicateKeys(data)
+  if err != nil {
+    add("", "invalid JSON: %s", err)
+  }
+  for _, location := range duplicates {
+    add(location, "duplicate key")
+  }
+
+  // Validate against the schema, reporting only the innermost causes.
+  kind := detectListKind(file, data)
+  wrapped := listFileVersion(data) > 1
+  schemaKind := kind
+  if wrapped {
+    schemaKind = "list"
+  }
+  schema, err := compileListSchema(schemaKind)
+  if err != nil {
+    return nil, err
+  }
+  if err := schema.Validate(document); err != nil {
+    var validationError *jsonschema.ValidationError
+    if !errors.As(err, &validationError) {
+      return nil, err
+    }
+    var report func(*jsonschema.ValidationError)
+    report = func(cause *jsonschema.ValidationError) {
+      if len(cause.Causes) == 0 {
+        add(cause.InstanceLocation, "%s", cause.Message)
+      }
+      for _, nested := range cause.Causes {
+        report(nested)
+      }
+    }
+    report(validationError)
+  }
+
+  // Checks the schema can't express.
+  _, isArray := document.([]interface{})
+  itemLocation := func(payload string, i int) string {
+    switch {
+    case wrapped:
+      return fmt.Sprintf("/%s/%d", payload, i)
+    case isArray:
+      return fmt.Sprintf("/%d", i)
+    }
+    return ""
+  }
+
+  if wrapped || kind == "filters" {
+    filters, err := parseFilterList(data)
+    if err != nil {
+      add("", "%s", err)
+    }
+    for i, filter := range filters {
+      seen := make(map[string]bool)
+      for j, keyword := range filter.Keywords {
+        location := fmt.Sprintf("%s/keywords/%d", itemLocation("filters", i), j)
+        if strings.TrimSpace(keyword.Keyword) == "" {
+          add(location, "empty keyword")
+          continue
+        }
+        if keyword.Keyword != strings.TrimSpace(keyword.Keyword) {
+          add(location, "keyword %q has leading or trailing whitespace", keyword.Keyword)
+        }
+        key := strings.ToLower(strings.TrimSpace(keyword.Keyword))
+        if seen[key] {
+          add(location, "duplicate keyword %q in filter %q", keyword.Keyword, filter.Title)
+        }
+        seen[key] = true
+      }
+    }
+  }
+
+  if wrapped || kind == "tags" {
+    tags, err := parseTagList(data)
+    if err != nil {
+      add("", "%s", err)
+    }
+    seen := make(map[string]bool)
+    for i, tag := range tags {
+      key := strings.ToLower(tag.Name)
+      if seen[key] {
+        add(itemLocation("tags", i), "duplicate tag %q", tag.Name)
+      }
+      seen[key] = true
+    }
+  }
+
+  return problems, nil
+}
+
+// listFilesIn returns the JSON, YAML and TOML files under each path, which may be files or directories.
+func listFilesIn(paths []string) ([]string, error) {
+  var files []string
+  for _, path := range paths {
+    info, err := os.Stat(path)
+    if err != nil {
+      return nil, err
+    }
+    if !info.IsDir() {
+      files = append(files, path)
+      continue
+    }
+
+    err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+      if err != nil {
+        return err
+      }
+      if !info.IsDir() && isDocumentFile(file) {
+        files = append(files, file)
+      }
+      return nil
+    })
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  sort.Strings(files)
+  return files, nil
+}
+
+// lintCommand returns the function that runs the lint command, which fails if any problems are found.
+func lintCommand(flags *flag.FlagSet) func(args []string) error {
+  return func(args []string) error {
+    paths := args
+    if len(paths) == 0 {
+      paths = []string{"filters", "tags"}
+    }
+
+    files, err := listFilesIn(paths)
+    if err != nil {
+      return err
+    }
+
+    count := 0
+    for _, file := range files {
+      problems, err := lintListFile(file)
+      if err != nil {
+        return err
+      }
+      for _, problem := range problems {
+        fmt.Println(problem)
+      }
+      count += len(problems)
+    }
+
+    fmt.Printf("Checked %d files, found %d problems.\n", len(files), count)
+    if count > 0 {
+      return fmt.Errorf("found %d problems", count)
+    }
+    return nil
+  }
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestDuplicateKeys(t *testing.T) {
+  for data, want := range map[string][]string{
+    `{"a": 1, "b": 2}`:                           nil,
+    `{"a": 1, "a": 2}`:                           {"/a"},
+    `[{"k": 1}, {"k": 1, "k": 2}]`:               {"/1/k"},
+    `{"a": {"b": [1, {"c": 1, "c": 2}]}, "a": 3}`: {"/a/b/1/c", "/a"},
+    `{"a": "{\"a\": 1, \"a\": 2}"}`:              nil,
+  } {
+    got, err := duplicateKeys([]byte(data))
+    if err != nil || !reflect.DeepEqual(got, want) {
+      t.Errorf("duplicateKeys(%s) = %v, %v, want %v", data, got, err, want)
+    }
+  }
+}
+
+func TestLintListFile(t *testing.T) {
+  tests := []struct {
+    name string
+    data string
+    want []string // a part of each problem expected, in order
+  }{
+    {"filters/clean.json", `{"title": "Spoilers", "context": ["home"], "filter_action": "warn", "keywords": [{"keyword": "finale"}]}`, nil},
+    {"filters/duplicate.json", `{"title": "Spoilers", "context": ["home"], "filter_action": "warn", "keywords": [{"keyword": "Finale"}, {"keyword": "finale"}]}`,
+      []string{`/keywords/1: duplicate keyword "finale"`}},
+    {"filters/space.json", `{"title": "Spoilers", "context": ["home"], "filter_action": "warn", "keywords": [{"keyword": " finale"}]}`,
+      []string{`/keywords/0: keyword " finale" has leading or trailing whitespace`}},
+    {"filters/key.json", `{"title": "A", "title": "B", "context": ["home"], "filter_action": "warn", "keywords": [{"keyword": "x"}]}`,
+      []string{"/title: duplicate key"}},
+    {"filters/schema.json", `{"title": "Spoilers", "context": ["everywhere"], "filter_action": "warn", "keywords": []}`, []string{"/context/0"}},
+    {"tags/duplicate.json", `[{"name": "golang"}, {"name": "GoLang"}]`, []string{`/1: duplicate tag "GoLang"`}},
+    {"tags/broken.json", `[{"name": "golang"},]`, []string{"invalid JSON"}},
+    {"tags/list.yaml", "format_version: 2\nname: languages\ntags:\n  - name: rust\n  - name: rust\n", []string{`/tags/1: duplicate tag "rust"`}},
+  }
+
+  dir := t.TempDir()
+  for _, test := range tests {
+    file := filepath.Join(dir, test.name)
+    if err := mkdirAndWrite(file, test.data); err != nil {
+      t.Fatal(err)
+    }
+    problems, err := lintListFile(file)
+    if err != nil {
+      t.Errorf("%s: %s", test.name, err)
+      continue
+    }
+    if len(problems) != len(test.want) {
+      t.Errorf("%s: problems %v, want %d", test.name, problems, len(test.want))
+      continue
+    }
+    for i, want := range test.want {
+      if !strings.Contains(problems[i].String(), want) {
+        t.Errorf("%s: problem %q, want %q", test.name, problems[i], want)
+      }
+    }
+  }
+}
+
+// mkdirAndWrite writes the file, creating its directory first.
+func mkdirAndWrite(file, data string) error {
+  if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+    return err
+  }
+  return ioutil.WriteFile(file, []byte(data), 0644)
+}
+
+func TestShippedListsLint(t *testing.T) {
+  files, err := listFilesIn([]string{"filters", "tags"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, file := range files {
+    problems, err := lintListFile(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    for _, problem := range problems {
+      t.Error(problem)
+    }
+  }
+}
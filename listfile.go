@@ -0,0 +1,271 @@
+package main
+
+// Versioned list file format, wrapping the Mastodon API objects in a metadata header.
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "time"
+)
+
+// listFormatVersion is the version of the list file format written by this version of subscribe-o-mast.
+// Version 1 is the legacy format of bare Mastodon API objects, which is still read.
+const listFormatVersion = 2
+
+// ListFile is a list file in the current format: a metadata header followed by the filters and tags in the list.
+// A bundle is a list file that also carries featured tags, lists and domain blocks.
+type ListFile struct {
+  FormatVersion int            `json:"format_version"`
+  Name          string         `json:"name"`
+  Description   string         `json:"description,omitempty"`
+  Homepage      string         `json:"homepage,omitempty"`
+  License       string         `json:"license,omitempty"`
+  UpdatedAt     string         `json:"updated_at,omitempty"`
+  Filters       []*Filter      `json:"filters,omitempty"`
+  Tags          []*Tag         `json:"tags,omitempty"`
+  FeaturedTags  []*FeaturedTag `json:"featured_tags,omitempty"`
+  Lists         []*AccountList `json:"lists,omitempty"`
+  DomainBlocks  []string       `json:"domain_blocks,omitempty"`
+}
+
+// listFileVersion returns the format version of a list file, which is 1 for legacy files without a header.
+func listFileVersion(data []byte) int {
+  data = bytes.TrimSpace(data)
+  if len(data) == 0 || data[0] != '{' {
+    return 1
+  }
+
+  var header struct {
+    FormatVersion int `json:"format_version"`
+  }
+  if err := json.Unmarshal(data, &header); err != nil || header.FormatVersion == 0 {
+    return 1
+  }
+  return header.FormatVersion
+}
+
+// parseListFile parses a list file in either the current or the legacy format.
+// Legacy files are returned with an empty header and the payload of the given kind.
+func parseListFile(data []byte, kind string) (*ListFile, error) {
+  version := listFileVersion(data)
+  if version > listFormatVersion {
+    return nil, fmt.Errorf("list file has format version %d, this version of subscribe-o-mast reads up to %d", version, listFormatVersion)
+  }
+
+  if version > 1 {
+    var list ListFile
+    if err := json.Unmarshal(data, &list); err != nil {
+      return nil, fmt.Errorf("error parsing list file: %w", err)
+    }
+    return &list, nil
+  }
+
+  // A legacy file holds bare API objects of a single kind.
+  list := &ListFile{FormatVersion: 1}
+  switch kind {
+  case "filters":
+    filters, err := parseFilterList(data)
+    if err != nil {
+      return nil, err
+    }
+    list.Filters = filters
+  case "tags":
+    tags, err := parseTagList(data)
+    if err != nil {
+      return nil, err
+    }
+    list.Tags = tags
+  default:
+    return nil, fmt.Errorf("unknown list kind %q", kind)
+  }
+  return list, nil
+}
+
+// marshalListFile encodes a list file header around an already exported payload, such as the maps written by export.
+func marshalListFile(name, kind string, payload interface{}) ([]byte, error) {
+  // Build the file from the header fields so the payload is written exactly as given.
+  type header struct {
+    FormatVersion int    `json:"format_version"`
+    Name          string `json:"name"`
+    UpdatedAt     string `json:"updated_at"`
+  }
+  data, err := json.Marshal(header{FormatVersion: listFormatVersion, Name: name, UpdatedAt: time.Now().UTC().Format(time.RFC3339)})
+  if err != nil {
+    return nil, err
+  }
+  payloadJSON, err := json.Marshal(payload)
+  if err != nil {
+    return nil, err
+  }
+  kindJSON, err := json.Marshal(kind)
+  if err != nil {
+    return nil, err
+  }
+  data = append(data[:len(data)-1], ',')
+  data = append(append(append(data, kindJSON...), ':'), payloadJSON...)
+  data = append(data, '}')
+
+  // Prettify the JSON to make it human readable.
+  var prettyJSON bytes.Buffer
+  if err := json.Indent(&prettyJSON, data, "", "  "); err != nil {
+    return nil, err
+  }
+  prettyJSON.WriteString("\n")
+  return prettyJSON.Bytes(), nil
+}
+
+// writeExportFile writes an exported list file in the format of its extension. A file that only differs in updated_at
+// is left alone, so updated_at records when the contents last changed and repeated exports don't touch the file.
+func writeExportFile(file string, list []byte) error {
+  if existing, err := readDocument(file); err == nil && samePayload(existing, list) {
+    return nil
+  }
+  data, err := encodeDocument(documentFormat(file), list)
+  if err != nil {
+    return fmt.Errorf("error encoding %s: %w", file, err)
+  }
+  if err := ioutil.WriteFile(file, data, 0644); err != nil {
+    return fmt.Errorf("error writing %s: %w", file, err)
+  }
+  return nil
+}
+
+// samePayload reports whether two list files are the same apart from their updated_at.
+func samePayload(a, b []byte) bool {
+  x, err := normalizeJSON(a)
+  if err != nil {
+    return false
+  }
+  y, err := normalizeJSON(b)
+  if err != nil {
+    return false
+  }
+  for _, value := range []interface{}{x, y} {
+    if header, ok := value.(map[string]interface{}); ok {
+      delete(header, "updated_at")
+    }
+  }
+  return reflect.DeepEqual(x, y)
+}
+
+// migrateListFile upgrades a legacy list file to the current format in place, keeping its format and comments, and reports whether it changed.
+func migrateListFile(file string, dryRun bool) (bool, error) {
+  data, err := readDocument(file)
+  if err != nil {
+    return false, fmt.Errorf("error reading %s: %w", file, err)
+  }
+  if listFileVersion(data) >= listFormatVersion {
+    return false, nil
+  }
+
+  kind := detectListKind(file, data)
+  list, err := parseListFile(data, kind)
+  if err != nil {
+    return false, fmt.Errorf("error reading %s: %w", file, err)
+  }
+
+  // Name the list after its filter, or else after the file.
+  name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+  if len(list.Filters) == 1 {
+    name = list.Filters[0].Title
+  }
+
+  raw, err := ioutil.ReadFile(file)
+  if err != nil {
+    return false, fmt.Errorf("error reading %s: %w", file, err)
+  }
+  migrated, err := migrateDocument(file, raw, name, kind)
+  if err != nil {
+    return false, fmt.Errorf("error migrating %s: %w", file, err)
+  }
+  if dryRun {
+    return true, nil
+  }
+
+  info, err := os.Stat(file)
+  if err != nil {
+    return false, err
+  }
+  if err := ioutil.WriteFile(file, migrated, info.Mode().Perm()); err != nil {
+    return false, fmt.Errorf("error writing %s: %w", file, err)
+  }
+  return true, nil
+}
+
+// writeListFile writes a list file in the format of its name, creating it if needed.
+// An existing YAML or TOML file is edited in place to keep its comments, after migrating it if it's a legacy file.
+func writeListFile(file, kind string, list *ListFile) error {
+  original, err := ioutil.ReadFile(file)
+  if err != nil && !errors.Is(err, os.ErrNotExist) {
+    return fmt.Errorf("error reading %s: %w", file, err)
+  }
+  if len(bytes.TrimSpace(original)) > 0 {
+    current, err := decodeDocument(documentFormat(file), original)
+    if err != nil {
+      return fmt.Errorf("error reading %s: %w", file, err)
+    }
+    if listFileVersion(current) < listFormatVersion {
+      if original, err = migrateDocument(file, original, list.Name, kind); err != nil {
+        return fmt.Errorf("error migrating %s: %w", file, err)
+      }
+    }
+  }
+
+  data, err := json.Marshal(list)
+  if err != nil {
+    return fmt.Errorf("error encoding %s: %w", file, err)
+  }
+  if data, err = updateDocument(file, original, data); err != nil {
+    return fmt.Errorf("error encoding %s: %w", file, err)
+  }
+  mode := os.FileMode(0644)
+  if info, err := os.Stat(file); err == nil {
+    mode = info.Mode().Perm()
+  }
+  if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+    return fmt.Errorf("error creating %s: %w", filepath.Dir(file), err)
+  }
+  if err := ioutil.WriteFile(file, data, mode); err != nil {
+    return fmt.Errorf("error writing %s: %w", file, err)
+  }
+  return nil
+}
+
+// migrateFilesCommand defines the migrate-files command's flags and returns the function that runs it.
+func migrateFilesCommand(flags *flag.FlagSet) func(args []string) error {
+  dryRun := flags.Bool("dry-run", false, "only list the files that would be migrated")
+  return func(args []string) error {
+    if len(args) == 0 {
+      flags.Usage()
+      return fmt.Errorf("no files or directories to migrate")
+    }
+
+    files, err := listFilesIn(args)
+    if err != nil {
+      return err
+    }
+
+    migrated := 0
+    for _, file := range files {
+      changed, err := migrateListFile(file, *dryRun)
+      if err != nil {
+        return err
+      }
+      if changed {
+        migrated++
+        fmt.Println("Migrated " + file)
+      }
+    }
+
+    fmt.Printf("Migrated %d of %d files to format version %d.\n", migrated, len(files), listFormatVersion)
+    return nil
+  }
+}
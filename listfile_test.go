@@ -0,0 +1,171 @@
+package main
+
+import (
+  "io/ioutil"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func TestListFileVersion(t *testing.T) {
+  for data, want := range map[string]int{
+    `{"format_version": 2, "name": "x"}`: 2,
+    `  {"format_version": 3}`:            3,
+    `{"title": "Legacy"}`:                1,
+    `[{"name": "golang"}]`:               1,
+    `{"format_version": "2"}`:            1,
+    ``:                                   1,
+  } {
+    if got := listFileVersion([]byte(data)); got != want {
+      t.Errorf("listFileVersion(%s) = %d, want %d", data, got, want)
+    }
+  }
+}
+
+func TestParseListFile(t *testing.T) {
+  if _, err := parseListFile([]byte(`{"format_version": 99, "name": "future"}`), "tags"); err == nil {
+    t.Error("a list from a newer version was accepted")
+  }
+  list, err := parseListFile([]byte(`[{"name": "golang"}, {"name": "rust"}]`), "tags")
+  if err != nil || list.FormatVersion != 1 || len(list.Tags) != 2 {
+    t.Errorf("legacy tag array parsed as %+v, %v", list, err)
+  }
+  list, err = parseListFile([]byte(`{"title": "Spoilers", "context": ["home"], "keywords": [{"keyword": "finale"}]}`), "filters")
+  if err != nil || len(list.Filters) != 1 || list.Filters[0].Title != "Spoilers" {
+    t.Errorf("legacy filter parsed as %+v, %v", list, err)
+  }
+}
+
+func TestMigrateListFile(t *testing.T) {
+  tests := []struct {
+    file string
+    data string
+    name string
+    kind string
+    n    int
+  }{
+    {"filters/spoilers.json", `{"title": "Spoilers", "context": ["home"], "filter_action": "warn", "keywords": [{"keyword": "finale", "whole_word": true}]}`, "Spoilers", "filters", 1},
+    {"filters/several.json", `[{"title": "A", "context": ["home"], "filter_action": "warn", "keywords": []}, {"title": "B", "context": ["home"], "filter_action": "hide", "keywords": []}]`, "several", "filters", 2},
+    {"tags/golang.json", `{"name": "golang", "url": "https://example.com/tags/golang"}`, "golang", "tags", 1},
+    {"tags/languages.json", `[{"name": "golang"}, {"name": "rust"}]`, "languages", "tags", 2},
+  }
+
+  dir := t.TempDir()
+  for _, test := range tests {
+    file := filepath.Join(dir, test.file)
+    if err := mkdirAndWrite(file, test.data); err != nil {
+      t.Fatal(err)
+    }
+
+    // A dry run changes nothing.
+    if changed, err := migrateListFile(file, true); err != nil || !changed {
+      t.Errorf("%s: dry run = %v, %v", test.file, changed, err)
+    }
+    if data, _ := ioutil.ReadFile(file); string(data) != test.data {
+      t.Errorf("%s: dry run wrote %s", test.file, data)
+    }
+
+    if changed, err := migrateListFile(file, false); err != nil || !changed {
+      t.Fatalf("%s: migrate = %v, %v", test.file, changed, err)
+    }
+    data, err := readDocument(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    list, err := parseListFile(data, test.kind)
+    if err != nil {
+      t.Fatal(err)
+    }
+    n := len(list.Filters) + len(list.Tags)
+    if list.FormatVersion != listFormatVersion || list.Name != test.name || n != test.n {
+      t.Errorf("%s: migrated to version %d named %q with %d entries", test.file, list.FormatVersion, list.Name, n)
+    }
+    if problems, err := lintListFile(file); err != nil || len(problems) > 0 {
+      t.Errorf("%s: migrated file has problems %v %v", test.file, problems, err)
+    }
+
+    // Migrating again does nothing.
+    if changed, err := migrateListFile(file, false); err != nil || changed {
+      t.Errorf("%s: second migrate = %v, %v", test.file, changed, err)
+    }
+  }
+
+  // Nothing in the payload is lost.
+  data, err := readDocument(filepath.Join(dir, "filters/spoilers.json"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  list, _ := parseListFile(data, "filters")
+  if want := []FilterKeyword{{Keyword: "finale", WholeWord: true}}; !reflect.DeepEqual(list.Filters[0].Keywords, want) {
+    t.Errorf("migrated keywords %+v, want %+v", list.Filters[0].Keywords, want)
+  }
+}
+
+func TestMigrateListFileComments(t *testing.T) {
+  tests := map[string]string{
+    "filters/spoilers.yaml": `# Spoilers for the shows I'm watching.
+
+title: Spoilers # shown on the warning
+context: [home]
+filter_action: warn
+keywords:
+  # The finale airs on Sunday.
+  - keyword: finale
+`,
+    "filters/spoilers.toml": `# Spoilers for the shows I'm watching.
+
+title = "Spoilers" # shown on the warning
+context = ["home"]
+filter_action = "warn"
+
+# The finale airs on Sunday.
+[[keywords]]
+keyword = "finale"
+`,
+  }
+
+  dir := t.TempDir()
+  for name, data := range tests {
+    file := filepath.Join(dir, name)
+    if err := mkdirAndWrite(file, data); err != nil {
+      t.Fatal(err)
+    }
+    if _, err := migrateListFile(file, false); err != nil {
+      t.Fatal(err)
+    }
+    if problems, err := lintListFile(file); err != nil || len(problems) > 0 {
+      t.Errorf("%s: migrated file has problems %v %v", name, problems, err)
+    }
+
+    // Editing the migrated file keeps the comments too.
+    if _, err := editFilterFile(file, func(list *ListFile) (filterChange, error) {
+      return filterChange{}, addKeywords(list.Filters[0], []FilterKeyword{{Keyword: "trailer"}})
+    }); err != nil {
+      t.Fatal(err)
+    }
+    migrated, err := ioutil.ReadFile(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    for _, comment := range []string{"# Spoilers for the shows I'm watching.", "# shown on the warning", "# The finale airs on Sunday."} {
+      if !strings.Contains(string(migrated), comment) {
+        t.Errorf("%s: %q lost from\n%s", name, comment, migrated)
+      }
+    }
+    list, err := parseListFile(mustReadDocument(t, file), "filters")
+    if err != nil || list.FormatVersion != listFormatVersion || len(list.Filters) != 1 || len(list.Filters[0].Keywords) != 2 {
+      t.Errorf("%s: migrated and edited to %+v, %v", name, list, err)
+    }
+  }
+}
+
+// mustReadDocument reads a list file as JSON, failing the test if it can't.
+func mustReadDocument(t *testing.T, file string) []byte {
+  t.Helper()
+  data, err := readDocument(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return data
+}
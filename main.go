@@ -6,25 +6,31 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pmezard/go-difflib/difflib"
 )
 
 // MastodonConfig contains the configuration for connecting to a Mastodon instance.
 type MastodonConfig struct {
+  ConfigVersion int   `json:"config_version,omitempty"`
   InstanceURL  string `json:"instance_url"`
   AccessToken  string `json:"access_token"`
+  AccessTokenFile string `json:"access_token_file,omitempty"`
+  AccessTokenCmd  string `json:"access_token_cmd,omitempty"`
+  TokenStore   string `json:"token_store,omitempty"`
+  Profile      string `json:"profile,omitempty"`
   FilterExport string `json:"filters_export"`
   FilterImport string `json:"filters_import"`
   FilterURL    string `json:"filters_import_url"`
@@ -33,54 +39,63 @@ type MastodonConfig struct {
   TagsImport   string `json:"tags_import"`
   TagsURL      string `json:"tags_import_url"`
   TagsDownload string `json:"tags_download"`
+  FilterSHA256 string `json:"filters_import_sha256"`
+  TagsSHA256   string `json:"tags_import_sha256"`
+  Subscriptions []Subscription `json:"subscriptions"`
+  TrustedKeys  []TrustedKey `json:"trusted_keys"`
+  Catalog      string `json:"catalog"`
+  ExportFormat string `json:"export_format"`
+  HistoryDir   string `json:"history_dir"`
+  ExportRepo   string `json:"export_repo,omitempty"`
+  ExportRemote string `json:"export_remote,omitempty"`
+
+  // tokenSource is where the access token was read from, see resolveAccessToken.
+  tokenSource string
 }
 
-// loadConfig loads the configuration from the specified file.
+// loadConfig loads the configuration from the specified file and checks it has the account details.
 func loadConfig(file string) (*MastodonConfig, error) {
-  // Read the file contents.
-  data, err := ioutil.ReadFile(file)
+  config, err := readConfig(file)
   if err != nil {
-    return nil, fmt.Errorf("error reading file: %w", err)
+    return nil, err
   }
-
-  // ignore comments in the config file
-  data = bytes.ReplaceAll(data, []byte("#"), []byte(""))
-
-  // Unmarshal the JSON(5) data
-  var config MastodonConfig
-  if err := json.Unmarshal(data, &config); err != nil {
-    // print the error and the line number
-    if syntaxError, ok := err.(*json.SyntaxError); ok {
-      reader := bytes.NewReader(data)
-      scanner := bufio.NewScanner(reader)
-      line := 1
-      for scanner.Scan() {
-        if int(syntaxError.Offset) < len(scanner.Bytes()) {
-          // print the line before the error
-          if line > 1 {
-            fmt.Printf("%d: %s (before)\n", line-1, scanner.Text())
-          }
-          fmt.Printf("error parsing config file: %s at line %d", err, line)
-          break
-        }
-        line++
-      }
-    }
-
-  return nil, fmt.Errorf("error passing config file JSON: %w", err)
+  if err := config.resolveAccessToken(); err != nil {
+    return nil, err
   }
 
-
   // Validate the configuration.
   if config.InstanceURL == "" {
     return nil, fmt.Errorf("missing instance_url in configuration")
   }
   if config.AccessToken == "" {
-    return nil, fmt.Errorf("missing access_token in configuration")
+    return nil, fmt.Errorf("missing access_token in configuration, set %s, access_token_file or access_token_cmd, or run login", tokenEnvVar)
   }
 
-  return &config, nil
+  return config, nil
+}
 
+// readConfig reads the configuration from the specified file without validating it.
+func readConfig(file string) (*MastodonConfig, error) {
+  // Read the file contents.
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return nil, fmt.Errorf("error reading file: %w", err)
+  }
+
+  // YAML and TOML config files are converted to JSON first.
+  if format := documentFormat(file); format != "json" {
+    if data, err = decodeDocument(format, data); err != nil {
+      return nil, fmt.Errorf("error parsing config file: %w", err)
+    }
+  }
+
+  // Unmarshal the JSON, which may have comments and trailing commas.
+  var config MastodonConfig
+  if err := unmarshalJSONC(file, data, &config); err != nil {
+    return nil, fmt.Errorf("error parsing config file: %w", err)
+  }
+
+  return &config, nil
 }
 
 // exportFilters exports the user's filters using the specified configuration.
@@ -90,11 +105,24 @@ func exportFilters(config *MastodonConfig) error {
   return fmt.Errorf("missing filters_export in configuration")
   }
 
+  // Work out which format to write.
+  ext, err := formatExtension(config.ExportFormat)
+  if err != nil {
+    return err
+  }
+
   // Create the export directory if it does not exist.
   if err := os.MkdirAll(config.FilterExport, 0755); err != nil {
     return fmt.Errorf("error creating export directory: %w", err)
   }
 
+  // Remember the last export so the commit can say what changed.
+  var before *exportState
+  written := make(map[string]bool)
+  if config.ExportRepo != "" {
+    before = readExportState(config.FilterExport, "filters")
+  }
+
   // Download the user's current filters.
   filters, err := downloadFilters(config)
   if err != nil {
@@ -126,36 +154,30 @@ func exportFilters(config *MastodonConfig) error {
       delete(keywordMap, "id")
     }
 
-    // extract the values from each filter to a json string
-    filterJSON, err := json.Marshal(filterMap)
+    // Wrap the filter in a list file header, prettified to make it human readable after export
+    listJSON, err := marshalListFile(filterMap["title"].(string), "filters", []interface{}{filterMap})
     if err != nil {
       return fmt.Errorf("error parsing filter: %w", err)
     }
 
-    // Prettify the JSON string to make it human readable after export, keep the variable as a string
-    var prettyJSON bytes.Buffer
-    err = json.Indent(&prettyJSON, filterJSON, "", "  ")
-    if err != nil {
-      return fmt.Errorf("error parsing filter: %w", err)
-    }
-
-    // Write the filter to a file.
-    filepath := config.FilterExport+strings.ReplaceAll(strings.ReplaceAll(filterMap["title"].(string), " ", "_"), "/", "-") + ".json"
-    if err := ioutil.WriteFile(filepath, prettyJSON.Bytes(), 0644); err != nil {
-      return fmt.Errorf("error writing filter file: %w", err)
+    // Write the filter to a file in the export format.
+    file := config.FilterExport+strings.ReplaceAll(strings.ReplaceAll(filterMap["title"].(string), " ", "_"), "/", "-") + ext
+    if err := writeExportFile(file, listJSON); err != nil {
+      return err
     }
+    written[filepath.Clean(file)] = true
   }
 
+  reportExport("filters", written)
+  if config.ExportRepo != "" {
+    return commitExport(config, config.FilterExport, "filters", before, written)
+  }
   return nil
 }
 
 
 // downloadFilters downloads the user's current filters.
 func downloadFilters(config *MastodonConfig) (string, error) {
-  // Create an HTTP client.
-  client := &http.Client{}
-
-
   // Create an HTTP request to download the user's filters.
   req, err := http.NewRequest("GET", config.InstanceURL+"/api/v2/filters", nil)
   if err != nil {
@@ -167,7 +189,7 @@ func downloadFilters(config *MastodonConfig) (string, error) {
   req.Header.Set("Authorization", "Bearer "+config.AccessToken)
 
   // Send the request and get the response.
-  resp, err := client.Do(req)
+  resp, err := httpClient.Do(req)
   if err != nil {
     return "", fmt.Errorf("error sending request: %w", err)
     // return nil, fmt.Errorf("error sending request: %w", err)
@@ -209,17 +231,22 @@ func importFilters(config *MastodonConfig) error {
     return fmt.Errorf("error downloading filters: %w", err)
   }
 
-  // convert the currentFilters json string to a map
-  var currentFiltersMap map[string]interface{}
-  if err := json.Unmarshal([]byte(currentFilters), &currentFiltersMap); err != nil {
+  // Index the current filters by title.
+  var currentFiltersArray []map[string]interface{}
+  if err := json.Unmarshal([]byte(currentFilters), &currentFiltersArray); err != nil {
     return fmt.Errorf("error parsing filters: %w", err)
   }
+  currentTitles := make(map[string]bool)
+  for _, filter := range currentFiltersArray {
+    if title, ok := filter["title"].(string); ok {
+      currentTitles[strings.ToLower(title)] = true
+    }
+  }
 
-  println("Current filters:")
-  println(currentFiltersMap)
-
-  // Get the filters to import.
+  // Get the filters to import, as the JSON of each list file with new filters.
   var importFilters []byte
+  var sources []string
+  var lists [][]byte
   if config.FilterImport != "" {
     // loop through the configure tags import directory and import all the files
     files, err := ioutil.ReadDir(config.FilterImport)
@@ -230,76 +257,55 @@ func importFilters(config *MastodonConfig) error {
     for _, file := range files {
       // Read the filter file.
 
-      importFilter, err := ioutil.ReadFile(config.FilterImport + file.Name())
+      if !isDocumentFile(file.Name()) {
+        continue
+      }
+      importFilter, err := readDocument(config.FilterImport + file.Name())
       if err != nil {
         return fmt.Errorf("error reading filter file: %w", err)
       }
 
-      // convert the importFilter json string to a map
-      var importFilterMap map[string]interface{}
-      if err := json.Unmarshal([]byte(importFilter), &importFilterMap); err != nil {
-        return fmt.Errorf("error parsing filters: %w", err)
+      // Parse the file, which may be a versioned list, an array of filters or a single legacy filter.
+      list, err := parseListFile(importFilter, "filters")
+      if err != nil {
+        return fmt.Errorf("error parsing filters in %s: %w", file.Name(), err)
+      }
+
+      // Check which of its filters already exist, as uploadFilters leaves those alone.
+      added := false
+      for _, filter := range list.Filters {
+        if currentTitles[strings.ToLower(filter.Title)] {
+          fmt.Println("Filter already exists:", filter.Title)
+        } else {
+          added = true
+        }
       }
 
-      // check if the filter already exists
-      if currentFiltersMap["title"] == importFilterMap["title"] {
-        println("Filter already exists: ", importFilterMap["title"])
-      } else {
-        // Add the filter to the importFilters array
+      // Add the file to the filters to import if it has any new ones.
+      if added {
         importFilters = append(importFilters, importFilter...)
+        sources = append(sources, file.Name())
+        lists = append(lists, importFilter)
       }
     }
 
   } else if config.FilterURL != "" {
-    // Download the filters to import.
-    resp, err := http.Get(config.FilterURL)
+    // Download the filters to import, reusing the cached copy if it hasn't changed.
+    resp, err := downloadCached(context.Background(), config.FilterDownload, config.FilterURL, config.FilterSHA256, config.TrustedKeys)
     if err != nil {
       return fmt.Errorf("error downloading filters: %w", err)
     }
-    defer resp.Body.Close()
-
-    // Check the response status code.
-    if resp.StatusCode != http.StatusOK {
-      return fmt.Errorf("received non-200 response: %d", resp.StatusCode)
-    }
-
-    // Read the response body.
-    importFilters, err = ioutil.ReadAll(resp.Body)
+    importFilters, err = decodeDocument(documentFormat(config.FilterURL), resp.Body)
     if err != nil {
-      return fmt.Errorf("error reading response body: %w", err)
+      return fmt.Errorf("error reading %s: %w", config.FilterURL, err)
     }
+    sources = append(sources, config.FilterURL)
+    lists = append(lists, importFilters)
 
   }
 
 
-  // convert importFilters to bytes
-  importFiltersBytes := []byte(importFilters)
-
-  // Create an HTTP client.
-  client := &http.Client{}
-
-  // Create an HTTP request to import the filters.
-  req, err := http.NewRequest("POST", config.InstanceURL+"/api/v2/filters", bytes.NewBuffer(importFiltersBytes))
-  if err != nil {
-    return fmt.Errorf("error creating request: %w", err)
-  }
-
-  // Set the authorization header.
-  req.Header.Set("Authorization", "Bearer "+config.AccessToken)
-
-  // Send the request and get the response.
-  resp, err := client.Do(req)
-  if err != nil {
-    return fmt.Errorf("error sending request: %w", err)
-  }
-
-  // Check the response status code.
-  if resp.StatusCode != http.StatusOK {
-    return fmt.Errorf("received non-200 response: %d", resp.StatusCode)
-  }
-
-
-  // Show a diff of the pending changes.
+  // Show a diff of the pending changes. Nothing is sent to the account until the import is confirmed.
   diff := difflib.UnifiedDiff{
     A:        difflib.SplitLines(string(currentFilters)),
     B:        difflib.SplitLines(string(importFilters)),
@@ -314,24 +320,40 @@ func importFilters(config *MastodonConfig) error {
   }
 
   fmt.Println(text)
+  reportDiff("filters", text)
 
-  // Prompt the user to confirm the import.
-  if !confirmImport() {
+  // Plan the new filters, and let the user pick which to create.
+  plan := &bundlePlan{}
+  for i, list := range lists {
+    if err := planFilterUpload(plan, currentTitles, sources[i], list); err != nil {
+      return err
+    }
+  }
+  if plan.empty() {
+    fmt.Println("There are no new filters to import.")
+    return nil
+  }
+  selected, err := confirmPlan(plan)
+  if err != nil {
+    return err
+  }
+  if selected == nil {
+    reportPlan(plan, false)
     return nil
   }
 
-  // Upload the imported filters.
-  if err := uploadFilters(config); err != nil {
+  // Keep a snapshot to roll back to, then create the chosen filters.
+  done, err := applyBundlePlan(config, selected, "filter import")
+  reportPlan(done, true)
+  reportPlan(selected.remaining(done), false)
+  if err != nil {
     return fmt.Errorf("error uploading filters: %w", err)
   }
-
   return nil
 }
 
 // downloadURL downloads the contents of the specified URL.
 func downloadURL(url string) ([]byte, error) {
-// Create an HTTP client.
-client := &http.Client{}
 // Create an HTTP request to download the file.
 req, err := http.NewRequest("GET", url, nil)
 if err != nil {
@@ -339,7 +361,7 @@ if err != nil {
 }
 
 // Send the request and get the response.
-resp, err := client.Do(req)
+resp, err := httpClient.Do(req)
 if err != nil {
   return nil, fmt.Errorf("error sending request: %w", err)
   }
@@ -359,7 +381,7 @@ return body, nil
 }
 
 // showDiff shows a diff of the changes between the current and imported filters or tags JSON.
-func showDiff(current, imported []map[string]interface{}) error {
+func showDiff(kind string, current, imported []map[string]interface{}) error {
   // Create a temporary file for the current filters.
   currentFile, err := ioutil.TempFile("", "current-*.json")
   if err != nil {
@@ -388,12 +410,16 @@ func showDiff(current, imported []map[string]interface{}) error {
 
   // Create a diff command.
   cmd := exec.Command("diff", "-u", currentFile.Name(), importFile.Name())
-  // Set the output to stdout.
-  cmd.Stdout = os.Stdout
-  // Run the diff command.
+  var diff bytes.Buffer
+  cmd.Stdout = &diff
+  // Run the diff command, which exits with status 1 when the files differ.
   if err := cmd.Run(); err != nil {
-    return fmt.Errorf("error running diff command: %w", err)
+    if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+      return fmt.Errorf("error running diff command: %w", err)
+    }
   }
+  fmt.Print(diff.String())
+  reportDiff(kind, diff.String())
   return nil
 }
 
@@ -425,6 +451,10 @@ func printMenu() (int, error) {
   fmt.Println(" 3. Filters")
   fmt.Println(" 4. Tags")
   fmt.Println("-")
+  fmt.Println("Sync")
+  fmt.Println(" 5. Filters")
+  fmt.Println(" 6. Tags")
+  fmt.Println("-")
   // fmt.Println("Import from URL")
   // fmt.Println(" 7. Filters")
   // fmt.Println(" 8. Tags")
@@ -446,64 +476,25 @@ return choice, nil
 
 }
 
-// uploadFilters uploads filters to the user's account using the v2 api.
-func uploadFilters(config *MastodonConfig) error {
-  // Check if the import directory is specified.
-  if config.FilterImport == "" {
-  return fmt.Errorf("missing filters_import in configuration")
-  }
-
-  // Read the files in the import directory.
-  files, err := ioutil.ReadDir(config.FilterImport)
+// planFilterUpload adds the creation of each filter in a filters list file that isn't on the account, or already planned, to the plan.
+func planFilterUpload(plan *bundlePlan, existing map[string]bool, source string, data []byte) error {
+  filters, err := parseFilterList(data)
   if err != nil {
-    return fmt.Errorf("error reading import directory: %w", err)
+    return fmt.Errorf("error parsing filter data from %s: %w", source, err)
   }
 
-  // For each file, unmarshal the JSON data and upload the filter.
-  for _, file := range files {
-    // Only process files that end with ".json".
-    if !strings.HasSuffix(file.Name(), ".json") {
+  // Filters already on the account are left alone rather than duplicated, and a relative expiry starts when the filter is created.
+  for _, filter := range filters {
+    if existing[strings.ToLower(filter.Title)] {
       continue
     }
-
-    // Read the file contents.
-    contents, err := ioutil.ReadFile(filepath.Join(config.FilterImport, file.Name()))
-    if err != nil {
-      return fmt.Errorf("error reading file %s: %w", file.Name(), err)
-    }
-
-    // Unmarshal the JSON data.
-    var filter map[string]interface{}
-    if err := json.Unmarshal(contents, &filter); err != nil {
-      return fmt.Errorf("error parsing filter data from file %s: %w", file.Name(), err)
-    }
-
-    // Create an HTTP client.
-    client := &http.Client{}
-
-    // Create an HTTP request to upload the filter.
-    req, err := http.NewRequest("POST", config.InstanceURL+"/api/v2/filters", bytes.NewBuffer(contents))
-    if err != nil {
-      return fmt.Errorf("error creating request: %w", err)
-    }
-
-    // Set the authorization header.
-    req.Header.Set("Authorization", "Bearer "+config.AccessToken)
-    req.Header.Set("Content-Type", "application/json")
-
-    // Send the request and get the response.
-    resp, err := client.Do(req)
-    if err != nil {
-      return fmt.Errorf("error sending request: %w", err)
-    }
-    defer resp.Body.Close()
-
-    // Check the response status code.
-    if resp.StatusCode != http.StatusOK {
-      return fmt.Errorf("received non-200 response: %d", resp.StatusCode)
+    existing[strings.ToLower(filter.Title)] = true
+    var expiresIn time.Duration
+    if filter.ExpiresIn != nil {
+      expiresIn = time.Duration(*filter.ExpiresIn)
     }
+    plan.Filters = append(plan.Filters, filterChange{Action: "create", Title: filter.Title, Reason: "imported from " + source, Filter: filter, ExpiresIn: expiresIn, AddKeywords: filter.Keywords})
   }
-
   return nil
 }
 
@@ -515,11 +506,24 @@ func exportTags(config *MastodonConfig) error {
     return fmt.Errorf("missing tags_export in configuration")
   }
 
+  // Work out which format to write.
+  ext, err := formatExtension(config.ExportFormat)
+  if err != nil {
+    return err
+  }
+
   // Create the export directory if it does not exist.
   if err := os.MkdirAll(config.TagsExport, 0755); err != nil {
     return fmt.Errorf("error creating export directory: %w", err)
   }
 
+  // Remember the last export so the commit can say what changed.
+  var before *exportState
+  written := make(map[string]bool)
+  if config.ExportRepo != "" {
+    before = readExportState(config.TagsExport, "tags")
+  }
+
   // Download the user's current tags.
   tags, err := downloadTags(config)
   if err != nil {
@@ -533,7 +537,7 @@ func exportTags(config *MastodonConfig) error {
   for _, tag := range tags {
     tagMap[tag["name"].(string)] = tag
 
-    // Clean up the JSON.
+    // History changes every day, so it's left out of list files. tags report uses it instead.
     delete(tag, "history")
   }
 
@@ -541,64 +545,36 @@ func exportTags(config *MastodonConfig) error {
   // Iterate over the entries in the map.
   for key, value := range tagMap {
 
-    // Marshal the value into JSON.
-    jsonBytes, err := json.Marshal(value)
+    // Wrap the tag in a list file header, prettified to make it human readable after export
+    listJSON, err := marshalListFile(key, "tags", []interface{}{value})
     if err != nil {
       return fmt.Errorf("error marshalling JSON: %w", err)
     }
 
-    // Prettify the JSON string to make it human readable after export, keep the variable as a string
-    var prettyJSON bytes.Buffer
-    err = json.Indent(&prettyJSON, jsonBytes, "", "  ")
-    if err != nil {
-      return fmt.Errorf("error parsing filter: %w", err)
-    }
-
 
-    // Write the JSON to a file named after the key.
-    err = ioutil.WriteFile(filepath.Join(config.TagsExport, key+".json"), prettyJSON.Bytes(), 0644)
-    if err != nil {
-      return fmt.Errorf("error writing JSON to file: %w", err)
+    // Write the list to a file named after the key, in the export format.
+    if err := writeExportFile(filepath.Join(config.TagsExport, key+ext), listJSON); err != nil {
+      return err
     }
+    written[filepath.Join(config.TagsExport, key+ext)] = true
   }
 
   PrettifyJSONFiles(config.TagsExport)
 
+  reportExport("tags", written)
+  if config.ExportRepo != "" {
+    return commitExport(config, config.TagsExport, "tags", before, written)
+  }
   return nil
 }
 
 
 // downloadTags downloads the user's current tags.
 func downloadTags(config *MastodonConfig) ([]map[string]interface{}, error) {
-
-  // Create an HTTP client.
-  client := &http.Client{}
-
-  // Create an HTTP request to download the user's tags.
-  req, err := http.NewRequest("GET", config.InstanceURL+"/api/v1/followed_tags", nil)
-  if err != nil {
-    return nil, fmt.Errorf("error creating request: %w", err)
-  }
-
-  // Set the authorization header.
-  req.Header.Set("Authorization", "Bearer "+config.AccessToken)
-
-  // Send the request and get the response.
-  resp, err := client.Do(req)
-  if err != nil {
-    return nil, fmt.Errorf("error sending request: %w", err)
-  }
-  defer resp.Body.Close()
-
-  // Check the response status code.
-  if resp.StatusCode != http.StatusOK {
-    return nil, fmt.Errorf("received non-200 response: %d", resp.StatusCode)
-  }
-
-  // Read the response body.
-  body, err := ioutil.ReadAll(resp.Body)
+  // Download every page of the user's tags.
+  body, err := apiGetAll(config, "/api/v1/followed_tags")
   if err != nil {
-    return nil, fmt.Errorf("error reading response body: %w", err)
+    return nil, err
   }
 
   // Unmarshal the byte slice into a slice of JSON objects.
@@ -608,7 +584,6 @@ func downloadTags(config *MastodonConfig) ([]map[string]interface{}, error) {
   }
 
   return tags, nil
-
 }
 
 // importFromDirectory imports data from the specified directory using the provided import function.
@@ -619,10 +594,14 @@ func importFromDirectory(directory string, importFn func(filename string, data [
   return fmt.Errorf("error reading directory: %w", err)
   }
 
-  // Loop through the files and import the data.
+  // Loop through the list files and import the data.
   for _, file := range files {
+    if !isDocumentFile(file.Name()) {
+      continue
+    }
+
     // Read the file data.
-    data, err := ioutil.ReadFile(filepath.Join(directory, file.Name()))
+    data, err := readDocument(filepath.Join(directory, file.Name()))
     if err != nil {
       return fmt.Errorf("error reading file: %w", err)
     }
@@ -641,208 +620,134 @@ func importTagsFromDirectory(directory string, importFn func(filename string, da
   return importFromDirectory(directory, importFn)
 }
 
-func importTagsFromURL(config *MastodonConfig, url string, importFn func(filename string, data []byte) error) error {
-  // Try to download the file from the url
-  resp, err := http.Get(url)
-  if err != nil {
-    return fmt.Errorf("error downloading file: %w", err)
-  }
-  defer resp.Body.Close()
-
-  // Check the response status code.
-  if resp.StatusCode != http.StatusOK {
-    return fmt.Errorf("received non-200 response: %d", resp.StatusCode)
-  }
-
-  // Read the response body.
-  body, err := ioutil.ReadAll(resp.Body)
+// importTags imports the user's tags from the tags_import_url, or else from each file in the tags_import directory.
+func importTags(config *MastodonConfig) error {
+  // Download the current tags.
+  current, err := downloadTags(config)
   if err != nil {
-    return fmt.Errorf("error reading response body: %w", err)
-  }
-
-  // Import the data.
-  if err := importFn(url, body); err != nil {
-    return fmt.Errorf("error importing file: %w", err)
-  }
-
-  // check that the file is a valid JSON file, with the correct schema for a tag
-  var tag map[string]interface{}
-  if err := json.Unmarshal(body, &tag); err != nil {
-    return fmt.Errorf("error unmarshalling tag: %w", err)
-  }
-
-  // check that the tag has the correct schema
-  if _, ok := tag["name"]; !ok {
-    return fmt.Errorf("error unmarshalling tag: %w", err)
+    return fmt.Errorf("error downloading tags: %w", err)
   }
-
-  // save the response body to a file in the downloads/tags directory
-  // create the directory if it does not exist
-  if err := os.MkdirAll(config.TagsDownload, 0755); err != nil {
-    return fmt.Errorf("error creating download directory: %w", err)
+  for _, tag := range current {
+    delete(tag, "history")
   }
 
-  // clean the filename to replace spaces with underscores, and forward slashes with dashes
-  filename := strings.ReplaceAll(strings.ReplaceAll(url, " ", "_"), "/", "-")
-  filename = filepath.Join(config.TagsDownload, filename)
-
-  // Prettify the JSON string to make it human readable after export, keep the variable as a string
-  var prettyJSON bytes.Buffer
-  err = json.Indent(&prettyJSON, body, "", "  ")
-  if err != nil {
-    return fmt.Errorf("error parsing filter: %w", err)
+  // Check if a URL is specified.
+  if config.TagsURL != "" {
+    // Download the tags from the URL, reusing the cached copy if it hasn't changed.
+    resp, err := downloadCached(context.Background(), config.TagsDownload, config.TagsURL, config.TagsSHA256, config.TrustedKeys)
+    if err != nil {
+      return fmt.Errorf("error downloading tags from URL: %w", err)
+    }
+    data, err := decodeDocument(documentFormat(config.TagsURL), resp.Body)
+    if err != nil {
+      return fmt.Errorf("error reading %s: %w", config.TagsURL, err)
+    }
+    return importTagList(config, config.TagsURL, current, data)
   }
 
-  // Write the JSON to a file named after the name key.
-  err = ioutil.WriteFile(filename, prettyJSON.Bytes(), 0644)
-  if err != nil {
-    return fmt.Errorf("error writing JSON to file: %w", err)
+  // Check if a directory is specified.
+  if config.TagsImport == "" {
+    return fmt.Errorf("no import source specified")
   }
 
-  // Prompt the user to confirm the import
-  fmt.Println("The following tag will be imported:")
-  fmt.Println(string(prettyJSON.Bytes()))
-  fmt.Println("Do you want to continue? (y/n)")
-
-  // Read the user's response.
-  var response string
-  fmt.Scanln(&response)
-
-  // Check the response.
-  if response == "y" {
-    // Import the data.
-    if err := importFn(url, body); err != nil {
-      return fmt.Errorf("error importing file: %w", err)
-    }
-
-  } else {
-    return fmt.Errorf("import cancelled")
+  // Import the tags from each file in the directory.
+  if err := importTagsFromDirectory(config.TagsImport, func(filename string, data []byte) error {
+    return importTagList(config, filename, current, data)
+  }); err != nil {
+    return fmt.Errorf("error importing tags: %w", err)
   }
-
   return nil
-
 }
 
-
-
-// importTags imports the user's tags from the specified directory or URL.
-func importTags(config *MastodonConfig) error {
-  // Download the current tags.
-  current, err := downloadTags(config)
+// importTagList shows a diff of the account's tags against a tag list, and follows the tags in it after confirmation.
+func importTagList(config *MastodonConfig, source string, current []map[string]interface{}, data []byte) error {
+  tags, err := parseTagList(data)
   if err != nil {
-    return fmt.Errorf("error downloading tags: %w", err)
+    return fmt.Errorf("error reading %s: %w", source, err)
   }
 
-// Check if a URL is specified.
-if config.TagsURL != "" {
-  // Download the tags from the URL.
-  imported, err := downloadTags(config)
+  // Read the list's tags back as plain JSON objects so they diff like the account's.
+  listJSON, err := json.Marshal(tags)
   if err != nil {
-    return fmt.Errorf("error downloading tags from URL: %w", err)
+    return fmt.Errorf("error encoding tags: %w", err)
+  }
+  var imported []map[string]interface{}
+  if err := json.Unmarshal(listJSON, &imported); err != nil {
+    return fmt.Errorf("error encoding tags: %w", err)
   }
 
   // Show a diff of the changes.
-  if err := showDiff(current, imported); err != nil {
+  if err := showDiff("tags", current, imported); err != nil {
     return fmt.Errorf("error showing diff: %w", err)
   }
-} else {
-  // Check if a directory is specified.
-  if config.TagsImport == "" {
-    return fmt.Errorf("no import source specified")
-  }
 
-  // Import the tags from the directory.
-  if err := importTagsFromDirectory(config.TagsImport, func(filename string, data []byte) error {
-    // Download the tags from the URL.
-    imported, err := downloadTags(config)
-    if err != nil {
-      return fmt.Errorf("error downloading tags from URL: %w", err)
-    }
-    // Show a diff of the changes.
-    if err := showDiff(current, imported); err != nil {
-      return fmt.Errorf("error showing diff: %w", err)
-    }
-
-    // Prompt the user to confirm the import.
-    if confirmed := confirmImport(); !confirmed {
-      return fmt.Errorf("import cancelled")
-    }
-
-    // Upload the tags.
-    if err := uploadTags(config, data); err != nil {
-      return fmt.Errorf("error uploading tags: %w", err)
-    }
-
-      return nil
-    }); err != nil {
-      return fmt.Errorf("error importing tags: %w", err)
+  // Plan the follows and unfollows, and let the user pick which to make.
+  var followed []*Tag
+  for _, tag := range current {
+    if name, ok := tag["name"].(string); ok {
+      followed = append(followed, &Tag{Name: name})
     }
   }
-  return nil
-}
-
-// uploadTags uploads the specified tags to the user's account.
-func uploadTags(config *MastodonConfig, tags []byte) error {
-  // Create an HTTP client.
-  client := &http.Client{}
-  // Create an HTTP request to upload the tags.
-  req, err := http.NewRequest("POST", config.InstanceURL+"/api/v1/tag_following", bytes.NewBuffer(tags))
-  if err != nil {
-  return fmt.Errorf("error creating request: %w", err)
+  plan := &bundlePlan{Tags: planTagSync(followed, tags)}
+  if plan.empty() {
+    fmt.Printf("The account already follows the tags in %s.\n", source)
+    return nil
   }
-
-  // Set the authorization header.
-  req.Header.Set("Authorization", "Bearer "+config.AccessToken)
-
-  // Send the request and get the response.
-  resp, err := client.Do(req)
+  selected, err := confirmPlan(plan)
   if err != nil {
-    return fmt.Errorf("error sending request: %w", err)
+    return err
+  }
+  if selected == nil {
+    reportPlan(plan, false)
+    return fmt.Errorf("import cancelled")
   }
-  defer resp.Body.Close()
 
-  // Check the response status code.
-  if resp.StatusCode != http.StatusOK {
-    return fmt.Errorf("received non-200 response: %d", resp.StatusCode)
+  // Keep a snapshot to roll back to, then follow and unfollow the chosen tags.
+  done, err := applyBundlePlan(config, selected, "tag import of "+source)
+  reportPlan(done, true)
+  reportPlan(selected.remaining(done), false)
+  if err != nil {
+    return fmt.Errorf("error uploading tags: %w", err)
   }
   return nil
 }
 
-// A function that add from inputs.
+// createTag asks for a tag name and follows it, returning the tag as JSON.
 func createTag(config *MastodonConfig) ([]byte, error) {
-  // ask the user to input the tag name, assign it to a variable
   var tagName string
   fmt.Print("Enter the tag name: ")
   fmt.Scanln(&tagName)
 
+  name := normalizeTag(tagName)
+  if name == "" {
+    return nil, fmt.Errorf("%q isn't a valid tag", tagName)
+  }
+  if err := applyTagChange(config, tagChange{Action: "follow", Name: name}); err != nil {
+    return nil, fmt.Errorf("error following tag: %w", err)
+  }
 
-  // Convert the tag to JSON.
-  data, err := json.Marshal(tagName)
+  data, err := json.Marshal(Tag{Name: name})
   if err != nil {
     return nil, fmt.Errorf("error converting tag to JSON: %w", err)
   }
-
-  // Upload the tag.
-  if err := uploadTags(config, data); err != nil {
-    return nil, fmt.Errorf("error uploading tag: %w", err)
-  }
-
   return data, nil
 }
 
 
 // Define configTemplate as json
 var configTemplate = []byte(`{
+  "config_version": 2,
   "instance_url": "https://mastodon.social",
   "access_token": "REPLACEME",
   "tags_export": "export/tags/",
   "tags_import": "import/tags/",
   "tags_import_url": "",
-  "tags_download": "downloads/tags/"
+  "tags_download": "downloads/tags/",
   "filters_export": "export/filters/",
   "filters_import": "import/filters/",
-  "filters_import_url": ""
-  "filters_download": "downloads/filters/"
+  "filters_import_url": "",
+  "filters_download": "downloads/filters/",
+  "history_dir": "history/"
 }`)
 
 
@@ -853,6 +758,12 @@ func generateConfig(configFile string) error {
     return nil
   }
 
+  // Convert the template to the format of the config file.
+  template, err := encodeDocument(documentFormat(configFile), configTemplate)
+  if err != nil {
+    return fmt.Errorf("error encoding config file: %w", err)
+  }
+
   // Create the config file.
   f, err := os.Create(configFile)
   if err != nil {
@@ -861,7 +772,7 @@ func generateConfig(configFile string) error {
   defer f.Close()
 
   // Write the config file template.
-  if _, err := f.Write(configTemplate); err != nil {
+  if _, err := f.Write(template); err != nil {
     return fmt.Errorf("error writing config file: %w", err)
   }
 
@@ -935,121 +846,84 @@ func PrettifyJSONFiles(dir string) error {
 }
 
 
-var configFile = flag.String("config", "config.json", "the path to the config file")
+var configFile = flag.String("config", "config.json", "the path to the config file, which may be JSON, YAML or TOML")
+var profileName = flag.String("profile", "", "the profile to use from the token store (default profile in the config, or default)")
+var outputFormat = flag.String("output", "text", "how to print results: text, or json for a single JSON document on stdout")
+var recordDir = flag.String("record", "", "record every HTTP request and response to this directory, with tokens scrubbed, e.g. for a bug report")
+var plainPrompts = flag.Bool("plain", false, "use the numbered menu and y/n prompts instead of the terminal UI")
+var replayDir = flag.String("replay", "", "answer HTTP requests from the fixtures recorded in this directory instead of the network")
 
 // Main is the entry point of the program.
 func main() {
-// Parse the command line arguments.
+// Parse the global flags, the command has flags of its own.
+flag.Usage = printUsage
 flag.Parse()
 
-// Generate the config file if it doesn't exist.
-if err := generateConfig(*configFile); err != nil {
-  log.Fatalf("error generating config file: %v", err)
+// Print results as JSON if asked.
+if err := setupOutput(*outputFormat); err != nil {
+  fmt.Fprintf(os.Stderr, "error: %s\n", err)
+  os.Exit(2)
 }
 
-
-// Load the configuration from the config file.
-config, err := loadConfig(*configFile)
-if err != nil {
-  fmt.Printf("error loading configuration: %s\n", err)
+// Record or replay the HTTP traffic.
+if err := setupFixtures(*recordDir, *replayDir); err != nil {
+  printError("", err)
   os.Exit(1)
 }
+if *replayDir != "" {
+  // The recorded token was scrubbed, so any token will do and the token store needn't be unlocked.
+  os.Setenv(tokenEnvVar, redacted)
+}
 
+os.Exit(runCommand(flag.Args()))
+}
 
-// parse the arguments
-// possible arguments are: "import", "export", "importFromURL"
-
-args := flag.Args()
-
-// check if the user passed any arguments
-if len(args) > 0 {
-  // loop over the arguments
-  for _, arg := range args {
-    // check if the argument is a valid action
-    if arg == "filters" || arg == "tags" {
-      // check if the user wants to import or export
-      if strings.Contains(strings.Join(args, " "), "import") {
-        // import the data
-        if arg == "filters" {
-          if err := importFilters(config); err != nil {
-            fmt.Printf("error importing filters: %s\n", err)
-            os.Exit(1)
-          }
-        } else if arg == "tags" {
-          if err := importTags(config); err != nil {
-            fmt.Printf("error importing tags: %s\n", err)
-            os.Exit(1)
-          }
-        }
-      } else if strings.Contains(strings.Join(args, " "), "export") {
-        // export the data
-        if arg == "filters" {
-          if err := exportFilters(config); err != nil {
-            fmt.Printf("error exporting filters: %s\n", err)
-            os.Exit(1)
-          }
-        } else if arg == "tags" {
-          if err := exportTags(config); err != nil {
-            fmt.Printf("error exporting tags: %s\n", err)
-            os.Exit(1)
-          }
-        }
-      }
-    }
-  }
-} else {
-  // Print the menu and get the user's choice.
-  choice, err := printMenu()
+// runMenu asks which action to perform when no command is given.
+func runMenu() error {
+  config, err := loadAccount()
   if err != nil {
-    fmt.Printf("error getting menu choice: %s\n", err)
-    os.Exit(1)
+    return err
+  }
+
+  // Show the menu and get the user's choice.
+  var choice int
+  if useTUI() {
+    choice, err = chooseMenu()
+    if err != nil {
+      return err
+    }
+    if choice == 0 {
+      return nil
+    }
+  } else {
+    choice, err = printMenu()
+    if err != nil {
+      return fmt.Errorf("error getting menu choice: %w", err)
+    }
   }
 
   // Perform the selected action.
   switch choice {
   case 1:
-    if err := exportFilters(config); err != nil {
-      fmt.Printf("error exporting filters: %s\n", err)
-      os.Exit(1)
-    }
+    err = exportFilters(config)
   case 2:
-    if err := exportTags(config); err != nil {
-      fmt.Printf("error exporting tags: %s\n", err)
-      os.Exit(1)
-    }
+    err = exportTags(config)
   case 3:
-    if err := importFilters(config); err != nil {
-      fmt.Printf("error importing filters: %s\n", err)
-      os.Exit(1)
-    }
+    err = importFilters(config)
   case 4:
-    if err := importTags(config); err != nil {
-      fmt.Printf("error importing tags: %s\n", err)
-      os.Exit(1)
-    }
+    err = importTags(config)
+  case 5:
+    err = syncFilters(config, true)
+  case 6:
+    err = syncTags(config, true)
+  default:
+    return fmt.Errorf("there is no choice %d", choice)
+  }
+  if err != nil {
+    return err
   }
-}
-
-// Print a summary of the performed action.
-fmt.Printf("Action completed successfully.\n")
-
 
-// Define the JSON specification for the tags files.
-type tagsFile struct {
-  Tags []struct {
-    Name string `json:"name"`
-    Following bool `json:"following,omitempty"`
-    URL string `json:"url,omitempty"`
-    ID string `json:"id,omitempty"`
-  } `json:"tags"`
+  // Print a summary of the performed action.
+  fmt.Printf("Action completed successfully.\n")
+  return nil
 }
-
-// Define the JSON specification for the filters files.
-type filtersFile struct {
-  Filters []struct {
-    Name string `json:"name"`
-    Query string `json:"query"`
-    URL string `json:"url,omitempty"`
-    ID string `json:"id,omitempty"`
-  } `json:"filters"`
-}}
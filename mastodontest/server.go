@@ -0,0 +1,1106 @@
+// Package mastodontest provides an in-process fake Mastodon server for tests.
+//
+// The server keeps the state of a single account in memory and implements the parts of the
+// Mastodon API that subscribe-o-mast uses: v2 filters with keywords and statuses, followed and
+// featured tags with their usage history and trends, lists, domain blocks, account search, follows, and the
+// statuses of accounts and tags. Like a real instance it checks the
+// access token, paginates long lists with Link headers, sends rate limit headers and answers
+// errors with a JSON body.
+package mastodontest
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Token is the access token the server accepts unless Server.Token is changed.
+const Token = "mastodontest-token"
+
+// Filter is a v2 filter as the API returns it.
+type Filter struct {
+  ID           string          `json:"id"`
+  Title        string          `json:"title"`
+  Context      []string        `json:"context"`
+  ExpiresAt    *string         `json:"expires_at"`
+  FilterAction string          `json:"filter_action"`
+  Keywords     []FilterKeyword `json:"keywords"`
+  Statuses     []FilterStatus  `json:"statuses"`
+}
+
+// FilterKeyword is a keyword of a filter.
+type FilterKeyword struct {
+  ID        string `json:"id"`
+  Keyword   string `json:"keyword"`
+  WholeWord bool   `json:"whole_word"`
+}
+
+// FilterStatus is a status matched by a filter.
+type FilterStatus struct {
+  ID       string `json:"id"`
+  StatusID string `json:"status_id"`
+}
+
+// Tag is a hashtag as the API returns it.
+type Tag struct {
+  Name      string       `json:"name"`
+  URL       string       `json:"url"`
+  History   []TagHistory `json:"history"`
+  Following bool         `json:"following"`
+}
+
+// TagHistory is the use of a tag on one day, with the numbers as strings as the API sends them.
+type TagHistory struct {
+  Day      string `json:"day"`
+  Uses     string `json:"uses"`
+  Accounts string `json:"accounts"`
+}
+
+// FeaturedTag is a tag featured on the account's profile.
+type FeaturedTag struct {
+  ID   string `json:"id"`
+  Name string `json:"name"`
+}
+
+// List is a list of accounts.
+type List struct {
+  ID            string `json:"id"`
+  Title         string `json:"title"`
+  RepliesPolicy string `json:"replies_policy"`
+  Exclusive     bool   `json:"exclusive"`
+}
+
+// Account is an account the server knows about, for search, follows and lists.
+type Account struct {
+  ID       string `json:"id"`
+  Username string `json:"username"`
+  Acct     string `json:"acct"`
+}
+
+// Status is a post by an account, with the hashtags in it.
+type Status struct {
+  ID        string      `json:"id"`
+  URL       string      `json:"url"`
+  CreatedAt string      `json:"created_at"`
+  Content   string      `json:"content"`
+  Account   *Account    `json:"account"`
+  Tags      []StatusTag `json:"tags"`
+}
+
+// StatusTag is a hashtag used in a status.
+type StatusTag struct {
+  Name string `json:"name"`
+  URL  string `json:"url"`
+}
+
+// failure is an error response queued by FailNext.
+type failure struct {
+  method, path string
+  status       int
+  message      string
+}
+
+// Server is a fake Mastodon instance. Its fields can be changed before the first request.
+type Server struct {
+  *httptest.Server
+
+  // Token is the access token requests must send.
+  Token string
+  // PageSize is the number of items in a page of a paginated list when the request doesn't give a limit.
+  PageSize int
+  // RateLimit is the number of requests allowed in each RateLimitWindow, or 0 for no limit.
+  RateLimit int
+  // RateLimitWindow is how often the rate limit resets.
+  RateLimitWindow time.Duration
+
+  mu           sync.Mutex
+  nextID       int
+  me           *Account
+  filters      []*Filter
+  tags         map[string]*Tag
+  followed     []string
+  featured     []*FeaturedTag
+  lists        []*List
+  listAccounts map[string][]string
+  domainBlocks []string
+  accounts     []*Account
+  statuses     []*Status
+  trending     []string
+  following    map[string]bool
+  failures     []failure
+  requests     []string
+  remaining    int
+  resetAt      time.Time
+}
+
+// NewServer starts a fake instance for an empty account called tester. Close it when done.
+func NewServer() *Server {
+  s := &Server{
+    Token:           Token,
+    PageSize:        40,
+    RateLimitWindow: 5 * time.Minute,
+    tags:            make(map[string]*Tag),
+    listAccounts:    make(map[string][]string),
+    following:       make(map[string]bool),
+  }
+  s.me = s.AddAccount("tester")
+  s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+  return s
+}
+
+// id returns a new id, increasing like Mastodon's so that they sort in creation order.
+func (s *Server) id() string {
+  s.nextID++
+  return strconv.Itoa(s.nextID)
+}
+
+// AddFilter adds a filter to the account, giving it and its keywords and statuses ids, and returns a copy.
+func (s *Server) AddFilter(filter Filter) Filter {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  added := filter
+  added.ID = s.id()
+  added.Keywords = nil
+  for _, keyword := range filter.Keywords {
+    keyword.ID = s.id()
+    added.Keywords = append(added.Keywords, keyword)
+  }
+  added.Statuses = nil
+  for _, status := range filter.Statuses {
+    status.ID = s.id()
+    added.Statuses = append(added.Statuses, status)
+  }
+  if added.FilterAction == "" {
+    added.FilterAction = "warn"
+  }
+  s.filters = append(s.filters, &added)
+  return copyFilter(&added)
+}
+
+// Filters returns copies of the account's filters.
+func (s *Server) Filters() []Filter {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  var filters []Filter
+  for _, filter := range s.filters {
+    filters = append(filters, copyFilter(filter))
+  }
+  return filters
+}
+
+// Filter returns a copy of the filter with the title, and whether there is one.
+func (s *Server) Filter(title string) (Filter, bool) {
+  for _, filter := range s.Filters() {
+    if filter.Title == title {
+      return filter, true
+    }
+  }
+  return Filter{}, false
+}
+
+// FollowTags follows the tags, in order.
+func (s *Server) FollowTags(names ...string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for _, name := range names {
+    s.follow(s.tag(name))
+  }
+}
+
+// FollowedTags returns the names of the followed tags, in the order they were followed.
+func (s *Server) FollowedTags() []string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return append([]string(nil), s.followed...)
+}
+
+// FeaturedTags returns the names of the featured tags.
+func (s *Server) FeaturedTags() []string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  var names []string
+  for _, tag := range s.featured {
+    names = append(names, tag.Name)
+  }
+  return names
+}
+
+// DomainBlocks returns the blocked domains.
+func (s *Server) DomainBlocks() []string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return append([]string(nil), s.domainBlocks...)
+}
+
+// BlockDomains blocks the domains.
+func (s *Server) BlockDomains(domains ...string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.domainBlocks = append(s.domainBlocks, domains...)
+}
+
+// AddAccount adds an account that can be found by search, followed and added to lists.
+// Accounts on this server have no domain in their acct, others are user@domain.
+func (s *Server) AddAccount(acct string) *Account {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  account := &Account{ID: s.id(), Username: strings.SplitN(acct, "@", 2)[0], Acct: acct}
+  s.accounts = append(s.accounts, account)
+  return account
+}
+
+// AddStatus adds a status by the account with the acct, which is added if the server doesn't know it, using the tags.
+func (s *Server) AddStatus(acct, content string, tags ...string) Status {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  var account *Account
+  for _, a := range s.accounts {
+    if a.Acct == acct {
+      account = a
+    }
+  }
+  if account == nil {
+    account = &Account{ID: s.id(), Username: strings.SplitN(acct, "@", 2)[0], Acct: acct}
+    s.accounts = append(s.accounts, account)
+  }
+
+  id := s.id()
+  status := &Status{ID: id, URL: s.URL + "/@" + acct + "/" + id, CreatedAt: time.Now().UTC().Format(time.RFC3339), Content: content, Account: account, Tags: []StatusTag{}}
+  for _, name := range tags {
+    status.Tags = append(status.Tags, StatusTag{Name: strings.ToLower(name), URL: s.URL + "/tags/" + url.PathEscape(strings.ToLower(name))})
+  }
+  s.statuses = append(s.statuses, status)
+  return *status
+}
+
+// SetTagUses sets how many posts used the tag on each of the last days, today first, and by as many accounts.
+func (s *Server) SetTagUses(name string, uses ...int) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  tag := s.tag(name)
+  tag.History = []TagHistory{}
+  today := time.Now().UTC().Truncate(24 * time.Hour)
+  for i, n := range uses {
+    day := strconv.FormatInt(today.AddDate(0, 0, -i).Unix(), 10)
+    tag.History = append(tag.History, TagHistory{Day: day, Uses: strconv.Itoa(n), Accounts: strconv.Itoa(n)})
+  }
+}
+
+// SetTrendingTags sets the tags returned by the trends API, most trending first.
+func (s *Server) SetTrendingTags(names ...string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.trending = nil
+  for _, name := range names {
+    s.trending = append(s.trending, strings.ToLower(s.tag(name).Name))
+  }
+}
+
+// FollowAccounts makes the account follow the accounts with the accts, adding any the server doesn't know.
+func (s *Server) FollowAccounts(accts ...string) {
+  for _, acct := range accts {
+    var account *Account
+    s.mu.Lock()
+    for _, a := range s.accounts {
+      if a.Acct == acct {
+        account = a
+      }
+    }
+    s.mu.Unlock()
+    if account == nil {
+      account = s.AddAccount(acct)
+    }
+    s.mu.Lock()
+    s.following[account.ID] = true
+    s.mu.Unlock()
+  }
+}
+
+// Lists returns the titles of the lists with the accts of their accounts.
+func (s *Server) Lists() map[string][]string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  lists := make(map[string][]string)
+  for _, list := range s.lists {
+    accts := []string{}
+    for _, id := range s.listAccounts[list.ID] {
+      accts = append(accts, s.account(id).Acct)
+    }
+    lists[list.Title] = accts
+  }
+  return lists
+}
+
+// FailNext makes the next request for the method and path fail with the status and error message.
+func (s *Server) FailNext(method, path string, status int, message string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.failures = append(s.failures, failure{method: method, path: path, status: status, message: message})
+}
+
+// Requests returns every request made so far, as "METHOD /path".
+func (s *Server) Requests() []string {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return append([]string(nil), s.requests...)
+}
+
+// copyFilter returns a deep copy of the filter.
+func copyFilter(filter *Filter) Filter {
+  copied := *filter
+  copied.Context = append([]string(nil), filter.Context...)
+  copied.Keywords = append([]FilterKeyword{}, filter.Keywords...)
+  copied.Statuses = append([]FilterStatus{}, filter.Statuses...)
+  return copied
+}
+
+// apiError is an error response, written as Mastodon does as {"error": "..."}.
+type apiError struct {
+  status  int
+  message string
+}
+
+func (e *apiError) Error() string {
+  return e.message
+}
+
+// errorf returns an error response with the status.
+func errorf(status int, format string, args ...interface{}) error {
+  return &apiError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// errNotFound is the response for missing records, as Mastodon words it.
+var errNotFound = errorf(http.StatusNotFound, "Record not found")
+
+// serveHTTP checks the token and rate limit, then routes the request.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+  // Every response carries the rate limit headers, as on a real instance.
+  if s.RateLimit > 0 {
+    now := time.Now()
+    if now.After(s.resetAt) {
+      s.remaining = s.RateLimit
+      s.resetAt = now.Add(s.RateLimitWindow)
+    }
+    w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.RateLimit))
+    w.Header().Set("X-RateLimit-Reset", s.resetAt.UTC().Format(time.RFC3339))
+    if s.remaining == 0 {
+      w.Header().Set("X-RateLimit-Remaining", "0")
+      writeError(w, errorf(http.StatusTooManyRequests, "Too many requests"))
+      return
+    }
+    s.remaining--
+    w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(s.remaining))
+  }
+
+  if r.Header.Get("Authorization") != "Bearer "+s.Token {
+    writeError(w, errorf(http.StatusUnauthorized, "The access token is invalid"))
+    return
+  }
+
+  for i, fail := range s.failures {
+    if fail.method == r.Method && fail.path == r.URL.Path {
+      s.failures = append(s.failures[:i], s.failures[i+1:]...)
+      writeError(w, errorf(fail.status, "%s", fail.message))
+      return
+    }
+  }
+
+  params, err := readParams(r)
+  if err != nil {
+    writeError(w, err)
+    return
+  }
+
+  result, err := s.route(w, r, params)
+  if err != nil {
+    writeError(w, err)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  json.NewEncoder(w).Encode(result)
+}
+
+// writeError writes the error as a JSON error response.
+func writeError(w http.ResponseWriter, err error) {
+  status := http.StatusInternalServerError
+  if e, ok := err.(*apiError); ok {
+    status = e.status
+  }
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// params holds the parameters of a request, from the query string and a JSON or form body.
+type params map[string]interface{}
+
+// readParams reads the query string and body of the request.
+func readParams(r *http.Request) (params, error) {
+  p := make(params)
+  for key, values := range r.URL.Query() {
+    p[strings.TrimSuffix(key, "[]")] = values[0]
+  }
+  if r.Body == nil || r.ContentLength == 0 {
+    return p, nil
+  }
+  if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+    if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+      return nil, errorf(http.StatusBadRequest, "Error while parsing request body: %s", err)
+    }
+    return p, nil
+  }
+  if err := r.ParseForm(); err != nil {
+    return nil, errorf(http.StatusBadRequest, "%s", err)
+  }
+  for key, values := range r.PostForm {
+    if strings.HasSuffix(key, "[]") {
+      list := []interface{}{}
+      for _, value := range values {
+        list = append(list, value)
+      }
+      p[strings.TrimSuffix(key, "[]")] = list
+    } else {
+      p[key] = values[0]
+    }
+  }
+  return p, nil
+}
+
+// string returns a parameter as a string.
+func (p params) string(key string) string {
+  switch value := p[key].(type) {
+  case string:
+    return value
+  case float64:
+    return strconv.FormatFloat(value, 'f', -1, 64)
+  case bool:
+    return strconv.FormatBool(value)
+  }
+  return ""
+}
+
+// bool returns a parameter as a boolean.
+func (p params) bool(key string) bool {
+  switch value := p[key].(type) {
+  case bool:
+    return value
+  case string:
+    return value == "true" || value == "1"
+  }
+  return false
+}
+
+// has reports whether the request has the parameter.
+func (p params) has(key string) bool {
+  _, ok := p[key]
+  return ok
+}
+
+// strings returns a parameter as a list of strings.
+func (p params) strings(key string) []string {
+  var values []string
+  switch value := p[key].(type) {
+  case []interface{}:
+    for _, item := range value {
+      if s, ok := item.(string); ok {
+        values = append(values, s)
+      }
+    }
+  case string:
+    values = append(values, value)
+  }
+  return values
+}
+
+// route calls the handler for the request's path, returning the value to send as JSON.
+func (s *Server) route(w http.ResponseWriter, r *http.Request, p params) (interface{}, error) {
+  parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+  if len(parts) < 3 || parts[0] != "api" {
+    return nil, errorf(http.StatusNotFound, "Not found")
+  }
+  version, resource, rest := parts[1], parts[2], parts[3:]
+  method := r.Method
+
+  switch {
+  case version == "v2" && resource == "filters":
+    return s.routeFilters(method, rest, p)
+  case version == "v2" && resource == "search" && method == "GET":
+    return s.search(p), nil
+  case version == "v1" && resource == "followed_tags" && method == "GET":
+    var tags []interface{}
+    for _, name := range s.followed {
+      tags = append(tags, s.tags[name])
+    }
+    return paginate(w, r, tags, s.PageSize), nil
+  case version == "v1" && resource == "tags" && len(rest) >= 1:
+    return s.routeTag(method, rest)
+  case version == "v1" && resource == "featured_tags":
+    return s.routeFeaturedTags(method, rest, p)
+  case version == "v1" && resource == "lists":
+    return s.routeLists(method, rest, p)
+  case version == "v1" && resource == "domain_blocks":
+    return s.routeDomainBlocks(w, r, p)
+  case version == "v1" && resource == "accounts" && len(rest) == 1 && rest[0] == "verify_credentials":
+    return s.me, nil
+  case version == "v1" && resource == "accounts" && len(rest) == 2 && rest[1] == "follow" && method == "POST":
+    if s.account(rest[0]) == nil {
+      return nil, errNotFound
+    }
+    s.following[rest[0]] = true
+    return map[string]interface{}{"id": rest[0], "following": true}, nil
+  case version == "v1" && resource == "accounts" && len(rest) == 2 && rest[1] == "following" && method == "GET":
+    if rest[0] != s.me.ID {
+      return []interface{}{}, nil
+    }
+    var accounts []interface{}
+    for _, account := range s.accounts {
+      if s.following[account.ID] {
+        accounts = append(accounts, account)
+      }
+    }
+    return paginate(w, r, accounts, 40), nil
+  case version == "v1" && resource == "trends" && len(rest) == 1 && rest[0] == "tags" && method == "GET":
+    var tags []interface{}
+    for _, name := range s.trending {
+      tags = append(tags, s.tags[name])
+    }
+    return paginate(w, r, tags, 10), nil
+  case version == "v1" && resource == "timelines" && len(rest) == 2 && rest[0] == "tag" && method == "GET":
+    name, err := url.PathUnescape(rest[1])
+    if err != nil {
+      return nil, errNotFound
+    }
+    var statuses []interface{}
+    for i := len(s.statuses) - 1; i >= 0; i-- {
+      for _, tag := range s.statuses[i].Tags {
+        if strings.EqualFold(tag.Name, name) {
+          statuses = append(statuses, s.statuses[i])
+          break
+        }
+      }
+    }
+    return paginate(w, r, statuses, 20), nil
+  case version == "v1" && resource == "accounts" && len(rest) == 2 && rest[1] == "statuses" && method == "GET":
+    if s.account(rest[0]) == nil {
+      return nil, errNotFound
+    }
+    // Newest first, as the API returns them.
+    var statuses []interface{}
+    for i := len(s.statuses) - 1; i >= 0; i-- {
+      if s.statuses[i].Account.ID == rest[0] {
+        statuses = append(statuses, s.statuses[i])
+      }
+    }
+    return paginate(w, r, statuses, 20), nil
+  }
+
+  return nil, errorf(http.StatusNotFound, "Not found")
+}
+
+// paginate returns the page of items asked for with limit and max_id, setting a Link header to the next page as Mastodon does.
+// Pages here are simply offsets into the list, passed as max_id.
+func paginate(w http.ResponseWriter, r *http.Request, items []interface{}, pageSize int) []interface{} {
+  limit := pageSize
+  if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 {
+    limit = n
+  }
+  start, _ := strconv.Atoi(r.URL.Query().Get("max_id"))
+  if start > len(items) {
+    start = len(items)
+  }
+  end := start + limit
+  if end > len(items) {
+    end = len(items)
+  }
+
+  link := func(offset int, rel string) string {
+    next := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+    query := url.Values{"max_id": {strconv.Itoa(offset)}, "limit": {strconv.Itoa(limit)}}
+    next.RawQuery = query.Encode()
+    return fmt.Sprintf("<%s>; rel=\"%s\"", next.String(), rel)
+  }
+  var links []string
+  if end < len(items) {
+    links = append(links, link(end, "next"))
+  }
+  if start > 0 {
+    prev := start - limit
+    if prev < 0 {
+      prev = 0
+    }
+    links = append(links, link(prev, "prev"))
+  }
+  if len(links) > 0 {
+    w.Header().Set("Link", strings.Join(links, ", "))
+  }
+
+  return append([]interface{}{}, items[start:end]...)
+}
+
+// validContexts are the contexts a filter can apply in.
+var validContexts = map[string]bool{"home": true, "notifications": true, "public": true, "thread": true, "account": true}
+
+// findFilter returns the filter with the id.
+func (s *Server) findFilter(id string) (*Filter, error) {
+  for _, filter := range s.filters {
+    if filter.ID == id {
+      return filter, nil
+    }
+  }
+  return nil, errNotFound
+}
+
+// routeFilters handles /api/v2/filters and the keywords and statuses of each filter.
+func (s *Server) routeFilters(method string, rest []string, p params) (interface{}, error) {
+  if len(rest) == 0 {
+    switch method {
+    case "GET":
+      filters := []Filter{}
+      for _, filter := range s.filters {
+        filters = append(filters, copyFilter(filter))
+      }
+      return filters, nil
+    case "POST":
+      filter := &Filter{ID: s.id(), FilterAction: "warn", Keywords: []FilterKeyword{}, Statuses: []FilterStatus{}}
+      if err := s.updateFilter(filter, p, true); err != nil {
+        s.nextID--
+        return nil, err
+      }
+      s.filters = append(s.filters, filter)
+      return copyFilter(filter), nil
+    }
+    return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+  }
+
+  // Keywords and statuses are also reached by their own ids.
+  switch {
+  case len(rest) == 2 && rest[0] == "keywords":
+    return s.routeFilterKeyword(method, rest[1], p)
+  case len(rest) == 2 && rest[0] == "statuses":
+    return s.routeFilterStatus(method, rest[1])
+  }
+
+  filter, err := s.findFilter(rest[0])
+  if err != nil {
+    return nil, err
+  }
+
+  if len(rest) == 1 {
+    switch method {
+    case "GET":
+      return copyFilter(filter), nil
+    case "PUT":
+      updated := copyFilter(filter)
+      if err := s.updateFilter(&updated, p, false); err != nil {
+        return nil, err
+      }
+      *filter = updated
+      return copyFilter(filter), nil
+    case "DELETE":
+      for i := range s.filters {
+        if s.filters[i] == filter {
+          s.filters = append(s.filters[:i], s.filters[i+1:]...)
+          break
+        }
+      }
+      return map[string]interface{}{}, nil
+    }
+    return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+  }
+
+  switch {
+  case rest[1] == "keywords" && method == "GET":
+    return filter.Keywords, nil
+  case rest[1] == "keywords" && method == "POST":
+    if p.string("keyword") == "" {
+      return nil, errorf(http.StatusUnprocessableEntity, "Validation failed: Keyword can't be blank")
+    }
+    keyword := FilterKeyword{ID: s.id(), Keyword: p.string("keyword"), WholeWord: p.bool("whole_word")}
+    filter.Keywords = append(filter.Keywords, keyword)
+    return keyword, nil
+  case rest[1] == "statuses" && method == "GET":
+    return filter.Statuses, nil
+  case rest[1] == "statuses" && method == "POST":
+    if p.string("status_id") == "" {
+      return nil, errorf(http.StatusUnprocessableEntity, "Validation failed: Status can't be blank")
+    }
+    status := FilterStatus{ID: s.id(), StatusID: p.string("status_id")}
+    filter.Statuses = append(filter.Statuses, status)
+    return status, nil
+  }
+  return nil, errorf(http.StatusNotFound, "Not found")
+}
+
+// updateFilter applies the parameters of a create or update request to the filter, validating them as Mastodon does.
+func (s *Server) updateFilter(filter *Filter, p params, create bool) error {
+  if create || p.has("title") {
+    filter.Title = p.string("title")
+  }
+  if create || p.has("context") {
+    filter.Context = p.strings("context")
+  }
+  if p.has("filter_action") {
+    filter.FilterAction = p.string("filter_action")
+  }
+  if p.has("expires_in") {
+    if seconds, err := strconv.Atoi(p.string("expires_in")); err == nil && seconds > 0 {
+      expiresAt := time.Now().UTC().Add(time.Duration(seconds) * time.Second).Format(time.RFC3339)
+      filter.ExpiresAt = &expiresAt
+    } else {
+      filter.ExpiresAt = nil
+    }
+  }
+
+  var problems []string
+  if filter.Title == "" {
+    problems = append(problems, "Title can't be blank")
+  }
+  if len(filter.Context) == 0 {
+    problems = append(problems, "Context can't be blank")
+  }
+  for _, context := range filter.Context {
+    if !validContexts[context] {
+      problems = append(problems, "Context contains invalid context types")
+    }
+  }
+  if filter.FilterAction != "warn" && filter.FilterAction != "hide" {
+    problems = append(problems, "Filter action is not included in the list")
+  }
+
+  // keywords_attributes adds keywords without an id, changes those with one, and removes those marked _destroy.
+  attributes, _ := p["keywords_attributes"].([]interface{})
+  for _, item := range attributes {
+    attrs, _ := item.(map[string]interface{})
+    attr := params(attrs)
+    id := attr.string("id")
+    if id == "" {
+      if attr.string("keyword") == "" {
+        problems = append(problems, "Keywords keyword can't be blank")
+        continue
+      }
+      filter.Keywords = append(filter.Keywords, FilterKeyword{ID: s.id(), Keyword: attr.string("keyword"), WholeWord: attr.bool("whole_word")})
+      continue
+    }
+    found := false
+    for i, keyword := range filter.Keywords {
+      if keyword.ID != id {
+        continue
+      }
+      found = true
+      if attr.bool("_destroy") {
+        filter.Keywords = append(filter.Keywords[:i], filter.Keywords[i+1:]...)
+        break
+      }
+      if attr.has("keyword") {
+        filter.Keywords[i].Keyword = attr.string("keyword")
+      }
+      if attr.has("whole_word") {
+        filter.Keywords[i].WholeWord = attr.bool("whole_word")
+      }
+      break
+    }
+    if !found {
+      return errNotFound
+    }
+  }
+
+  if len(problems) > 0 {
+    return errorf(http.StatusUnprocessableEntity, "Validation failed: %s", strings.Join(problems, ", "))
+  }
+  return nil
+}
+
+// routeFilterKeyword handles /api/v2/filters/keywords/:id.
+func (s *Server) routeFilterKeyword(method, id string, p params) (interface{}, error) {
+  for _, filter := range s.filters {
+    for i := range filter.Keywords {
+      if filter.Keywords[i].ID != id {
+        continue
+      }
+      switch method {
+      case "GET":
+        return filter.Keywords[i], nil
+      case "PUT":
+        if p.has("keyword") {
+          filter.Keywords[i].Keyword = p.string("keyword")
+        }
+        if p.has("whole_word") {
+          filter.Keywords[i].WholeWord = p.bool("whole_word")
+        }
+        return filter.Keywords[i], nil
+      case "DELETE":
+        filter.Keywords = append(filter.Keywords[:i], filter.Keywords[i+1:]...)
+        return map[string]interface{}{}, nil
+      }
+      return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+    }
+  }
+  return nil, errNotFound
+}
+
+// routeFilterStatus handles /api/v2/filters/statuses/:id.
+func (s *Server) routeFilterStatus(method, id string) (interface{}, error) {
+  for _, filter := range s.filters {
+    for i := range filter.Statuses {
+      if filter.Statuses[i].ID != id {
+        continue
+      }
+      switch method {
+      case "GET":
+        return filter.Statuses[i], nil
+      case "DELETE":
+        status := filter.Statuses[i]
+        filter.Statuses = append(filter.Statuses[:i], filter.Statuses[i+1:]...)
+        return status, nil
+      }
+      return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+    }
+  }
+  return nil, errNotFound
+}
+
+// tag returns the tag with the name, creating it if the server hasn't seen it. Names are case insensitive.
+func (s *Server) tag(name string) *Tag {
+  key := strings.ToLower(name)
+  if tag, ok := s.tags[key]; ok {
+    return tag
+  }
+  tag := &Tag{Name: name, URL: s.URL + "/tags/" + url.PathEscape(name), History: []TagHistory{}}
+  s.tags[key] = tag
+  return tag
+}
+
+// follow follows the tag, if it isn't already followed.
+func (s *Server) follow(tag *Tag) {
+  if !tag.Following {
+    tag.Following = true
+    s.followed = append(s.followed, strings.ToLower(tag.Name))
+  }
+}
+
+// routeTag handles /api/v1/tags/:name and following and unfollowing it.
+func (s *Server) routeTag(method string, rest []string) (interface{}, error) {
+  name, err := url.PathUnescape(rest[0])
+  if err != nil || name == "" || strings.ContainsAny(name, " #") {
+    return nil, errNotFound
+  }
+  tag := s.tag(name)
+
+  switch {
+  case len(rest) == 1 && method == "GET":
+  case len(rest) == 2 && rest[1] == "follow" && method == "POST":
+    s.follow(tag)
+  case len(rest) == 2 && rest[1] == "unfollow" && method == "POST":
+    if tag.Following {
+      tag.Following = false
+      for i, followed := range s.followed {
+        if followed == strings.ToLower(tag.Name) {
+          s.followed = append(s.followed[:i], s.followed[i+1:]...)
+          break
+        }
+      }
+    }
+  default:
+    return nil, errorf(http.StatusNotFound, "Not found")
+  }
+  return tag, nil
+}
+
+// routeFeaturedTags handles /api/v1/featured_tags.
+func (s *Server) routeFeaturedTags(method string, rest []string, p params) (interface{}, error) {
+  switch {
+  case len(rest) == 0 && method == "GET":
+    return append([]*FeaturedTag{}, s.featured...), nil
+  case len(rest) == 0 && method == "POST":
+    name := strings.TrimPrefix(p.string("name"), "#")
+    if name == "" {
+      return nil, errorf(http.StatusUnprocessableEntity, "Validation failed: Tag can't be blank")
+    }
+    for _, tag := range s.featured {
+      if strings.EqualFold(tag.Name, name) {
+        return nil, errorf(http.StatusUnprocessableEntity, "Validation failed: Tag has already been taken")
+      }
+    }
+    tag := &FeaturedTag{ID: s.id(), Name: name}
+    s.featured = append(s.featured, tag)
+    return tag, nil
+  case len(rest) == 1 && method == "DELETE":
+    for i, tag := range s.featured {
+      if tag.ID == rest[0] {
+        s.featured = append(s.featured[:i], s.featured[i+1:]...)
+        return map[string]interface{}{}, nil
+      }
+    }
+    return nil, errNotFound
+  }
+  return nil, errorf(http.StatusNotFound, "Not found")
+}
+
+// account returns the account with the id.
+func (s *Server) account(id string) *Account {
+  for _, account := range s.accounts {
+    if account.ID == id {
+      return account
+    }
+  }
+  return nil
+}
+
+// routeLists handles /api/v1/lists and the accounts in each list.
+func (s *Server) routeLists(method string, rest []string, p params) (interface{}, error) {
+  if len(rest) == 0 {
+    switch method {
+    case "GET":
+      return append([]*List{}, s.lists...), nil
+    case "POST":
+      if p.string("title") == "" {
+        return nil, errorf(http.StatusUnprocessableEntity, "Validation failed: Title can't be blank")
+      }
+      list := &List{ID: s.id(), Title: p.string("title"), RepliesPolicy: "list", Exclusive: p.bool("exclusive")}
+      if p.has("replies_policy") {
+        list.RepliesPolicy = p.string("replies_policy")
+      }
+      s.lists = append(s.lists, list)
+      return list, nil
+    }
+    return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+  }
+
+  var list *List
+  index := 0
+  for i, l := range s.lists {
+    if l.ID == rest[0] {
+      list, index = l, i
+    }
+  }
+  if list == nil {
+    return nil, errNotFound
+  }
+
+  if len(rest) == 1 {
+    switch method {
+    case "GET":
+      return list, nil
+    case "PUT":
+      if p.has("title") {
+        list.Title = p.string("title")
+      }
+      if p.has("replies_policy") {
+        list.RepliesPolicy = p.string("replies_policy")
+      }
+      if p.has("exclusive") {
+        list.Exclusive = p.bool("exclusive")
+      }
+      return list, nil
+    case "DELETE":
+      s.lists = append(s.lists[:index], s.lists[index+1:]...)
+      delete(s.listAccounts, list.ID)
+      return map[string]interface{}{}, nil
+    }
+    return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+  }
+
+  if rest[1] != "accounts" {
+    return nil, errorf(http.StatusNotFound, "Not found")
+  }
+  switch method {
+  case "GET":
+    accounts := []*Account{}
+    for _, id := range s.listAccounts[list.ID] {
+      accounts = append(accounts, s.account(id))
+    }
+    return accounts, nil
+  case "POST":
+    // Like Mastodon, only followed accounts can be added to a list.
+    for _, id := range p.strings("account_ids") {
+      if s.account(id) == nil {
+        return nil, errNotFound
+      }
+      if !s.following[id] {
+        return nil, errorf(http.StatusUnprocessableEntity, "Validation failed: Account has not been followed")
+      }
+      s.listAccounts[list.ID] = append(s.listAccounts[list.ID], id)
+    }
+    return map[string]interface{}{}, nil
+  case "DELETE":
+    for _, id := range p.strings("account_ids") {
+      members := s.listAccounts[list.ID]
+      for i, member := range members {
+        if member == id {
+          s.listAccounts[list.ID] = append(members[:i], members[i+1:]...)
+          break
+        }
+      }
+    }
+    return map[string]interface{}{}, nil
+  }
+  return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// routeDomainBlocks handles /api/v1/domain_blocks.
+func (s *Server) routeDomainBlocks(w http.ResponseWriter, r *http.Request, p params) (interface{}, error) {
+  domain := strings.ToLower(p.string("domain"))
+  switch r.Method {
+  case "GET":
+    var domains []interface{}
+    for _, domain := range s.domainBlocks {
+      domains = append(domains, domain)
+    }
+    return paginate(w, r, domains, s.PageSize), nil
+  case "POST":
+    if domain == "" {
+      return nil, errorf(http.StatusUnprocessableEntity, "Validation failed: Domain can't be blank")
+    }
+    for _, blocked := range s.domainBlocks {
+      if blocked == domain {
+        return map[string]interface{}{}, nil
+      }
+    }
+    s.domainBlocks = append(s.domainBlocks, domain)
+    return map[string]interface{}{}, nil
+  case "DELETE":
+    for i, blocked := range s.domainBlocks {
+      if blocked == domain {
+        s.domainBlocks = append(s.domainBlocks[:i], s.domainBlocks[i+1:]...)
+        break
+      }
+    }
+    return map[string]interface{}{}, nil
+  }
+  return nil, errorf(http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// search handles /api/v2/search for accounts, matching an acct exactly with or without this server's domain,
+// and for statuses, matching a status URL exactly.
+func (s *Server) search(p params) interface{} {
+  statuses := []*Status{}
+  for _, status := range s.statuses {
+    if status.URL == p.string("q") {
+      statuses = append(statuses, status)
+    }
+  }
+
+  query := strings.ToLower(strings.TrimPrefix(p.string("q"), "@"))
+  host := strings.TrimPrefix(strings.TrimPrefix(s.URL, "http://"), "https://")
+  query = strings.TrimSuffix(query, "@"+host)
+
+  accounts := []*Account{}
+  for _, account := range s.accounts {
+    if strings.ToLower(account.Acct) == query {
+      accounts = append(accounts, account)
+    }
+  }
+  sort.Slice(accounts, func(i, j int) bool { return accounts[i].Acct < accounts[j].Acct })
+  return map[string]interface{}{"accounts": accounts, "statuses": statuses, "hashtags": []interface{}{}}
+}
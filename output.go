@@ -0,0 +1,171 @@
+package main
+
+// Machine-readable output: with -output json a command prints a single JSON document describing what it did,
+// for automation such as posting a summary of a sync to a chat channel.
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "sort"
+)
+
+// commandResult is the JSON document printed for a command with -output json.
+type commandResult struct {
+  Command   string         `json:"command"`
+  OK        bool           `json:"ok"`
+  Error     string         `json:"error,omitempty"`
+  Exports   []exportResult `json:"exports,omitempty"`
+  Diffs     []diffResult   `json:"diffs,omitempty"`
+  Changes   []changeResult `json:"changes,omitempty"`
+  TagReport []tagUsage     `json:"tag_report,omitempty"`
+}
+
+// exportResult is the files written by an export of one kind of list.
+type exportResult struct {
+  Kind  string   `json:"kind"`
+  Files []string `json:"files"`
+}
+
+// diffResult is a diff shown before an import.
+type diffResult struct {
+  Kind string `json:"kind"`
+  Diff string `json:"diff"`
+}
+
+// changeResult is a single change planned for the account, and whether it was made.
+type changeResult struct {
+  Kind     string   `json:"kind"` // filter, tag, featured_tag, list or domain_block
+  Action   string   `json:"action"`
+  Name     string   `json:"name"`
+  Reason   string   `json:"reason,omitempty"`
+  Accounts []string `json:"accounts,omitempty"`
+  Applied  bool     `json:"applied"`
+}
+
+// jsonResult collects what the command did when the output is JSON, and is nil for text output.
+var jsonResult *commandResult
+
+// jsonOutput is where the JSON document is written, the real stdout.
+var jsonOutput io.Writer
+
+// setupOutput checks the -output flag. For JSON, everything else printed is sent to stderr so stdout only has the document.
+func setupOutput(format string) error {
+  switch format {
+  case "", "text":
+    return nil
+  case "json":
+    jsonResult = &commandResult{OK: true}
+    jsonOutput = os.Stdout
+    os.Stdout = os.Stderr
+    return nil
+  }
+  return fmt.Errorf("unknown output %q, expected text or json", format)
+}
+
+// writeResult prints the JSON document for the command, failed with err if it isn't nil. It does nothing for text output.
+func writeResult(command string, err error) {
+  if jsonResult == nil {
+    return
+  }
+  jsonResult.Command = command
+  if err != nil {
+    jsonResult.OK = false
+    jsonResult.Error = err.Error()
+  }
+  data, marshalErr := json.MarshalIndent(jsonResult, "", "  ")
+  if marshalErr != nil {
+    fmt.Fprintf(os.Stderr, "error writing output: %s\n", marshalErr)
+    return
+  }
+  jsonOutput.Write(append(data, '\n'))
+}
+
+// printError prints the error a command failed with, as the JSON document for JSON output.
+func printError(command string, err error) {
+  if jsonResult != nil {
+    writeResult(command, err)
+    return
+  }
+  fmt.Fprintf(os.Stderr, "error: %s\n", err)
+}
+
+// reportExport records the files written by an export.
+func reportExport(kind string, written map[string]bool) {
+  if jsonResult == nil {
+    return
+  }
+  files := []string{}
+  for file := range written {
+    files = append(files, file)
+  }
+  sort.Strings(files)
+  jsonResult.Exports = append(jsonResult.Exports, exportResult{Kind: kind, Files: files})
+}
+
+// reportDiff records a diff shown before an import.
+func reportDiff(kind, diff string) {
+  if jsonResult != nil {
+    jsonResult.Diffs = append(jsonResult.Diffs, diffResult{Kind: kind, Diff: diff})
+  }
+}
+
+// reportFilterChanges records changes to filters.
+func reportFilterChanges(changes []filterChange, applied bool) {
+  if jsonResult == nil {
+    return
+  }
+  for _, change := range changes {
+    jsonResult.Changes = append(jsonResult.Changes, changeResult{Kind: "filter", Action: change.Action, Name: change.Title, Reason: change.Reason, Applied: applied})
+  }
+}
+
+// reportTagChanges records follows and unfollows of tags.
+func reportTagChanges(changes []tagChange, applied bool) {
+  if jsonResult == nil {
+    return
+  }
+  for _, change := range changes {
+    jsonResult.Changes = append(jsonResult.Changes, changeResult{Kind: "tag", Action: change.Action, Name: change.Name, Applied: applied})
+  }
+}
+
+// reportPlan records every change in a bundle plan.
+func reportPlan(plan *bundlePlan, applied bool) {
+  if jsonResult == nil {
+    return
+  }
+  reportFilterChanges(plan.Filters, applied)
+  reportTagChanges(plan.Tags, applied)
+  add := func(kind, action, name string, accounts []string) {
+    jsonResult.Changes = append(jsonResult.Changes, changeResult{Kind: kind, Action: action, Name: name, Accounts: accounts, Applied: applied})
+  }
+  for _, name := range plan.FeaturedTags {
+    add("featured_tag", "feature", name, nil)
+  }
+  for _, tag := range plan.UnfeatureTags {
+    add("featured_tag", "unfeature", tag.Name, nil)
+  }
+  for _, change := range plan.Lists {
+    switch change.Action {
+    case "delete":
+      add("list", "delete", change.Existing.Title, nil)
+      continue
+    case "create":
+      add("list", "create", change.List.Title, nil)
+    }
+    if len(change.AddAccounts) > 0 {
+      add("list", "add_accounts", change.List.Title, change.AddAccounts)
+    }
+    if len(change.RemoveAccounts) > 0 {
+      add("list", "remove_accounts", change.List.Title, change.RemoveAccounts)
+    }
+  }
+  for _, domain := range plan.DomainBlocks {
+    add("domain_block", "block", domain, nil)
+  }
+  for _, domain := range plan.DomainUnblocks {
+    add("domain_block", "unblock", domain, nil)
+  }
+}
@@ -0,0 +1,227 @@
+package main
+
+// Relative expiry and scheduled activation windows for filters in list files.
+
+import (
+  "encoding/json"
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Duration is a relative duration in a list file, e.g. "72h", "3d", "1w" or a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON accepts either a duration string or a number of seconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+  // Accept a plain number of seconds, as used by the Mastodon API.
+  var seconds float64
+  if err := json.Unmarshal(data, &seconds); err == nil {
+    *d = Duration(time.Duration(seconds * float64(time.Second)))
+    return nil
+  }
+
+  var s string
+  if err := json.Unmarshal(data, &s); err != nil {
+    return fmt.Errorf("expires_in must be a duration string or a number of seconds")
+  }
+
+  parsed, err := parseDuration(s)
+  if err != nil {
+    return err
+  }
+  *d = Duration(parsed)
+  return nil
+}
+
+// MarshalJSON writes the duration back out in its string form.
+func (d Duration) MarshalJSON() ([]byte, error) {
+  return json.Marshal(time.Duration(d).String())
+}
+
+// parseDuration parses a Go duration, extended with "d" (days) and "w" (weeks) suffixes.
+func parseDuration(s string) (time.Duration, error) {
+  s = strings.TrimSpace(s)
+  if s == "" {
+    return 0, fmt.Errorf("empty duration")
+  }
+
+  // Handle the day and week suffixes that time.ParseDuration doesn't know about.
+  for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+    if strings.HasSuffix(s, suffix) {
+      n, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+      if err != nil {
+        return 0, fmt.Errorf("invalid duration %q", s)
+      }
+      return time.Duration(n * float64(unit)), nil
+    }
+  }
+
+  parsed, err := time.ParseDuration(s)
+  if err != nil {
+    return 0, fmt.Errorf("invalid duration %q", s)
+  }
+  return parsed, nil
+}
+
+// FilterSchedule describes when a filter from a list file should be active on the account.
+type FilterSchedule struct {
+  Timezone string           `json:"timezone,omitempty"`
+  Windows  []ScheduleWindow `json:"windows"`
+}
+
+// ScheduleWindow is a recurring or dated activation window.
+// A window matches when the day is one of Days (if set) and falls between From and Until (if set).
+type ScheduleWindow struct {
+  Days  []string `json:"days,omitempty"`
+  From  string   `json:"from,omitempty"`
+  Until string   `json:"until,omitempty"`
+}
+
+// scheduleDateFormat is the layout used for the from and until dates of a window.
+const scheduleDateFormat = "2006-01-02"
+
+// location returns the time zone the schedule is evaluated in.
+func (s *FilterSchedule) location() (*time.Location, error) {
+  if s.Timezone == "" {
+    return time.Local, nil
+  }
+  loc, err := time.LoadLocation(s.Timezone)
+  if err != nil {
+    return nil, fmt.Errorf("invalid schedule timezone %q: %w", s.Timezone, err)
+  }
+  return loc, nil
+}
+
+// validate checks that the schedule's days and dates can be parsed.
+func (s *FilterSchedule) validate() error {
+  if _, err := s.location(); err != nil {
+    return err
+  }
+  if len(s.Windows) == 0 {
+    return fmt.Errorf("schedule has no windows")
+  }
+  for i, window := range s.Windows {
+    for _, day := range window.Days {
+      if _, err := parseDays(day); err != nil {
+        return fmt.Errorf("schedule window %d: %w", i+1, err)
+      }
+    }
+    for _, date := range []string{window.From, window.Until} {
+      if date == "" {
+        continue
+      }
+      if _, err := time.Parse(scheduleDateFormat, date); err != nil {
+        return fmt.Errorf("schedule window %d: invalid date %q, expected YYYY-MM-DD", i+1, date)
+      }
+    }
+  }
+  return nil
+}
+
+// activeAt reports whether any window of the schedule matches the given time.
+func (s *FilterSchedule) activeAt(t time.Time) (bool, error) {
+  loc, err := s.location()
+  if err != nil {
+    return false, err
+  }
+  t = t.In(loc)
+
+  for _, window := range s.Windows {
+    matched, err := window.matches(t)
+    if err != nil {
+      return false, err
+    }
+    if matched {
+      return true, nil
+    }
+  }
+  return false, nil
+}
+
+// activeUntil returns the time at which the schedule next becomes inactive, starting from an active time.
+// Windows have a granularity of whole days, so only midnights need to be checked.
+func (s *FilterSchedule) activeUntil(t time.Time) (time.Time, error) {
+  loc, err := s.location()
+  if err != nil {
+    return time.Time{}, err
+  }
+  t = t.In(loc)
+
+  // Walk forward a day at a time, giving up after a year of continuous activity.
+  day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+  for i := 0; i < 366; i++ {
+    day = day.AddDate(0, 0, 1)
+    active, err := s.activeAt(day)
+    if err != nil {
+      return time.Time{}, err
+    }
+    if !active {
+      return day, nil
+    }
+  }
+  return day, nil
+}
+
+// matches reports whether the window covers the given time, which must already be in the schedule's zone.
+func (w ScheduleWindow) matches(t time.Time) (bool, error) {
+  if len(w.Days) > 0 {
+    dayMatched := false
+    for _, day := range w.Days {
+      weekdays, err := parseDays(day)
+      if err != nil {
+        return false, err
+      }
+      for _, weekday := range weekdays {
+        if weekday == t.Weekday() {
+          dayMatched = true
+        }
+      }
+    }
+    if !dayMatched {
+      return false, nil
+    }
+  }
+
+  // Both dates are inclusive and interpreted in the schedule's time zone.
+  if w.From != "" {
+    from, err := time.ParseInLocation(scheduleDateFormat, w.From, t.Location())
+    if err != nil {
+      return false, fmt.Errorf("invalid date %q: %w", w.From, err)
+    }
+    if t.Before(from) {
+      return false, nil
+    }
+  }
+  if w.Until != "" {
+    until, err := time.ParseInLocation(scheduleDateFormat, w.Until, t.Location())
+    if err != nil {
+      return false, fmt.Errorf("invalid date %q: %w", w.Until, err)
+    }
+    if !t.Before(until.AddDate(0, 0, 1)) {
+      return false, nil
+    }
+  }
+
+  return true, nil
+}
+
+// parseDays parses a full or abbreviated English weekday name, or one of "weekend" and "weekdays".
+func parseDays(name string) ([]time.Weekday, error) {
+  name = strings.ToLower(strings.TrimSpace(name))
+  switch name {
+  case "weekend", "weekends":
+    return []time.Weekday{time.Saturday, time.Sunday}, nil
+  case "weekday", "weekdays":
+    return []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, nil
+  }
+
+  for day := time.Sunday; day <= time.Saturday; day++ {
+    full := strings.ToLower(day.String())
+    if name == full || name == full[:3] {
+      return []time.Weekday{day}, nil
+    }
+  }
+  return nil, fmt.Errorf("invalid day %q", name)
+}
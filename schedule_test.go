@@ -0,0 +1,143 @@
+package main
+
+import (
+  "encoding/json"
+  "testing"
+  "time"
+)
+
+func TestParseDuration(t *testing.T) {
+  for s, want := range map[string]time.Duration{
+    "72h":   72 * time.Hour,
+    "90m":   90 * time.Minute,
+    "3d":    3 * 24 * time.Hour,
+    "1.5d":  36 * time.Hour,
+    "1w":    7 * 24 * time.Hour,
+    " 2w ":  14 * 24 * time.Hour,
+    "1h30m": 90 * time.Minute,
+    "0s":    0,
+  } {
+    if got, err := parseDuration(s); err != nil || got != want {
+      t.Errorf("parseDuration(%q) = %v, %v, want %v", s, got, err, want)
+    }
+  }
+  for _, s := range []string{"", "d", "3x", "three days", "1d2h"} {
+    if _, err := parseDuration(s); err == nil {
+      t.Errorf("parseDuration(%q) succeeded", s)
+    }
+  }
+}
+
+func TestDurationJSON(t *testing.T) {
+  for data, want := range map[string]time.Duration{
+    `3600`:  time.Hour,
+    `1.5`:   1500 * time.Millisecond,
+    `"3d"`:  72 * time.Hour,
+    `"45m"`: 45 * time.Minute,
+  } {
+    var d Duration
+    if err := json.Unmarshal([]byte(data), &d); err != nil || time.Duration(d) != want {
+      t.Errorf("unmarshalling %s = %v, %v, want %v", data, time.Duration(d), err, want)
+    }
+  }
+  for _, data := range []string{`true`, `"soon"`, `[]`} {
+    var d Duration
+    if err := json.Unmarshal([]byte(data), &d); err == nil {
+      t.Errorf("unmarshalling %s succeeded", data)
+    }
+  }
+
+  data, err := json.Marshal(Duration(36 * time.Hour))
+  if err != nil || string(data) != `"36h0m0s"` {
+    t.Errorf("marshalled 36h as %s, %v", data, err)
+  }
+}
+
+func TestParseDays(t *testing.T) {
+  for name, want := range map[string][]time.Weekday{
+    "monday":   {time.Monday},
+    "Sat":      {time.Saturday},
+    " SUNDAY ": {time.Sunday},
+    "weekend":  {time.Saturday, time.Sunday},
+    "weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+  } {
+    got, err := parseDays(name)
+    if err != nil || len(got) != len(want) {
+      t.Errorf("parseDays(%q) = %v, %v, want %v", name, got, err, want)
+      continue
+    }
+    for i := range want {
+      if got[i] != want[i] {
+        t.Errorf("parseDays(%q) = %v, want %v", name, got, want)
+      }
+    }
+  }
+  for _, name := range []string{"", "mo", "funday"} {
+    if _, err := parseDays(name); err == nil {
+      t.Errorf("parseDays(%q) succeeded", name)
+    }
+  }
+}
+
+func TestScheduleActive(t *testing.T) {
+  // 2024-06-01 is a Saturday.
+  at := func(date string, hour int) time.Time {
+    day, err := time.ParseInLocation(scheduleDateFormat, date, time.UTC)
+    if err != nil {
+      t.Fatal(err)
+    }
+    return day.Add(time.Duration(hour) * time.Hour)
+  }
+  tests := []struct {
+    name     string
+    schedule FilterSchedule
+    at       time.Time
+    want     bool
+  }{
+    {"weekend on saturday", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Days: []string{"weekend"}}}}, at("2024-06-01", 12), true},
+    {"weekend on monday", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Days: []string{"weekend"}}}}, at("2024-06-03", 12), false},
+    {"from is inclusive", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{From: "2024-06-01"}}}, at("2024-06-01", 0), true},
+    {"before from", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{From: "2024-06-01"}}}, at("2024-05-31", 23), false},
+    {"until is inclusive", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Until: "2024-06-01"}}}, at("2024-06-01", 23), true},
+    {"after until", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Until: "2024-06-01"}}}, at("2024-06-02", 0), false},
+    {"days within dates", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Days: []string{"sun"}, From: "2024-06-01", Until: "2024-06-30"}}}, at("2024-06-02", 8), true},
+    {"any window", FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Days: []string{"mon"}}, {Days: []string{"sat"}}}}, at("2024-06-01", 8), true},
+    // 23:00 UTC on Friday is already Saturday in Sydney.
+    {"in the schedule's zone", FilterSchedule{Timezone: "Australia/Sydney", Windows: []ScheduleWindow{{Days: []string{"saturday"}}}}, at("2024-05-31", 23), true},
+  }
+  for _, test := range tests {
+    if got, err := test.schedule.activeAt(test.at); err != nil || got != test.want {
+      t.Errorf("%s: activeAt = %v, %v, want %v", test.name, got, err, test.want)
+    }
+  }
+}
+
+func TestScheduleActiveUntil(t *testing.T) {
+  schedule := FilterSchedule{Timezone: "UTC", Windows: []ScheduleWindow{{Days: []string{"weekend"}}}}
+  saturday := time.Date(2024, 6, 1, 15, 0, 0, 0, time.UTC)
+  until, err := schedule.activeUntil(saturday)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if want := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC); !until.Equal(want) {
+    t.Errorf("weekend schedule active until %v, want %v", until, want)
+  }
+}
+
+func TestScheduleValidate(t *testing.T) {
+  for _, schedule := range []FilterSchedule{
+    {Windows: nil},
+    {Timezone: "Mars/Olympus_Mons", Windows: []ScheduleWindow{{Days: []string{"mon"}}}},
+    {Windows: []ScheduleWindow{{Days: []string{"someday"}}}},
+    {Windows: []ScheduleWindow{{From: "01/06/2024"}}},
+    {Windows: []ScheduleWindow{{Until: "2024-13-01"}}},
+  } {
+    if err := schedule.validate(); err == nil {
+      t.Errorf("schedule %+v is valid", schedule)
+    }
+  }
+  valid := FilterSchedule{Timezone: "Europe/London", Windows: []ScheduleWindow{{Days: []string{"weekdays"}, From: "2024-01-01", Until: "2024-12-31"}}}
+  if err := valid.validate(); err != nil {
+    t.Errorf("schedule %+v: %s", valid, err)
+  }
+}
@@ -0,0 +1,265 @@
+package main
+
+// Detached ed25519 signatures for list files, in minisign or raw ed25519 format.
+
+import (
+  "bytes"
+  "context"
+  "crypto/ed25519"
+  "crypto/rand"
+  "encoding/base64"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/blake2b"
+)
+
+// signatureSuffix is appended to a list file or URL to find its detached signature.
+const signatureSuffix = ".minisig"
+
+// TrustedKey is a public key allowed to sign subscribed lists.
+// PublicKey is either a minisign public key ("RW...") or a base64 encoded raw ed25519 public key.
+type TrustedKey struct {
+  Name      string `json:"name"`
+  PublicKey string `json:"public_key"`
+}
+
+// publicKey is a parsed trusted key. Raw ed25519 keys have no key ID.
+type publicKey struct {
+  name  string
+  keyID []byte
+  key   ed25519.PublicKey
+}
+
+// parsePublicKey parses a minisign or raw ed25519 public key.
+func parsePublicKey(trusted TrustedKey) (*publicKey, error) {
+  data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(trusted.PublicKey))
+  if err != nil {
+    return nil, fmt.Errorf("trusted key %q: invalid base64: %w", trusted.Name, err)
+  }
+
+  switch {
+  case len(data) == 2+8+ed25519.PublicKeySize && string(data[:2]) == "Ed":
+    return &publicKey{name: trusted.Name, keyID: data[2:10], key: ed25519.PublicKey(data[10:])}, nil
+  case len(data) == ed25519.PublicKeySize:
+    return &publicKey{name: trusted.Name, key: ed25519.PublicKey(data)}, nil
+  }
+
+  return nil, fmt.Errorf("trusted key %q: not a minisign or ed25519 public key", trusted.Name)
+}
+
+// verifySignature checks a detached signature of the data against the trusted keys and returns the name of the key that signed it.
+// A minisign signature has four lines: an untrusted comment, the signature, a trusted comment and a global signature over both.
+// A raw ed25519 signature is a single base64 encoded line.
+func verifySignature(data, signature []byte, trusted []TrustedKey) (string, error) {
+  var keys []*publicKey
+  for _, t := range trusted {
+    key, err := parsePublicKey(t)
+    if err != nil {
+      return "", err
+    }
+    keys = append(keys, key)
+  }
+
+  lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+  for i := range lines {
+    lines[i] = strings.TrimSpace(lines[i])
+  }
+
+  // Raw ed25519 signature.
+  if len(lines) == 1 {
+    sig, err := base64.StdEncoding.DecodeString(lines[0])
+    if err != nil || len(sig) != ed25519.SignatureSize {
+      return "", fmt.Errorf("invalid ed25519 signature")
+    }
+    for _, key := range keys {
+      if ed25519.Verify(key.key, data, sig) {
+        return key.name, nil
+      }
+    }
+    return "", fmt.Errorf("signature does not match any trusted key")
+  }
+
+  // Minisign signature.
+  if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+    return "", fmt.Errorf("invalid minisign signature")
+  }
+  sig, err := base64.StdEncoding.DecodeString(lines[1])
+  if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+    return "", fmt.Errorf("invalid minisign signature")
+  }
+  globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+  if err != nil || len(globalSig) != ed25519.SignatureSize {
+    return "", fmt.Errorf("invalid minisign global signature")
+  }
+
+  // "ED" signatures are over the BLAKE2b-512 hash of the data, "Ed" signatures over the data itself.
+  message := data
+  switch string(sig[:2]) {
+  case "ED":
+    hash := blake2b.Sum512(data)
+    message = hash[:]
+  case "Ed":
+  default:
+    return "", fmt.Errorf("unsupported minisign signature algorithm %q", sig[:2])
+  }
+
+  keyID := sig[2:10]
+  trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+  for _, key := range keys {
+    if key.keyID != nil && !bytes.Equal(key.keyID, keyID) {
+      continue
+    }
+    if !ed25519.Verify(key.key, message, sig[10:]) {
+      continue
+    }
+    if !ed25519.Verify(key.key, append(append([]byte{}, sig[10:]...), trustedComment...), globalSig) {
+      return "", fmt.Errorf("invalid trusted comment signature from key %q", key.name)
+    }
+    return key.name, nil
+  }
+
+  return "", fmt.Errorf("signature does not match any trusted key")
+}
+
+// verifyDownload downloads the detached signature of a list and verifies it, when trusted keys are configured.
+// Cached content is verified against its cached signature, fresh content against a freshly downloaded one.
+func verifyDownload(ctx context.Context, trusted []TrustedKey, listURL string, data []byte, cachedSignature []byte) ([]byte, error) {
+  if len(trusted) == 0 {
+    return nil, nil
+  }
+
+  signature := cachedSignature
+  if signature == nil {
+    sigURL, err := signatureURL(listURL)
+    if err != nil {
+      return nil, err
+    }
+    resp, err := downloadConditional(ctx, sigURL, "", "")
+    if err != nil {
+      return nil, fmt.Errorf("error downloading signature for %s: %w", listURL, err)
+    }
+    signature = resp.Body
+  }
+
+  if _, err := verifySignature(data, signature, trusted); err != nil {
+    return nil, fmt.Errorf("error verifying signature of %s: %w", listURL, err)
+  }
+  return signature, nil
+}
+
+// signatureURL returns the URL of a list's detached signature, adding the suffix to the path so any query is kept.
+func signatureURL(listURL string) (string, error) {
+  u, err := url.Parse(listURL)
+  if err != nil {
+    return "", fmt.Errorf("invalid list URL %s: %w", listURL, err)
+  }
+  u.Path += signatureSuffix
+  if u.RawPath != "" {
+    u.RawPath += signatureSuffix
+  }
+  return u.String(), nil
+}
+
+// secretKeyComment is the first line of a secret key file written by generateSigningKey.
+const secretKeyComment = "untrusted comment: subscribe-o-mast secret key"
+
+// generateSigningKey writes a new secret key file and prints the matching minisign public key for trusted_keys.
+func generateSigningKey(file string) error {
+  if _, err := os.Stat(file); err == nil {
+    return fmt.Errorf("%s already exists", file)
+  }
+
+  public, private, err := ed25519.GenerateKey(rand.Reader)
+  if err != nil {
+    return fmt.Errorf("error generating key: %w", err)
+  }
+  keyID := make([]byte, 8)
+  if _, err := rand.Read(keyID); err != nil {
+    return fmt.Errorf("error generating key ID: %w", err)
+  }
+
+  // The secret key file holds the algorithm, key ID and private key, in the same layout as the public key.
+  secret := append(append([]byte("Ed"), keyID...), private...)
+  contents := secretKeyComment + "\n" + base64.StdEncoding.EncodeToString(secret) + "\n"
+  if err := ioutil.WriteFile(file, []byte(contents), 0600); err != nil {
+    return fmt.Errorf("error writing secret key: %w", err)
+  }
+
+  fmt.Println("Secret key written to " + file)
+  fmt.Println("Public key for trusted_keys:")
+  fmt.Println(base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), public...)))
+  return nil
+}
+
+// readSigningKey reads a secret key file written by generateSigningKey.
+func readSigningKey(file string) ([]byte, ed25519.PrivateKey, error) {
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return nil, nil, fmt.Errorf("error reading secret key: %w", err)
+  }
+
+  lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+  secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+  if err != nil || len(secret) != 2+8+ed25519.PrivateKeySize || string(secret[:2]) != "Ed" {
+    return nil, nil, fmt.Errorf("%s is not a subscribe-o-mast secret key", file)
+  }
+
+  return secret[2:10], ed25519.PrivateKey(secret[10:]), nil
+}
+
+// signFile writes a minisign compatible detached signature for a list file next to it.
+func signFile(keyID []byte, key ed25519.PrivateKey, file string) error {
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return fmt.Errorf("error reading %s: %w", file, err)
+  }
+
+  // Sign the BLAKE2b-512 hash of the file, as current minisign versions do.
+  hash := blake2b.Sum512(data)
+  sig := append(append([]byte("ED"), keyID...), ed25519.Sign(key, hash[:])...)
+  trustedComment := fmt.Sprintf("timestamp:%d\tfile:%s", time.Now().Unix(), filepath.Base(file))
+  globalSig := ed25519.Sign(key, append(append([]byte{}, sig[10:]...), trustedComment...))
+
+  contents := fmt.Sprintf("untrusted comment: signature from subscribe-o-mast\n%s\ntrusted comment: %s\n%s\n",
+    base64.StdEncoding.EncodeToString(sig), trustedComment, base64.StdEncoding.EncodeToString(globalSig))
+  if err := ioutil.WriteFile(file+signatureSuffix, []byte(contents), 0644); err != nil {
+    return fmt.Errorf("error writing signature: %w", err)
+  }
+
+  fmt.Println("Signed " + file)
+  return nil
+}
+
+// signCommand defines the sign command's flags and returns the function that runs it.
+func signCommand(flags *flag.FlagSet) func(args []string) error {
+  keyFile := flags.String("key", "subscribe-o-mast.key", "the path to the secret key file")
+  generate := flags.Bool("generate", false, "generate a new secret key file and print its public key")
+  return func(args []string) error {
+    if *generate {
+      return generateSigningKey(*keyFile)
+    }
+
+    if len(args) == 0 {
+      flags.Usage()
+      return fmt.Errorf("no list files to sign")
+    }
+
+    keyID, key, err := readSigningKey(*keyFile)
+    if err != nil {
+      return err
+    }
+    for _, file := range args {
+      if err := signFile(keyID, key, file); err != nil {
+        return err
+      }
+    }
+    return nil
+  }
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+  "context"
+  "crypto/ed25519"
+  "crypto/rand"
+  "encoding/base64"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "strings"
+  "testing"
+
+  "golang.org/x/crypto/blake2b"
+)
+
+// testKey returns a new key pair and its minisign trusted key.
+func testKey(t *testing.T, name string) ([]byte, ed25519.PrivateKey, TrustedKey) {
+  t.Helper()
+  public, private, err := ed25519.GenerateKey(rand.Reader)
+  if err != nil {
+    t.Fatal(err)
+  }
+  keyID := make([]byte, 8)
+  rand.Read(keyID)
+  return keyID, private, TrustedKey{Name: name, PublicKey: base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), public...))}
+}
+
+// minisign returns a minisign signature of the data, with the "ED" prehashed or the legacy "Ed" algorithm.
+func minisign(keyID []byte, key ed25519.PrivateKey, algorithm string, data []byte, trustedComment string) string {
+  message := data
+  if algorithm == "ED" {
+    hash := blake2b.Sum512(data)
+    message = hash[:]
+  }
+  sig := append(append([]byte(algorithm), keyID...), ed25519.Sign(key, message)...)
+  globalSig := ed25519.Sign(key, append(append([]byte{}, sig[10:]...), trustedComment...))
+  return fmt.Sprintf("untrusted comment: test\n%s\ntrusted comment: %s\n%s\n",
+    base64.StdEncoding.EncodeToString(sig), trustedComment, base64.StdEncoding.EncodeToString(globalSig))
+}
+
+func TestVerifySignature(t *testing.T) {
+  data := []byte(`{"format_version": 2, "name": "test"}`)
+  keyID, key, trusted := testKey(t, "maintainer")
+  _, otherKey, other := testKey(t, "other")
+  raw := TrustedKey{Name: "raw", PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))}
+
+  // A signature whose trusted comment was changed after signing.
+  tampered := strings.Replace(minisign(keyID, key, "ED", data, "timestamp:1"), "timestamp:1", "timestamp:2", 1)
+
+  tests := []struct {
+    name      string
+    signature string
+    trusted   []TrustedKey
+    want      string // the key that signed it, or "" if it must be rejected
+  }{
+    {"prehashed", minisign(keyID, key, "ED", data, "timestamp:1"), []TrustedKey{other, trusted}, "maintainer"},
+    {"legacy", minisign(keyID, key, "Ed", data, "timestamp:1"), []TrustedKey{trusted}, "maintainer"},
+    {"raw ed25519 key", minisign(keyID, key, "ED", data, "timestamp:1"), []TrustedKey{raw}, "raw"},
+    {"raw signature", base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)), []TrustedKey{trusted}, "maintainer"},
+    {"untrusted key", minisign(keyID, otherKey, "ED", data, "timestamp:1"), []TrustedKey{trusted}, ""},
+    {"other key ID", minisign([]byte("12345678"), key, "ED", data, "timestamp:1"), []TrustedKey{trusted}, ""},
+    {"tampered trusted comment", tampered, []TrustedKey{trusted}, ""},
+    {"unknown algorithm", minisign(keyID, key, "XX", data, "timestamp:1"), []TrustedKey{trusted}, ""},
+    {"not a signature", "hello\nworld", []TrustedKey{trusted}, ""},
+  }
+  for _, test := range tests {
+    got, err := verifySignature(data, []byte(test.signature), test.trusted)
+    if test.want == "" {
+      if err == nil {
+        t.Errorf("%s: signature accepted as %q", test.name, got)
+      }
+    } else if err != nil || got != test.want {
+      t.Errorf("%s: verifySignature = %q, %v, want %q", test.name, got, err, test.want)
+    }
+  }
+
+  // Changed content fails with either algorithm.
+  for _, algorithm := range []string{"ED", "Ed"} {
+    signature := minisign(keyID, key, algorithm, data, "timestamp:1")
+    if _, err := verifySignature(append(data, ' '), []byte(signature), []TrustedKey{trusted}); err == nil {
+      t.Errorf("%s signature accepted for changed content", algorithm)
+    }
+  }
+}
+
+func TestParsePublicKey(t *testing.T) {
+  for _, key := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("too short"))} {
+    if _, err := parsePublicKey(TrustedKey{Name: "bad", PublicKey: key}); err == nil {
+      t.Errorf("public key %q accepted", key)
+    }
+  }
+}
+
+func TestSignFile(t *testing.T) {
+  dir := t.TempDir()
+  keyFile := filepath.Join(dir, "test.key")
+  if err := generateSigningKey(keyFile); err != nil {
+    t.Fatal(err)
+  }
+  if err := generateSigningKey(keyFile); err == nil {
+    t.Error("generating a key overwrote the existing one")
+  }
+  keyID, key, err := readSigningKey(keyFile)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  file := filepath.Join(dir, "list.json")
+  data := []byte(`{"format_version": 2, "name": "list"}`)
+  if err := ioutil.WriteFile(file, data, 0644); err != nil {
+    t.Fatal(err)
+  }
+  if err := signFile(keyID, key, file); err != nil {
+    t.Fatal(err)
+  }
+  signature, err := ioutil.ReadFile(file + signatureSuffix)
+  if err != nil {
+    t.Fatal(err)
+  }
+  public := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyID...), key.Public().(ed25519.PublicKey)...))
+  if _, err := verifySignature(data, signature, []TrustedKey{{Name: "me", PublicKey: public}}); err != nil {
+    t.Errorf("signed file doesn't verify: %s", err)
+  }
+}
+
+func TestDownloadSigned(t *testing.T) {
+  data := []byte(`[{"name": "golang"}]`)
+  keyID, key, trusted := testKey(t, "maintainer")
+  signature := minisign(keyID, key, "ED", data, "timestamp:1")
+  mux := http.NewServeMux()
+  mux.HandleFunc("/tags.json", func(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("If-None-Match") == `"v1"` {
+      w.WriteHeader(http.StatusNotModified)
+      return
+    }
+    w.Header().Set("ETag", `"v1"`)
+    w.Write(data)
+  })
+  mux.HandleFunc("/tags.json"+signatureSuffix, func(w http.ResponseWriter, r *http.Request) {
+    w.Write([]byte(signature))
+  })
+  mux.HandleFunc("/unsigned.json", func(w http.ResponseWriter, r *http.Request) {
+    w.Write(data)
+  })
+  server := httptest.NewServer(mux)
+  defer server.Close()
+
+  // Both fresh and cached content are checked, in memory and on disk.
+  for _, dir := range []string{"", t.TempDir()} {
+    for i := 0; i < 2; i++ {
+      if _, err := downloadCached(context.Background(), dir, server.URL+"/tags.json", "", []TrustedKey{trusted}); err != nil {
+        t.Errorf("download %d to %q: %s", i+1, dir, err)
+      }
+    }
+  }
+  if _, err := downloadCached(context.Background(), "", server.URL+"/unsigned.json", "", []TrustedKey{trusted}); err == nil {
+    t.Error("unsigned list was accepted")
+  }
+
+  // The signature of a URL with a query is found next to the list's path, not after the query.
+  if _, err := downloadCached(context.Background(), "", server.URL+"/tags.json?ref=main#top", "", []TrustedKey{trusted}); err != nil {
+    t.Errorf("download with a query: %s", err)
+  }
+}
+
+func TestSignatureURL(t *testing.T) {
+  tests := map[string]string{
+    "https://example.com/tags.json":              "https://example.com/tags.json.minisig",
+    "https://example.com/tags.json?ref=main":     "https://example.com/tags.json.minisig?ref=main",
+    "https://example.com/tags.json?ref=main#top": "https://example.com/tags.json.minisig?ref=main#top",
+    "https://example.com/my%2Flists/tags.json":   "https://example.com/my%2Flists/tags.json.minisig",
+  }
+  for listURL, want := range tests {
+    got, err := signatureURL(listURL)
+    if err != nil {
+      t.Errorf("%s: %v", listURL, err)
+    } else if got != want {
+      t.Errorf("signatureURL(%q) = %q, want %q", listURL, got, want)
+    }
+  }
+}
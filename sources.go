@@ -0,0 +1,147 @@
+package main
+
+// Reading lists from the sources given to import and sync: files, directories, URLs, stdin and catalog entries.
+
+import (
+  "context"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// catalogPrefix marks a source naming a list in the catalog, e.g. catalog:sportsball.
+const catalogPrefix = "catalog:"
+
+// readSource reads the lists in a source, whichever kind of list or bundle it holds.
+// A source is a local file or directory, an http or https URL, - for stdin, or catalog:<name>.
+func readSource(config *MastodonConfig, source string) (*ListFile, error) {
+  switch {
+  case source == "-":
+    data, err := ioutil.ReadAll(os.Stdin)
+    if err != nil {
+      return nil, fmt.Errorf("error reading stdin: %w", err)
+    }
+    return parseBundle("stdin", data)
+
+  case strings.HasPrefix(source, catalogPrefix):
+    index, location, err := loadCatalog(config)
+    if err != nil {
+      return nil, err
+    }
+    entry, err := findCatalogEntry(index, strings.TrimPrefix(source, catalogPrefix))
+    if err != nil {
+      return nil, err
+    }
+    file, err := entryLocation(location, *entry)
+    if err != nil {
+      return nil, err
+    }
+    if !isURL(file) {
+      return readSource(config, file)
+    }
+    // The index records each list's checksum, so a list can't be swapped without changing the index.
+    resp, err := downloadCached(context.Background(), config.downloadDir(entry.Kind), file, entry.SHA256, config.TrustedKeys)
+    if err != nil {
+      return nil, fmt.Errorf("error downloading %s: %w", entry.Name, err)
+    }
+    return parseBundle(file, resp.Body)
+
+  case isURL(source):
+    return readBundle(config, source)
+  }
+
+  info, err := os.Stat(source)
+  if err != nil {
+    return nil, fmt.Errorf("error reading %s: %w", source, err)
+  }
+  if !info.IsDir() {
+    return readBundle(config, source)
+  }
+
+  // Read every list file in a directory, as for filters_import and tags_import.
+  files, err := listFilesIn([]string{source})
+  if err != nil {
+    return nil, err
+  }
+  if len(files) == 0 {
+    return nil, fmt.Errorf("there are no list files in %s", source)
+  }
+  list := &ListFile{FormatVersion: listFormatVersion, Name: filepath.Base(source)}
+  for _, file := range files {
+    contents, err := readBundle(config, file)
+    if err != nil {
+      return nil, err
+    }
+    list.merge(contents)
+  }
+  return list, nil
+}
+
+// readSources reads and merges the lists in each source, keeping only the given kinds of list if any are given.
+func readSources(config *MastodonConfig, sources, kinds []string) (*ListFile, error) {
+  merged := &ListFile{FormatVersion: listFormatVersion}
+  stdin := false
+  for _, source := range sources {
+    if source == "-" {
+      if stdin {
+        return nil, fmt.Errorf("- can only be given once")
+      }
+      stdin = true
+    }
+    list, err := readSource(config, source)
+    if err != nil {
+      return nil, err
+    }
+    merged.merge(list)
+  }
+
+  if len(kinds) > 0 {
+    kept := &ListFile{FormatVersion: listFormatVersion}
+    for _, kind := range kinds {
+      if kind == "filters" {
+        kept.Filters = merged.Filters
+      } else {
+        kept.Tags = merged.Tags
+      }
+    }
+    merged = kept
+  }
+  return merged, nil
+}
+
+// importSources applies the lists in the sources to the account, like a bundle, returning the plan that was applied.
+// When interactive is set the changes are shown and the user is asked to confirm them first.
+func importSources(config *MastodonConfig, sources, kinds []string, interactive bool) (*bundlePlan, error) {
+  list, err := readSources(config, sources, kinds)
+  if err != nil {
+    return nil, err
+  }
+
+  plan, err := planBundle(config, list, false)
+  if err != nil {
+    return nil, err
+  }
+
+  if interactive {
+    if plan.empty() {
+      fmt.Println("The account already matches " + strings.Join(sources, ", ") + ".")
+      return plan, nil
+    }
+    selected, err := confirmPlan(plan)
+    if err != nil {
+      return nil, err
+    }
+    if selected == nil {
+      reportPlan(plan, false)
+      return &bundlePlan{}, nil
+    }
+    plan = selected
+  }
+
+  done, err := applyBundlePlan(config, plan, "import of "+strings.Join(sources, ", "))
+  reportPlan(done, true)
+  reportPlan(plan.remaining(done), false)
+  return plan, err
+}
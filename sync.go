@@ -0,0 +1,848 @@
+package main
+
+// Synchronises filters and tags from list files with the user's account.
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// Filter is a Mastodon v2 filter, as returned by the API and stored in list files.
+// ExpiresIn and Schedule only appear in list files and are turned into API calls when syncing.
+type Filter struct {
+  ID           string          `json:"id,omitempty"`
+  Title        string          `json:"title"`
+  Context      []string        `json:"context"`
+  ExpiresAt    *string         `json:"expires_at"`
+  FilterAction string          `json:"filter_action"`
+  Keywords     []FilterKeyword `json:"keywords"`
+  Statuses     []FilterStatus  `json:"statuses"`
+  ExpiresIn    *Duration       `json:"expires_in,omitempty"`
+  Schedule     *FilterSchedule `json:"schedule,omitempty"`
+}
+
+// FilterKeyword is a single keyword of a filter.
+type FilterKeyword struct {
+  ID        string `json:"id,omitempty"`
+  Keyword   string `json:"keyword"`
+  WholeWord bool   `json:"whole_word"`
+}
+
+// FilterStatus is a single status of a filter.
+type FilterStatus struct {
+  ID       string `json:"id,omitempty"`
+  StatusID string `json:"status_id"`
+}
+
+// filterChange is a single create, update or delete action computed by planFilterSync.
+type filterChange struct {
+  Action         string
+  Title          string
+  Reason         string
+  Filter         *Filter
+  Existing       *Filter
+  ExpiresIn      time.Duration
+  AddKeywords    []FilterKeyword
+  RemoveKeywords []FilterKeyword
+  UpdateKeywords []FilterKeyword
+  AddStatuses    []FilterStatus
+  RemoveStatuses []FilterStatus
+}
+
+// apiRequest sends an authenticated JSON request to the Mastodon API and returns the response body.
+func apiRequest(config *MastodonConfig, method, path string, payload interface{}) ([]byte, error) {
+  body, _, err := apiResponse(config, method, path, payload)
+  return body, err
+}
+
+// apiResponse sends the request like apiRequest, also returning the response headers for pagination.
+func apiResponse(config *MastodonConfig, method, path string, payload interface{}) ([]byte, http.Header, error) {
+  // Encode the payload, if any.
+  var body *bytes.Buffer
+  if payload != nil {
+    data, err := json.Marshal(payload)
+    if err != nil {
+      return nil, nil, fmt.Errorf("error encoding request: %w", err)
+    }
+    body = bytes.NewBuffer(data)
+  } else {
+    body = &bytes.Buffer{}
+  }
+
+  // Create the HTTP request.
+  req, err := http.NewRequest(method, config.InstanceURL+path, body)
+  if err != nil {
+    return nil, nil, fmt.Errorf("error creating request: %w", err)
+  }
+
+  // Set the authorization header.
+  req.Header.Set("Authorization", "Bearer "+config.AccessToken)
+  if payload != nil {
+    req.Header.Set("Content-Type", "application/json")
+  }
+
+  // Send the request and get the response.
+  resp, err := httpClient.Do(req)
+  if err != nil {
+    return nil, nil, fmt.Errorf("error sending request: %w", err)
+  }
+  defer resp.Body.Close()
+
+  // Read the response body.
+  respBody, err := ioutil.ReadAll(resp.Body)
+  if err != nil {
+    return nil, nil, fmt.Errorf("error reading response body: %w", err)
+  }
+
+  // Check the response status code.
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    // Mastodon explains most errors in the body.
+    var apiError struct {
+      Error string `json:"error"`
+    }
+    if json.Unmarshal(respBody, &apiError) == nil && apiError.Error != "" {
+      return nil, nil, fmt.Errorf("%s %s: received non-2xx response: %s: %s", method, path, resp.Status, apiError.Error)
+    }
+    return nil, nil, fmt.Errorf("%s %s: received non-2xx response: %s", method, path, resp.Status)
+  }
+
+  return respBody, resp.Header, nil
+}
+
+// apiGetAll fetches every page of a paginated list, following the Link headers Mastodon sends, and returns the items as one JSON array.
+func apiGetAll(config *MastodonConfig, path string) ([]byte, error) {
+  items := []json.RawMessage{}
+  for path != "" {
+    body, header, err := apiResponse(config, "GET", path, nil)
+    if err != nil {
+      return nil, err
+    }
+    var page []json.RawMessage
+    if err := json.Unmarshal(body, &page); err != nil {
+      return nil, fmt.Errorf("error parsing %s: %w", path, err)
+    }
+    items = append(items, page...)
+    path = nextPage(header.Get("Link"))
+  }
+  return json.Marshal(items)
+}
+
+// nextPage returns the path of the next page from a Link header, or "" if this is the last page.
+func nextPage(link string) string {
+  for _, part := range strings.Split(link, ",") {
+    sections := strings.Split(part, ";")
+    for _, param := range sections[1:] {
+      if strings.TrimSpace(param) != `rel="next"` {
+        continue
+      }
+      next, err := url.Parse(strings.Trim(strings.TrimSpace(sections[0]), "<>"))
+      if err != nil {
+        return ""
+      }
+      return next.RequestURI()
+    }
+  }
+  return ""
+}
+
+// parseFilterList parses the filters from a list file, or a legacy file containing either a single filter or an array of filters.
+func parseFilterList(data []byte) ([]*Filter, error) {
+  data = bytes.TrimSpace(data)
+
+  var filters []*Filter
+  if listFileVersion(data) > 1 {
+    list, err := parseListFile(data, "filters")
+    if err != nil {
+      return nil, err
+    }
+    filters = list.Filters
+  } else if len(data) > 0 && data[0] == '[' {
+    if err := json.Unmarshal(data, &filters); err != nil {
+      return nil, fmt.Errorf("error parsing filters: %w", err)
+    }
+  } else {
+    var filter Filter
+    if err := json.Unmarshal(data, &filter); err != nil {
+      return nil, fmt.Errorf("error parsing filter: %w", err)
+    }
+    filters = append(filters, &filter)
+  }
+
+  // Check the filters can be synced.
+  for _, filter := range filters {
+    if filter.Title == "" {
+      return nil, fmt.Errorf("filter is missing a title")
+    }
+    if filter.Schedule != nil {
+      if err := filter.Schedule.validate(); err != nil {
+        return nil, fmt.Errorf("filter %q: %w", filter.Title, err)
+      }
+    }
+  }
+
+  return filters, nil
+}
+
+// loadListFilters reads the filters to sync from the configured import directory and URL.
+func loadListFilters(config *MastodonConfig) ([]*Filter, error) {
+  if config.FilterImport == "" && config.FilterURL == "" {
+    return nil, fmt.Errorf("missing filters_import or filters_import_url in configuration")
+  }
+
+  var filters []*Filter
+
+  // Read the JSON, YAML and TOML files in the import directory.
+  if config.FilterImport != "" {
+    files, err := ioutil.ReadDir(config.FilterImport)
+    if err != nil {
+      return nil, fmt.Errorf("error reading import directory: %w", err)
+    }
+
+    for _, file := range files {
+      if !isDocumentFile(file.Name()) {
+        continue
+      }
+
+      contents, err := readDocument(filepath.Join(config.FilterImport, file.Name()))
+      if err != nil {
+        return nil, fmt.Errorf("error reading file %s: %w", file.Name(), err)
+      }
+
+      fileFilters, err := parseFilterList(contents)
+      if err != nil {
+        return nil, fmt.Errorf("error reading file %s: %w", file.Name(), err)
+      }
+      filters = append(filters, fileFilters...)
+    }
+  }
+
+  // Download the subscribed list.
+  if config.FilterURL != "" {
+    resp, err := downloadCached(context.Background(), config.FilterDownload, config.FilterURL, config.FilterSHA256, config.TrustedKeys)
+    if err != nil {
+      return nil, fmt.Errorf("error downloading filters: %w", err)
+    }
+
+    body, err := decodeDocument(documentFormat(config.FilterURL), resp.Body)
+    if err != nil {
+      return nil, fmt.Errorf("error reading %s: %w", config.FilterURL, err)
+    }
+    urlFilters, err := parseFilterList(body)
+    if err != nil {
+      return nil, fmt.Errorf("error reading %s: %w", config.FilterURL, err)
+    }
+    filters = append(filters, urlFilters...)
+  }
+
+  return filters, nil
+}
+
+// fetchFilters downloads the user's current filters and parses them.
+func fetchFilters(config *MastodonConfig) ([]*Filter, error) {
+  body, err := downloadFilters(config)
+  if err != nil {
+    return nil, err
+  }
+
+  var filters []*Filter
+  if err := json.Unmarshal([]byte(body), &filters); err != nil {
+    return nil, fmt.Errorf("error parsing filters: %w", err)
+  }
+  return filters, nil
+}
+
+// planFilterSync computes the changes needed to bring the current filters in line with the list filters at the given time.
+// Filters on the account that aren't in any list are left alone.
+func planFilterSync(current, desired []*Filter, now time.Time) ([]filterChange, error) {
+  // Index the current filters by title.
+  existing := make(map[string]*Filter)
+  for _, filter := range current {
+    existing[strings.ToLower(filter.Title)] = filter
+  }
+
+  var changes []filterChange
+  for _, filter := range desired {
+    found := existing[strings.ToLower(filter.Title)]
+
+    // Work out whether the filter should be switched on right now.
+    active := true
+    var expiresIn time.Duration
+    if filter.Schedule != nil {
+      var err error
+      active, err = filter.Schedule.activeAt(now)
+      if err != nil {
+        return nil, fmt.Errorf("filter %q: %w", filter.Title, err)
+      }
+      if active {
+        until, err := filter.Schedule.activeUntil(now)
+        if err != nil {
+          return nil, fmt.Errorf("filter %q: %w", filter.Title, err)
+        }
+        expiresIn = until.Sub(now)
+      }
+    }
+
+    if !active {
+      if found != nil {
+        changes = append(changes, filterChange{Action: "delete", Title: found.Title, Reason: "outside its scheduled windows", Existing: found})
+      }
+      continue
+    }
+
+    // A relative expiry only applies from when the filter is first created.
+    if found == nil {
+      if filter.ExpiresIn != nil && (expiresIn == 0 || time.Duration(*filter.ExpiresIn) < expiresIn) {
+        expiresIn = time.Duration(*filter.ExpiresIn)
+      }
+      changes = append(changes, filterChange{Action: "create", Title: filter.Title, Reason: "not on the account", Filter: filter, ExpiresIn: expiresIn, AddKeywords: filter.Keywords})
+      continue
+    }
+
+    change := filterChange{Action: "update", Title: found.Title, Filter: filter, Existing: found}
+    var reasons []string
+
+    // Compare the filter settings.
+    if filter.FilterAction != "" && filter.FilterAction != found.FilterAction {
+      reasons = append(reasons, fmt.Sprintf("filter_action %s -> %s", found.FilterAction, filter.FilterAction))
+    }
+    if len(filter.Context) > 0 && !sameStrings(filter.Context, found.Context) {
+      reasons = append(reasons, fmt.Sprintf("context %v -> %v", found.Context, filter.Context))
+    }
+
+    // Scheduled filters are kept expiring at the end of the current window, so they switch off even if sync doesn't run.
+    if expiresIn > 0 {
+      wanted := now.Add(expiresIn)
+      if found.ExpiresAt == nil {
+        reasons = append(reasons, "expiry set to "+wanted.Format(time.RFC3339))
+        change.ExpiresIn = expiresIn
+      } else if expiresAt, err := time.Parse(time.RFC3339, *found.ExpiresAt); err != nil || absDuration(expiresAt.Sub(wanted)) > time.Hour {
+        reasons = append(reasons, "expiry moved to "+wanted.Format(time.RFC3339))
+        change.ExpiresIn = expiresIn
+      }
+    }
+
+    // Compare the keywords.
+    currentKeywords := make(map[string]FilterKeyword)
+    for _, keyword := range found.Keywords {
+      currentKeywords[strings.ToLower(keyword.Keyword)] = keyword
+    }
+    wantedKeywords := make(map[string]bool)
+    for _, keyword := range filter.Keywords {
+      key := strings.ToLower(keyword.Keyword)
+      wantedKeywords[key] = true
+      if currentKeyword, ok := currentKeywords[key]; !ok {
+        change.AddKeywords = append(change.AddKeywords, keyword)
+      } else if currentKeyword.WholeWord != keyword.WholeWord {
+        currentKeyword.WholeWord = keyword.WholeWord
+        change.UpdateKeywords = append(change.UpdateKeywords, currentKeyword)
+      }
+    }
+    for _, keyword := range found.Keywords {
+      if !wantedKeywords[strings.ToLower(keyword.Keyword)] {
+        change.RemoveKeywords = append(change.RemoveKeywords, keyword)
+      }
+    }
+    if n := len(change.AddKeywords); n > 0 {
+      reasons = append(reasons, fmt.Sprintf("+%d keywords", n))
+    }
+    if n := len(change.RemoveKeywords); n > 0 {
+      reasons = append(reasons, fmt.Sprintf("-%d keywords", n))
+    }
+    if n := len(change.UpdateKeywords); n > 0 {
+      reasons = append(reasons, fmt.Sprintf("~%d keywords", n))
+    }
+
+    if len(reasons) > 0 {
+      change.Reason = strings.Join(reasons, ", ")
+      changes = append(changes, change)
+    }
+  }
+
+  return changes, nil
+}
+
+// applyFilterChange sends the API requests for a single planned change.
+func applyFilterChange(config *MastodonConfig, change filterChange) error {
+  switch change.Action {
+  case "create":
+    body, err := apiRequest(config, "POST", "/api/v2/filters", filterPayload(change.Filter, change.ExpiresIn, change.AddKeywords, nil, nil))
+    if err != nil || len(change.AddStatuses) == 0 {
+      return err
+    }
+    var created Filter
+    if err := json.Unmarshal(body, &created); err != nil {
+      return fmt.Errorf("error parsing created filter: %w", err)
+    }
+    return applyFilterStatuses(config, created.ID, change)
+
+  case "update":
+    if _, err := apiRequest(config, "PUT", "/api/v2/filters/"+change.Existing.ID, filterPayload(change.Filter, change.ExpiresIn, change.AddKeywords, change.UpdateKeywords, change.RemoveKeywords)); err != nil {
+      return err
+    }
+    return applyFilterStatuses(config, change.Existing.ID, change)
+
+  case "delete":
+    _, err := apiRequest(config, "DELETE", "/api/v2/filters/"+change.Existing.ID, nil)
+    return err
+  }
+
+  return fmt.Errorf("unknown filter change %q", change.Action)
+}
+
+// applyFilterStatuses adds and removes the posts a filter applies to, which are only changed by a rollback.
+func applyFilterStatuses(config *MastodonConfig, filterID string, change filterChange) error {
+  for _, status := range change.AddStatuses {
+    if _, err := apiRequest(config, "POST", "/api/v2/filters/"+filterID+"/statuses", map[string]interface{}{"status_id": status.StatusID}); err != nil {
+      return fmt.Errorf("error adding post %s: %w", status.StatusID, err)
+    }
+  }
+  for _, status := range change.RemoveStatuses {
+    if _, err := apiRequest(config, "DELETE", "/api/v2/filters/statuses/"+status.ID, nil); err != nil {
+      return fmt.Errorf("error removing post %s: %w", status.StatusID, err)
+    }
+  }
+  return nil
+}
+
+// planFilterStatuses adds the changes that make the posts each filter applies to match the wanted filters.
+// Sync leaves posts alone as list files don't carry them, so this is only used to roll back to a snapshot.
+func planFilterStatuses(current, desired []*Filter, changes []filterChange) []filterChange {
+  existing := make(map[string]*Filter)
+  for _, filter := range current {
+    existing[strings.ToLower(filter.Title)] = filter
+  }
+
+  for _, filter := range desired {
+    found := existing[strings.ToLower(filter.Title)]
+    have := make(map[string]bool)
+    want := make(map[string]bool)
+    var add, remove []FilterStatus
+    if found != nil {
+      for _, status := range found.Statuses {
+        have[status.StatusID] = true
+      }
+    }
+    for _, status := range filter.Statuses {
+      want[status.StatusID] = true
+      if !have[status.StatusID] {
+        add = append(add, status)
+      }
+    }
+    if found != nil {
+      for _, status := range found.Statuses {
+        if !want[status.StatusID] {
+          remove = append(remove, status)
+        }
+      }
+    }
+    if len(add) == 0 && len(remove) == 0 {
+      continue
+    }
+
+    // Add the posts to the filter's change, or to an update of their own.
+    i := -1
+    for j, change := range changes {
+      if strings.EqualFold(change.Title, filter.Title) && change.Action != "delete" {
+        i = j
+      }
+    }
+    if i < 0 {
+      if found == nil {
+        continue
+      }
+      changes = append(changes, filterChange{Action: "update", Title: found.Title, Filter: filter, Existing: found})
+      i = len(changes) - 1
+    }
+    changes[i].AddStatuses, changes[i].RemoveStatuses = add, remove
+
+    if changes[i].Action == "update" {
+      var reasons []string
+      if changes[i].Reason != "" {
+        reasons = append(reasons, changes[i].Reason)
+      }
+      if len(add) > 0 {
+        reasons = append(reasons, fmt.Sprintf("+%d posts", len(add)))
+      }
+      if len(remove) > 0 {
+        reasons = append(reasons, fmt.Sprintf("-%d posts", len(remove)))
+      }
+      changes[i].Reason = strings.Join(reasons, ", ")
+    }
+  }
+  return changes
+}
+
+// filterPayload builds the body of a create or update request for the v2 filters API.
+// The absolute expires_at from a list file is never sent, only a relative expires_in.
+func filterPayload(filter *Filter, expiresIn time.Duration, add, update, remove []FilterKeyword) map[string]interface{} {
+  payload := map[string]interface{}{
+    "title": filter.Title,
+  }
+  if len(filter.Context) > 0 {
+    payload["context"] = filter.Context
+  }
+  if filter.FilterAction != "" {
+    payload["filter_action"] = filter.FilterAction
+  }
+  if expiresIn > 0 {
+    payload["expires_in"] = int(expiresIn.Seconds())
+  }
+
+  // New keywords have no ID, changed keywords keep theirs, and removed keywords are flagged for destruction.
+  var attributes []map[string]interface{}
+  for _, keyword := range add {
+    attributes = append(attributes, map[string]interface{}{"keyword": keyword.Keyword, "whole_word": keyword.WholeWord})
+  }
+  for _, keyword := range update {
+    attributes = append(attributes, map[string]interface{}{"id": keyword.ID, "keyword": keyword.Keyword, "whole_word": keyword.WholeWord})
+  }
+  for _, keyword := range remove {
+    attributes = append(attributes, map[string]interface{}{"id": keyword.ID, "_destroy": true})
+  }
+  if len(attributes) > 0 {
+    payload["keywords_attributes"] = attributes
+  }
+
+  return payload
+}
+
+// syncFilters brings the filters on the account in line with the list filters, switching scheduled filters on and off.
+// When interactive is set the user is asked to confirm the changes first, otherwise they're printed once applied.
+func syncFilters(config *MastodonConfig, interactive bool) error {
+  // Read the filters to sync.
+  desired, err := loadListFilters(config)
+  if err != nil {
+    return err
+  }
+
+  changes, err := applyFilterSync(config, desired, interactive)
+  if !interactive {
+    for _, change := range changes {
+      fmt.Printf(" %s %s (%s)\n", change.Action, change.Title, change.Reason)
+    }
+  }
+  return err
+}
+
+// applyFilterSync plans and applies the changes for the given list filters, returning the changes that were applied.
+// When interactive is set the changes are printed and the user is asked to confirm them first.
+func applyFilterSync(config *MastodonConfig, desired []*Filter, interactive bool) ([]filterChange, error) {
+  // Download the user's current filters.
+  current, err := fetchFilters(config)
+  if err != nil {
+    return nil, fmt.Errorf("error downloading filters: %w", err)
+  }
+
+  // Work out what needs to change.
+  changes, err := planFilterSync(current, desired, time.Now())
+  if err != nil {
+    return nil, fmt.Errorf("error planning filter sync: %w", err)
+  }
+
+  if interactive {
+    if len(changes) == 0 {
+      fmt.Println("Filters are already in sync.")
+      return nil, nil
+    }
+
+    // Let the user pick the changes in the terminal UI, or else show them all and ask to confirm.
+    if useTUI() {
+      selected, err := reviewPlan(&bundlePlan{Filters: changes})
+      if err != nil {
+        return nil, err
+      }
+      if selected == nil {
+        return nil, nil
+      }
+      changes = selected.Filters
+    } else {
+      for _, change := range changes {
+        fmt.Printf(" %s %s (%s)\n", change.Action, change.Title, change.Reason)
+      }
+      if !confirmImport() {
+        reportFilterChanges(changes, false)
+        return nil, nil
+      }
+    }
+  }
+
+  // Keep a snapshot to roll back to before changing anything.
+  if len(changes) > 0 {
+    if _, err := takeSnapshot(config, "filter sync"); err != nil {
+      return nil, err
+    }
+  }
+
+  // Apply the changes.
+  for i, change := range changes {
+    if err := applyFilterChange(config, change); err != nil {
+      reportFilterChanges(changes[:i], true)
+      reportFilterChanges(changes[i:], false)
+      return changes[:i], fmt.Errorf("error applying %s of filter %q: %w", change.Action, change.Title, err)
+    }
+  }
+
+  reportFilterChanges(changes, true)
+  return changes, nil
+}
+
+// Tag is a Mastodon hashtag, as returned by the API and stored in list files.
+// In a list file, "following": false asks for the tag to be unfollowed.
+type Tag struct {
+  Name      string       `json:"name"`
+  URL       string       `json:"url,omitempty"`
+  Following *bool        `json:"following,omitempty"`
+  History   []TagHistory `json:"history,omitempty"`
+}
+
+// TagHistory is the use of a tag on one day, from the API's history of the last week.
+type TagHistory struct {
+  Day      string `json:"day"`
+  Uses     string `json:"uses"`
+  Accounts string `json:"accounts"`
+}
+
+// tagChange is a single follow or unfollow action computed by planTagSync.
+type tagChange struct {
+  Action string
+  Name   string
+}
+
+// parseTagList parses the tags from a list file, or a legacy file containing either a single tag or an array of tags.
+func parseTagList(data []byte) ([]*Tag, error) {
+  data = bytes.TrimSpace(data)
+
+  var tags []*Tag
+  if listFileVersion(data) > 1 {
+    list, err := parseListFile(data, "tags")
+    if err != nil {
+      return nil, err
+    }
+    tags = list.Tags
+  } else if len(data) > 0 && data[0] == '[' {
+    if err := json.Unmarshal(data, &tags); err != nil {
+      return nil, fmt.Errorf("error parsing tags: %w", err)
+    }
+  } else {
+    var tag Tag
+    if err := json.Unmarshal(data, &tag); err != nil {
+      return nil, fmt.Errorf("error parsing tag: %w", err)
+    }
+    tags = append(tags, &tag)
+  }
+
+  // Check that each tag has the correct schema.
+  for _, tag := range tags {
+    if tag.Name == "" {
+      return nil, fmt.Errorf("tag is missing a name")
+    }
+  }
+
+  return tags, nil
+}
+
+// fetchTags downloads the user's followed tags and parses them.
+func fetchTags(config *MastodonConfig) ([]*Tag, error) {
+  body, err := apiGetAll(config, "/api/v1/followed_tags")
+  if err != nil {
+    return nil, err
+  }
+
+  var tags []*Tag
+  if err := json.Unmarshal(body, &tags); err != nil {
+    return nil, fmt.Errorf("error parsing tags: %w", err)
+  }
+  return tags, nil
+}
+
+// planTagSync computes the follows and unfollows needed to bring the followed tags in line with the list tags.
+// Followed tags that aren't in any list are left alone.
+func planTagSync(current, desired []*Tag) []tagChange {
+  followed := make(map[string]bool)
+  for _, tag := range current {
+    followed[strings.ToLower(tag.Name)] = true
+  }
+
+  var changes []tagChange
+  for _, tag := range desired {
+    key := strings.ToLower(tag.Name)
+    wanted := tag.Following == nil || *tag.Following
+    if wanted && !followed[key] {
+      changes = append(changes, tagChange{Action: "follow", Name: tag.Name})
+      followed[key] = true
+    } else if !wanted && followed[key] {
+      changes = append(changes, tagChange{Action: "unfollow", Name: tag.Name})
+      followed[key] = false
+    }
+  }
+
+  return changes
+}
+
+// applyTagChange follows or unfollows a single tag.
+func applyTagChange(config *MastodonConfig, change tagChange) error {
+  _, err := apiRequest(config, "POST", "/api/v1/tags/"+url.PathEscape(change.Name)+"/"+change.Action, nil)
+  return err
+}
+
+// loadListTags reads the list tags from the tags_import directory and the tags_import_url subscription.
+func loadListTags(config *MastodonConfig) ([]*Tag, error) {
+  if config.TagsImport == "" && config.TagsURL == "" {
+    return nil, fmt.Errorf("missing tags_import or tags_import_url in configuration")
+  }
+
+  var tags []*Tag
+
+  // Read the JSON, YAML and TOML files in the import directory.
+  if config.TagsImport != "" {
+    files, err := ioutil.ReadDir(config.TagsImport)
+    if err != nil {
+      return nil, fmt.Errorf("error reading import directory: %w", err)
+    }
+
+    for _, file := range files {
+      if !isDocumentFile(file.Name()) {
+        continue
+      }
+
+      contents, err := readDocument(filepath.Join(config.TagsImport, file.Name()))
+      if err != nil {
+        return nil, fmt.Errorf("error reading file %s: %w", file.Name(), err)
+      }
+
+      fileTags, err := parseTagList(contents)
+      if err != nil {
+        return nil, fmt.Errorf("error reading file %s: %w", file.Name(), err)
+      }
+      tags = append(tags, fileTags...)
+    }
+  }
+
+  // Download the subscribed list.
+  if config.TagsURL != "" {
+    resp, err := downloadCached(context.Background(), config.TagsDownload, config.TagsURL, config.TagsSHA256, config.TrustedKeys)
+    if err != nil {
+      return nil, fmt.Errorf("error downloading tags: %w", err)
+    }
+
+    body, err := decodeDocument(documentFormat(config.TagsURL), resp.Body)
+    if err != nil {
+      return nil, fmt.Errorf("error reading %s: %w", config.TagsURL, err)
+    }
+    urlTags, err := parseTagList(body)
+    if err != nil {
+      return nil, fmt.Errorf("error reading %s: %w", config.TagsURL, err)
+    }
+    tags = append(tags, urlTags...)
+  }
+
+  return tags, nil
+}
+
+// syncTags follows and unfollows tags to match the list tags.
+// When interactive is set the user is asked to confirm the changes first, otherwise they're printed once applied.
+func syncTags(config *MastodonConfig, interactive bool) error {
+  desired, err := loadListTags(config)
+  if err != nil {
+    return err
+  }
+
+  changes, err := applyTagSync(config, desired, interactive)
+  if !interactive {
+    for _, change := range changes {
+      fmt.Printf(" %s #%s\n", change.Action, change.Name)
+    }
+  }
+  return err
+}
+
+// applyTagSync plans and applies the follows and unfollows for the given list tags, returning the changes that were applied.
+// When interactive is set the changes are printed and the user is asked to confirm them first.
+func applyTagSync(config *MastodonConfig, desired []*Tag, interactive bool) ([]tagChange, error) {
+  // Download the user's current tags.
+  current, err := fetchTags(config)
+  if err != nil {
+    return nil, fmt.Errorf("error downloading tags: %w", err)
+  }
+
+  changes := planTagSync(current, desired)
+  if interactive {
+    if len(changes) == 0 {
+      fmt.Println("Tags are already in sync.")
+      return nil, nil
+    }
+
+    // Let the user pick the changes in the terminal UI, or else show them all and ask to confirm.
+    if useTUI() {
+      selected, err := reviewPlan(&bundlePlan{Tags: changes})
+      if err != nil {
+        return nil, err
+      }
+      if selected == nil {
+        return nil, nil
+      }
+      changes = selected.Tags
+    } else {
+      for _, change := range changes {
+        fmt.Printf(" %s #%s\n", change.Action, change.Name)
+      }
+      if !confirmImport() {
+        reportTagChanges(changes, false)
+        return nil, nil
+      }
+    }
+  }
+
+  // Keep a snapshot to roll back to before changing anything.
+  if len(changes) > 0 {
+    if _, err := takeSnapshot(config, "tag sync"); err != nil {
+      return nil, err
+    }
+  }
+
+  // Apply the changes.
+  for i, change := range changes {
+    if err := applyTagChange(config, change); err != nil {
+      reportTagChanges(changes[:i], true)
+      reportTagChanges(changes[i:], false)
+      return changes[:i], fmt.Errorf("error applying %s of tag %q: %w", change.Action, change.Name, err)
+    }
+  }
+
+  reportTagChanges(changes, true)
+  return changes, nil
+}
+
+// sameStrings reports whether two string slices contain the same values, ignoring order.
+func sameStrings(a, b []string) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  seen := make(map[string]int)
+  for _, s := range a {
+    seen[s]++
+  }
+  for _, s := range b {
+    if seen[s] == 0 {
+      return false
+    }
+    seen[s]--
+  }
+  return true
+}
+
+// absDuration returns the absolute value of a duration.
+func absDuration(d time.Duration) time.Duration {
+  if d < 0 {
+    return -d
+  }
+  return d
+}
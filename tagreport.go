@@ -0,0 +1,154 @@
+package main
+
+// A report of how much the followed tags are used, from the daily history Mastodon keeps for each tag,
+// for finding tags that have gone quiet and are worth unfollowing.
+
+import (
+  "fmt"
+  "os"
+  "sort"
+  "strconv"
+  "strings"
+  "text/tabwriter"
+  "time"
+)
+
+// tagUsage is how much a followed tag was used over the days of the report.
+type tagUsage struct {
+  Name     string  `json:"name"`
+  Uses     int     `json:"uses"`
+  Accounts int     `json:"accounts"`
+  PerDay   float64 `json:"per_day"`
+  Days     int     `json:"days"`               // the days of history the numbers cover
+  LastUsed string  `json:"last_used,omitempty"` // the last day in the history with a post
+  Stale    bool    `json:"stale"`
+}
+
+// tagUsageOf adds up the tag's history over the last days, which Mastodon keeps for a week.
+// Accounts is the most on any one day, as the same account can post on several days.
+func tagUsageOf(tag *Tag, days int) tagUsage {
+  usage := tagUsage{Name: tag.Name}
+
+  // The history is newest first, but sort it to be sure.
+  history := append([]TagHistory(nil), tag.History...)
+  sort.Slice(history, func(i, j int) bool {
+    a, _ := strconv.ParseInt(history[i].Day, 10, 64)
+    b, _ := strconv.ParseInt(history[j].Day, 10, 64)
+    return a > b
+  })
+  if len(history) > days {
+    history = history[:days]
+  }
+
+  for _, day := range history {
+    uses, _ := strconv.Atoi(day.Uses)
+    accounts, _ := strconv.Atoi(day.Accounts)
+    usage.Uses += uses
+    if accounts > usage.Accounts {
+      usage.Accounts = accounts
+    }
+    if uses > 0 && usage.LastUsed == "" {
+      if unix, err := strconv.ParseInt(day.Day, 10, 64); err == nil {
+        usage.LastUsed = time.Unix(unix, 0).UTC().Format("2006-01-02")
+      }
+    }
+  }
+  usage.Days = len(history)
+  if usage.Days > 0 {
+    usage.PerDay = float64(usage.Uses) / float64(usage.Days)
+  }
+  usage.Stale = usage.Uses == 0
+  return usage
+}
+
+// tagReport returns the usage of the followed tags over the last days, most used first and stale tags last.
+func tagReport(config *MastodonConfig, days int) ([]tagUsage, error) {
+  tags, err := fetchTags(config)
+  if err != nil {
+    return nil, fmt.Errorf("error downloading tags: %w", err)
+  }
+
+  var report []tagUsage
+  for _, tag := range tags {
+    report = append(report, tagUsageOf(tag, days))
+  }
+  sort.SliceStable(report, func(i, j int) bool {
+    if report[i].Uses != report[j].Uses {
+      return report[i].Uses > report[j].Uses
+    }
+    if report[i].Accounts != report[j].Accounts {
+      return report[i].Accounts > report[j].Accounts
+    }
+    return report[i].Name < report[j].Name
+  })
+  return report, nil
+}
+
+// printTagReport prints the report as a table, marking the stale tags.
+func printTagReport(report []tagUsage, days int) {
+  w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(w, "TAG\tUSES\tACCOUNTS\tPER DAY\tLAST USED\t")
+  stale := 0
+  for _, usage := range report {
+    lastUsed := usage.LastUsed
+    if usage.Stale {
+      lastUsed = fmt.Sprintf("not in %d days, unfollow?", days)
+      stale++
+    }
+    fmt.Fprintf(w, "#%s\t%d\t%d\t%.1f\t%s\t\n", usage.Name, usage.Uses, usage.Accounts, usage.PerDay, lastUsed)
+  }
+  w.Flush()
+  fmt.Printf("%d followed tags, %d with no posts in the last %d days.\n", len(report), stale, days)
+}
+
+// tagReportCommand prints the report of the followed tags, and with unfollow, offers to unfollow the stale ones.
+func tagReportCommand(config *MastodonConfig, days int, unfollow, yes bool) error {
+  if days < 1 {
+    return fmt.Errorf("-days must be at least 1")
+  }
+  if days > 7 {
+    fmt.Fprintf(os.Stderr, "Mastodon only keeps a week of tag history, so the report covers the last 7 days, not %d.\n", days)
+    days = 7
+  }
+
+  report, err := tagReport(config, days)
+  if err != nil {
+    return err
+  }
+  if jsonResult != nil {
+    jsonResult.TagReport = report
+  } else {
+    printTagReport(report, days)
+  }
+  if !unfollow {
+    return nil
+  }
+
+  // Unfollow the stale tags, after confirmation unless -yes is given.
+  var desired []*Tag
+  for _, usage := range report {
+    if usage.Stale {
+      following := false
+      desired = append(desired, &Tag{Name: usage.Name, Following: &following})
+    }
+  }
+  if len(desired) == 0 {
+    fmt.Println("No stale tags to unfollow.")
+    return nil
+  }
+  if !yes {
+    fmt.Println("\nUnfollow the stale tags:")
+  }
+  changes, err := applyTagSync(config, desired, !yes)
+  if err != nil {
+    return err
+  }
+  if len(changes) > 0 {
+    var names []string
+    for _, change := range changes {
+      names = append(names, "#"+change.Name)
+    }
+    fmt.Printf("Unfollowed %s.\n", strings.Join(names, " "))
+  }
+  return nil
+}
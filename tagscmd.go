@@ -0,0 +1,271 @@
+package main
+
+// The tags command, for following and unfollowing many tags at once, from arguments, a text file or the hashtags used in posts,
+// for discovering new tags to follow and for reporting on how much the followed ones are used.
+
+import (
+  "bufio"
+  "flag"
+  "fmt"
+  "io"
+  "net/url"
+  "os"
+  "strings"
+  "unicode"
+
+  "golang.org/x/text/cases"
+  "golang.org/x/text/unicode/norm"
+)
+
+// normalizeTag returns a tag name as Mastodon matches it, without the # and case folded in Unicode NFC,
+// or "" if it can't be a hashtag. Like Mastodon, a tag is letters, numbers, marks, _ and ·, and isn't all numbers.
+func normalizeTag(name string) string {
+  name = norm.NFC.String(cases.Fold().String(strings.TrimLeft(strings.TrimSpace(name), "#")))
+  if name == "" {
+    return ""
+  }
+  digits := true
+  for _, r := range name {
+    if !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r) && r != '_' && r != '·' {
+      return ""
+    }
+    if !unicode.IsDigit(r) {
+      digits = false
+    }
+  }
+  if digits {
+    return ""
+  }
+  return name
+}
+
+// readTagText reads the tags in a text file, separated by spaces, commas or lines, with or without a #.
+// Lines starting with "# " or "//" are comments.
+func readTagText(r io.Reader) ([]string, error) {
+  var tags []string
+  scanner := bufio.NewScanner(r)
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+    if line == "#" || strings.HasPrefix(line, "# ") || strings.HasPrefix(line, "//") {
+      continue
+    }
+    tags = append(tags, strings.FieldsFunc(line, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })...)
+  }
+  return tags, scanner.Err()
+}
+
+// apiStatus is the part of a status the tags command uses.
+type apiStatus struct {
+  URL  string `json:"url"`
+  Tags []struct {
+    Name string `json:"name"`
+  } `json:"tags"`
+}
+
+// statusTags returns the names of the hashtags in the statuses.
+func statusTags(statuses []apiStatus) []string {
+  var tags []string
+  for _, status := range statuses {
+    for _, tag := range status.Tags {
+      tags = append(tags, tag.Name)
+    }
+  }
+  return tags
+}
+
+// tagsFromStatus returns the hashtags in the status at the URL, which is fetched to the user's instance if it's from elsewhere.
+func tagsFromStatus(config *MastodonConfig, statusURL string) ([]string, error) {
+  var results struct {
+    Statuses []apiStatus `json:"statuses"`
+  }
+  query := url.Values{"q": {statusURL}, "type": {"statuses"}, "resolve": {"true"}, "limit": {"1"}}
+  if err := fetchJSON(config, "/api/v2/search?"+query.Encode(), &results); err != nil {
+    return nil, fmt.Errorf("error finding status %s: %w", statusURL, err)
+  }
+  if len(results.Statuses) == 0 {
+    return nil, fmt.Errorf("status %s not found", statusURL)
+  }
+  return statusTags(results.Statuses), nil
+}
+
+// tagsFromAccount returns the hashtags in an account's recent posts, leaving out boosts.
+func tagsFromAccount(config *MastodonConfig, acct string, limit int) ([]string, error) {
+  accountID, err := resolveAccount(config, strings.TrimPrefix(acct, "@"))
+  if err != nil {
+    return nil, err
+  }
+  var statuses []apiStatus
+  query := url.Values{"limit": {fmt.Sprint(limit)}, "exclude_reblogs": {"true"}}
+  if err := fetchJSON(config, "/api/v1/accounts/"+accountID+"/statuses?"+query.Encode(), &statuses); err != nil {
+    return nil, fmt.Errorf("error reading posts by %s: %w", acct, err)
+  }
+  return statusTags(statuses), nil
+}
+
+// collectTags gathers the tags from the arguments, which are tags, status URLs or @accounts, and the text file if any.
+// It returns the normalised tags in the order first seen, and the names that aren't valid tags.
+func collectTags(config *MastodonConfig, args []string, file string, limit int) ([]string, []string, error) {
+  var names []string
+  for _, arg := range args {
+    switch {
+    case isURL(arg):
+      tags, err := tagsFromStatus(config, arg)
+      if err != nil {
+        return nil, nil, err
+      }
+      names = append(names, tags...)
+    case strings.HasPrefix(arg, "@"):
+      tags, err := tagsFromAccount(config, arg, limit)
+      if err != nil {
+        return nil, nil, err
+      }
+      names = append(names, tags...)
+    default:
+      names = append(names, arg)
+    }
+  }
+
+  if file != "" {
+    var r io.Reader = os.Stdin
+    if file != "-" {
+      f, err := os.Open(file)
+      if err != nil {
+        return nil, nil, fmt.Errorf("error reading tags: %w", err)
+      }
+      defer f.Close()
+      r = f
+    }
+    tags, err := readTagText(r)
+    if err != nil {
+      return nil, nil, fmt.Errorf("error reading %s: %w", file, err)
+    }
+    names = append(names, tags...)
+  }
+
+  var tags, invalid []string
+  seen := make(map[string]bool)
+  for _, name := range names {
+    tag := normalizeTag(name)
+    if tag == "" {
+      invalid = append(invalid, name)
+      continue
+    }
+    if !seen[tag] {
+      seen[tag] = true
+      tags = append(tags, tag)
+    }
+  }
+  return tags, invalid, nil
+}
+
+// followTags follows or unfollows the tags, skipping those already followed or not followed, and prints a summary.
+// A tag that fails doesn't stop the rest.
+func followTags(config *MastodonConfig, action string, tags []string, dryRun bool) error {
+  current, err := fetchTags(config)
+  if err != nil {
+    return fmt.Errorf("error downloading tags: %w", err)
+  }
+  for _, tag := range current {
+    tag.Name = normalizeTag(tag.Name)
+  }
+  following := action == "follow"
+  var desired []*Tag
+  for _, name := range tags {
+    desired = append(desired, &Tag{Name: name, Following: &following})
+  }
+  changes := planTagSync(current, desired)
+  unchanged := fmt.Sprintf("%d already followed", len(tags)-len(changes))
+  if !following {
+    unchanged = fmt.Sprintf("%d not followed", len(tags)-len(changes))
+  }
+
+  for _, change := range changes {
+    fmt.Printf(" %s #%s\n", change.Action, change.Name)
+  }
+  if dryRun || len(changes) == 0 {
+    reportTagChanges(changes, false)
+    fmt.Printf("%d tags to %s, %s.\n", len(changes), action, unchanged)
+    return nil
+  }
+
+  if _, err := takeSnapshot(config, "tags "+action); err != nil {
+    return err
+  }
+  var done, failed []tagChange
+  for _, change := range changes {
+    if err := applyTagChange(config, change); err != nil {
+      fmt.Printf("error: %s #%s: %s\n", change.Action, change.Name, err)
+      failed = append(failed, change)
+      continue
+    }
+    done = append(done, change)
+  }
+  reportTagChanges(done, true)
+  reportTagChanges(failed, false)
+
+  fmt.Printf("%sed %d tags, %s.\n", strings.ToUpper(action[:1])+action[1:], len(done), unchanged)
+  if len(failed) > 0 {
+    return fmt.Errorf("failed to %s %d tags", action, len(failed))
+  }
+  return nil
+}
+
+// tagsCommand defines the tags command's flags and returns the function that runs it.
+func tagsCommand(flags *flag.FlagSet) func(args []string) error {
+  file := flags.String("file", "", "also read tags from this text file, or - for stdin, separated by spaces, commas or lines")
+  limit := flags.Int("limit", 40, "the number of recent posts to read the hashtags of, for @accounts and discover")
+  dryRun := flags.Bool("dry-run", false, "only show what would change")
+  list := flags.String("list", "", "the tag list file discover adds to (default discovered.json in tags_import)")
+  top := flags.Int("top", 20, "the number of tags discover suggests")
+  accounts := flags.Int("accounts", 20, "the number of followed accounts, and of followed tags, discover reads recent posts from")
+  days := flags.Int("days", 7, "the number of days report counts posts over, at most the 7 Mastodon keeps")
+  unfollow := flags.Bool("unfollow", false, "offer to unfollow the tags report finds with no posts")
+  yes := flags.Bool("yes", false, "add every tag discover suggests, or unfollow every stale tag report finds, without asking")
+  return func(args []string) error {
+    if len(args) == 0 {
+      flags.Usage()
+      return fmt.Errorf("missing tags command")
+    }
+
+    switch args[0] {
+    case "follow", "unfollow":
+      if len(args) == 1 && *file == "" {
+        flags.Usage()
+        return fmt.Errorf("missing tags to %s", args[0])
+      }
+      config, err := loadAccount()
+      if err != nil {
+        return err
+      }
+      tags, invalid, err := collectTags(config, args[1:], *file, *limit)
+      if err != nil {
+        return err
+      }
+      if len(invalid) > 0 {
+        fmt.Printf("Ignoring %d names that aren't valid tags: %s\n", len(invalid), strings.Join(invalid, ", "))
+      }
+      if len(tags) == 0 {
+        return fmt.Errorf("no tags to %s", args[0])
+      }
+      return followTags(config, args[0], tags, *dryRun)
+
+    case "discover":
+      config, err := loadAccount()
+      if err != nil {
+        return err
+      }
+      return discoverCommand(config, *list, *top, discoverOptions{Accounts: *accounts, Posts: *limit}, *yes)
+
+    case "report":
+      config, err := loadAccount()
+      if err != nil {
+        return err
+      }
+      return tagReportCommand(config, *days, *unfollow, *yes)
+
+    default:
+      flags.Usage()
+      return fmt.Errorf("unknown tags command %q", args[0])
+    }
+  }
+}
@@ -0,0 +1,110 @@
+package main
+
+// Reading the access token from the environment, a file or a command, so it doesn't have to be kept in the config file.
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "runtime"
+  "strings"
+)
+
+// tokenEnvVar is the environment variable that overrides every other source of the access token.
+const tokenEnvVar = "SUBSCRIBE_O_MAST_TOKEN"
+
+// tokenPrecedence lists the sources of the access token, highest precedence first.
+var tokenPrecedence = []string{tokenEnvVar, "access_token_cmd", "access_token_file", "token_store", "access_token"}
+
+// placeholderToken is the access_token in the config file written on first run, which means no token is set.
+const placeholderToken = "REPLACEME"
+
+// usesTokenStore reports whether the token store should be read: when it's configured, a profile is chosen,
+// or there's no access_token to fall back on.
+func (config *MastodonConfig) usesTokenStore() bool {
+  return config.TokenStore != "" || config.Profile != "" || *profileName != "" || config.AccessToken == "" || config.AccessToken == placeholderToken
+}
+
+// resolveAccessToken sets the access token from the first source that is set, recording which one it came from.
+func (config *MastodonConfig) resolveAccessToken() error {
+  if config.AccessToken == placeholderToken {
+    config.AccessToken = ""
+  }
+  if token := strings.TrimSpace(os.Getenv(tokenEnvVar)); token != "" {
+    config.AccessToken = token
+    config.tokenSource = tokenEnvVar
+    return nil
+  }
+
+  if config.AccessTokenCmd != "" {
+    token, err := runTokenCommand(config.AccessTokenCmd)
+    if err != nil {
+      return err
+    }
+    config.AccessToken = token
+    config.tokenSource = "access_token_cmd"
+    return nil
+  }
+
+  if config.AccessTokenFile != "" {
+    data, err := ioutil.ReadFile(config.AccessTokenFile)
+    if err != nil {
+      return fmt.Errorf("error reading access_token_file: %w", err)
+    }
+    token := strings.TrimSpace(string(data))
+    if token == "" {
+      return fmt.Errorf("access_token_file %s is empty", config.AccessTokenFile)
+    }
+    config.AccessToken = token
+    config.tokenSource = "access_token_file"
+    return nil
+  }
+
+  // The encrypted token store written by login, only read when usesTokenStore says so,
+  // so a plain access_token never waits on a passphrase.
+  if config.usesTokenStore() {
+    stored, err := config.loadStoredToken()
+    switch {
+    case errors.Is(err, errNoPassphrase) && config.AccessToken != "":
+      fmt.Fprintf(os.Stderr, "note: %s, using access_token instead\n", err)
+    case err != nil:
+      return err
+    case stored:
+      config.tokenSource = "token_store"
+      return nil
+    }
+  }
+
+  if config.AccessToken != "" {
+    config.tokenSource = "access_token"
+  }
+  return nil
+}
+
+// runTokenCommand runs access_token_cmd with the shell and returns the first line of its output.
+func runTokenCommand(command string) (string, error) {
+  var cmd *exec.Cmd
+  if runtime.GOOS == "windows" {
+    cmd = exec.Command("cmd", "/C", command)
+  } else {
+    cmd = exec.Command("sh", "-c", command)
+  }
+  // Let password managers prompt for a passphrase.
+  cmd.Stdin = os.Stdin
+  cmd.Stderr = os.Stderr
+
+  out, err := cmd.Output()
+  if err != nil {
+    return "", fmt.Errorf("error running access_token_cmd: %w", err)
+  }
+
+  // Tools like pass print the secret on the first line, followed by other fields.
+  token := strings.TrimSpace(string(bytes.SplitN(out, []byte("\n"), 2)[0]))
+  if token == "" {
+    return "", fmt.Errorf("access_token_cmd printed no token")
+  }
+  return token, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+  "bufio"
+  "errors"
+  "flag"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestResolveAccessToken(t *testing.T) {
+  dir := t.TempDir()
+  t.Setenv("XDG_CONFIG_HOME", dir)
+  t.Setenv(tokenEnvVar, "")
+  t.Setenv(passphraseEnvVar, "")
+
+  tokenFile := filepath.Join(dir, "token")
+  if err := ioutil.WriteFile(tokenFile, []byte("from-file\n"), 0600); err != nil {
+    t.Fatal(err)
+  }
+  emptyFile := filepath.Join(dir, "empty")
+  if err := ioutil.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+    t.Fatal(err)
+  }
+  store := filepath.Join(dir, "tokens.age")
+  if err := writeTokenStore(store, "secret", map[string]storedToken{defaultProfile: {InstanceURL: "https://stored.example", AccessToken: "from-store"}}); err != nil {
+    t.Fatal(err)
+  }
+  // The store login writes when token_store isn't set.
+  if err := writeTokenStore(filepath.Join(dir, "subscribe-o-mast", "tokens.age"), "secret", map[string]storedToken{
+    defaultProfile: {InstanceURL: "https://stored.example", AccessToken: "from-default-store"},
+    "work":         {InstanceURL: "https://work.example", AccessToken: "from-work"},
+  }); err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    name       string
+    env        string
+    passphrase string
+    config     MastodonConfig
+    token      string
+    source     string
+    err        string
+  }{
+    {"environment first", "from-env", "", MastodonConfig{AccessTokenCmd: "echo from-cmd", AccessToken: "plain"}, "from-env", tokenEnvVar, ""},
+    {"command", "", "", MastodonConfig{AccessTokenCmd: "echo from-cmd; echo other", AccessTokenFile: tokenFile}, "from-cmd", "access_token_cmd", ""},
+    {"file", "", "", MastodonConfig{AccessTokenFile: tokenFile, AccessToken: "plain"}, "from-file", "access_token_file", ""},
+    {"failing command", "", "", MastodonConfig{AccessTokenCmd: "exit 1"}, "", "", "error running access_token_cmd"},
+    {"command without output", "", "", MastodonConfig{AccessTokenCmd: "true"}, "", "", "printed no token"},
+    {"missing file", "", "", MastodonConfig{AccessTokenFile: filepath.Join(dir, "missing")}, "", "", "error reading access_token_file"},
+    {"empty file", "", "", MastodonConfig{AccessTokenFile: emptyFile}, "", "", "is empty"},
+    {"token store", "", "secret", MastodonConfig{TokenStore: store, AccessToken: "plain"}, "from-store", "token_store", ""},
+    {"store without a passphrase", "", "", MastodonConfig{TokenStore: store, AccessToken: "plain"}, "plain", "access_token", ""},
+    {"store with the wrong passphrase", "", "wrong", MastodonConfig{TokenStore: store, AccessToken: "plain"}, "", "", "check the passphrase"},
+    {"store without a passphrase or access_token", "", "", MastodonConfig{TokenStore: store}, "", "", "SUBSCRIBE_O_MAST_PASSPHRASE"},
+    {"placeholder access_token", "", "secret", MastodonConfig{AccessToken: placeholderToken}, "from-default-store", "token_store", ""},
+    {"placeholder access_token without a store", "", "", MastodonConfig{AccessToken: placeholderToken, TokenStore: filepath.Join(dir, "missing.age")}, "", "", ""},
+    {"profile in the config", "", "secret", MastodonConfig{Profile: "work", AccessToken: "plain"}, "from-work", "token_store", ""},
+    {"access_token without token_store or a profile", "", "secret", MastodonConfig{AccessToken: "plain"}, "plain", "access_token", ""},
+    {"nothing set", "", "", MastodonConfig{TokenStore: filepath.Join(dir, "missing.age")}, "", "", ""},
+  }
+  for _, test := range tests {
+    t.Setenv(tokenEnvVar, test.env)
+    t.Setenv(passphraseEnvVar, test.passphrase)
+    config := test.config
+    err := config.resolveAccessToken()
+    if test.err != "" {
+      if err == nil || !strings.Contains(err.Error(), test.err) {
+        t.Errorf("%s: error %v, want %q", test.name, err, test.err)
+      }
+      continue
+    }
+    if err != nil || config.AccessToken != test.token || config.tokenSource != test.source {
+      t.Errorf("%s: token %q from %q, %v, want %q from %q", test.name, config.AccessToken, config.tokenSource, err, test.token, test.source)
+    }
+  }
+}
+
+func TestLogin(t *testing.T) {
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("Authorization") != "Bearer saved-token" {
+      w.WriteHeader(http.StatusUnauthorized)
+      return
+    }
+    w.Write([]byte(`{"acct": "me"}`))
+  }))
+  defer server.Close()
+
+  dir := t.TempDir()
+  t.Setenv("XDG_CONFIG_HOME", dir)
+  t.Setenv(tokenEnvVar, "")
+  t.Setenv(passphraseEnvVar, "secret")
+  file := filepath.Join(dir, "config.json")
+  if err := ioutil.WriteFile(file, []byte(`{"instance_url": "`+server.URL+`", "access_token": "`+placeholderToken+`"}`), 0600); err != nil {
+    t.Fatal(err)
+  }
+  previous := *configFile
+  *configFile = file
+  t.Cleanup(func() { *configFile = previous })
+  input := secretInput
+  secretInput = bufio.NewReader(strings.NewReader("saved-token\n"))
+  t.Cleanup(func() { secretInput = input })
+
+  flags := flag.NewFlagSet("login", flag.ContinueOnError)
+  if err := loginCommand(flags)(nil); err != nil {
+    t.Fatal(err)
+  }
+
+  // The config now points at the store, and later commands use the saved token.
+  config, err := loadConfig(file)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if config.TokenStore != filepath.Join(dir, "subscribe-o-mast", "tokens.age") || config.AccessToken != "saved-token" || config.tokenSource != "token_store" {
+    t.Errorf("after login, token %q from %q with token_store %q", config.AccessToken, config.tokenSource, config.TokenStore)
+  }
+}
+
+func TestTokenStore(t *testing.T) {
+  file := filepath.Join(t.TempDir(), "tokens.age")
+  tokens := map[string]storedToken{"work": {InstanceURL: "https://work.example", AccessToken: "work-access-token", Account: "me"}}
+  if err := writeTokenStore(file, "secret", tokens); err != nil {
+    t.Fatal(err)
+  }
+  data, err := ioutil.ReadFile(file)
+  if err != nil || strings.Contains(string(data), "work-access-token") {
+    t.Fatalf("token store isn't encrypted: %v", err)
+  }
+
+  read, err := readTokenStore(file, "secret")
+  if err != nil || read["work"].AccessToken != "work-access-token" || read["work"].Account != "me" {
+    t.Errorf("read back %+v, %v", read, err)
+  }
+  if _, err := readTokenStore(file, "wrong"); err == nil {
+    t.Error("token store opened with the wrong passphrase")
+  }
+
+  t.Setenv(passphraseEnvVar, "")
+  if _, err := readPassphrase(file); !errors.Is(err, errNoPassphrase) {
+    t.Errorf("readPassphrase without a terminal = %v, want errNoPassphrase", err)
+  }
+}
@@ -0,0 +1,658 @@
+package main
+
+// The terminal UI: a menu of the common actions, and a review of planned changes where individual keywords,
+// follows and other changes can be switched on or off and the result previewed before applying.
+
+import (
+  "fmt"
+  "os"
+  "strings"
+
+  tea "github.com/charmbracelet/bubbletea"
+  "github.com/charmbracelet/lipgloss"
+  "golang.org/x/term"
+)
+
+var (
+  headerStyle = lipgloss.NewStyle().Bold(true)
+  cursorStyle = lipgloss.NewStyle().Reverse(true)
+  offStyle    = lipgloss.NewStyle().Faint(true).Strikethrough(true)
+  helpStyle   = lipgloss.NewStyle().Faint(true)
+  addStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+  removeStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+)
+
+// useTUI reports whether to use the terminal UI rather than plain prompts, which needs a terminal and isn't wanted with -plain or -output json.
+func useTUI() bool {
+  return !*plainPrompts && jsonResult == nil && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// menuChoices are the actions offered by the menu, numbered as in printMenu.
+var menuChoices = []string{"Export filters", "Export tags", "Import filters from file", "Import tags from file", "Sync filters", "Sync tags"}
+
+// menuModel is the menu of common actions.
+type menuModel struct {
+  cursor int
+  choice int
+}
+
+func (m *menuModel) Init() tea.Cmd { return nil }
+
+func (m *menuModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+  key, ok := msg.(tea.KeyMsg)
+  if !ok {
+    return m, nil
+  }
+  switch key.String() {
+  case "up", "k":
+    if m.cursor > 0 {
+      m.cursor--
+    }
+  case "down", "j":
+    if m.cursor < len(menuChoices)-1 {
+      m.cursor++
+    }
+  case "enter", " ":
+    m.choice = m.cursor + 1
+    return m, tea.Quit
+  case "q", "esc", "ctrl+c":
+    return m, tea.Quit
+  default:
+    // The numbers from the plain menu work too.
+    if s := key.String(); len(s) == 1 && s[0] >= '1' && int(s[0]-'0') <= len(menuChoices) {
+      m.choice = int(s[0] - '0')
+      return m, tea.Quit
+    }
+  }
+  return m, nil
+}
+
+func (m *menuModel) View() string {
+  var b strings.Builder
+  b.WriteString(headerStyle.Render("subscribe-o-mast") + "\n\n")
+  for i, choice := range menuChoices {
+    line := fmt.Sprintf(" %d. %s ", i+1, choice)
+    if i == m.cursor {
+      line = cursorStyle.Render(line)
+    }
+    b.WriteString(line + "\n")
+  }
+  b.WriteString("\n" + helpStyle.Render("↑/↓ move · enter choose · q quit") + "\n")
+  return b.String()
+}
+
+// chooseMenu shows the menu and returns the number of the chosen action, or 0 if the user quit.
+func chooseMenu() (int, error) {
+  m := &menuModel{}
+  if _, err := tea.NewProgram(m).Run(); err != nil {
+    return 0, fmt.Errorf("error running menu: %w", err)
+  }
+  return m.choice, nil
+}
+
+// reviewItem is a single change that can be switched on or off.
+type reviewItem struct {
+  label string
+  style lipgloss.Style
+  on    bool
+}
+
+// reviewGroup is the items of one filter, list or section of the plan, under a heading.
+type reviewGroup struct {
+  heading string
+  items   []*reviewItem
+}
+
+// reviewModel lets the user pick which of the planned changes to apply.
+type reviewModel struct {
+  plan    *bundlePlan
+  groups  []*reviewGroup
+  rows    []reviewRow
+  cursor  int
+  offset  int
+  height  int
+  preview bool
+  apply   bool
+
+  // Where each part of the plan is in groups, to turn the selection back into a plan.
+  filterGroups   []*reviewGroup
+  listGroups     []*reviewGroup
+  tagItems       []*reviewItem
+  featureItems   []*reviewItem
+  unfeatureItems []*reviewItem
+  blockItems     []*reviewItem
+  unblockItems   []*reviewItem
+}
+
+// reviewRow is a line of the review, either a group heading (item -1) or one of its items.
+type reviewRow struct {
+  group *reviewGroup
+  item  int
+}
+
+// newReviewModel lays out the plan as groups of items, all switched on.
+func newReviewModel(plan *bundlePlan) *reviewModel {
+  m := &reviewModel{plan: plan, height: 20}
+  add := func(heading string) *reviewGroup {
+    group := &reviewGroup{heading: heading}
+    m.groups = append(m.groups, group)
+    return group
+  }
+  item := func(group *reviewGroup, style lipgloss.Style, format string, args ...interface{}) *reviewItem {
+    it := &reviewItem{label: fmt.Sprintf(format, args...), style: style, on: true}
+    group.items = append(group.items, it)
+    return it
+  }
+
+  for _, change := range plan.Filters {
+    group := add(fmt.Sprintf("%s filter %s", change.Action, change.Title))
+    m.filterGroups = append(m.filterGroups, group)
+    switch change.Action {
+    case "delete":
+      item(group, removeStyle, "delete (%s)", change.Reason)
+      continue
+    case "update":
+      if settings := filterSettingsReason(change.Reason); settings != "" {
+        item(group, lipgloss.NewStyle(), "settings: %s", settings)
+      }
+    }
+    for _, keyword := range change.AddKeywords {
+      item(group, addStyle, "+ %s%s", keyword.Keyword, wholeWordLabel(keyword))
+    }
+    for _, keyword := range change.RemoveKeywords {
+      item(group, removeStyle, "- %s", keyword.Keyword)
+    }
+    for _, keyword := range change.UpdateKeywords {
+      item(group, lipgloss.NewStyle(), "~ %s%s", keyword.Keyword, wholeWordLabel(keyword))
+    }
+    if len(group.items) == 0 {
+      item(group, addStyle, "%s (%s)", change.Action, change.Reason)
+    }
+  }
+
+  if len(plan.Tags) > 0 {
+    group := add("followed tags")
+    for _, change := range plan.Tags {
+      style := addStyle
+      if change.Action == "unfollow" {
+        style = removeStyle
+      }
+      m.tagItems = append(m.tagItems, item(group, style, "%s #%s", change.Action, change.Name))
+    }
+  }
+
+  if len(plan.FeaturedTags) > 0 || len(plan.UnfeatureTags) > 0 {
+    group := add("featured tags")
+    for _, name := range plan.FeaturedTags {
+      m.featureItems = append(m.featureItems, item(group, addStyle, "feature #%s", name))
+    }
+    for _, tag := range plan.UnfeatureTags {
+      m.unfeatureItems = append(m.unfeatureItems, item(group, removeStyle, "unfeature #%s", tag.Name))
+    }
+  }
+
+  for _, change := range plan.Lists {
+    if change.Action == "delete" {
+      group := add("delete list " + change.Existing.Title)
+      item(group, removeStyle, "delete")
+      m.listGroups = append(m.listGroups, group)
+      continue
+    }
+    group := add(fmt.Sprintf("%s list %s", change.Action, change.List.Title))
+    for _, account := range change.AddAccounts {
+      item(group, addStyle, "+ %s", account)
+    }
+    for _, account := range change.RemoveAccounts {
+      item(group, removeStyle, "- %s", account)
+    }
+    if len(group.items) == 0 {
+      item(group, addStyle, "create")
+    }
+    m.listGroups = append(m.listGroups, group)
+  }
+
+  if len(plan.DomainBlocks) > 0 || len(plan.DomainUnblocks) > 0 {
+    group := add("domain blocks")
+    for _, domain := range plan.DomainBlocks {
+      m.blockItems = append(m.blockItems, item(group, removeStyle, "block %s", domain))
+    }
+    for _, domain := range plan.DomainUnblocks {
+      m.unblockItems = append(m.unblockItems, item(group, addStyle, "unblock %s", domain))
+    }
+  }
+
+  for _, group := range m.groups {
+    m.rows = append(m.rows, reviewRow{group: group, item: -1})
+    for i := range group.items {
+      m.rows = append(m.rows, reviewRow{group: group, item: i})
+    }
+  }
+  return m
+}
+
+// filterSettingsReason returns the parts of a filter change's reason that aren't about keywords.
+func filterSettingsReason(reason string) string {
+  var settings []string
+  for _, part := range strings.Split(reason, ", ") {
+    if part != "" && !strings.HasSuffix(part, " keywords") {
+      settings = append(settings, part)
+    }
+  }
+  return strings.Join(settings, ", ")
+}
+
+// wholeWordLabel describes a keyword's whole_word setting.
+func wholeWordLabel(keyword FilterKeyword) string {
+  if keyword.WholeWord {
+    return " (whole word)"
+  }
+  return ""
+}
+
+func (m *reviewModel) Init() tea.Cmd { return nil }
+
+func (m *reviewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+  switch msg := msg.(type) {
+  case tea.WindowSizeMsg:
+    m.height = msg.Height - 4
+
+  case tea.KeyMsg:
+    switch msg.String() {
+    case "up", "k":
+      if m.cursor > 0 {
+        m.cursor--
+      }
+    case "down", "j":
+      if m.cursor < len(m.rows)-1 {
+        m.cursor++
+      }
+    case " ", "x":
+      m.toggle(m.cursor)
+    case "a":
+      m.setAll(true)
+    case "n":
+      m.setAll(false)
+    case "p", "tab":
+      m.preview = !m.preview
+    case "enter":
+      m.apply = true
+      return m, tea.Quit
+    case "q", "esc", "ctrl+c":
+      return m, tea.Quit
+    }
+  }
+
+  // Keep the cursor on screen.
+  if m.cursor < m.offset {
+    m.offset = m.cursor
+  }
+  if m.height > 0 && m.cursor >= m.offset+m.height {
+    m.offset = m.cursor - m.height + 1
+  }
+  return m, nil
+}
+
+// toggle switches an item on or off, or all of a group's items if the row is its heading.
+func (m *reviewModel) toggle(row int) {
+  r := m.rows[row]
+  if r.item >= 0 {
+    r.group.items[r.item].on = !r.group.items[r.item].on
+    return
+  }
+  on := !r.group.allOn()
+  for _, it := range r.group.items {
+    it.on = on
+  }
+}
+
+// setAll switches every item on or off.
+func (m *reviewModel) setAll(on bool) {
+  for _, group := range m.groups {
+    for _, it := range group.items {
+      it.on = on
+    }
+  }
+}
+
+// allOn reports whether every item in the group is on.
+func (group *reviewGroup) allOn() bool {
+  for _, it := range group.items {
+    if !it.on {
+      return false
+    }
+  }
+  return true
+}
+
+// anyOn reports whether any item in the group is on.
+func (group *reviewGroup) anyOn() bool {
+  for _, it := range group.items {
+    if it.on {
+      return true
+    }
+  }
+  return false
+}
+
+func (m *reviewModel) View() string {
+  var b strings.Builder
+  if m.preview {
+    b.WriteString(headerStyle.Render("Preview of the account after applying") + "\n\n")
+    b.WriteString(previewPlan(m.selected()))
+    b.WriteString("\n" + helpStyle.Render("p back to the changes · enter apply · q cancel") + "\n")
+    return b.String()
+  }
+
+  b.WriteString(headerStyle.Render("Choose the changes to apply") + "\n\n")
+  end := len(m.rows)
+  if m.height > 0 && m.offset+m.height < end {
+    end = m.offset + m.height
+  }
+  for i := m.offset; i < end; i++ {
+    r := m.rows[i]
+    var line string
+    if r.item < 0 {
+      mark := "[ ]"
+      if r.group.allOn() {
+        mark = "[x]"
+      } else if r.group.anyOn() {
+        mark = "[-]"
+      }
+      line = mark + " " + headerStyle.Render(r.group.heading)
+    } else {
+      it := r.group.items[r.item]
+      if it.on {
+        line = "    [x] " + it.style.Render(it.label)
+      } else {
+        line = "    [ ] " + offStyle.Render(it.label)
+      }
+    }
+    if i == m.cursor {
+      line = cursorStyle.Render(">") + line
+    } else {
+      line = " " + line
+    }
+    b.WriteString(line + "\n")
+  }
+  b.WriteString("\n" + helpStyle.Render("↑/↓ move · space toggle · a all · n none · p preview · enter apply · q cancel") + "\n")
+  return b.String()
+}
+
+// selected returns the plan with only the changes that are switched on.
+func (m *reviewModel) selected() *bundlePlan {
+  selected := &bundlePlan{}
+
+  for i, change := range m.plan.Filters {
+    group := m.filterGroups[i]
+    if !group.anyOn() {
+      continue
+    }
+    if change.Action == "delete" || (len(change.AddKeywords)+len(change.RemoveKeywords)+len(change.UpdateKeywords) == 0 && filterSettingsReason(change.Reason) == "") {
+      selected.Filters = append(selected.Filters, change)
+      continue
+    }
+
+    // Items are laid out as settings, then added, removed and updated keywords.
+    items := group.items
+    if change.Action == "update" && filterSettingsReason(change.Reason) != "" {
+      if !items[0].on {
+        // Keep the filter's current settings, so only the chosen keywords change.
+        filter := *change.Filter
+        filter.FilterAction = change.Existing.FilterAction
+        filter.Context = change.Existing.Context
+        change.Filter = &filter
+        change.ExpiresIn = 0
+      }
+      items = items[1:]
+    }
+    adds, removes := len(change.AddKeywords), len(change.RemoveKeywords)
+    change.AddKeywords = selectedKeywords(change.AddKeywords, items[:adds])
+    change.RemoveKeywords = selectedKeywords(change.RemoveKeywords, items[adds:adds+removes])
+    change.UpdateKeywords = selectedKeywords(change.UpdateKeywords, items[adds+removes:])
+    selected.Filters = append(selected.Filters, change)
+  }
+
+  for i, change := range m.plan.Tags {
+    if m.tagItems[i].on {
+      selected.Tags = append(selected.Tags, change)
+    }
+  }
+  for i, name := range m.plan.FeaturedTags {
+    if m.featureItems[i].on {
+      selected.FeaturedTags = append(selected.FeaturedTags, name)
+    }
+  }
+  for i, tag := range m.plan.UnfeatureTags {
+    if m.unfeatureItems[i].on {
+      selected.UnfeatureTags = append(selected.UnfeatureTags, tag)
+    }
+  }
+
+  for i, change := range m.plan.Lists {
+    group := m.listGroups[i]
+    if !group.anyOn() {
+      continue
+    }
+    if change.Action != "delete" && len(change.AddAccounts)+len(change.RemoveAccounts) > 0 {
+      var add, remove []string
+      for j, account := range change.AddAccounts {
+        if group.items[j].on {
+          add = append(add, account)
+        }
+      }
+      for j, account := range change.RemoveAccounts {
+        if group.items[len(change.AddAccounts)+j].on {
+          remove = append(remove, account)
+        }
+      }
+      change.AddAccounts, change.RemoveAccounts = add, remove
+    }
+    selected.Lists = append(selected.Lists, change)
+  }
+
+  for i, domain := range m.plan.DomainBlocks {
+    if m.blockItems[i].on {
+      selected.DomainBlocks = append(selected.DomainBlocks, domain)
+    }
+  }
+  for i, domain := range m.plan.DomainUnblocks {
+    if m.unblockItems[i].on {
+      selected.DomainUnblocks = append(selected.DomainUnblocks, domain)
+    }
+  }
+  return selected
+}
+
+// selectedKeywords returns the keywords whose items are on.
+func selectedKeywords(keywords []FilterKeyword, items []*reviewItem) []FilterKeyword {
+  var selected []FilterKeyword
+  for i, keyword := range keywords {
+    if items[i].on {
+      selected = append(selected, keyword)
+    }
+  }
+  return selected
+}
+
+// previewPlan describes how the filters and tags will look once the plan is applied.
+func previewPlan(plan *bundlePlan) string {
+  if plan.empty() {
+    return "Nothing will change.\n"
+  }
+
+  var b strings.Builder
+  for _, change := range plan.Filters {
+    if change.Action == "delete" {
+      fmt.Fprintf(&b, "%s\n  %s\n", headerStyle.Render(change.Title), removeStyle.Render("deleted"))
+      continue
+    }
+
+    // Work out the keywords the filter will have.
+    removed := make(map[string]bool)
+    for _, keyword := range change.RemoveKeywords {
+      removed[strings.ToLower(keyword.Keyword)] = true
+    }
+    var keywords []string
+    if change.Existing != nil {
+      for _, keyword := range change.Existing.Keywords {
+        if !removed[strings.ToLower(keyword.Keyword)] {
+          keywords = append(keywords, keyword.Keyword)
+        }
+      }
+    }
+    for _, keyword := range change.AddKeywords {
+      keywords = append(keywords, addStyle.Render(keyword.Keyword))
+    }
+
+    action, context := change.Filter.FilterAction, change.Filter.Context
+    if change.Existing != nil {
+      if action == "" {
+        action = change.Existing.FilterAction
+      }
+      if len(context) == 0 {
+        context = change.Existing.Context
+      }
+    }
+    fmt.Fprintf(&b, "%s [%s, %s]\n  %s\n", headerStyle.Render(change.Title), action, strings.Join(context, ", "), strings.Join(keywords, ", "))
+  }
+
+  var follow, unfollow []string
+  for _, change := range plan.Tags {
+    if change.Action == "follow" {
+      follow = append(follow, "#"+change.Name)
+    } else {
+      unfollow = append(unfollow, "#"+change.Name)
+    }
+  }
+  if len(follow) > 0 {
+    fmt.Fprintf(&b, "%s\n  %s\n", headerStyle.Render("Newly followed tags"), addStyle.Render(strings.Join(follow, " ")))
+  }
+  if len(unfollow) > 0 {
+    fmt.Fprintf(&b, "%s\n  %s\n", headerStyle.Render("No longer followed"), removeStyle.Render(strings.Join(unfollow, " ")))
+  }
+
+  // The rest of a bundle is listed as it will be applied.
+  rest := &bundlePlan{FeaturedTags: plan.FeaturedTags, UnfeatureTags: plan.UnfeatureTags, Lists: plan.Lists, DomainBlocks: plan.DomainBlocks, DomainUnblocks: plan.DomainUnblocks}
+  if !rest.empty() {
+    b.WriteString(headerStyle.Render("Also") + "\n")
+    rest.fprint(&b)
+  }
+  return b.String()
+}
+
+// reviewPlan shows the plan in the terminal UI and returns the changes the user chose, or nil if they cancelled.
+func reviewPlan(plan *bundlePlan) (*bundlePlan, error) {
+  m := newReviewModel(plan)
+  if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+    return nil, fmt.Errorf("error running review: %w", err)
+  }
+  if !m.apply {
+    return nil, nil
+  }
+  return m.selected(), nil
+}
+
+// confirmPlan asks the user which of the planned changes to make, in the terminal UI if there is one,
+// or else by printing the plan and asking to confirm it all. It returns nil if nothing should be applied.
+func confirmPlan(plan *bundlePlan) (*bundlePlan, error) {
+  if useTUI() {
+    return reviewPlan(plan)
+  }
+  plan.print()
+  if !confirmImport() {
+    return nil, nil
+  }
+  return plan, nil
+}
+
+// pickModel lets the user pick some of a list of items, none of which are picked to begin with.
+type pickModel struct {
+  title  string
+  labels []string
+  picked []bool
+  cursor int
+  offset int
+  height int
+  done   bool
+}
+
+func (m *pickModel) Init() tea.Cmd { return nil }
+
+func (m *pickModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+  switch msg := msg.(type) {
+  case tea.WindowSizeMsg:
+    m.height = msg.Height - 4
+
+  case tea.KeyMsg:
+    switch msg.String() {
+    case "up", "k":
+      if m.cursor > 0 {
+        m.cursor--
+      }
+    case "down", "j":
+      if m.cursor < len(m.labels)-1 {
+        m.cursor++
+      }
+    case " ", "x":
+      m.picked[m.cursor] = !m.picked[m.cursor]
+    case "a", "n":
+      for i := range m.picked {
+        m.picked[i] = msg.String() == "a"
+      }
+    case "enter":
+      m.done = true
+      return m, tea.Quit
+    case "q", "esc", "ctrl+c":
+      return m, tea.Quit
+    }
+  }
+
+  if m.cursor < m.offset {
+    m.offset = m.cursor
+  }
+  if m.height > 0 && m.cursor >= m.offset+m.height {
+    m.offset = m.cursor - m.height + 1
+  }
+  return m, nil
+}
+
+func (m *pickModel) View() string {
+  var b strings.Builder
+  b.WriteString(headerStyle.Render(m.title) + "\n\n")
+  end := len(m.labels)
+  if m.height > 0 && m.offset+m.height < end {
+    end = m.offset + m.height
+  }
+  for i := m.offset; i < end; i++ {
+    line := "[ ] " + m.labels[i]
+    if m.picked[i] {
+      line = "[x] " + addStyle.Render(m.labels[i])
+    }
+    if i == m.cursor {
+      line = cursorStyle.Render(">") + line
+    } else {
+      line = " " + line
+    }
+    b.WriteString(line + "\n")
+  }
+  b.WriteString("\n" + helpStyle.Render("↑/↓ move · space pick · a all · n none · enter done · q cancel") + "\n")
+  return b.String()
+}
+
+// pickItems shows the items in the terminal UI and returns the indexes of those the user picked, or nil if they cancelled.
+func pickItems(title string, labels []string) ([]int, error) {
+  m := &pickModel{title: title, labels: labels, picked: make([]bool, len(labels)), height: 20}
+  if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+    return nil, fmt.Errorf("error running picker: %w", err)
+  }
+  if !m.done {
+    return nil, nil
+  }
+  picked := []int{}
+  for i, on := range m.picked {
+    if on {
+      picked = append(picked, i)
+    }
+  }
+  return picked, nil
+}
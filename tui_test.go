@@ -0,0 +1,78 @@
+package main
+
+import (
+  "strings"
+  "testing"
+
+  tea "github.com/charmbracelet/bubbletea"
+)
+
+// press sends the keys to the model in turn.
+func press(m tea.Model, keys ...string) {
+  for _, key := range keys {
+    msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+    switch key {
+    case "down":
+      msg = tea.KeyMsg{Type: tea.KeyDown}
+    case "enter":
+      msg = tea.KeyMsg{Type: tea.KeyEnter}
+    case " ":
+      msg = tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+    }
+    m.Update(msg)
+  }
+}
+
+func TestReviewPlan(t *testing.T) {
+  existing := &Filter{ID: "1", Title: "Spoilers", FilterAction: "warn", Context: []string{"home"}, Keywords: []FilterKeyword{{ID: "10", Keyword: "finale"}}}
+  plan := &bundlePlan{
+    Filters: []filterChange{
+      {Action: "update", Title: "Spoilers", Reason: "filter_action warn -> hide, +2 keywords, -1 keywords",
+        Filter: &Filter{Title: "Spoilers", FilterAction: "hide", Context: []string{"home"}}, Existing: existing,
+        AddKeywords: []FilterKeyword{{Keyword: "ending"}, {Keyword: "twist"}}, RemoveKeywords: []FilterKeyword{{ID: "10", Keyword: "finale"}}},
+      {Action: "delete", Title: "Old", Reason: "not in the list", Existing: &Filter{ID: "2", Title: "Old"}},
+    },
+    Tags: []tagChange{{Action: "follow", Name: "golang"}, {Action: "unfollow", Name: "rust"}},
+  }
+  m := newReviewModel(plan)
+
+  // Rows: Spoilers, settings, +ending, +twist, -finale, Old, delete, tags, #golang, #rust.
+  // Switch off the settings, twist and the whole Old filter, then unfollow of rust.
+  press(m, "down", " ", "down", "down", " ", "down", "down", " ", "down", "down", "down", "down", " ")
+  if m.preview {
+    t.Fatal("preview shown without asking")
+  }
+  press(m, "p")
+  if view := m.View(); !strings.Contains(view, "ending") || strings.Contains(view, "twist") || !strings.Contains(view, "#golang") {
+    t.Errorf("preview is\n%s", view)
+  }
+  press(m, "p", "enter")
+  if !m.apply {
+    t.Fatal("enter didn't apply")
+  }
+
+  selected := m.selected()
+  if len(selected.Filters) != 1 {
+    t.Fatalf("selected filters %+v", selected.Filters)
+  }
+  change := selected.Filters[0]
+  if change.Filter.FilterAction != "warn" || len(change.AddKeywords) != 1 || change.AddKeywords[0].Keyword != "ending" || len(change.RemoveKeywords) != 1 {
+    t.Errorf("selected filter change %+v", change)
+  }
+  if len(selected.Tags) != 1 || selected.Tags[0].Name != "golang" {
+    t.Errorf("selected tags %+v", selected.Tags)
+  }
+
+  // The plan itself is left alone.
+  if plan.Filters[0].Filter.FilterAction != "hide" || len(plan.Filters[0].AddKeywords) != 2 {
+    t.Errorf("plan changed to %+v", plan.Filters[0])
+  }
+}
+
+func TestReviewCancel(t *testing.T) {
+  m := newReviewModel(&bundlePlan{Tags: []tagChange{{Action: "follow", Name: "golang"}}})
+  press(m, "q")
+  if m.apply {
+    t.Error("q applied the changes")
+  }
+}
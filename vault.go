@@ -0,0 +1,331 @@
+package main
+
+// An encrypted store of access tokens for several profiles, for users without a password manager.
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "time"
+
+  "filippo.io/age"
+  "golang.org/x/term"
+)
+
+// passphraseEnvVar is the environment variable the token store passphrase is read from instead of prompting, e.g. for the daemon.
+const passphraseEnvVar = "SUBSCRIBE_O_MAST_PASSPHRASE"
+
+// defaultProfile is the profile used when neither the config nor the -profile flag choose one.
+const defaultProfile = "default"
+
+// secretInput reads answers from stdin when it isn't a terminal, shared so that buffered input isn't lost between prompts.
+var secretInput = bufio.NewReader(os.Stdin)
+
+// storedToken is the account details saved for a profile in the token store.
+type storedToken struct {
+  InstanceURL string    `json:"instance_url"`
+  AccessToken string    `json:"access_token"`
+  Account     string    `json:"account,omitempty"`
+  SavedAt     time.Time `json:"saved_at"`
+}
+
+// profile returns the profile to use, from the -profile flag or else the config.
+func (config *MastodonConfig) profile() string {
+  if *profileName != "" {
+    return *profileName
+  }
+  if config.Profile != "" {
+    return config.Profile
+  }
+  return defaultProfile
+}
+
+// tokenStorePath returns the path of the token store, which defaults to tokens.age in the user's config directory.
+func (config *MastodonConfig) tokenStorePath() string {
+  if config.TokenStore != "" {
+    return config.TokenStore
+  }
+  dir, err := os.UserConfigDir()
+  if err != nil {
+    return ""
+  }
+  return filepath.Join(dir, "subscribe-o-mast", "tokens.age")
+}
+
+// readSecret prompts for a secret on the terminal without echoing it, or reads a line from stdin when it isn't a terminal.
+func readSecret(prompt string) (string, error) {
+  fd := int(os.Stdin.Fd())
+  if !term.IsTerminal(fd) {
+    line, err := secretInput.ReadString('\n')
+    if err != nil && line == "" {
+      return "", fmt.Errorf("error reading %s: %w", strings.ToLower(prompt), err)
+    }
+    return strings.TrimSpace(line), nil
+  }
+
+  fmt.Fprint(os.Stderr, prompt+": ")
+  secret, err := term.ReadPassword(fd)
+  fmt.Fprintln(os.Stderr)
+  if err != nil {
+    return "", fmt.Errorf("error reading %s: %w", strings.ToLower(prompt), err)
+  }
+  return strings.TrimSpace(string(secret)), nil
+}
+
+// errNoPassphrase is returned when the token store passphrase isn't set and can't be prompted for.
+var errNoPassphrase = errors.New("no passphrase for the token store")
+
+// readPassphrase returns the token store passphrase from the environment, or else prompts for it.
+func readPassphrase(file string) (string, error) {
+  if passphrase := os.Getenv(passphraseEnvVar); passphrase != "" {
+    return passphrase, nil
+  }
+  if !term.IsTerminal(int(os.Stdin.Fd())) {
+    return "", fmt.Errorf("%w: %s is encrypted, set %s to unlock it", errNoPassphrase, file, passphraseEnvVar)
+  }
+  return readSecret("Passphrase for " + file)
+}
+
+// readTokenStore decrypts the token store and returns the saved tokens by profile.
+func readTokenStore(file, passphrase string) (map[string]storedToken, error) {
+  data, err := ioutil.ReadFile(file)
+  if err != nil {
+    return nil, fmt.Errorf("error reading token store: %w", err)
+  }
+
+  identity, err := age.NewScryptIdentity(passphrase)
+  if err != nil {
+    return nil, err
+  }
+  reader, err := age.Decrypt(bytes.NewReader(data), identity)
+  if err != nil {
+    return nil, fmt.Errorf("error decrypting token store %s, check the passphrase: %w", file, err)
+  }
+  plaintext, err := ioutil.ReadAll(reader)
+  if err != nil {
+    return nil, fmt.Errorf("error decrypting token store %s: %w", file, err)
+  }
+
+  tokens := make(map[string]storedToken)
+  if err := json.Unmarshal(plaintext, &tokens); err != nil {
+    return nil, fmt.Errorf("error parsing token store %s: %w", file, err)
+  }
+  return tokens, nil
+}
+
+// writeTokenStore encrypts the tokens with the passphrase and replaces the token store, readable only by the user.
+func writeTokenStore(file, passphrase string, tokens map[string]storedToken) error {
+  plaintext, err := json.Marshal(tokens)
+  if err != nil {
+    return err
+  }
+
+  recipient, err := age.NewScryptRecipient(passphrase)
+  if err != nil {
+    return err
+  }
+  var encrypted bytes.Buffer
+  writer, err := age.Encrypt(&encrypted, recipient)
+  if err != nil {
+    return fmt.Errorf("error encrypting token store: %w", err)
+  }
+  if _, err := writer.Write(plaintext); err != nil {
+    return fmt.Errorf("error encrypting token store: %w", err)
+  }
+  if err := writer.Close(); err != nil {
+    return fmt.Errorf("error encrypting token store: %w", err)
+  }
+
+  // Write to a temporary file first so a failed write can't lose the existing tokens.
+  if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+    return fmt.Errorf("error creating token store directory: %w", err)
+  }
+  tmp, err := ioutil.TempFile(filepath.Dir(file), ".tokens-*")
+  if err != nil {
+    return fmt.Errorf("error writing token store: %w", err)
+  }
+  defer os.Remove(tmp.Name())
+  if _, err := tmp.Write(encrypted.Bytes()); err != nil {
+    tmp.Close()
+    return fmt.Errorf("error writing token store: %w", err)
+  }
+  if err := tmp.Close(); err != nil {
+    return fmt.Errorf("error writing token store: %w", err)
+  }
+  if err := os.Rename(tmp.Name(), file); err != nil {
+    return fmt.Errorf("error writing token store: %w", err)
+  }
+  return nil
+}
+
+// loadStoredToken reads the token for the current profile from the token store, if there is a store.
+// It reports false when there is no store or the profile isn't in it.
+func (config *MastodonConfig) loadStoredToken() (bool, error) {
+  file := config.tokenStorePath()
+  if file == "" {
+    return false, nil
+  }
+  if _, err := os.Stat(file); os.IsNotExist(err) {
+    return false, nil
+  }
+
+  passphrase, err := readPassphrase(file)
+  if err != nil {
+    return false, err
+  }
+  tokens, err := readTokenStore(file, passphrase)
+  if err != nil {
+    return false, err
+  }
+
+  stored, ok := tokens[config.profile()]
+  if !ok {
+    return false, nil
+  }
+  config.AccessToken = stored.AccessToken
+  if config.InstanceURL == "" {
+    config.InstanceURL = stored.InstanceURL
+  }
+  return true, nil
+}
+
+// verifyCredentials checks the token works and returns the account's name.
+func verifyCredentials(config *MastodonConfig) (string, error) {
+  var account struct {
+    Acct string `json:"acct"`
+  }
+  if err := fetchJSON(config, "/api/v1/accounts/verify_credentials", &account); err != nil {
+    return "", fmt.Errorf("error checking access token: %w", err)
+  }
+  return account.Acct, nil
+}
+
+// loginCommand defines the login command's flags and returns the function that runs it, saving an access token to the encrypted token store.
+func loginCommand(flags *flag.FlagSet) func(args []string) error {
+  instance := flags.String("instance", "", "the instance URL, defaulting to instance_url in the config file")
+  list := flags.Bool("list", false, "list the profiles in the token store")
+  remove := flags.Bool("remove", false, "remove the profile from the token store")
+  return func(args []string) error {
+    // The config doesn't need an access token, or to exist at all, to log in.
+    config, err := readConfig(*configFile)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+      return err
+    }
+    if config == nil {
+      config = &MastodonConfig{}
+    }
+    file := config.tokenStorePath()
+    if file == "" {
+      return fmt.Errorf("no token_store in configuration and no user config directory")
+    }
+
+    // Unlock the existing store, or choose a passphrase for a new one.
+    tokens := make(map[string]storedToken)
+    var passphrase string
+    if _, err := os.Stat(file); err == nil {
+      if passphrase, err = readPassphrase(file); err != nil {
+        return err
+      }
+      if tokens, err = readTokenStore(file, passphrase); err != nil {
+        return err
+      }
+    } else if *list || *remove {
+      return fmt.Errorf("there is no token store at %s", file)
+    }
+
+    profile := config.profile()
+    switch {
+    case *list:
+      var names []string
+      for name := range tokens {
+        names = append(names, name)
+      }
+      sort.Strings(names)
+      for _, name := range names {
+        fmt.Printf("%s\t%s\t@%s\tsaved %s\n", name, tokens[name].InstanceURL, tokens[name].Account, tokens[name].SavedAt.Format(time.RFC3339))
+      }
+      return nil
+
+    case *remove:
+      if _, ok := tokens[profile]; !ok {
+        return fmt.Errorf("profile %q isn't in the token store", profile)
+      }
+      delete(tokens, profile)
+      if err := writeTokenStore(file, passphrase, tokens); err != nil {
+        return err
+      }
+      fmt.Printf("Removed profile %s from %s\n", profile, file)
+      return nil
+    }
+
+    // Ask for the account details and check they work before saving them.
+    login := &MastodonConfig{InstanceURL: *instance}
+    if login.InstanceURL == "" {
+      login.InstanceURL = config.InstanceURL
+    }
+    if login.InstanceURL == "" {
+      fmt.Fprint(os.Stderr, "Instance URL: ")
+      line, _ := secretInput.ReadString('\n')
+      login.InstanceURL = strings.TrimSpace(line)
+    }
+    login.InstanceURL = strings.TrimRight(login.InstanceURL, "/")
+    if login.AccessToken, err = readSecret("Access token"); err != nil {
+      return err
+    }
+    if login.AccessToken == "" {
+      return fmt.Errorf("no access token given")
+    }
+    account, err := verifyCredentials(login)
+    if err != nil {
+      return err
+    }
+
+    if passphrase == "" {
+      if passphrase = os.Getenv(passphraseEnvVar); passphrase == "" {
+        if passphrase, err = readSecret("New passphrase for " + file); err != nil {
+          return err
+        }
+        confirm, err := readSecret("Repeat passphrase")
+        if err != nil {
+          return err
+        }
+        if passphrase != confirm {
+          return fmt.Errorf("passphrases don't match")
+        }
+      }
+      if passphrase == "" {
+        return fmt.Errorf("the token store needs a passphrase")
+      }
+    }
+
+    tokens[profile] = storedToken{InstanceURL: login.InstanceURL, AccessToken: login.AccessToken, Account: account, SavedAt: time.Now().UTC()}
+    if err := writeTokenStore(file, passphrase, tokens); err != nil {
+      return err
+    }
+
+    fmt.Printf("Logged in as @%s on %s, saved as profile %s in %s\n", account, login.InstanceURL, profile, file)
+
+    // Point the config at the store, so it's read from now on even if the config has an access_token.
+    if _, err := os.Stat(*configFile); err != nil {
+      return nil
+    }
+    if config.TokenStore == "" {
+      if err := setConfig(*configFile, "token_store", file); err != nil {
+        return err
+      }
+      fmt.Printf("Set token_store in %s\n", *configFile)
+    }
+    if config.AccessToken != "" && config.AccessToken != placeholderToken {
+      fmt.Printf("access_token in %s is now ignored and can be removed.\n", *configFile)
+    }
+    return nil
+  }
+}